@@ -3,11 +3,14 @@ module sigs.k8s.io/scheduler-plugins
 go 1.13
 
 require (
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	k8s.io/api v0.18.0
 	k8s.io/apimachinery v0.18.0
 	k8s.io/client-go v0.18.0
+	k8s.io/component-base v0.18.0
 	k8s.io/klog v1.0.0
 	k8s.io/kubernetes v1.18.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
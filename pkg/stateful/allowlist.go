@@ -0,0 +1,72 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// allowListNodesKey is the key of the allow-list ConfigMap listing the allowed nodes,
+// separated by commas or new lines.
+const allowListNodesKey = "nodes"
+
+// NodeAllowList decides which nodes pods may be pinned to. Records of nodes that are not
+// allowed are neither enforced nor written.
+type NodeAllowList interface {
+	// Allowed checks whether pods may be pinned to the node.
+	Allowed(nodeName string) bool
+}
+
+// configMapAllowList reads the allowed nodes from a ConfigMap cached by an informer.
+type configMapAllowList struct {
+	lister          corelisters.ConfigMapLister
+	namespace, name string
+}
+
+// newConfigMapAllowList returns a NodeAllowList reading the nodes key of the ConfigMap namespace/name.
+func newConfigMapAllowList(lister corelisters.ConfigMapLister, namespace, name string) NodeAllowList {
+	return &configMapAllowList{lister: lister, namespace: namespace, name: name}
+}
+
+// Allowed checks whether the node is listed in the ConfigMap. All nodes are allowed while the
+// ConfigMap doesn't exist, so a missing ConfigMap doesn't release every pin.
+func (a *configMapAllowList) Allowed(nodeName string) bool {
+	configMap, err := a.lister.ConfigMaps(a.namespace).Get(a.name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.V(4).Infof("Failed to get allow-list ConfigMap %s/%s: %v", a.namespace, a.name, err)
+		}
+		return true
+	}
+	for _, line := range strings.Split(configMap.Data[allowListNodesKey], "\n") {
+		for _, node := range strings.Split(line, ",") {
+			if node = strings.TrimSpace(node); node != "" && node == nodeName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeAllowed checks whether pods may be pinned to the node, all nodes are allowed without allow-list.
+func (st *Stable) nodeAllowed(nodeName string) bool {
+	return st.allowList == nil || st.allowList.Allowed(nodeName)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestConfigMapAllowList(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	allowList := newConfigMapAllowList(configMapInformer.Lister(), "kube-system", "allowed-nodes")
+
+	if !allowList.Allowed("node3") {
+		t.Errorf("expected all nodes to be allowed without ConfigMap")
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "allowed-nodes", Namespace: "kube-system"},
+		Data:       map[string]string{"nodes": "node1, node2\nnode4\n"},
+	}
+	if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+	for node, expected := range map[string]bool{"node1": true, "node2": true, "node3": false, "node4": true, "": false} {
+		if got := allowList.Allowed(node); got != expected {
+			t.Errorf("expected %q allowed to be %v, got %v", node, expected, got)
+		}
+	}
+}
+
+func TestAllowListReleasesPins(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "allowed-nodes", Namespace: "kube-system"},
+		Data:       map[string]string{"nodes": "node1,node2"},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		allowList:         newConfigMapAllowList(configMapInformer.Lister(), "kube-system", "allowed-nodes"),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	ctx := context.TODO()
+	filter := func(nodeName string) framework.Code {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+			t.Fatal(err)
+		}
+		return stableSchedule.Filter(ctx, nil, pod, nodeInfo).Code()
+	}
+	getRecord := func() string {
+		s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+			t.Fatal(err)
+		}
+		return s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]
+	}
+
+	if code := filter("node3"); code != framework.Unschedulable {
+		t.Errorf("expected %v while node1 is allowed, got %v", framework.Unschedulable, code)
+	}
+
+	// node1 is removed from the allow-list, the pin is released
+	removed := configMap.DeepCopy()
+	removed.Data["nodes"] = "node2,node3"
+	if err := configMapInformer.Informer().GetIndexer().Update(removed); err != nil {
+		t.Fatal(err)
+	}
+	if code := filter("node3"); code != framework.Success {
+		t.Errorf("expected %v after node1 is removed from the allow-list, got %v", framework.Success, code)
+	}
+	stableSchedule.PostBind(ctx, nil, pod, "node3")
+	if got, expected := getRecord(), `{"Records":{"web-0":"node3"}}`; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// node3 is removed as well, the pod is released again and the disallowed node4 is never written
+	removed = removed.DeepCopy()
+	removed.Data["nodes"] = "node2"
+	if err := configMapInformer.Informer().GetIndexer().Update(removed); err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule.PostBind(ctx, nil, pod, "node4")
+	if got, expected := getRecord(), `{"Records":{}}`; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
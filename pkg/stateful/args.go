@@ -0,0 +1,697 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// StableArgs holds the arguments used to configure the statefulset-stable plugin.
+type StableArgs struct {
+	// StickyOrdinals limits stable scheduling to the listed pod ordinals, e.g. "0-2,5".
+	// The StatefulsetStableOrdinals annotation overrides it for a single statefulset.
+	// All pods are sticky when it is empty.
+	StickyOrdinals string `json:"stickyOrdinals,omitempty"`
+	// NodeReadinessSelector is a label selector, e.g. "example.com/initialized=true", that a
+	// recorded node must match before pods are pinned to it again. Pinned pods stay pending
+	// until their recorded node matches. It is not checked when empty.
+	NodeReadinessSelector string `json:"nodeReadinessSelector,omitempty"`
+	// ReconcileWorkers is the number of workers reconciling the schedule records of statefulsets
+	// in the background. The reconcile is disabled when it is 0.
+	ReconcileWorkers int `json:"reconcileWorkers,omitempty"`
+	// ReconcileQPS and ReconcileBurst limit the rate of statefulsets the workers reconcile.
+	ReconcileQPS   float64 `json:"reconcileQPS,omitempty"`
+	ReconcileBurst int     `json:"reconcileBurst,omitempty"`
+	// EnforceAfterReady skips enforcing the records of a statefulset until all of its
+	// replicas have been ready, so pods can move freely during the initial rollout.
+	EnforceAfterReady bool `json:"enforceAfterReady,omitempty"`
+	// PinToVolumeNode records the node selected for the WaitForFirstConsumer volumes of a pod,
+	// so the record always agrees with the node its volume was provisioned on.
+	PinToVolumeNode bool `json:"pinToVolumeNode,omitempty"`
+	// KeyConflictPolicy decides what happens when a pod is recorded under a key that another
+	// pod already recorded, either KeyConflictLastWriterWins (the default) or KeyConflictReject.
+	KeyConflictPolicy string `json:"keyConflictPolicy,omitempty"`
+	// RecordKey selects the key pods are recorded under, either RecordKeyPodName (the default)
+	// or RecordKeyOrdinal.
+	RecordKey string `json:"recordKey,omitempty"`
+	// IdentityAnnotation is a pod annotation, e.g. "example.com/shard", whose value pods are
+	// recorded under instead of the RecordKey, so pods keep their node by a business identity
+	// rather than by name. Pods without the annotation fall back to the RecordKey.
+	IdentityAnnotation string `json:"identityAnnotation,omitempty"`
+	// SentinelConfigMap is the "namespace/name" of a ConfigMap pausing the plugin cluster wide.
+	// Its "paused" key stops enforcing and writing records, its "pauseNewPins" key only stops
+	// writing records. The plugin isn't paused when it is empty or the ConfigMap doesn't exist.
+	SentinelConfigMap string `json:"sentinelConfigMap,omitempty"`
+	// RecordUpdatePolicy decides whether a record follows a pod bound to another node than the
+	// recorded one, either RecordUpdateImmutable (the default) or RecordUpdateMutable.
+	RecordUpdatePolicy string `json:"recordUpdatePolicy,omitempty"`
+	// ObservationPeriodSeconds delays the first record of a pod until it stayed on the node it
+	// was bound to for this long, so brief initial placements are not pinned. The first record
+	// is written right after binding when it is 0.
+	ObservationPeriodSeconds int64 `json:"observationPeriodSeconds,omitempty"`
+	// RecordAfterPodCondition delays the first record of a pod until the pod condition of this
+	// type is True, e.g. Ready or the condition of a readiness gate, so only healthy placements
+	// are pinned. It combines with ObservationPeriodSeconds. Pods are recorded right after
+	// binding when it is empty.
+	RecordAfterPodCondition string `json:"recordAfterPodCondition,omitempty"`
+	// NodeNameMatch decides how recorded node names are compared with node names, either
+	// NodeNameMatchExact (the default), NodeNameMatchTrim or NodeNameMatchTrimIgnoreCase.
+	NodeNameMatch string `json:"nodeNameMatch,omitempty"`
+	// AuditLog receives the decisions of the plugin as JSON lines, either "stdout" or the path of
+	// a file they are appended to. Decisions are not audited when it is empty.
+	AuditLog string `json:"auditLog,omitempty"`
+	// FallbackLabelKeys are the node labels, e.g. "topology.kubernetes.io/zone", saved with the
+	// record of a pod and matched against other nodes when the recorded node is unavailable.
+	FallbackLabelKeys []string `json:"fallbackLabelKeys,omitempty"`
+	// Fallback decides how pods whose recorded node is unavailable are scheduled, either
+	// FallbackPreferred or FallbackRequired. Such pods stay pending when it is empty.
+	Fallback string `json:"fallback,omitempty"`
+	// NodeAllowListConfigMap is the "namespace/name" of a ConfigMap listing the nodes pods may be
+	// pinned to under its "nodes" key. Records of other nodes are released and not written. All
+	// nodes are allowed when it is empty or the ConfigMap doesn't exist.
+	NodeAllowListConfigMap string `json:"nodeAllowListConfigMap,omitempty"`
+	// DeleteTerminatingRecords deletes the record of a statefulset from the record store once
+	// the statefulset is terminating. Records of terminating statefulsets are never enforced
+	// nor written.
+	DeleteTerminatingRecords bool `json:"deleteTerminatingRecords,omitempty"`
+	// ReturnGraceSeconds keeps the previous node of a pod relocated by RecordUpdateMutable for
+	// this long. The pod may return to it and is scored towards it when it is rescheduled within
+	// the window. Nothing is kept when it is 0.
+	ReturnGraceSeconds int64 `json:"returnGraceSeconds,omitempty"`
+	// StuckPendingSeconds reports the pods pinned to a recorded node that are pending for longer
+	// than this, with a warning event and the stuck_pods metric. It doesn't change scheduling.
+	// Pods are not checked when it is 0.
+	StuckPendingSeconds int64 `json:"stuckPendingSeconds,omitempty"`
+	// ConfigFile is the path of a YAML or JSON policy file setting stickyOrdinals and
+	// nodeReadinessSelector, which override the args. The file is read again every 10 seconds
+	// and changes apply without restarting the scheduler.
+	ConfigFile string `json:"configFile,omitempty"`
+	// ImageLocality records the primary image of pods and schedules the pods without a recorded
+	// node to the nodes that ran their image, either ImageLocalityPreferred or
+	// ImageLocalityRequired. Images are not recorded when it is empty.
+	ImageLocality string `json:"imageLocality,omitempty"`
+	// ClusterRecordConfigMap is the "namespace/name" of a ConfigMap keeping the records of all
+	// statefulsets instead of their annotations. Records are keyed by the namespace, name and
+	// UID of their statefulset. The records are kept in annotations when it is empty.
+	ClusterRecordConfigMap string `json:"clusterRecordConfigMap,omitempty"`
+	// FederateClusterRecords shares one record between the statefulsets with the same name in
+	// all namespaces. It requires ClusterRecordConfigMap.
+	FederateClusterRecords bool `json:"federateClusterRecords,omitempty"`
+	// DiagnosePod is the "namespace/name" of a pod whose Filter and PostBind decisions are logged
+	// in detail regardless of the log verbosity. It doesn't change scheduling.
+	DiagnosePod string `json:"diagnosePod,omitempty"`
+	// TenureSaturationSeconds weights the score of a preferred recorded node by how long the pod
+	// has been recorded on it, reaching the full score after this long. The score is not
+	// weighted when it is 0.
+	TenureSaturationSeconds int64 `json:"tenureSaturationSeconds,omitempty"`
+	// TenureMaxScore bounds the part of the score of a preferred recorded node earned by tenure,
+	// between 1 and 100. The node scores at least 100 - TenureMaxScore.
+	TenureMaxScore int64 `json:"tenureMaxScore,omitempty"`
+	// ConsistentHashing prefers a node chosen by rendezvous hashing of the pod identity over the
+	// feasible nodes for pods without a recorded node, so their first placement is deterministic.
+	ConsistentHashing bool `json:"consistentHashing,omitempty"`
+	// ClearCordonedNodeRecords removes the entries of a node from all records once it has been
+	// cordoned for CordonedNodeGraceSeconds, so the pinned pods are scheduled elsewhere when
+	// they are evicted. It clears records only, pods are not evicted.
+	ClearCordonedNodeRecords bool `json:"clearCordonedNodeRecords,omitempty"`
+	// CordonedNodeGraceSeconds is how long a node must stay cordoned before its records are
+	// cleared, so short maintenance cordons keep the records. Records are cleared as soon as
+	// the node is seen cordoned when it is 0.
+	CordonedNodeGraceSeconds int64 `json:"cordonedNodeGraceSeconds,omitempty"`
+	// SiblingPlacement scores nodes for pods without a recorded node by the recorded siblings of
+	// the pod on them, Spread prefers nodes with fewer siblings and Cluster nodes with more.
+	// Nodes are not scored by siblings when it is empty.
+	SiblingPlacement string `json:"siblingPlacement,omitempty"`
+	// PinsExportConfigMap is the "namespace/name" of a ConfigMap the records of all statefulsets
+	// are periodically exported to in a versioned format, for the descheduler and other
+	// components. The records are not exported when it is empty.
+	PinsExportConfigMap string `json:"pinsExportConfigMap,omitempty"`
+	// OptInLabelValue decides which values of the opt-in label opt a pod in, either
+	// OptInLabelValueStrict (the default) or OptInLabelValueLenient.
+	OptInLabelValue string `json:"optInLabelValue,omitempty"`
+	// TTL expires the entries of a record this long after their node was last recorded or
+	// confirmed by binding the pod to it, e.g. "168h", so the pod is free to go elsewhere. The
+	// statefulset-stable.scheduling.sigs.k8s.io/ttl annotation overrides it per statefulset.
+	// Entries don't expire when it is 0.
+	TTL metav1.Duration `json:"ttl,omitempty"`
+	// MaxTotalRecordEntries caps the number of record entries across all statefulsets, new
+	// entries beyond it are not recorded. The entries are not capped when it is 0.
+	MaxTotalRecordEntries int `json:"maxTotalRecordEntries,omitempty"`
+	// EvictOldestRecordEntries makes room for new entries beyond MaxTotalRecordEntries or
+	// MaxRecords by evicting the least recently recorded entries instead. It requires one of
+	// them.
+	EvictOldestRecordEntries bool `json:"evictOldestRecordEntries,omitempty"`
+	// ScopeRecordsByRevision scopes the entries of a record to the controller revision of the
+	// pod they were recorded with, from its controller-revision-hash label. A pod of another
+	// revision ignores the entry and replaces it once bound, and reconcile prunes the entries of
+	// revisions the statefulset has rolled away from.
+	ScopeRecordsByRevision bool `json:"scopeRecordsByRevision,omitempty"`
+	// EnforceNodeEpoch saves the epoch of the recorded node, its UID or creation timestamp, with
+	// each entry. An entry only pins its pod while the node has the same epoch, a node recreated
+	// under the same name releases the pin.
+	EnforceNodeEpoch bool `json:"enforceNodeEpoch,omitempty"`
+	// PlacementWebhookURL receives a PlacementChange as JSON POST each time the recorded node of
+	// a pod changes. Failed requests are retried a few times, they never fail a bind. No changes
+	// are sent when it is empty.
+	PlacementWebhookURL string `json:"placementWebhookURL,omitempty"`
+	// NodeDomainSuffix is the cluster domain of the node names, e.g. "example.internal". Recorded
+	// node names match nodes with or without the suffix, for kubelets registering short names
+	// while the record holds fully qualified ones or the other way round. Node names are
+	// compared with the suffix when it is empty.
+	NodeDomainSuffix string `json:"nodeDomainSuffix,omitempty"`
+	// PreemptedPodPolicy decides how the record of a pod deleted by preemption is handled when
+	// the pod is rescheduled, either PreemptedPodReclaim (the default) or PreemptedPodMove.
+	PreemptedPodPolicy string `json:"preemptedPodPolicy,omitempty"`
+	// MetricLabels decides whether metrics carry the namespace and name of statefulsets, either
+	// MetricLabelsAuto (the default), MetricLabelsPerStatefulSet or MetricLabelsAggregate.
+	MetricLabels string `json:"metricLabels,omitempty"`
+	// RecordedNodeScoreFloor raises the score of the recorded node of a pod to the highest score
+	// of the feasible nodes, so it wins ties where the record doesn't filter the other nodes, e.g.
+	// advisory records of an older generation.
+	RecordedNodeScoreFloor bool `json:"recordedNodeScoreFloor,omitempty"`
+	// CustomOwners are owner kinds other than StatefulSet whose pods are stable scheduled. Their
+	// records are kept under the identity of their set, which requires ClusterRecordConfigMap or
+	// a record store of the embedder.
+	CustomOwners []CustomOwner `json:"customOwners,omitempty"`
+	// NodeIdentityLabel is a node label identifying the machine behind a node, e.g. an instance
+	// id, saved with each entry. The entries are reconciled when the label of a node changes.
+	NodeIdentityLabel string `json:"nodeIdentityLabel,omitempty"`
+	// NodeIdentityChangePolicy decides how an entry whose node identity changed since recording
+	// is handled, either NodeIdentitySameNode (the default) or NodeIdentityChangedNode.
+	NodeIdentityChangePolicy string `json:"nodeIdentityChangePolicy,omitempty"`
+	// EnforceAfterVolumeBound only enforces the record of a pod once its claims are bound to
+	// node-local volumes, before that the pod is scheduled freely.
+	EnforceAfterVolumeBound bool `json:"enforceAfterVolumeBound,omitempty"`
+	// SkipWithoutOptIns makes the plugin return right away while no statefulset opts in with the
+	// labels of its pod template, checked periodically. A statefulset opting in activates the
+	// plugin again.
+	SkipWithoutOptIns bool `json:"skipWithoutOptIns,omitempty"`
+	// Mode decides how records are enforced, either ModeHard (the default), Filter rejects the
+	// nodes other than the recorded node, or ModeSoft, Filter admits every node and Score
+	// prefers the recorded node.
+	Mode string `json:"mode,omitempty"`
+	// OtherNodeScore is the score of the nodes other than the recorded node of a pod in ModeSoft,
+	// below the score of the recorded node. It requires ModeSoft.
+	OtherNodeScore int64 `json:"otherNodeScore,omitempty"`
+	// LabelKey is the key of the label pods opt in with, StatefulsetStable when empty. Pods
+	// labeled with StatefulsetStable don't opt in when another key is set.
+	LabelKey string `json:"labelKey,omitempty"`
+	// PinEvents emits a Warning event on a pod whose node is filtered out because the pod is
+	// pinned to another node, and a Normal event when a pod is recorded on a new node. Each
+	// reason is emitted at most once a minute per pod.
+	PinEvents bool `json:"pinEvents,omitempty"`
+	// PinToDeletedNodes keeps a pod whose recorded node was deleted pinned to it, the pod is
+	// rejected as UnschedulableAndUnresolvable until its record is cleared by hand. Without it
+	// the entries of a node are removed from all records when the node is deleted, and a pod
+	// whose recorded node was deleted while the scheduler was down is scheduled on any node and
+	// recorded anew once it is bound.
+	PinToDeletedNodes bool `json:"pinToDeletedNodes,omitempty"`
+	// OwnerKinds are apps/v1 owner kinds other than StatefulSet whose pods are stable scheduled,
+	// OwnerKindReplicaSet and OwnerKindDeployment. Their records are kept under the name of the
+	// owner like those of CustomOwners, the Deployment of the ReplicaSet of a pod with
+	// OwnerKindDeployment, and require OwnerIdentityLabel.
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// OwnerIdentityLabel is the pod label whose value the pods of the OwnerKinds are recorded by,
+	// the names of these pods are random. Pods without the label are not stable scheduled.
+	OwnerIdentityLabel string `json:"ownerIdentityLabel,omitempty"`
+	// StoreType decides where the records are kept, either StoreTypeAnnotation (the default), an
+	// annotation of the statefulset, or StoreTypeConfigMap, a ConfigMap per statefulset named
+	// after it with the "-schedule-record" suffix and owned by it. ClusterRecordConfigMap
+	// requires StoreTypeAnnotation.
+	StoreType string `json:"storeType,omitempty"`
+	// SecondaryStores are store types, StoreTypeAnnotation or StoreTypeConfigMap, the records are
+	// written to as well, besides the store of StoreType or ClusterRecordConfigMap they are read
+	// from, e.g. to migrate the records to another store type before switching StoreType. The
+	// statefulsets whose records differ between the stores are counted periodically.
+	SecondaryStores []string `json:"secondaryStores,omitempty"`
+	// RecordAnnotationKey is the key of the statefulset annotation the records are kept in with
+	// StoreTypeAnnotation, StatefulsetStableRecord when empty. Records under another key are
+	// not read, so profiles with different keys keep separate records.
+	RecordAnnotationKey string `json:"recordAnnotationKey,omitempty"`
+	// TopologyKey makes pods stable within a topology domain instead of on a node, e.g.
+	// "topology.kubernetes.io/zone". The value of the node label is recorded with the node, and
+	// Filter admits every node sharing it. Entries recorded without a value pin the pod to the node.
+	TopologyKey string `json:"topologyKey,omitempty"`
+	// OrderedPlacementTimeoutSeconds holds a stable scheduled pod in Permit until the pods of
+	// its lower ordinals are recorded or terminal, at most this long, after which the pod is
+	// allowed anyway. 0 (the default) permits pods right away. It requires the plugin to be
+	// enabled at the permit extension point and is at most 600.
+	OrderedPlacementTimeoutSeconds int64 `json:"orderedPlacementTimeoutSeconds,omitempty"`
+	// RelaxWhenUnschedulable makes the record of a pod advisory and clears its entry when its
+	// last scheduling cycle failed only because Filter pinned it to its recorded node, so the
+	// next cycle may place the pod anywhere. The failure is parsed from the PodScheduled
+	// condition message in the FitError format of the 1.18 scheduler, another format never
+	// relaxes a record.
+	RelaxWhenUnschedulable bool `json:"relaxWhenUnschedulable,omitempty"`
+	// StrictRecordParsing makes the pods of a statefulset whose record can't be decoded
+	// unschedulable until the record is fixed. By default the record is ignored with a Warning
+	// event on the statefulset and the pods are scheduled as if it had no record.
+	StrictRecordParsing bool `json:"strictRecordParsing,omitempty"`
+	// MaxRecords caps the number of entries in the record of each statefulset, new entries
+	// beyond it are not recorded unless EvictOldestRecordEntries is set. The entries are not
+	// capped when it is 0.
+	MaxRecords int `json:"maxRecords,omitempty"`
+	// Compress writes the record annotation compressed with gzip, in base64 after a "gzip:"
+	// prefix, for statefulsets with many replicas. Compressed and plain records are both read
+	// regardless of it. It requires StoreTypeAnnotation.
+	Compress bool `json:"compress,omitempty"`
+	// StrictBind makes Bind re-read the record from the API server and fail the binding of a pod
+	// recorded on another node since Filter. It requires the plugin to be enabled at the bind
+	// extension point ahead of the default binder and adds a read to every binding.
+	StrictBind bool `json:"strictBind,omitempty"`
+	// DebugListenAddress is the "host:port" a read-only HTTP server listens on, serving the
+	// pins of all statefulsets as JSON on DebugPinsPath. The server is off when it is empty.
+	DebugListenAddress string `json:"debugListenAddress,omitempty"`
+}
+
+const (
+	// RecordKeyPodName records pods by name.
+	RecordKeyPodName = "podName"
+	// RecordKeyOrdinal records pods by the ordinal parsed from their name, so the record survives
+	// recreating the pod under another name with the same ordinal. Pods whose name has no
+	// ordinal suffix are recorded by name.
+	RecordKeyOrdinal = "ordinal"
+)
+
+const (
+	// KeyConflictLastWriterWins overwrites the record of the key with the node of the last pod.
+	KeyConflictLastWriterWins = "LastWriterWins"
+	// KeyConflictReject keeps the record of the pod that recorded the key first.
+	KeyConflictReject = "Reject"
+)
+
+const (
+	// RecordUpdateImmutable keeps the first recorded node of a pod.
+	RecordUpdateImmutable = "Immutable"
+	// RecordUpdateMutable records the node a pod is bound to when it differs from the recorded
+	// node, so the record reflects where the pod runs after the record wasn't enforced.
+	RecordUpdateMutable = "Mutable"
+)
+
+const (
+	// StoreTypeAnnotation keeps the record of a statefulset in its RecordAnnotationKey annotation.
+	StoreTypeAnnotation = "Annotation"
+	// StoreTypeConfigMap keeps the record of a statefulset in a ConfigMap of its own, so writing
+	// the record doesn't update the statefulset.
+	StoreTypeConfigMap = "ConfigMap"
+)
+
+const (
+	// ModeHard pins pods to their recorded node, they stay pending while it is unavailable.
+	ModeHard = "Hard"
+	// ModeSoft prefers the recorded node of pods, they are scheduled elsewhere when it is
+	// unavailable, e.g. deleted or cordoned.
+	ModeSoft = "Soft"
+)
+
+const (
+	// MetricLabelsAuto labels metrics per statefulset until the cluster has more than
+	// autoAggregateMetricsStatefulSets statefulsets, then only aggregate metrics are reported.
+	MetricLabelsAuto = "Auto"
+	// MetricLabelsPerStatefulSet labels metrics with the namespace and name of statefulsets.
+	MetricLabelsPerStatefulSet = "PerStatefulSet"
+	// MetricLabelsAggregate only reports aggregate metrics, without statefulset labels.
+	MetricLabelsAggregate = "Aggregate"
+)
+
+const (
+	// NodeNameMatchExact matches recorded node names that are equal to the node name.
+	NodeNameMatchExact = "Exact"
+	// NodeNameMatchTrim ignores leading and trailing whitespace of recorded node names.
+	NodeNameMatchTrim = "Trim"
+	// NodeNameMatchTrimIgnoreCase also ignores the case of recorded node names. Node names are
+	// lower case, so it only guards against records edited by hand.
+	NodeNameMatchTrimIgnoreCase = "TrimIgnoreCase"
+)
+
+const (
+	// OptInLabelValueStrict only opts pods in with the value "true".
+	OptInLabelValueStrict = "Strict"
+	// OptInLabelValueLenient opts pods in with any value parsed as true by strconv.ParseBool,
+	// e.g. "1" or "True", and with "yes", "y", "on" or "enabled" in any case.
+	OptInLabelValueLenient = "Lenient"
+)
+
+const (
+	defaultReconcileQPS   = 10
+	defaultReconcileBurst = 100
+)
+
+// defaultStableArgs returns the args used for the fields that are not configured.
+func defaultStableArgs() *StableArgs {
+	return &StableArgs{
+		ReconcileQPS:             defaultReconcileQPS,
+		ReconcileBurst:           defaultReconcileBurst,
+		KeyConflictPolicy:        KeyConflictLastWriterWins,
+		RecordKey:                RecordKeyPodName,
+		RecordUpdatePolicy:       RecordUpdateImmutable,
+		NodeNameMatch:            NodeNameMatchExact,
+		OptInLabelValue:          OptInLabelValueStrict,
+		PreemptedPodPolicy:       PreemptedPodReclaim,
+		MetricLabels:             MetricLabelsAuto,
+		NodeIdentityChangePolicy: NodeIdentitySameNode,
+		Mode:                     ModeHard,
+		StoreType:                StoreTypeAnnotation,
+	}
+}
+
+// getStableArgs decodes the plugin args and validates them.
+func getStableArgs(configuration *runtime.Unknown) (*StableArgs, error) {
+	args := defaultStableArgs()
+	if err := framework.DecodeInto(configuration, args); err != nil {
+		return nil, fmt.Errorf("failed to decode %s args: %v", Name, err)
+	}
+	if err := checkUnknownArgs(configuration); err != nil {
+		return nil, fmt.Errorf("failed to decode %s args: %v", Name, err)
+	}
+	if err := validateStableArgs(args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	return args, nil
+}
+
+// checkUnknownArgs rejects JSON or YAML args with fields StableArgs doesn't have, so a
+// misspelled arg fails the profile at startup instead of being ignored.
+func checkUnknownArgs(configuration *runtime.Unknown) error {
+	if configuration == nil || len(configuration.Raw) == 0 {
+		return nil
+	}
+	raw := configuration.Raw
+	switch configuration.ContentType {
+	case runtime.ContentTypeJSON, "":
+	case runtime.ContentTypeYAML:
+		var err error
+		if raw, err = yaml.YAMLToJSON(raw); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&StableArgs{})
+}
+
+func validateStableArgs(args *StableArgs) error {
+	if args.StickyOrdinals != "" {
+		if _, err := parseOrdinalRanges(args.StickyOrdinals); err != nil {
+			return fmt.Errorf("stickyOrdinals: %v", err)
+		}
+	}
+	if _, err := labels.Parse(args.NodeReadinessSelector); err != nil {
+		return fmt.Errorf("nodeReadinessSelector: %v", err)
+	}
+	if args.ReconcileWorkers < 0 {
+		return fmt.Errorf("reconcileWorkers must not be negative, got %d", args.ReconcileWorkers)
+	}
+	if args.ReconcileQPS <= 0 {
+		return fmt.Errorf("reconcileQPS must be positive, got %v", args.ReconcileQPS)
+	}
+	if args.ReconcileBurst <= 0 {
+		return fmt.Errorf("reconcileBurst must be positive, got %d", args.ReconcileBurst)
+	}
+	switch args.KeyConflictPolicy {
+	case KeyConflictLastWriterWins, KeyConflictReject:
+	default:
+		return fmt.Errorf("keyConflictPolicy must be %s or %s, got %q",
+			KeyConflictLastWriterWins, KeyConflictReject, args.KeyConflictPolicy)
+	}
+	switch args.RecordKey {
+	case RecordKeyPodName, RecordKeyOrdinal:
+	default:
+		return fmt.Errorf("recordKey must be %s or %s, got %q", RecordKeyPodName, RecordKeyOrdinal, args.RecordKey)
+	}
+	if args.IdentityAnnotation != "" {
+		if errs := validation.IsQualifiedName(args.IdentityAnnotation); len(errs) > 0 {
+			return fmt.Errorf("identityAnnotation %q is invalid: %s", args.IdentityAnnotation, strings.Join(errs, "; "))
+		}
+	}
+	switch args.RecordUpdatePolicy {
+	case RecordUpdateImmutable, RecordUpdateMutable:
+	default:
+		return fmt.Errorf("recordUpdatePolicy must be %s or %s, got %q",
+			RecordUpdateImmutable, RecordUpdateMutable, args.RecordUpdatePolicy)
+	}
+	switch args.OptInLabelValue {
+	case OptInLabelValueStrict, OptInLabelValueLenient:
+	default:
+		return fmt.Errorf("optInLabelValue must be %s or %s, got %q",
+			OptInLabelValueStrict, OptInLabelValueLenient, args.OptInLabelValue)
+	}
+	switch args.PreemptedPodPolicy {
+	case PreemptedPodReclaim, PreemptedPodMove:
+	default:
+		return fmt.Errorf("preemptedPodPolicy must be %s or %s, got %q",
+			PreemptedPodReclaim, PreemptedPodMove, args.PreemptedPodPolicy)
+	}
+	switch args.MetricLabels {
+	case MetricLabelsAuto, MetricLabelsPerStatefulSet, MetricLabelsAggregate:
+	default:
+		return fmt.Errorf("metricLabels must be %s, %s or %s, got %q",
+			MetricLabelsAuto, MetricLabelsPerStatefulSet, MetricLabelsAggregate, args.MetricLabels)
+	}
+	switch args.Mode {
+	case ModeHard, ModeSoft:
+	default:
+		return fmt.Errorf("mode must be %s or %s, got %q", ModeHard, ModeSoft, args.Mode)
+	}
+	switch args.StoreType {
+	case StoreTypeAnnotation:
+	case StoreTypeConfigMap:
+		if args.Compress {
+			return fmt.Errorf("compress requires storeType %s", StoreTypeAnnotation)
+		}
+		if args.ClusterRecordConfigMap != "" {
+			return fmt.Errorf("clusterRecordConfigMap requires storeType %s", StoreTypeAnnotation)
+		}
+		// the ConfigMaps are owned by statefulsets, which custom owners and owner kinds lack
+		if len(args.CustomOwners) > 0 || len(args.OwnerKinds) > 0 {
+			return fmt.Errorf("customOwners and ownerKinds require storeType %s with clusterRecordConfigMap", StoreTypeAnnotation)
+		}
+	default:
+		return fmt.Errorf("storeType must be %s or %s, got %q", StoreTypeAnnotation, StoreTypeConfigMap, args.StoreType)
+	}
+	for i, storeType := range args.SecondaryStores {
+		if storeType != StoreTypeAnnotation && storeType != StoreTypeConfigMap {
+			return fmt.Errorf("secondaryStores must be %s or %s, got %q", StoreTypeAnnotation, StoreTypeConfigMap, storeType)
+		}
+		if storeType == args.StoreType && args.ClusterRecordConfigMap == "" {
+			return fmt.Errorf("secondaryStores must not include storeType %s the records are read from", storeType)
+		}
+		if hasStoreType(args.SecondaryStores[:i], storeType) {
+			return fmt.Errorf("secondaryStores has %s twice", storeType)
+		}
+		// both store types keep the records of statefulsets, which custom owners and owner kinds lack
+		if len(args.CustomOwners) > 0 || len(args.OwnerKinds) > 0 {
+			return fmt.Errorf("customOwners and ownerKinds don't support secondaryStores")
+		}
+	}
+	if args.OtherNodeScore != 0 {
+		if args.Mode != ModeSoft {
+			return fmt.Errorf("otherNodeScore requires mode %s", ModeSoft)
+		}
+		// the recorded node scores at least MaxNodeScore - TenureMaxScore
+		if maxScore := framework.MaxNodeScore - args.TenureMaxScore; args.OtherNodeScore < 0 || args.OtherNodeScore >= maxScore {
+			return fmt.Errorf("otherNodeScore must be between 0 and %d, below the score of the recorded node, got %d",
+				maxScore-1, args.OtherNodeScore)
+		}
+	}
+	switch args.NodeIdentityChangePolicy {
+	case NodeIdentitySameNode, NodeIdentityChangedNode:
+	default:
+		return fmt.Errorf("nodeIdentityChangePolicy must be %s or %s, got %q",
+			NodeIdentitySameNode, NodeIdentityChangedNode, args.NodeIdentityChangePolicy)
+	}
+	if args.LabelKey != "" {
+		if errs := validation.IsQualifiedName(args.LabelKey); len(errs) > 0 {
+			return fmt.Errorf("labelKey must be a label key: %s", strings.Join(errs, ", "))
+		}
+	}
+	if args.RecordAnnotationKey != "" {
+		if errs := validation.IsQualifiedName(args.RecordAnnotationKey); len(errs) > 0 {
+			return fmt.Errorf("recordAnnotationKey must be an annotation key: %s", strings.Join(errs, ", "))
+		}
+	}
+	if args.TopologyKey != "" {
+		if errs := validation.IsQualifiedName(args.TopologyKey); len(errs) > 0 {
+			return fmt.Errorf("topologyKey must be a label key: %s", strings.Join(errs, ", "))
+		}
+	}
+	if args.NodeIdentityLabel != "" {
+		if errs := validation.IsQualifiedName(args.NodeIdentityLabel); len(errs) > 0 {
+			return fmt.Errorf("nodeIdentityLabel must be a label key: %s", strings.Join(errs, ", "))
+		}
+	}
+	switch args.NodeNameMatch {
+	case NodeNameMatchExact, NodeNameMatchTrim, NodeNameMatchTrimIgnoreCase:
+	default:
+		return fmt.Errorf("nodeNameMatch must be %s, %s or %s, got %q",
+			NodeNameMatchExact, NodeNameMatchTrim, NodeNameMatchTrimIgnoreCase, args.NodeNameMatch)
+	}
+	switch args.Fallback {
+	case "":
+	case FallbackPreferred, FallbackRequired:
+		if len(args.FallbackLabelKeys) == 0 {
+			return fmt.Errorf("fallback %s requires fallbackLabelKeys", args.Fallback)
+		}
+	default:
+		return fmt.Errorf("fallback must be %s or %s, got %q", FallbackPreferred, FallbackRequired, args.Fallback)
+	}
+	switch args.ImageLocality {
+	case "", ImageLocalityPreferred, ImageLocalityRequired:
+	default:
+		return fmt.Errorf("imageLocality must be %s or %s, got %q", ImageLocalityPreferred, ImageLocalityRequired, args.ImageLocality)
+	}
+	switch args.SiblingPlacement {
+	case "", SiblingPlacementSpread, SiblingPlacementCluster:
+	default:
+		return fmt.Errorf("siblingPlacement must be %s or %s, got %q", SiblingPlacementSpread, SiblingPlacementCluster, args.SiblingPlacement)
+	}
+	if args.ReturnGraceSeconds < 0 {
+		return fmt.Errorf("returnGraceSeconds must not be negative, got %d", args.ReturnGraceSeconds)
+	}
+	if args.ReturnGraceSeconds > 0 && args.RecordUpdatePolicy != RecordUpdateMutable {
+		return fmt.Errorf("returnGraceSeconds requires recordUpdatePolicy %s", RecordUpdateMutable)
+	}
+	if args.MaxTotalRecordEntries < 0 {
+		return fmt.Errorf("maxTotalRecordEntries must not be negative, got %d", args.MaxTotalRecordEntries)
+	}
+	if args.Compress && args.ClusterRecordConfigMap != "" {
+		return fmt.Errorf("compress is not supported with clusterRecordConfigMap")
+	}
+	if args.MaxRecords < 0 {
+		return fmt.Errorf("maxRecords must not be negative, got %d", args.MaxRecords)
+	}
+	if args.EvictOldestRecordEntries && args.MaxTotalRecordEntries == 0 && args.MaxRecords == 0 {
+		return fmt.Errorf("evictOldestRecordEntries requires maxTotalRecordEntries or maxRecords")
+	}
+	if args.OrderedPlacementTimeoutSeconds < 0 || args.OrderedPlacementTimeoutSeconds > maxOrderedPlacementTimeoutSeconds {
+		return fmt.Errorf("orderedPlacementTimeoutSeconds must be between 0 and %d, got %d",
+			maxOrderedPlacementTimeoutSeconds, args.OrderedPlacementTimeoutSeconds)
+	}
+	if args.TTL.Duration < 0 {
+		return fmt.Errorf("ttl must not be negative, got %v", args.TTL.Duration)
+	}
+	if args.TenureSaturationSeconds < 0 {
+		return fmt.Errorf("tenureSaturationSeconds must not be negative, got %d", args.TenureSaturationSeconds)
+	}
+	if args.TenureSaturationSeconds > 0 && (args.TenureMaxScore < 1 || args.TenureMaxScore > framework.MaxNodeScore) {
+		return fmt.Errorf("tenureMaxScore must be between 1 and %d, got %d", framework.MaxNodeScore, args.TenureMaxScore)
+	}
+	if args.TenureSaturationSeconds == 0 && args.TenureMaxScore != 0 {
+		return fmt.Errorf("tenureMaxScore requires tenureSaturationSeconds")
+	}
+	if args.CordonedNodeGraceSeconds < 0 {
+		return fmt.Errorf("cordonedNodeGraceSeconds must not be negative, got %d", args.CordonedNodeGraceSeconds)
+	}
+	if args.CordonedNodeGraceSeconds > 0 && !args.ClearCordonedNodeRecords {
+		return fmt.Errorf("cordonedNodeGraceSeconds requires clearCordonedNodeRecords")
+	}
+	if args.StuckPendingSeconds < 0 {
+		return fmt.Errorf("stuckPendingSeconds must not be negative, got %d", args.StuckPendingSeconds)
+	}
+	if args.ObservationPeriodSeconds < 0 {
+		return fmt.Errorf("observationPeriodSeconds must not be negative, got %d", args.ObservationPeriodSeconds)
+	}
+	if args.RecordAfterPodCondition != "" {
+		if errs := validation.IsQualifiedName(args.RecordAfterPodCondition); len(errs) > 0 {
+			return fmt.Errorf("recordAfterPodCondition must be a pod condition type: %s", strings.Join(errs, ", "))
+		}
+	}
+	if args.SentinelConfigMap != "" && !isNamespacedName(args.SentinelConfigMap) {
+		return fmt.Errorf("sentinelConfigMap must be namespace/name, got %q", args.SentinelConfigMap)
+	}
+	if args.NodeAllowListConfigMap != "" && !isNamespacedName(args.NodeAllowListConfigMap) {
+		return fmt.Errorf("nodeAllowListConfigMap must be namespace/name, got %q", args.NodeAllowListConfigMap)
+	}
+	if args.ClusterRecordConfigMap != "" && !isNamespacedName(args.ClusterRecordConfigMap) {
+		return fmt.Errorf("clusterRecordConfigMap must be namespace/name, got %q", args.ClusterRecordConfigMap)
+	}
+	if args.DebugListenAddress != "" {
+		if _, _, err := net.SplitHostPort(args.DebugListenAddress); err != nil {
+			return fmt.Errorf("debugListenAddress must be host:port, got %q: %v", args.DebugListenAddress, err)
+		}
+	}
+	if args.PinsExportConfigMap != "" && !isNamespacedName(args.PinsExportConfigMap) {
+		return fmt.Errorf("pinsExportConfigMap must be namespace/name, got %q", args.PinsExportConfigMap)
+	}
+	if args.DiagnosePod != "" && !isNamespacedName(args.DiagnosePod) {
+		return fmt.Errorf("diagnosePod must be namespace/name, got %q", args.DiagnosePod)
+	}
+	if args.PlacementWebhookURL != "" {
+		if u, err := url.Parse(args.PlacementWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("placementWebhookURL must be an http or https URL, got %q", args.PlacementWebhookURL)
+		}
+	}
+	if args.NodeDomainSuffix != "" {
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(args.NodeDomainSuffix, ".")); len(errs) > 0 {
+			return fmt.Errorf("nodeDomainSuffix must be a DNS domain: %s", strings.Join(errs, ", "))
+		}
+	}
+	for _, kind := range args.OwnerKinds {
+		if kind != OwnerKindReplicaSet && kind != OwnerKindDeployment {
+			return fmt.Errorf("ownerKinds must be %s or %s, got %q", OwnerKindReplicaSet, OwnerKindDeployment, kind)
+		}
+	}
+	if len(args.OwnerKinds) > 0 && args.OwnerIdentityLabel == "" {
+		return fmt.Errorf("ownerKinds require ownerIdentityLabel")
+	}
+	if args.OwnerIdentityLabel != "" {
+		if len(args.OwnerKinds) == 0 {
+			return fmt.Errorf("ownerIdentityLabel requires ownerKinds")
+		}
+		if errs := validation.IsQualifiedName(args.OwnerIdentityLabel); len(errs) > 0 {
+			return fmt.Errorf("ownerIdentityLabel must be a label key: %s", strings.Join(errs, ", "))
+		}
+	}
+	for _, owner := range args.CustomOwners {
+		if owner.APIVersion == "" || owner.Kind == "" {
+			return fmt.Errorf("customOwners require apiVersion and kind, got %q %q", owner.APIVersion, owner.Kind)
+		}
+		if owner.APIVersion == "apps/v1" && owner.Kind == Kind {
+			return fmt.Errorf("customOwners must not list the StatefulSet kind")
+		}
+		if owner.IdentityLabel != "" {
+			if errs := validation.IsQualifiedName(owner.IdentityLabel); len(errs) > 0 {
+				return fmt.Errorf("identityLabel of custom owner %s must be a label key: %s", owner.Kind, strings.Join(errs, ", "))
+			}
+		}
+	}
+	if args.FederateClusterRecords && args.ClusterRecordConfigMap == "" {
+		return fmt.Errorf("federateClusterRecords requires clusterRecordConfigMap")
+	}
+	return nil
+}
+
+// isNamespacedName checks whether the value is a "namespace/name" key.
+func isNamespacedName(value string) bool {
+	namespace, name, err := cache.SplitMetaNamespaceKey(value)
+	return err == nil && namespace != "" && name != ""
+}
@@ -0,0 +1,883 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestGetStableArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		obj         *runtime.Unknown
+		expected    *StableArgs
+		expectError bool
+	}{
+		{
+			name:     "nil args",
+			expected: defaultStableArgs(),
+		},
+		{
+			name:     "empty args",
+			obj:      &runtime.Unknown{Raw: []byte(`{}`)},
+			expected: defaultStableArgs(),
+		},
+		{
+			name: "sticky ordinals",
+			obj:  &runtime.Unknown{Raw: []byte(`{"stickyOrdinals":"0-2,5"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.StickyOrdinals = "0-2,5"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid sticky ordinals",
+			obj:         &runtime.Unknown{Raw: []byte(`{"stickyOrdinals":"2-0"}`)},
+			expectError: true,
+		},
+		{
+			name: "node readiness selector",
+			obj:  &runtime.Unknown{Raw: []byte(`{"nodeReadinessSelector":"example.com/initialized=true"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.NodeReadinessSelector = "example.com/initialized=true"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid node readiness selector",
+			obj:         &runtime.Unknown{Raw: []byte(`{"nodeReadinessSelector":"a=b=c"}`)},
+			expectError: true,
+		},
+		{
+			name: "reconcile",
+			obj:  &runtime.Unknown{Raw: []byte(`{"reconcileWorkers":2,"reconcileQPS":5,"reconcileBurst":10}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ReconcileWorkers, args.ReconcileQPS, args.ReconcileBurst = 2, 5, 10
+				return args
+			}(),
+		},
+		{
+			name:        "negative reconcile workers",
+			obj:         &runtime.Unknown{Raw: []byte(`{"reconcileWorkers":-1}`)},
+			expectError: true,
+		},
+		{
+			name:        "zero reconcile qps",
+			obj:         &runtime.Unknown{Raw: []byte(`{"reconcileQPS":0}`)},
+			expectError: true,
+		},
+		{
+			name: "reject key conflicts",
+			obj:  &runtime.Unknown{Raw: []byte(`{"keyConflictPolicy":"Reject"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.KeyConflictPolicy = KeyConflictReject
+				return args
+			}(),
+		},
+		{
+			name:        "unknown key conflict policy",
+			obj:         &runtime.Unknown{Raw: []byte(`{"keyConflictPolicy":"Merge"}`)},
+			expectError: true,
+		},
+		{
+			name: "ordinal record key",
+			obj:  &runtime.Unknown{Raw: []byte(`{"recordKey":"ordinal"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RecordKey = RecordKeyOrdinal
+				return args
+			}(),
+		},
+		{
+			name:        "unknown record key",
+			obj:         &runtime.Unknown{Raw: []byte(`{"recordKey":"uid"}`)},
+			expectError: true,
+		},
+		{
+			name: "identity annotation",
+			obj:  &runtime.Unknown{Raw: []byte(`{"identityAnnotation":"example.com/shard"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.IdentityAnnotation = "example.com/shard"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid identity annotation",
+			obj:         &runtime.Unknown{Raw: []byte(`{"identityAnnotation":"example.com/shard/id"}`)},
+			expectError: true,
+		},
+		{
+			name: "sentinel ConfigMap",
+			obj:  &runtime.Unknown{Raw: []byte(`{"sentinelConfigMap":"kube-system/statefulset-stable"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.SentinelConfigMap = "kube-system/statefulset-stable"
+				return args
+			}(),
+		},
+		{
+			name:        "sentinel ConfigMap without namespace",
+			obj:         &runtime.Unknown{Raw: []byte(`{"sentinelConfigMap":"statefulset-stable"}`)},
+			expectError: true,
+		},
+		{
+			name: "mutable records",
+			obj:  &runtime.Unknown{Raw: []byte(`{"recordUpdatePolicy":"Mutable"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RecordUpdatePolicy = RecordUpdateMutable
+				return args
+			}(),
+		},
+		{
+			name:        "unknown record update policy",
+			obj:         &runtime.Unknown{Raw: []byte(`{"recordUpdatePolicy":"Sometimes"}`)},
+			expectError: true,
+		},
+		{
+			name:        "negative observation period",
+			obj:         &runtime.Unknown{Raw: []byte(`{"observationPeriodSeconds":-1}`)},
+			expectError: true,
+		},
+		{
+			name: "trimmed case insensitive node names",
+			obj:  &runtime.Unknown{Raw: []byte(`{"nodeNameMatch":"TrimIgnoreCase"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.NodeNameMatch = NodeNameMatchTrimIgnoreCase
+				return args
+			}(),
+		},
+		{
+			name:        "unknown node name match",
+			obj:         &runtime.Unknown{Raw: []byte(`{"nodeNameMatch":"Fuzzy"}`)},
+			expectError: true,
+		},
+		{
+			name: "required fallback",
+			obj:  &runtime.Unknown{Raw: []byte(`{"fallback":"Required","fallbackLabelKeys":["topology.kubernetes.io/zone"]}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.Fallback = FallbackRequired
+				args.FallbackLabelKeys = []string{"topology.kubernetes.io/zone"}
+				return args
+			}(),
+		},
+		{
+			name:        "fallback without label keys",
+			obj:         &runtime.Unknown{Raw: []byte(`{"fallback":"Preferred"}`)},
+			expectError: true,
+		},
+		{
+			name:        "unknown fallback",
+			obj:         &runtime.Unknown{Raw: []byte(`{"fallback":"Anywhere","fallbackLabelKeys":["zone"]}`)},
+			expectError: true,
+		},
+		{
+			name:        "node allow-list ConfigMap without namespace",
+			obj:         &runtime.Unknown{Raw: []byte(`{"nodeAllowListConfigMap":"allowed-nodes"}`)},
+			expectError: true,
+		},
+		{
+			name: "return grace window",
+			obj:  &runtime.Unknown{Raw: []byte(`{"recordUpdatePolicy":"Mutable","returnGraceSeconds":600}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RecordUpdatePolicy = RecordUpdateMutable
+				args.ReturnGraceSeconds = 600
+				return args
+			}(),
+		},
+		{
+			name:        "return grace window with immutable records",
+			obj:         &runtime.Unknown{Raw: []byte(`{"returnGraceSeconds":600}`)},
+			expectError: true,
+		},
+		{
+			name: "required image locality",
+			obj:  &runtime.Unknown{Raw: []byte(`{"imageLocality":"Required"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ImageLocality = ImageLocalityRequired
+				return args
+			}(),
+		},
+		{
+			name:        "unknown image locality",
+			obj:         &runtime.Unknown{Raw: []byte(`{"imageLocality":"Always"}`)},
+			expectError: true,
+		},
+		{
+			name: "federated cluster records",
+			obj:  &runtime.Unknown{Raw: []byte(`{"clusterRecordConfigMap":"kube-system/statefulset-records","federateClusterRecords":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ClusterRecordConfigMap = "kube-system/statefulset-records"
+				args.FederateClusterRecords = true
+				return args
+			}(),
+		},
+		{
+			name:        "federated records without cluster records",
+			obj:         &runtime.Unknown{Raw: []byte(`{"federateClusterRecords":true}`)},
+			expectError: true,
+		},
+		{
+			name: "diagnosed pod",
+			obj:  &runtime.Unknown{Raw: []byte(`{"diagnosePod":"n1/web-0"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.DiagnosePod = "n1/web-0"
+				return args
+			}(),
+		},
+		{
+			name:        "diagnosed pod without namespace",
+			obj:         &runtime.Unknown{Raw: []byte(`{"diagnosePod":"web-0"}`)},
+			expectError: true,
+		},
+		{
+			name: "tenure weighting",
+			obj:  &runtime.Unknown{Raw: []byte(`{"tenureSaturationSeconds":86400,"tenureMaxScore":30}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.TenureSaturationSeconds, args.TenureMaxScore = 86400, 30
+				return args
+			}(),
+		},
+		{
+			name:        "tenure weighting above the max node score",
+			obj:         &runtime.Unknown{Raw: []byte(`{"tenureSaturationSeconds":86400,"tenureMaxScore":101}`)},
+			expectError: true,
+		},
+		{
+			name:        "tenure max score without saturation",
+			obj:         &runtime.Unknown{Raw: []byte(`{"tenureMaxScore":30}`)},
+			expectError: true,
+		},
+		{
+			name: "consistent hashing",
+			obj:  &runtime.Unknown{Raw: []byte(`{"consistentHashing":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ConsistentHashing = true
+				return args
+			}(),
+		},
+		{
+			name: "clear cordoned node records",
+			obj:  &runtime.Unknown{Raw: []byte(`{"clearCordonedNodeRecords":true,"cordonedNodeGraceSeconds":600}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ClearCordonedNodeRecords, args.CordonedNodeGraceSeconds = true, 600
+				return args
+			}(),
+		},
+		{
+			name:        "cordoned node grace without clearing",
+			obj:         &runtime.Unknown{Raw: []byte(`{"cordonedNodeGraceSeconds":600}`)},
+			expectError: true,
+		},
+		{
+			name: "cluster sibling placement",
+			obj:  &runtime.Unknown{Raw: []byte(`{"siblingPlacement":"Cluster"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.SiblingPlacement = SiblingPlacementCluster
+				return args
+			}(),
+		},
+		{
+			name:        "invalid sibling placement",
+			obj:         &runtime.Unknown{Raw: []byte(`{"siblingPlacement":"cluster"}`)},
+			expectError: true,
+		},
+		{
+			name: "pins export",
+			obj:  &runtime.Unknown{Raw: []byte(`{"pinsExportConfigMap":"kube-system/statefulset-stable-pins"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.PinsExportConfigMap = "kube-system/statefulset-stable-pins"
+				return args
+			}(),
+		},
+		{
+			name:        "pins export without namespace",
+			obj:         &runtime.Unknown{Raw: []byte(`{"pinsExportConfigMap":"statefulset-stable-pins"}`)},
+			expectError: true,
+		},
+		{
+			name: "lenient opt-in label value",
+			obj:  &runtime.Unknown{Raw: []byte(`{"optInLabelValue":"Lenient"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.OptInLabelValue = OptInLabelValueLenient
+				return args
+			}(),
+		},
+		{
+			name:        "invalid opt-in label value",
+			obj:         &runtime.Unknown{Raw: []byte(`{"optInLabelValue":"lenient"}`)},
+			expectError: true,
+		},
+		{
+			name: "record TTL",
+			obj:  &runtime.Unknown{Raw: []byte(`{"ttl":"168h"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.TTL = metav1.Duration{Duration: 168 * time.Hour}
+				return args
+			}(),
+		},
+		{
+			name:        "negative record TTL",
+			obj:         &runtime.Unknown{Raw: []byte(`{"ttl":"-1h"}`)},
+			expectError: true,
+		},
+		{
+			name: "record after pod condition",
+			obj:  &runtime.Unknown{Raw: []byte(`{"recordAfterPodCondition":"example.com/healthy"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RecordAfterPodCondition = "example.com/healthy"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid record after pod condition",
+			obj:         &runtime.Unknown{Raw: []byte(`{"recordAfterPodCondition":"not a condition"}`)},
+			expectError: true,
+		},
+		{
+			name: "record entry cap with eviction",
+			obj:  &runtime.Unknown{Raw: []byte(`{"maxTotalRecordEntries":10000,"evictOldestRecordEntries":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.MaxTotalRecordEntries, args.EvictOldestRecordEntries = 10000, true
+				return args
+			}(),
+		},
+		{
+			name:        "eviction without record entry cap",
+			obj:         &runtime.Unknown{Raw: []byte(`{"evictOldestRecordEntries":true}`)},
+			expectError: true,
+		},
+		{
+			name: "records scoped by revision",
+			obj:  &runtime.Unknown{Raw: []byte(`{"scopeRecordsByRevision":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ScopeRecordsByRevision = true
+				return args
+			}(),
+		},
+		{
+			name: "node epoch enforced",
+			obj:  &runtime.Unknown{Raw: []byte(`{"enforceNodeEpoch":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.EnforceNodeEpoch = true
+				return args
+			}(),
+		},
+		{
+			name: "placement webhook",
+			obj:  &runtime.Unknown{Raw: []byte(`{"placementWebhookURL":"https://cmdb.example.com/placements"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.PlacementWebhookURL = "https://cmdb.example.com/placements"
+				return args
+			}(),
+		},
+		{
+			name:        "placement webhook without scheme",
+			obj:         &runtime.Unknown{Raw: []byte(`{"placementWebhookURL":"cmdb.example.com/placements"}`)},
+			expectError: true,
+		},
+		{
+			name: "node domain suffix",
+			obj:  &runtime.Unknown{Raw: []byte(`{"nodeDomainSuffix":"example.internal"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.NodeDomainSuffix = "example.internal"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid node domain suffix",
+			obj:         &runtime.Unknown{Raw: []byte(`{"nodeDomainSuffix":"Example_Internal"}`)},
+			expectError: true,
+		},
+		{
+			name: "preempted pods move",
+			obj:  &runtime.Unknown{Raw: []byte(`{"preemptedPodPolicy":"Move"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.PreemptedPodPolicy = PreemptedPodMove
+				return args
+			}(),
+		},
+		{
+			name:        "invalid preempted pod policy",
+			obj:         &runtime.Unknown{Raw: []byte(`{"preemptedPodPolicy":"Evict"}`)},
+			expectError: true,
+		},
+		{
+			name: "aggregate metrics",
+			obj:  &runtime.Unknown{Raw: []byte(`{"metricLabels":"Aggregate"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.MetricLabels = MetricLabelsAggregate
+				return args
+			}(),
+		},
+		{
+			name:        "invalid metric labels",
+			obj:         &runtime.Unknown{Raw: []byte(`{"metricLabels":"PerPod"}`)},
+			expectError: true,
+		},
+		{
+			name: "recorded node score floor",
+			obj:  &runtime.Unknown{Raw: []byte(`{"recordedNodeScoreFloor":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RecordedNodeScoreFloor = true
+				return args
+			}(),
+		},
+		{
+			name: "custom owners",
+			obj:  &runtime.Unknown{Raw: []byte(`{"clusterRecordConfigMap":"kube-system/records","customOwners":[{"apiVersion":"example.com/v1","kind":"Database","identityLabel":"example.com/cluster"}]}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.ClusterRecordConfigMap = "kube-system/records"
+				args.CustomOwners = []CustomOwner{{APIVersion: "example.com/v1", Kind: "Database", IdentityLabel: "example.com/cluster"}}
+				return args
+			}(),
+		},
+		{
+			name:        "custom owner without kind",
+			obj:         &runtime.Unknown{Raw: []byte(`{"customOwners":[{"apiVersion":"example.com/v1"}]}`)},
+			expectError: true,
+		},
+		{
+			name:        "invalid custom owner identity label",
+			obj:         &runtime.Unknown{Raw: []byte(`{"customOwners":[{"apiVersion":"example.com/v1","kind":"Database","identityLabel":"-cluster"}]}`)},
+			expectError: true,
+		},
+		{
+			name: "node identity label",
+			obj:  &runtime.Unknown{Raw: []byte(`{"nodeIdentityLabel":"example.com/instance-id","nodeIdentityChangePolicy":"ChangedNode"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.NodeIdentityLabel = "example.com/instance-id"
+				args.NodeIdentityChangePolicy = NodeIdentityChangedNode
+				return args
+			}(),
+		},
+		{
+			name:        "invalid node identity change policy",
+			obj:         &runtime.Unknown{Raw: []byte(`{"nodeIdentityChangePolicy":"Ignore"}`)},
+			expectError: true,
+		},
+		{
+			name:        "invalid node identity label",
+			obj:         &runtime.Unknown{Raw: []byte(`{"nodeIdentityLabel":"instance id"}`)},
+			expectError: true,
+		},
+		{
+			name: "enforce after volume bound",
+			obj:  &runtime.Unknown{Raw: []byte(`{"enforceAfterVolumeBound":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.EnforceAfterVolumeBound = true
+				return args
+			}(),
+		},
+		{
+			name: "skip without opt-ins",
+			obj:  &runtime.Unknown{Raw: []byte(`{"skipWithoutOptIns":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.SkipWithoutOptIns = true
+				return args
+			}(),
+		},
+		{
+			name: "soft mode",
+			obj:  &runtime.Unknown{Raw: []byte(`{"mode":"Soft"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.Mode = ModeSoft
+				return args
+			}(),
+		},
+		{
+			name: "other node score",
+			obj:  &runtime.Unknown{Raw: []byte(`{"mode":"Soft","otherNodeScore":50}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.Mode = ModeSoft
+				args.OtherNodeScore = 50
+				return args
+			}(),
+		},
+		{
+			name:        "other node score in hard mode",
+			obj:         &runtime.Unknown{Raw: []byte(`{"otherNodeScore":50}`)},
+			expectError: true,
+		},
+		{
+			name:        "other node score not below the recorded node",
+			obj:         &runtime.Unknown{Raw: []byte(`{"mode":"Soft","otherNodeScore":100}`)},
+			expectError: true,
+		},
+		{
+			name:        "other node score not below the recorded node with tenure",
+			obj:         &runtime.Unknown{Raw: []byte(`{"mode":"Soft","otherNodeScore":80,"tenureSaturationSeconds":3600,"tenureMaxScore":30}`)},
+			expectError: true,
+		},
+		{
+			name: "hard mode",
+			obj:  &runtime.Unknown{Raw: []byte(`{"mode":"Hard"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.Mode = ModeHard
+				return args
+			}(),
+		},
+		{
+			name:        "invalid mode",
+			obj:         &runtime.Unknown{Raw: []byte(`{"mode":"hard"}`)},
+			expectError: true,
+		},
+		{
+			name: "label key",
+			obj:  &runtime.Unknown{Raw: []byte(`{"labelKey":"example.com/sticky"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.LabelKey = "example.com/sticky"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid label key",
+			obj:         &runtime.Unknown{Raw: []byte(`{"labelKey":"example.com/sticky pods"}`)},
+			expectError: true,
+		},
+		{
+			name: "pin events",
+			obj:  &runtime.Unknown{Raw: []byte(`{"pinEvents":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.PinEvents = true
+				return args
+			}(),
+		},
+		{
+			name: "pin to deleted nodes",
+			obj:  &runtime.Unknown{Raw: []byte(`{"pinToDeletedNodes":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.PinToDeletedNodes = true
+				return args
+			}(),
+		},
+		{
+			name: "replicaset owners",
+			obj:  &runtime.Unknown{Raw: []byte(`{"ownerKinds":["ReplicaSet"],"ownerIdentityLabel":"example.com/shard"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.OwnerKinds = []string{OwnerKindReplicaSet}
+				args.OwnerIdentityLabel = "example.com/shard"
+				return args
+			}(),
+		},
+		{
+			name: "deployment owners",
+			obj:  &runtime.Unknown{Raw: []byte(`{"ownerKinds":["Deployment"],"ownerIdentityLabel":"example.com/shard"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.OwnerKinds = []string{OwnerKindDeployment}
+				args.OwnerIdentityLabel = "example.com/shard"
+				return args
+			}(),
+		},
+		{
+			name:        "unsupported owner kind",
+			obj:         &runtime.Unknown{Raw: []byte(`{"ownerKinds":["Job"],"ownerIdentityLabel":"example.com/shard"}`)},
+			expectError: true,
+		},
+		{
+			name:        "owner kinds without identity label",
+			obj:         &runtime.Unknown{Raw: []byte(`{"ownerKinds":["ReplicaSet"]}`)},
+			expectError: true,
+		},
+		{
+			name: "configmap store",
+			obj:  &runtime.Unknown{Raw: []byte(`{"storeType":"ConfigMap"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.StoreType = StoreTypeConfigMap
+				return args
+			}(),
+		},
+		{
+			name:        "configmap store with cluster record",
+			obj:         &runtime.Unknown{Raw: []byte(`{"storeType":"ConfigMap","clusterRecordConfigMap":"kube-system/statefulset-records"}`)},
+			expectError: true,
+		},
+		{
+			name:        "unknown store type",
+			obj:         &runtime.Unknown{Raw: []byte(`{"storeType":"CRD"}`)},
+			expectError: true,
+		},
+		{
+			name: "secondary store",
+			obj:  &runtime.Unknown{Raw: []byte(`{"storeType":"ConfigMap","secondaryStores":["Annotation"]}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.StoreType = StoreTypeConfigMap
+				args.SecondaryStores = []string{StoreTypeAnnotation}
+				return args
+			}(),
+		},
+		{
+			name:        "secondary store of the primary store type",
+			obj:         &runtime.Unknown{Raw: []byte(`{"secondaryStores":["Annotation"]}`)},
+			expectError: true,
+		},
+		{
+			name:        "unknown secondary store type",
+			obj:         &runtime.Unknown{Raw: []byte(`{"secondaryStores":["CRD"]}`)},
+			expectError: true,
+		},
+		{
+			name:        "duplicate secondary store",
+			obj:         &runtime.Unknown{Raw: []byte(`{"secondaryStores":["ConfigMap","ConfigMap"]}`)},
+			expectError: true,
+		},
+		{
+			name: "record annotation key",
+			obj:  &runtime.Unknown{Raw: []byte(`{"recordAnnotationKey":"example.com/record"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RecordAnnotationKey = "example.com/record"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid record annotation key",
+			obj:         &runtime.Unknown{Raw: []byte(`{"recordAnnotationKey":"example.com/"}`)},
+			expectError: true,
+		},
+		{
+			name: "topology key",
+			obj:  &runtime.Unknown{Raw: []byte(`{"topologyKey":"topology.kubernetes.io/zone"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.TopologyKey = "topology.kubernetes.io/zone"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid topology key",
+			obj:         &runtime.Unknown{Raw: []byte(`{"topologyKey":"topology.kubernetes.io/zone name"}`)},
+			expectError: true,
+		},
+		{
+			name: "ordered placement timeout",
+			obj:  &runtime.Unknown{Raw: []byte(`{"orderedPlacementTimeoutSeconds":60}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.OrderedPlacementTimeoutSeconds = 60
+				return args
+			}(),
+		},
+		{
+			name:        "negative ordered placement timeout",
+			obj:         &runtime.Unknown{Raw: []byte(`{"orderedPlacementTimeoutSeconds":-1}`)},
+			expectError: true,
+		},
+		{
+			name:        "ordered placement timeout beyond the permit timeout of the framework",
+			obj:         &runtime.Unknown{Raw: []byte(`{"orderedPlacementTimeoutSeconds":900}`)},
+			expectError: true,
+		},
+		{
+			name: "relax when unschedulable",
+			obj:  &runtime.Unknown{Raw: []byte(`{"relaxWhenUnschedulable":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.RelaxWhenUnschedulable = true
+				return args
+			}(),
+		},
+		{
+			name: "strict record parsing",
+			obj:  &runtime.Unknown{Raw: []byte(`{"strictRecordParsing":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.StrictRecordParsing = true
+				return args
+			}(),
+		},
+		{
+			name: "record cap per statefulset with eviction",
+			obj:  &runtime.Unknown{Raw: []byte(`{"maxRecords":100,"evictOldestRecordEntries":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.MaxRecords, args.EvictOldestRecordEntries = 100, true
+				return args
+			}(),
+		},
+		{
+			name:        "negative record cap per statefulset",
+			obj:         &runtime.Unknown{Raw: []byte(`{"maxRecords":-1}`)},
+			expectError: true,
+		},
+		{
+			name: "compressed records",
+			obj:  &runtime.Unknown{Raw: []byte(`{"compress":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.Compress = true
+				return args
+			}(),
+		},
+		{
+			name:        "compressed records in ConfigMaps",
+			obj:         &runtime.Unknown{Raw: []byte(`{"compress":true,"storeType":"ConfigMap"}`)},
+			expectError: true,
+		},
+		{
+			name: "strict bind",
+			obj:  &runtime.Unknown{Raw: []byte(`{"strictBind":true}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.StrictBind = true
+				return args
+			}(),
+		},
+		{
+			name: "debug listen address",
+			obj:  &runtime.Unknown{Raw: []byte(`{"debugListenAddress":"127.0.0.1:10260"}`)},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.DebugListenAddress = "127.0.0.1:10260"
+				return args
+			}(),
+		},
+		{
+			name:        "invalid debug listen address",
+			obj:         &runtime.Unknown{Raw: []byte(`{"debugListenAddress":"localhost"}`)},
+			expectError: true,
+		},
+		{
+			name:        "unknown args",
+			obj:         &runtime.Unknown{Raw: []byte(`{"stickyOrdinal":"0-2"}`)},
+			expectError: true,
+		},
+		{
+			name:        "unknown nested args",
+			obj:         &runtime.Unknown{Raw: []byte(`{"customOwners":[{"apiVersion":"example.com/v1","kind":"Database","identity":"name"}]}`)},
+			expectError: true,
+		},
+		{
+			name: "yaml args",
+			obj:  &runtime.Unknown{Raw: []byte("stickyOrdinals: 0-2\n"), ContentType: runtime.ContentTypeYAML},
+			expected: func() *StableArgs {
+				args := defaultStableArgs()
+				args.StickyOrdinals = "0-2"
+				return args
+			}(),
+		},
+		{
+			name:        "unknown yaml args",
+			obj:         &runtime.Unknown{Raw: []byte("stickyOrdinal: 0-2\n"), ContentType: runtime.ContentTypeYAML},
+			expectError: true,
+		},
+		{
+			name:        "malformed args",
+			obj:         &runtime.Unknown{Raw: []byte(`{"stickyOrdinals":`)},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := getStableArgs(tt.obj)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %v", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.expected, args) {
+				t.Errorf("expected %v, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestDefaultMode(t *testing.T) {
+	for _, obj := range []*runtime.Unknown{nil, {Raw: []byte(`{}`)}} {
+		args, err := getStableArgs(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args.Mode != ModeHard {
+			t.Errorf("expected mode %s without args, got %q", ModeHard, args.Mode)
+		}
+	}
+}
+
+func TestNewRejectsUnknownMode(t *testing.T) {
+	// the args are validated before the framework handle is used
+	_, err := New(&runtime.Unknown{Raw: []byte(`{"mode":"Sticky"}`)}, nil)
+	if err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+	expected := `invalid statefulset-stable args: mode must be Hard or Soft, got "Sticky"`
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestUnknownArgsError(t *testing.T) {
+	_, err := getStableArgs(&runtime.Unknown{Raw: []byte(`{"mode":"Soft","stickyOrdinal":"0-2"}`)})
+	if err == nil {
+		t.Fatal("expected an error for an unknown arg")
+	}
+	expected := `failed to decode statefulset-stable args: json: unknown field "stickyOrdinal"`
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
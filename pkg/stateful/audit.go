@@ -0,0 +1,104 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// DecisionAdmit is audited when Filter admits a pod on its recorded node.
+	DecisionAdmit = "admit"
+	// DecisionReject is audited when Filter rejects a node because of the record of a pod.
+	DecisionReject = "reject"
+	// DecisionRecord is audited when the node of a pod is written to the record.
+	DecisionRecord = "record"
+)
+
+// Decision is a structured record of a decision of the plugin.
+type Decision struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Namespace   string    `json:"namespace"`
+	StatefulSet string    `json:"statefulset"`
+	Pod         string    `json:"pod"`
+	Node        string    `json:"node"`
+	// RecordedNode is the node recorded for the pod when the decision was made.
+	RecordedNode string `json:"recordedNode,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// AuditSink receives the decisions of the plugin, e.g. to forward them to a logging pipeline.
+// Audit is called from the scheduling goroutines and must not block.
+type AuditSink interface {
+	Audit(decision Decision)
+}
+
+// noopAuditSink drops all decisions, it is the default sink.
+type noopAuditSink struct{}
+
+func (noopAuditSink) Audit(Decision) {}
+
+// jsonAuditSink writes decisions as JSON lines.
+type jsonAuditSink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONAuditSink returns an AuditSink writing the decisions as JSON lines to w.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonAuditSink) Audit(decision Decision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.encoder.Encode(decision); err != nil {
+		klog.Warningf("Failed to audit %s decision of pod %s/%s: %v", decision.Type, decision.Namespace, decision.Pod, err)
+	}
+}
+
+// auditSinkFor returns the sink for the AuditLog arg, "stdout" or the path of a file the decisions
+// are appended to. Decisions are dropped when it is empty.
+func auditSinkFor(auditLog string) (AuditSink, error) {
+	switch auditLog {
+	case "":
+		return noopAuditSink{}, nil
+	case "stdout":
+		return NewJSONAuditSink(os.Stdout), nil
+	}
+	file, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditSink(file), nil
+}
+
+// audit sends a decision to the audit sink.
+func (st *Stable) audit(decision Decision) {
+	if st.auditSink == nil {
+		return
+	}
+	decision.Time = time.Now()
+	st.auditSink.Audit(decision)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+type capturingAuditSink struct {
+	decisions []Decision
+}
+
+func (s *capturingAuditSink) Audit(decision Decision) {
+	s.decisions = append(s.decisions, decision)
+}
+
+func TestAuditDecisions(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	sink := &capturingAuditSink{}
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		auditSink:         sink,
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+	newNodeInfo := func(name string) *schedulernodeinfo.NodeInfo {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil {
+			t.Fatal(err)
+		}
+		return nodeInfo
+	}
+
+	ctx := context.TODO()
+	stableSchedule.Filter(ctx, nil, newPod("web-0"), newNodeInfo("node2"))
+	stableSchedule.Filter(ctx, nil, newPod("web-0"), newNodeInfo("node1"))
+	// web-1 has no record, Filter has nothing to decide
+	stableSchedule.Filter(ctx, nil, newPod("web-1"), newNodeInfo("node2"))
+	stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+	// web-0 is already recorded, nothing is written
+	stableSchedule.PostBind(ctx, nil, newPod("web-0"), "node1")
+
+	expected := []Decision{
+		{Type: DecisionReject, Namespace: "n1", StatefulSet: "web", Pod: "web-0", Node: "node2", RecordedNode: "node1", Reason: "pod is recorded on another node"},
+		{Type: DecisionAdmit, Namespace: "n1", StatefulSet: "web", Pod: "web-0", Node: "node1", RecordedNode: "node1"},
+		{Type: DecisionRecord, Namespace: "n1", StatefulSet: "web", Pod: "web-1", Node: "node2", RecordedNode: "node2", Reason: RecordSourceAuto},
+	}
+	if len(sink.decisions) != len(expected) {
+		t.Fatalf("expected %d decisions, got %v", len(expected), sink.decisions)
+	}
+	for i, decision := range sink.decisions {
+		if decision.Time.IsZero() {
+			t.Errorf("expected decision %d to have a time", i)
+		}
+		decision.Time = time.Time{}
+		if decision != expected[i] {
+			t.Errorf("expected decision %d to be %+v, got %+v", i, expected[i], decision)
+		}
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+	sink.Audit(Decision{Type: DecisionAdmit, Namespace: "n1", StatefulSet: "web", Pod: "web-0", Node: "node1"})
+	sink.Audit(Decision{Type: DecisionRecord, Namespace: "n1", StatefulSet: "web", Pod: "web-1", Node: "node2"})
+
+	decoder := json.NewDecoder(&buf)
+	for _, expected := range []string{"web-0", "web-1"} {
+		var decision Decision
+		if err := decoder.Decode(&decision); err != nil {
+			t.Fatal(err)
+		}
+		if decision.Pod != expected {
+			t.Errorf("expected a decision of pod %v, got %+v", expected, decision)
+		}
+	}
+}
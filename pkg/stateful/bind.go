@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+var _ framework.BindPlugin = &Stable{}
+
+// Bind re-reads the record of the statefulset of the pod from the API server with StrictBind
+// and fails the binding when the pod was recorded on another node since Filter, e.g. by another
+// scheduler, so the pod is scheduled again against the current record. The pod is then bound
+// by the next bind plugin, the plugin doesn't bind pods itself.
+func (st *Stable) Bind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	if !st.args.StrictBind || st.idle() || !st.eligible(pod) {
+		return framework.NewStatus(framework.Skip, "")
+	}
+	s := st.getPreFilterState(ctx, state, pod)
+	if s.statefulset == nil || !s.enforce || s.advisory || st.args.Mode == ModeSoft {
+		// the record doesn't pin the pod to a node
+		return framework.NewStatus(framework.Skip, "")
+	}
+	if recorded := st.currentRecordedNode(ctx, s, pod); recorded != "" && st.nodeAllowed(recorded) && !st.matchesNode(recorded, nodeName) {
+		klog.V(3).Infof("Pod %s/%s was recorded on node %s since it was filtered, not binding it to node %s",
+			pod.Namespace, pod.Name, recorded, nodeName)
+		return framework.NewStatus(framework.Error, fmt.Sprintf("pod %s/%s was recorded on node %s since it was filtered, not binding it to node %s",
+			pod.Namespace, pod.Name, recorded, nodeName))
+	}
+	return framework.NewStatus(framework.Skip, "")
+}
+
+// currentRecordedNode returns the node the pod is recorded on in the latest record of its
+// statefulset, read from the API server, empty when the pod has no entry or the record can't
+// be read. An unreadable record doesn't fail the binding, Filter reports it in the next cycle.
+func (st *Stable) currentRecordedNode(ctx context.Context, s *preFilterState, pod *v1.Pod) string {
+	record, err := st.store.GetLatest(ctx, s.statefulset)
+	if err != nil {
+		klog.V(4).Infof("Failed to read the record of statefulset %s/%s to verify the record of pod %s: %v",
+			s.statefulset.Namespace, s.statefulset.Name, pod.Name, err)
+		return ""
+	}
+	if record == nil {
+		return ""
+	}
+	return record.Records[st.keyOf(pod)].Node
+}
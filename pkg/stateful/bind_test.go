@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func TestStrictBind(t *testing.T) {
+	tests := []struct {
+		name          string
+		strictBind    bool
+		record        string
+		currentRecord string
+		node          string
+		expectedCode  framework.Code
+	}{
+		{
+			name:          "record unchanged",
+			strictBind:    true,
+			record:        `{"Records":{"web-0":"node1"}}`,
+			currentRecord: `{"Records":{"web-0":"node1"}}`,
+			node:          "node1",
+			expectedCode:  framework.Skip,
+		},
+		{
+			name:          "recorded on another node since filter",
+			strictBind:    true,
+			record:        `{"Records":{"web-0":"node1"}}`,
+			currentRecord: `{"Records":{"web-0":"node2"}}`,
+			node:          "node1",
+			expectedCode:  framework.Error,
+		},
+		{
+			name:          "first placement recorded elsewhere since filter",
+			strictBind:    true,
+			record:        `{"Records":{}}`,
+			currentRecord: `{"Records":{"web-0":"node2"}}`,
+			node:          "node1",
+			expectedCode:  framework.Error,
+		},
+		{
+			name:          "entry removed since filter",
+			strictBind:    true,
+			record:        `{"Records":{"web-0":"node1"}}`,
+			currentRecord: `{"Records":{}}`,
+			node:          "node1",
+			expectedCode:  framework.Skip,
+		},
+		{
+			name:          "strict bind disabled",
+			record:        `{"Records":{"web-0":"node1"}}`,
+			currentRecord: `{"Records":{"web-0":"node2"}}`,
+			node:          "node1",
+			expectedCode:  framework.Skip,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{StrictBind: tt.strictBind},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := newOrderedPod("web-0", corev1.PodPending)
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			if status := stableSchedule.PreFilter(ctx, state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter failed: %v", status.Message())
+			}
+			// another scheduler updates the record between Filter and Bind
+			updated := statefulset.DeepCopy()
+			updated.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"] = tt.currentRecord
+			if _, err := clientset.AppsV1().StatefulSets("n1").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if status := stableSchedule.Bind(ctx, state, pod, tt.node); status.Code() != tt.expectedCode {
+				t.Errorf("expected code %v, got %v: %v", tt.expectedCode, status.Code(), status.Message())
+			}
+		})
+	}
+}
+
+func TestStrictBindConfigMapStore(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-schedule-record", Namespace: "n1"},
+		Data:       map[string]string{"record": `{"Records":{"web-0":"node1"}}`},
+	}
+	clientset := fake.NewSimpleClientset(statefulset, configMap)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newConfigMapStore(clientset, configMapInformer.Lister()),
+		args:              StableArgs{StrictBind: true},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+	pod := newOrderedPod("web-0", corev1.PodPending)
+
+	ctx := context.TODO()
+	state := framework.NewCycleState()
+	if status := stableSchedule.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter failed: %v", status.Message())
+	}
+	// another scheduler updates the record between Filter and Bind, the cache still holds node1
+	updated := configMap.DeepCopy()
+	updated.Data["record"] = `{"Records":{"web-0":"node2"}}`
+	if _, err := clientset.CoreV1().ConfigMaps("n1").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := stableSchedule.Bind(ctx, state, pod, "node1"); status.Code() != framework.Error {
+		t.Errorf("expected code %v, got %v: %v", framework.Error, status.Code(), status.Message())
+	}
+}
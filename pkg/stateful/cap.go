@@ -0,0 +1,336 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// recordEntryRef is an entry of the record of a statefulset, for the global record cap.
+type recordEntryRef struct {
+	statefulset *appsv1.StatefulSet
+	key         string
+	// at is the time the entry was last recorded or confirmed, zero when unknown.
+	at time.Time
+}
+
+// lastRecordedAt returns the time the entry of the key was last recorded or confirmed, zero
+// when the record has no time for it.
+func (r *ScheduleRecord) lastRecordedAt(key string) time.Time {
+	if at := r.Records[key].RecordedAt; !at.IsZero() {
+		return at.Time
+	}
+	return r.Records[key].Since.Time
+}
+
+// admitStatefulSetEntry checks whether a new entry may be added to the record of the
+// statefulset without exceeding MaxRecords. With EvictOldestRecordEntries, the least recently
+// recorded entries of the record are evicted to make room, entries without a time first.
+func (st *Stable) admitStatefulSetEntry(statefulset *appsv1.StatefulSet, record *ScheduleRecord) bool {
+	if st.args.MaxRecords <= 0 {
+		return true
+	}
+	excess := len(record.Records) - st.args.MaxRecords + 1
+	if excess <= 0 {
+		return true
+	}
+	if !st.args.EvictOldestRecordEntries {
+		klog.Warningf("Not recording a new entry for statefulset %s/%s, its %d record entries reached maxRecords",
+			statefulset.Namespace, statefulset.Name, len(record.Records))
+		recordEntriesSkipped.Inc()
+		return false
+	}
+
+	keys := make([]string, 0, len(record.Records))
+	for key := range record.Records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := record.lastRecordedAt(keys[i]), record.lastRecordedAt(keys[j])
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys[:excess] {
+		record.deleteEntry(key)
+	}
+	klog.V(2).Infof("Evicted the entries %v of statefulset %s/%s, its record entries reached maxRecords",
+		keys[:excess], statefulset.Namespace, statefulset.Name)
+	recordEntriesEvicted.Add(float64(excess))
+	return true
+}
+
+// recordEntriesCapInterval is the interval between two recounts of the record entries for
+// MaxTotalRecordEntries.
+const recordEntriesCapInterval = time.Minute
+
+// recordEntryCounts keeps the number of record entries of each statefulset for
+// MaxTotalRecordEntries, so recording a new entry doesn't read the records of all statefulsets.
+type recordEntryCounts struct {
+	lock    sync.Mutex
+	counted bool
+	byKey   map[string]int
+	total   int
+}
+
+// set updates the number of entries of the statefulset of the key.
+func (c *recordEntryCounts) set(key string, count int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.byKey == nil {
+		c.byKey = make(map[string]int)
+	}
+	c.total += count - c.byKey[key]
+	if count == 0 {
+		delete(c.byKey, key)
+	} else {
+		c.byKey[key] = count
+	}
+}
+
+// reset replaces the numbers of entries by the numbers counted from the records.
+func (c *recordEntryCounts) reset(byKey map[string]int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.counted, c.byKey, c.total = true, byKey, 0
+	for _, count := range byKey {
+		c.total += count
+	}
+}
+
+// totalExcept returns the number of entries of the statefulsets other than the one of the key,
+// false until the entries were counted.
+func (c *recordEntryCounts) totalExcept(key string) (int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.total - c.byKey[key], c.counted
+}
+
+// admitRecordEntry checks whether a new entry may be added to the record of the statefulset
+// without exceeding MaxTotalRecordEntries across all statefulsets, from the running counts of
+// the entries. With EvictOldestRecordEntries the entry is admitted, enforceRecordEntriesCap
+// evicts the entries beyond the cap once the record is written.
+func (st *Stable) admitRecordEntry(ctx context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) bool {
+	if st.args.MaxTotalRecordEntries <= 0 || st.args.EvictOldestRecordEntries {
+		return true
+	}
+	key, err := cache.MetaNamespaceKeyFunc(statefulset)
+	if err != nil {
+		return true
+	}
+	others, counted := st.recordEntries.totalExcept(key)
+	if !counted {
+		if _, err := st.countRecordEntries(ctx); err != nil {
+			klog.Errorf("Failed to count the record entries: %v", err)
+			return false
+		}
+		others, _ = st.recordEntries.totalExcept(key)
+	}
+	if total := others + len(record.Records); total >= st.args.MaxTotalRecordEntries {
+		klog.Warningf("Not recording a new entry for statefulset %s/%s, the %d record entries reached the cap",
+			statefulset.Namespace, statefulset.Name, total)
+		recordEntriesSkipped.Inc()
+		return false
+	}
+	return true
+}
+
+// recordEntriesWritten updates the count of the entries of the statefulset after its record was
+// written, and with EvictOldestRecordEntries wakes up the eviction once the entries exceed the cap.
+func (st *Stable) recordEntriesWritten(key string, record *ScheduleRecord) {
+	if st.args.MaxTotalRecordEntries <= 0 {
+		return
+	}
+	st.recordEntries.set(key, len(record.Records))
+	if others, counted := st.recordEntries.totalExcept(key); st.args.EvictOldestRecordEntries && counted &&
+		others+len(record.Records) > st.args.MaxTotalRecordEntries {
+		select {
+		case st.recordEntriesExceeded <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// countRecordEntries reads the records of all statefulsets and resets the counts of their
+// entries, it returns the records by statefulset.
+func (st *Stable) countRecordEntries(ctx context.Context) (map[*appsv1.StatefulSet]*ScheduleRecord, error) {
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[*appsv1.StatefulSet]*ScheduleRecord)
+	counts := make(map[string]int)
+	for _, statefulset := range statefulsets {
+		record, err := st.store.Get(ctx, statefulset)
+		if err != nil || record == nil || len(record.Records) == 0 {
+			continue
+		}
+		records[statefulset] = record
+		if key, err := cache.MetaNamespaceKeyFunc(statefulset); err == nil {
+			counts[key] = len(record.Records)
+		}
+	}
+	st.recordEntries.reset(counts)
+	return records, nil
+}
+
+// runRecordEntriesCap recounts the record entries periodically and whenever a write exceeds
+// the cap, evicting the entries beyond it with EvictOldestRecordEntries.
+func (st *Stable) runRecordEntriesCap(ctx context.Context) {
+	ticker := time.NewTicker(recordEntriesCapInterval)
+	defer ticker.Stop()
+	for {
+		st.enforceRecordEntriesCap(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-st.recordEntriesExceeded:
+		}
+	}
+}
+
+// enforceRecordEntriesCap recounts the record entries of all statefulsets. With
+// EvictOldestRecordEntries, the least recently recorded entries of any statefulset beyond
+// MaxTotalRecordEntries are evicted, entries without a time first. The evictions are written
+// apart from the records of PostBind, whose retries would evict again.
+func (st *Stable) enforceRecordEntriesCap(ctx context.Context) {
+	records, err := st.countRecordEntries(ctx)
+	if err != nil {
+		klog.Errorf("Failed to count the record entries: %v", err)
+		return
+	}
+	if !st.args.EvictOldestRecordEntries {
+		return
+	}
+	var entries []recordEntryRef
+	for owner, record := range records {
+		for key := range record.Records {
+			entries = append(entries, recordEntryRef{statefulset: owner, key: key, at: record.lastRecordedAt(key)})
+		}
+	}
+	excess := len(entries) - st.args.MaxTotalRecordEntries
+	if excess <= 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.at.Equal(b.at) {
+			return a.at.Before(b.at)
+		}
+		if a.statefulset.Namespace != b.statefulset.Namespace {
+			return a.statefulset.Namespace < b.statefulset.Namespace
+		}
+		if a.statefulset.Name != b.statefulset.Name {
+			return a.statefulset.Name < b.statefulset.Name
+		}
+		return a.key < b.key
+	})
+	evicted := make(map[*appsv1.StatefulSet][]recordEntryRef)
+	for _, entry := range entries[:excess] {
+		evicted[entry.statefulset] = append(evicted[entry.statefulset], entry)
+	}
+	for owner, refs := range evicted {
+		st.evictRecordEntries(ctx, owner.Namespace, owner.Name, refs)
+	}
+}
+
+// evictRecordEntries removes the entries from the record of the statefulset, except the
+// entries recorded again since they were counted.
+func (st *Stable) evictRecordEntries(ctx context.Context, namespace, name string, refs []recordEntryRef) {
+	var keys []string
+	var remaining int
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		keys = nil
+		statefulset, err := st.statefulSetLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return err
+		}
+		record, err := st.store.Get(ctx, statefulset)
+		if err != nil || record == nil {
+			return err
+		}
+		for _, ref := range refs {
+			if _, ok := record.Records[ref.key]; ok && record.lastRecordedAt(ref.key).Equal(ref.at) {
+				record.deleteEntry(ref.key)
+				keys = append(keys, ref.key)
+			}
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		remaining = len(record.Records)
+		return st.store.Set(ctx, statefulset, record)
+	})
+	if err != nil {
+		klog.Warningf("Failed to evict the entries of statefulset %s/%s beyond the cap: %v", namespace, name, err)
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	st.recordEntries.set(namespace+"/"+name, remaining)
+	klog.V(2).Infof("Evicted the entries %v of statefulset %s/%s, the record entries reached the cap",
+		keys, namespace, name)
+	recordEntriesEvicted.Add(float64(len(keys)))
+}
+
+// checkRecordSize refuses to write a record whose encoding exceeds the size limit of any of the
+// record stores, the plugin would refuse to decode it and the API server to store it. Records
+// kept in annotations share the size of the other annotations, ConfigMaps have the whole
+// object to themselves. With Compress, the compressed annotation is measured.
+func (st *Stable) checkRecordSize(statefulset *appsv1.StatefulSet, record *ScheduleRecord) error {
+	for _, store := range leafStores(st.store) {
+		size, limit, err := st.recordSize(store, record)
+		if err != nil {
+			return err
+		}
+		if size > limit {
+			return &InvalidRecordError{Reason: fmt.Sprintf("the record of statefulset %s/%s with %d entries would take %d bytes, more than the limit of %d bytes, lower maxRecords",
+				statefulset.Namespace, statefulset.Name, len(record.Records), size, limit)}
+		}
+	}
+	return nil
+}
+
+// recordSize returns the size of the record as the store writes it, and the size limit of the
+// store. Custom stores are held to the limit of the annotation.
+func (st *Stable) recordSize(store RecordStore, record *ScheduleRecord) (int, int, error) {
+	switch s := store.(type) {
+	case *configMapStore, *clusterStore:
+		recordBytes, err := json.Marshal(record)
+		return len(recordBytes), maxConfigMapRecordSize, err
+	case *annotationStore:
+		rec, err := encodeScheduleRecord(record, s.compress)
+		return len(rec), maxRecordSize, err
+	default:
+		rec, err := encodeScheduleRecord(record, st.args.Compress)
+		return len(rec), maxRecordSize, err
+	}
+}
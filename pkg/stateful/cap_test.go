@@ -0,0 +1,332 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestMaxTotalRecordEntries(t *testing.T) {
+	newStatefulSet := func(name, record string) *appsv1.StatefulSet {
+		statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "n1"}}
+		if record != "" {
+			statefulset.Annotations = map[string]string{"statefulset-stable.scheduling.sigs.k8s.io/record": record}
+		}
+		return statefulset
+	}
+	tests := []struct {
+		name            string
+		evict           bool
+		db, web         string
+		expectedDB      string
+		expectedWeb     string
+		expectedSkipped float64
+		expectedEvicted float64
+	}{
+		{
+			name:            "cap reached stops new entries",
+			db:              `{"Records":{"db-0":"node1"},"RecordedAt":{"db-0":"2020-05-01T00:00:00Z"}}`,
+			web:             `{"Records":{"web-0":"node2"},"RecordedAt":{"web-0":"2020-05-31T00:00:00Z"}}`,
+			expectedDB:      `{"Records":{"db-0":"node1"},"RecordedAt":{"db-0":"2020-05-01T00:00:00Z"}}`,
+			expectedWeb:     `{"Records":{"web-0":"node2"},"RecordedAt":{"web-0":"2020-05-31T00:00:00Z"}}`,
+			expectedSkipped: 1,
+		},
+		{
+			name:            "oldest entry of another statefulset is evicted",
+			evict:           true,
+			db:              `{"Records":{"db-0":"node1"},"RecordedAt":{"db-0":"2020-05-01T00:00:00Z"}}`,
+			web:             `{"Records":{"web-0":"node2"},"RecordedAt":{"web-0":"2020-05-31T00:00:00Z"}}`,
+			expectedDB:      `{"Records":{}}`,
+			expectedWeb:     `{"Records":{"web-0":{"Node":"node2","RecordedAt":"2020-05-31T00:00:00Z"},"web-1":{"Node":"node3","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+			expectedEvicted: 1,
+		},
+		{
+			name:            "oldest entry of the same statefulset is evicted",
+			evict:           true,
+			db:              `{"Records":{"db-0":"node1"},"RecordedAt":{"db-0":"2020-05-31T00:00:00Z"}}`,
+			web:             `{"Records":{"web-0":"node2"}}`,
+			expectedDB:      `{"Records":{"db-0":"node1"},"RecordedAt":{"db-0":"2020-05-31T00:00:00Z"}}`,
+			expectedWeb:     `{"Records":{"web-1":{"Node":"node3","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+			expectedEvicted: 1,
+		},
+		{
+			name:        "below the cap",
+			evict:       true,
+			web:         `{"Records":{"web-0":"node2"}}`,
+			expectedWeb: `{"Records":{"web-0":"node2","web-1":{"Node":"node3","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, web := newStatefulSet("db", tt.db), newStatefulSet("web", tt.web)
+			clientset := fake.NewSimpleClientset(db, web)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{MaxTotalRecordEntries: 2, EvictOldestRecordEntries: tt.evict},
+				clock:             clock.NewFakeClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)),
+			}
+			for _, statefulset := range []*appsv1.StatefulSet{db, web} {
+				if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			skipped, err := testutil.GetCounterMetricValue(recordEntriesSkipped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			evicted, err := testutil.GetCounterMetricValue(recordEntriesEvicted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-1",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			stableSchedule.PostBind(ctx, nil, pod, "node3")
+			// PostBind writes the record of web only, the entries beyond the cap are evicted after it
+			s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "db", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s.Annotations, db.Annotations) {
+				t.Errorf("expected PostBind not to write the record of db, got %v", s.Annotations)
+			}
+			for _, name := range []string{"db", "web"} {
+				s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stableSchedule.enforceRecordEntriesCap(ctx)
+
+			for name, expected := range map[string]string{"db": tt.expectedDB, "web": tt.expectedWeb} {
+				s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+					t.Errorf("%s: expected %v, got %v", name, expected, got)
+				}
+			}
+			if got, err := testutil.GetCounterMetricValue(recordEntriesSkipped); err != nil || got-skipped != tt.expectedSkipped {
+				t.Errorf("expected %v skipped entries, got %v (%v)", tt.expectedSkipped, got-skipped, err)
+			}
+			if got, err := testutil.GetCounterMetricValue(recordEntriesEvicted); err != nil || got-evicted != tt.expectedEvicted {
+				t.Errorf("expected %v evicted entries, got %v (%v)", tt.expectedEvicted, got-evicted, err)
+			}
+		})
+	}
+}
+
+func TestMaxRecords(t *testing.T) {
+	tests := []struct {
+		name            string
+		evict           bool
+		record          string
+		expected        string
+		expectedSkipped float64
+		expectedEvicted float64
+	}{
+		{
+			name:            "cap reached stops new entries",
+			record:          `{"Records":{"web-0":"node1","web-2":"node2"},"RecordedAt":{"web-0":"2020-05-01T00:00:00Z","web-2":"2020-05-31T00:00:00Z"}}`,
+			expected:        `{"Records":{"web-0":"node1","web-2":"node2"},"RecordedAt":{"web-0":"2020-05-01T00:00:00Z","web-2":"2020-05-31T00:00:00Z"}}`,
+			expectedSkipped: 1,
+		},
+		{
+			name:            "oldest entry is evicted",
+			evict:           true,
+			record:          `{"Records":{"web-0":"node1","web-2":"node2"},"RecordedAt":{"web-0":"2020-05-31T00:00:00Z","web-2":"2020-05-01T00:00:00Z"}}`,
+			expected:        `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-05-31T00:00:00Z"},"web-1":{"Node":"node3","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+			expectedEvicted: 1,
+		},
+		{
+			name:            "entries without a time are evicted first",
+			evict:           true,
+			record:          `{"Records":{"web-0":"node1","web-2":"node2"},"RecordedAt":{"web-0":"2020-05-01T00:00:00Z"}}`,
+			expected:        `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-05-01T00:00:00Z"},"web-1":{"Node":"node3","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+			expectedEvicted: 1,
+		},
+		{
+			name:     "below the cap",
+			record:   `{"Records":{"web-0":"node1"}}`,
+			expected: `{"Records":{"web-0":"node1","web-1":"node3"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "web",
+					Namespace:   "n1",
+					Annotations: map[string]string{"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			stableSchedule := &Stable{
+				clientset: clientset,
+				store:     newAnnotationStore(clientset),
+				args:      StableArgs{MaxRecords: 2, EvictOldestRecordEntries: tt.evict},
+				clock:     clock.NewFakeClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)),
+			}
+			skipped, err := testutil.GetCounterMetricValue(recordEntriesSkipped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			evicted, err := testutil.GetCounterMetricValue(recordEntriesEvicted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "n1"}}
+
+			ctx := context.TODO()
+			if err := stableSchedule.setScheduleRecord(ctx, statefulset, pod, "node3"); err != nil {
+				t.Fatal(err)
+			}
+			s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+			if got, err := testutil.GetCounterMetricValue(recordEntriesSkipped); err != nil || got-skipped != tt.expectedSkipped {
+				t.Errorf("expected %v skipped entries, got %v (%v)", tt.expectedSkipped, got-skipped, err)
+			}
+			if got, err := testutil.GetCounterMetricValue(recordEntriesEvicted); err != nil || got-evicted != tt.expectedEvicted {
+				t.Errorf("expected %v evicted entries, got %v (%v)", tt.expectedEvicted, got-evicted, err)
+			}
+		})
+	}
+}
+
+func TestCheckRecordSize(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	tests := []struct {
+		name        string
+		entries     int
+		compress    bool
+		store       RecordStore
+		expectError bool
+	}{
+		{
+			name:    "below the limit",
+			entries: 10,
+		},
+		{
+			name:        "beyond the limit",
+			entries:     maxRecordSize / 100,
+			expectError: true,
+		},
+		{
+			name:     "below the limit once compressed",
+			entries:  maxRecordSize / 100,
+			compress: true,
+		},
+		{
+			name:    "beyond the annotation limit in a ConfigMap",
+			entries: maxRecordSize / 100,
+			store:   newConfigMapStore(nil, nil),
+		},
+		{
+			name:        "beyond the ConfigMap limit",
+			entries:     maxConfigMapRecordSize / 100,
+			store:       newConfigMapStore(nil, nil),
+			expectError: true,
+		},
+		{
+			name:        "beyond the annotation limit of a secondary store",
+			entries:     maxRecordSize / 100,
+			store:       newMultiStore(newConfigMapStore(nil, nil), newAnnotationStore(nil)),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &ScheduleRecord{Records: map[string]RecordEntry{}}
+			for i := 0; i < tt.entries; i++ {
+				record.Records[fmt.Sprintf("web-%d", i)] = RecordEntry{Node: fmt.Sprintf("node-%090d", i)}
+			}
+			stableSchedule := &Stable{args: StableArgs{Compress: tt.compress}, store: tt.store}
+			err := stableSchedule.checkRecordSize(statefulset, record)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if err != nil && !isInvalidRecord(err) {
+				t.Errorf("expected an invalid record error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSetScheduleRecordRefusesOversizedRecord(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	record := &ScheduleRecord{Records: map[string]RecordEntry{}}
+	for i := 1; i <= maxRecordSize/100; i++ {
+		record.Records[fmt.Sprintf("web-%d", i)] = RecordEntry{Node: fmt.Sprintf("node-%090d", i)}
+	}
+	store := newMemoryStore()
+	store.records["n1/web"] = record
+	stableSchedule := &Stable{
+		store: store,
+		clock: clock.NewFakeClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1"}}
+
+	err := stableSchedule.setScheduleRecord(context.TODO(), statefulset, pod, "node1")
+	if !isInvalidRecord(err) {
+		t.Fatalf("expected an invalid record error, got %v", err)
+	}
+	if _, ok := store.records["n1/web"].Records["web-0"]; ok {
+		t.Errorf("expected the oversized record not to be written")
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// clusterRecordEntry is the value of a statefulset in the cluster record ConfigMap.
+type clusterRecordEntry struct {
+	// StatefulSet is the namespace/name of the statefulset that wrote the record.
+	StatefulSet string
+	// UID is the UID of the statefulset that wrote the record.
+	UID    types.UID
+	Record json.RawMessage
+}
+
+// clusterStore keeps the schedule records of all statefulsets in a single ConfigMap. Records
+// are keyed by the namespace and name of their statefulset and guarded by its UID, so
+// statefulsets with the same name in other namespaces, or an unrelated statefulset recreated
+// under the same name, never read each other's record. With federate, statefulsets with the
+// same name share one record across namespaces.
+type clusterStore struct {
+	clientset       clientset.Interface
+	lister          corelisters.ConfigMapLister
+	namespace, name string
+	federate        bool
+}
+
+var _ RecordStore = &clusterStore{}
+
+func newClusterStore(clientset clientset.Interface, lister corelisters.ConfigMapLister, namespace, name string, federate bool) *clusterStore {
+	return &clusterStore{clientset: clientset, lister: lister, namespace: namespace, name: name, federate: federate}
+}
+
+// keyOf returns the ConfigMap key of the record of the statefulset. Namespaces can't contain
+// dots, so the namespace and name of different statefulsets never join into the same key.
+func (s *clusterStore) keyOf(statefulset *appsv1.StatefulSet) string {
+	if s.federate {
+		return statefulset.Name
+	}
+	return statefulset.Namespace + "." + statefulset.Name
+}
+
+// owns checks whether the entry was written by the statefulset. Federated entries belong to
+// every statefulset with their name.
+func (s *clusterStore) owns(entry *clusterRecordEntry, statefulset *appsv1.StatefulSet) bool {
+	if s.federate {
+		return true
+	}
+	return entry.StatefulSet == statefulset.Namespace+"/"+statefulset.Name && (entry.UID == "" || entry.UID == statefulset.UID)
+}
+
+// entryOf decodes the entry of the statefulset from the ConfigMap, nil if it has none.
+func (s *clusterStore) entryOf(configMap *v1.ConfigMap, statefulset *appsv1.StatefulSet) (*clusterRecordEntry, error) {
+	value, ok := configMap.Data[s.keyOf(statefulset)]
+	if !ok {
+		return nil, nil
+	}
+	if len(value) > maxConfigMapRecordSize {
+		return nil, &InvalidRecordError{Reason: fmt.Sprintf("size %d exceeds the limit of %d bytes", len(value), maxConfigMapRecordSize)}
+	}
+	entry := &clusterRecordEntry{}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		return nil, &InvalidRecordError{Reason: err.Error()}
+	}
+	return entry, nil
+}
+
+// Get reads the record of the statefulset from the cached ConfigMap. A record written by
+// another statefulset is not returned.
+func (s *clusterStore) Get(_ context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	configMap, err := s.lister.ConfigMaps(s.namespace).Get(s.name)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.recordOf(configMap, statefulset)
+}
+
+// GetLatest reads the record of the statefulset like Get from the ConfigMap read from the API
+// server.
+func (s *clusterStore) GetLatest(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.recordOf(configMap, statefulset)
+}
+
+// recordOf decodes the record of the statefulset from the ConfigMap, nil when it has none.
+func (s *clusterStore) recordOf(configMap *v1.ConfigMap, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	entry, err := s.entryOf(configMap, statefulset)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+	if !s.owns(entry, statefulset) {
+		klog.Warningf("Ignoring the cluster record of statefulset %s/%s, it was written by statefulset %s with UID %s",
+			statefulset.Namespace, statefulset.Name, entry.StatefulSet, entry.UID)
+		return nil, nil
+	}
+	record, err := decodeScheduleRecord(string(entry.Record), maxConfigMapRecordSize)
+	if record != nil {
+		record.stored = configMap.Data[s.keyOf(statefulset)]
+	}
+	return record, err
+}
+
+// storedValue returns the entry of the statefulset in the ConfigMap as Get reads it, empty when
+// the entry was written by another statefulset.
+func (s *clusterStore) storedValue(configMap *v1.ConfigMap, statefulset *appsv1.StatefulSet) string {
+	if entry, err := s.entryOf(configMap, statefulset); err == nil && entry != nil && !s.owns(entry, statefulset) {
+		return ""
+	}
+	return configMap.Data[s.keyOf(statefulset)]
+}
+
+// Set writes the record of the statefulset to the ConfigMap, creating the ConfigMap when it
+// doesn't exist. The record of another statefulset under the same key is replaced. Like
+// configMapStore, an entry written since the record was read fails with a conflict for the
+// caller to read it again, while the conflicts of the writes of other statefulsets to the
+// ConfigMap are retried here.
+func (s *clusterStore) Set(ctx context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	entryBytes, err := json.Marshal(clusterRecordEntry{
+		StatefulSet: statefulset.Namespace + "/" + statefulset.Name,
+		UID:         statefulset.UID,
+		Record:      recordBytes,
+	})
+	if err != nil {
+		return err
+	}
+	changed := false
+	err = retry.OnError(retry.DefaultBackoff, func(err error) bool {
+		return errors.IsConflict(err) && !changed
+	}, func() error {
+		configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			if record.stored != "" && !record.overwrite {
+				changed = true
+				return recordChangedError(s.name, statefulset)
+			}
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: s.name},
+				Data:       map[string]string{s.keyOf(statefulset): string(entryBytes)},
+			}
+			_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Create(ctx, configMap, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// created concurrently, update it instead
+				return errors.NewConflict(v1.Resource("configmaps"), s.name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if s.storedValue(configMap, statefulset) != record.stored && !record.overwrite {
+			changed = true
+			return recordChangedError(s.name, statefulset)
+		}
+		if entry, err := s.entryOf(configMap, statefulset); err == nil && entry != nil && !s.owns(entry, statefulset) {
+			klog.Warningf("Replacing the cluster record of statefulset %s with UID %s by the record of statefulset %s/%s",
+				entry.StatefulSet, entry.UID, statefulset.Namespace, statefulset.Name)
+		}
+		configMapCopy := configMap.DeepCopy()
+		if configMapCopy.Data == nil {
+			configMapCopy.Data = make(map[string]string)
+		}
+		configMapCopy.Data[s.keyOf(statefulset)] = string(entryBytes)
+		_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, configMapCopy, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	record.stored = string(entryBytes)
+	return nil
+}
+
+// Delete removes the record of the statefulset from the ConfigMap. Records written by another
+// statefulset and federated records, which other statefulsets may still use, are kept.
+func (s *clusterStore) Delete(ctx context.Context, statefulset *appsv1.StatefulSet) error {
+	if s.federate {
+		return nil
+	}
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry, err := s.entryOf(configMap, statefulset); err != nil || entry == nil || !s.owns(entry, statefulset) {
+		return nil
+	}
+	configMapCopy := configMap.DeepCopy()
+	delete(configMapCopy.Data, s.keyOf(statefulset))
+	_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, configMapCopy, metav1.UpdateOptions{})
+	return err
+}
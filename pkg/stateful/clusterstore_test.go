@@ -0,0 +1,206 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClusterStore(t *testing.T) {
+	newStatefulSet := func(namespace string, uid types.UID) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace, UID: uid}}
+	}
+	tests := []struct {
+		name     string
+		federate bool
+		writer   *appsv1.StatefulSet
+		reader   *appsv1.StatefulSet
+		expected *ScheduleRecord
+	}{
+		{
+			name:     "statefulset reads its record",
+			writer:   newStatefulSet("n1", "uid1"),
+			reader:   newStatefulSet("n1", "uid1"),
+			expected: &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}},
+		},
+		{
+			name:   "same-named statefulset in another namespace doesn't collide",
+			writer: newStatefulSet("n1", "uid1"),
+			reader: newStatefulSet("n2", "uid2"),
+		},
+		{
+			name:   "recreated statefulset doesn't read the record of the old one",
+			writer: newStatefulSet("n1", "uid1"),
+			reader: newStatefulSet("n1", "uid2"),
+		},
+		{
+			name:     "federated statefulsets share the record",
+			federate: true,
+			writer:   newStatefulSet("n1", "uid1"),
+			reader:   newStatefulSet("n2", "uid2"),
+			expected: &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			configMapInformer := informers.Core().V1().ConfigMaps()
+			store := newClusterStore(clientset, configMapInformer.Lister(), "kube-system", "statefulset-records", tt.federate)
+
+			ctx := context.TODO()
+			if err := store.Set(ctx, tt.writer, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "statefulset-records", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+				t.Fatal(err)
+			}
+
+			record, err := store.Get(ctx, tt.reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (tt.expected == nil) != (record == nil) || (record != nil && !reflect.DeepEqual(tt.expected.Records, record.Records)) {
+				t.Errorf("expected %v, got %v", tt.expected, record)
+			}
+
+			// deleting the record of the reader keeps the record of another statefulset
+			if err := store.Delete(ctx, tt.reader); err != nil {
+				t.Fatal(err)
+			}
+			configMap, err = clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "statefulset-records", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedKeys := 1
+			if tt.expected != nil && !tt.federate {
+				expectedKeys = 0
+			}
+			if len(configMap.Data) != expectedKeys {
+				t.Errorf("expected %d records after delete, got %v", expectedKeys, configMap.Data)
+			}
+		})
+	}
+}
+
+func TestClusterStoreKeepsOtherRecords(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	store := newClusterStore(clientset, configMapInformer.Lister(), "kube-system", "statefulset-records", false)
+
+	ctx := context.TODO()
+	web1 := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "uid1"}}
+	web2 := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n2", UID: "uid2"}}
+	if err := store.Set(ctx, web1, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, web2, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node2"}}}); err != nil {
+		t.Fatal(err)
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "statefulset-records", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+	for statefulset, expected := range map[*appsv1.StatefulSet]string{web1: "node1", web2: "node2"} {
+		record, err := store.Get(ctx, statefulset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record == nil || record.Records["web-0"].Node != expected {
+			t.Errorf("expected statefulset %s/%s to be recorded on %s, got %v", statefulset.Namespace, statefulset.Name, expected, record)
+		}
+	}
+}
+
+func TestClusterStoreConcurrentRecords(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	store := newClusterStore(clientset, configMapInformer.Lister(), "kube-system", "statefulset-records", false)
+	ctx := context.TODO()
+	sync := func() {
+		configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "statefulset-records", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := configMapInformer.Informer().GetIndexer().Update(configMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(statefulset *appsv1.StatefulSet) *ScheduleRecord {
+		record, err := store.Get(ctx, statefulset)
+		if err != nil || record == nil {
+			t.Fatalf("expected the record of statefulset %s/%s, got %v (%v)", statefulset.Namespace, statefulset.Name, record, err)
+		}
+		return record
+	}
+
+	web1 := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "uid1"}}
+	web2 := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n2", UID: "uid2"}}
+	if err := store.Set(ctx, web1, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+
+	// two pods of web1 read the same record, the second write conflicts
+	first, second := get(web1), get(web1)
+	first.Records["web-1"] = RecordEntry{Node: "node2"}
+	if err := store.Set(ctx, web1, first); err != nil {
+		t.Fatal(err)
+	}
+	second.Records["web-2"] = RecordEntry{Node: "node3"}
+	if err := store.Set(ctx, web1, second); !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict writing a stale record, got %v", err)
+	}
+	// the write of another statefulset to the stale ConfigMap doesn't conflict
+	if err := store.Set(ctx, web2, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node4"}}}); err != nil {
+		t.Fatal(err)
+	}
+	// the retry reads the record again once the cache caught up
+	sync()
+	second = get(web1)
+	second.Records["web-2"] = RecordEntry{Node: "node3"}
+	if err := store.Set(ctx, web1, second); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+
+	if expected := map[string]string{"web-0": "node1", "web-1": "node2", "web-2": "node3"}; !reflect.DeepEqual(get(web1).nodes(), expected) {
+		t.Errorf("expected records %v, got %v", expected, get(web1).nodes())
+	}
+	if expected := map[string]string{"web-0": "node4"}; !reflect.DeepEqual(get(web2).nodes(), expected) {
+		t.Errorf("expected records %v, got %v", expected, get(web2).nodes())
+	}
+}
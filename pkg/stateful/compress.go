@@ -0,0 +1,82 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// compressedRecordPrefix marks a record annotation holding the gzip compressed record in
+// base64, the JSON of a record always starts with "{".
+const compressedRecordPrefix = "gzip:"
+
+// maxDecompressedRecordSize is the maximum size in bytes a compressed record may decompress to.
+const maxDecompressedRecordSize = 16 * 1024 * 1024
+
+// encodeScheduleRecord encodes the record for the record annotation, compressed with gzip and
+// in base64 after compressedRecordPrefix when compress is set.
+func encodeScheduleRecord(record *ScheduleRecord, compress bool) (string, error) {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	if !compress {
+		return string(recordBytes), nil
+	}
+	if len(recordBytes) > maxDecompressedRecordSize {
+		return "", &InvalidRecordError{Reason: fmt.Sprintf("size %d exceeds the limit of %d bytes before compression",
+			len(recordBytes), maxDecompressedRecordSize)}
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(recordBytes); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return compressedRecordPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decompressRecord returns the JSON of a record annotation written by encodeScheduleRecord,
+// as is when it isn't compressed.
+func decompressRecord(rec string) (string, error) {
+	if !strings.HasPrefix(rec, compressedRecordPrefix) {
+		return rec, nil
+	}
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.TrimPrefix(rec, compressedRecordPrefix)))
+	reader, err := gzip.NewReader(decoder)
+	if err != nil {
+		return "", &InvalidRecordError{Reason: fmt.Sprintf("decompressing: %v", err)}
+	}
+	defer reader.Close()
+	recordBytes, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedRecordSize+1))
+	if err != nil {
+		return "", &InvalidRecordError{Reason: fmt.Sprintf("decompressing: %v", err)}
+	}
+	if len(recordBytes) > maxDecompressedRecordSize {
+		return "", &InvalidRecordError{Reason: fmt.Sprintf("decompressed size exceeds the limit of %d bytes", maxDecompressedRecordSize)}
+	}
+	return string(recordBytes), nil
+}
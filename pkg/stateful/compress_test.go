@@ -0,0 +1,130 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCompressedRecord(t *testing.T) {
+	large := &ScheduleRecord{Records: map[string]RecordEntry{}}
+	for i := 0; i < 5000; i++ {
+		large.Records[fmt.Sprintf("web-%d", i)] = RecordEntry{Node: fmt.Sprintf("node-%d", i%20)}
+	}
+	tests := []struct {
+		name     string
+		record   *ScheduleRecord
+		compress bool
+	}{
+		{
+			name:   "plain record",
+			record: &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}}},
+		},
+		{
+			name:     "compressed record",
+			record:   &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}}},
+			compress: true,
+		},
+		{
+			name:     "large compressed record",
+			record:   large,
+			compress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+			clientset := fake.NewSimpleClientset(statefulset)
+			store := newAnnotationStore(clientset)
+			store.compress = tt.compress
+
+			ctx := context.TODO()
+			if err := store.Set(ctx, statefulset, tt.record); err != nil {
+				t.Fatal(err)
+			}
+			s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := s.Annotations[StatefulsetStableRecord]
+			if compressed := strings.HasPrefix(rec, compressedRecordPrefix); compressed != tt.compress {
+				t.Errorf("expected compressed %v, got %q", tt.compress, rec)
+			}
+			// records are read regardless of the compression of the store
+			store.compress = !tt.compress
+			record, err := store.Get(ctx, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(record, tt.record) {
+				t.Errorf("expected %v, got %v", tt.record, record)
+			}
+		})
+	}
+}
+
+func TestDecodeScheduleRecordCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		rec         string
+		expected    *ScheduleRecord
+		expectError bool
+	}{
+		{
+			name:     "plain record",
+			rec:      `{"Records":{"web-0":"node1"}}`,
+			expected: &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}},
+		},
+		{
+			name:        "invalid base64",
+			rec:         compressedRecordPrefix + "not base64!",
+			expectError: true,
+		},
+		{
+			name:        "not gzip",
+			rec:         compressedRecordPrefix + "eyJSZWNvcmRzIjp7fX0=",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := decodeScheduleRecord(tt.rec, maxRecordSize)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if err != nil {
+				if !isInvalidRecord(err) {
+					t.Errorf("expected an invalid record error, got %v", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(record, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, record)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// recordConfigMapSuffix is appended to the name of a statefulset to name the ConfigMap
+	// keeping its record with StoreTypeConfigMap.
+	recordConfigMapSuffix = "-schedule-record"
+	// recordConfigMapKey is the key of the record in the ConfigMap.
+	recordConfigMapKey = "record"
+)
+
+// configMapStore keeps the schedule record of each statefulset in a dedicated ConfigMap in the
+// namespace of the statefulset, owned by the statefulset so it is garbage collected with it.
+// Unlike annotationStore, writing a record doesn't update the statefulset, which would wake up
+// the other controllers watching it, nor does the record count against the size of its
+// annotations.
+type configMapStore struct {
+	clientset clientset.Interface
+	lister    corelisters.ConfigMapLister
+}
+
+var _ RecordStore = &configMapStore{}
+
+func newConfigMapStore(clientset clientset.Interface, lister corelisters.ConfigMapLister) *configMapStore {
+	return &configMapStore{clientset: clientset, lister: lister}
+}
+
+// recordConfigMapName returns the name of the ConfigMap keeping the record of the statefulset.
+func recordConfigMapName(statefulset *appsv1.StatefulSet) string {
+	return statefulset.Name + recordConfigMapSuffix
+}
+
+// owns checks whether the ConfigMap belongs to the statefulset. A ConfigMap left behind by a
+// deleted statefulset of the same name, before the garbage collector removes it, doesn't.
+func (s *configMapStore) owns(configMap *v1.ConfigMap, statefulset *appsv1.StatefulSet) bool {
+	if statefulset.UID == "" {
+		return true
+	}
+	for _, ow := range configMap.GetOwnerReferences() {
+		if ow.UID == statefulset.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// Get reads the record of the statefulset from the cached ConfigMap.
+func (s *configMapStore) Get(_ context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	configMap, err := s.lister.ConfigMaps(statefulset.Namespace).Get(recordConfigMapName(statefulset))
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.recordOf(configMap, statefulset)
+}
+
+// GetLatest reads the record of the statefulset from the ConfigMap read from the API server.
+func (s *configMapStore) GetLatest(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(statefulset.Namespace).Get(ctx, recordConfigMapName(statefulset), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.recordOf(configMap, statefulset)
+}
+
+// recordOf decodes the record of the statefulset from its ConfigMap, nil when the ConfigMap
+// has none or belongs to another statefulset.
+func (s *configMapStore) recordOf(configMap *v1.ConfigMap, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	if !s.owns(configMap, statefulset) {
+		klog.V(4).Infof("Ignoring the record ConfigMap %s/%s, it is not owned by statefulset %s with UID %s",
+			configMap.Namespace, configMap.Name, statefulset.Name, statefulset.UID)
+		return nil, nil
+	}
+	value, ok := configMap.Data[recordConfigMapKey]
+	if !ok {
+		return nil, nil
+	}
+	record, err := decodeScheduleRecord(value, maxConfigMapRecordSize)
+	if record != nil {
+		record.stored = value
+	}
+	return record, err
+}
+
+// storedValue returns the record value of the ConfigMap as Get reads it, empty when the
+// ConfigMap belongs to another statefulset.
+func (s *configMapStore) storedValue(configMap *v1.ConfigMap, statefulset *appsv1.StatefulSet) string {
+	if !s.owns(configMap, statefulset) {
+		return ""
+	}
+	return configMap.Data[recordConfigMapKey]
+}
+
+// Set writes the record of the statefulset to its ConfigMap, creating the ConfigMap on the first
+// write. A ConfigMap not owned by the statefulset is taken over. The record is read from the
+// cache, so a ConfigMap written since the record was read, or concurrently, fails with a
+// conflict instead of losing the entries written meanwhile, and the caller reads it again.
+func (s *configMapStore) Set(ctx context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	name := recordConfigMapName(statefulset)
+	configMap, err := s.clientset.CoreV1().ConfigMaps(statefulset.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if record.stored != "" && !record.overwrite {
+			return recordChangedError(name, statefulset)
+		}
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       statefulset.Namespace,
+				Name:            name,
+				OwnerReferences: recordConfigMapOwners(statefulset),
+			},
+			Data: map[string]string{recordConfigMapKey: string(recordBytes)},
+		}
+		_, err = s.clientset.CoreV1().ConfigMaps(statefulset.Namespace).Create(ctx, configMap, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			// created concurrently, the record is read again
+			return errors.NewConflict(v1.Resource("configmaps"), name, err)
+		}
+		if err == nil {
+			record.stored = string(recordBytes)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if s.storedValue(configMap, statefulset) != record.stored && !record.overwrite {
+		return recordChangedError(name, statefulset)
+	}
+	configMapCopy := configMap.DeepCopy()
+	if !s.owns(configMap, statefulset) {
+		klog.Warningf("Taking over the record ConfigMap %s/%s for statefulset %s with UID %s",
+			configMap.Namespace, configMap.Name, statefulset.Name, statefulset.UID)
+		configMapCopy.OwnerReferences = recordConfigMapOwners(statefulset)
+	}
+	if configMapCopy.Data == nil {
+		configMapCopy.Data = make(map[string]string)
+	}
+	configMapCopy.Data[recordConfigMapKey] = string(recordBytes)
+	// the resourceVersion of the ConfigMap just read fails the update on a concurrent write
+	if _, err := s.clientset.CoreV1().ConfigMaps(statefulset.Namespace).Update(ctx, configMapCopy, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	record.stored = string(recordBytes)
+	return nil
+}
+
+// Delete removes the ConfigMap of the statefulset, unless another statefulset owns it.
+func (s *configMapStore) Delete(ctx context.Context, statefulset *appsv1.StatefulSet) error {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(statefulset.Namespace).Get(ctx, recordConfigMapName(statefulset), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !s.owns(configMap, statefulset) {
+		return nil
+	}
+	err = s.clientset.CoreV1().ConfigMaps(statefulset.Namespace).Delete(ctx, configMap.Name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// recordConfigMapOwners returns the owner references of the record ConfigMap of the statefulset,
+// none for a statefulset without UID.
+func recordConfigMapOwners(statefulset *appsv1.StatefulSet) []metav1.OwnerReference {
+	if statefulset.UID == "" {
+		return nil
+	}
+	return []metav1.OwnerReference{{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       Kind,
+		Name:       statefulset.Name,
+		UID:        statefulset.UID,
+	}}
+}
+
+// recordChangedError returns the conflict of writing the record of the statefulset to the
+// ConfigMap after the record changed since it was read. Callers retrying on conflicts read the
+// record again and apply their change to it.
+func recordChangedError(configMapName string, statefulset *appsv1.StatefulSet) error {
+	return errors.NewConflict(v1.Resource("configmaps"), configMapName,
+		fmt.Errorf("the record of statefulset %s/%s changed since it was read", statefulset.Namespace, statefulset.Name))
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "web-uid"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	store := newConfigMapStore(clientset, configMapInformer.Lister())
+	sync := func() *corev1.ConfigMap {
+		configMap, err := clientset.CoreV1().ConfigMaps("n1").Get(ctx, "web-schedule-record", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := configMapInformer.Informer().GetIndexer().Update(configMap); err != nil {
+			t.Fatal(err)
+		}
+		return configMap
+	}
+
+	if record, err := store.Get(ctx, statefulset); err != nil || record != nil {
+		t.Fatalf("expected no record, got %v (%v)", record, err)
+	}
+
+	// the first write creates the ConfigMap owned by the statefulset
+	if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	configMap := sync()
+	if len(configMap.OwnerReferences) != 1 || configMap.OwnerReferences[0].Kind != "StatefulSet" || configMap.OwnerReferences[0].UID != "web-uid" {
+		t.Errorf("expected the ConfigMap to be owned by the statefulset, got %v", configMap.OwnerReferences)
+	}
+
+	// a record that wasn't read from the ConfigMap doesn't replace it
+	if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-1": {Node: "node2"}}}); !errors.IsConflict(err) {
+		t.Errorf("expected a conflict writing a record not read from the ConfigMap, got %v", err)
+	}
+
+	// the next write fails on a concurrent update and succeeds once retried
+	conflicts := 1
+	clientset.PrependReactor("update", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, errors.NewConflict(corev1.Resource("configmaps"), "web-schedule-record", fmt.Errorf("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+	record, err := store.Get(ctx, statefulset)
+	if err != nil || record == nil {
+		t.Fatalf("expected the record, got %v (%v)", record, err)
+	}
+	record.Records["web-1"] = RecordEntry{Node: "node2"}
+	if err := store.Set(ctx, statefulset, record); !errors.IsConflict(err) {
+		t.Errorf("expected the conflict of the update, got %v", err)
+	}
+	if err := store.Set(ctx, statefulset, record); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+	record, err = store.Get(ctx, statefulset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"web-0": "node1", "web-1": "node2"}; record == nil || !reflect.DeepEqual(record.nodes(), expected) {
+		t.Errorf("expected records %v, got %v", expected, record)
+	}
+	// the statefulset itself is never updated
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "statefulsets" && action.GetVerb() == "update" {
+			t.Errorf("expected the statefulset not to be updated, got %v", action)
+		}
+	}
+
+	// a recreated statefulset of the same name doesn't read the record of the old one
+	recreated := statefulset.DeepCopy()
+	recreated.UID = "web-recreated-uid"
+	if record, err := store.Get(ctx, recreated); err != nil || record != nil {
+		t.Errorf("expected no record of the recreated statefulset, got %v (%v)", record, err)
+	}
+	if err := store.Delete(ctx, recreated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("n1").Get(ctx, "web-schedule-record", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the ConfigMap of another statefulset to be kept, got %v", err)
+	}
+
+	if err := store.Delete(ctx, statefulset); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("n1").Get(ctx, "web-schedule-record", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the ConfigMap to be deleted, got %v", err)
+	}
+}
+
+func TestConfigMapStoreConcurrentRecords(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "web-uid"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	configMapInformer := informers.Core().V1().ConfigMaps()
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newConfigMapStore(clientset, configMapInformer.Lister()),
+	}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "n1",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "web"}},
+		}}
+	}
+	sync := func() {
+		configMap, err := clientset.CoreV1().ConfigMaps("n1").Get(ctx, "web-schedule-record", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := configMapInformer.Informer().GetIndexer().Update(configMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := stableSchedule.setScheduleRecord(ctx, statefulset, newPod("web-0"), "node1"); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+
+	// both pods read the record with web-0 from the cache, the second write conflicts
+	if err := stableSchedule.setScheduleRecord(ctx, statefulset, newPod("web-1"), "node2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stableSchedule.setScheduleRecord(ctx, statefulset, newPod("web-2"), "node3"); !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict writing a stale record, got %v", err)
+	}
+	// the retry reads the record again once the cache caught up
+	sync()
+	if err := stableSchedule.setScheduleRecord(ctx, statefulset, newPod("web-2"), "node3"); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+
+	record, err := stableSchedule.store.Get(ctx, statefulset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"web-0": "node1", "web-1": "node2", "web-2": "node3"}; record == nil || !reflect.DeepEqual(record.nodes(), expected) {
+		t.Errorf("expected records %v, got %v", expected, record)
+	}
+}
+
+func TestConfigMapStoreLargeRecord(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "web-uid"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	store := newConfigMapStore(clientset, informers.Core().V1().ConfigMaps().Lister())
+
+	// the record is larger than a record annotation may be
+	record := &ScheduleRecord{Records: map[string]RecordEntry{}}
+	for i := 0; i < maxRecordSize/100; i++ {
+		record.Records[fmt.Sprintf("web-%d", i)] = RecordEntry{Node: fmt.Sprintf("node-%090d", i)}
+	}
+	stableSchedule := &Stable{store: store}
+	if err := stableSchedule.checkRecordSize(statefulset, record); err != nil {
+		t.Fatalf("expected the record to fit in the ConfigMap, got %v", err)
+	}
+	if err := store.Set(ctx, statefulset, record); err != nil {
+		t.Fatal(err)
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("n1").Get(ctx, "web-schedule-record", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := len(configMap.Data["record"]); size <= maxRecordSize {
+		t.Fatalf("expected a record larger than %d bytes, got %d", maxRecordSize, size)
+	}
+	got, err := store.GetLatest(ctx, statefulset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("expected the record to round trip, got none")
+	}
+	if !reflect.DeepEqual(got.nodes(), record.nodes()) {
+		t.Errorf("expected the record to round trip, got %d entries", len(got.Records))
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+// cordonedNodesCheckInterval is the interval between two checks for cordoned nodes.
+const cordonedNodesCheckInterval = 10 * time.Second
+
+// cordonedNodes tracks the nodes seen cordoned by clearCordonedNodeRecords, it is only used by
+// the check loop. A restarted scheduler starts the grace period of cordoned nodes over.
+type cordonedNodes struct {
+	// since maps the cordoned nodes to the time they were first seen cordoned.
+	since map[string]time.Time
+	// cleared are the cordoned nodes whose records were cleared.
+	cleared sets.String
+}
+
+// clearCordonedNodeRecords clears the records of the nodes cordoned for longer than
+// CordonedNodeGraceSeconds, so the pods pinned to them are scheduled elsewhere once they are
+// evicted. It doesn't evict pods, a pod whose record is cleared gets an event suggesting its
+// eviction. Nodes that are uncordoned before the end of the grace period keep their records.
+func (st *Stable) clearCordonedNodeRecords(ctx context.Context) {
+	nodes, err := st.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list nodes to clear the records of cordoned nodes: %v", err)
+		return
+	}
+	if st.cordoned.since == nil {
+		st.cordoned.since = make(map[string]time.Time)
+		st.cordoned.cleared = sets.NewString()
+	}
+	grace := time.Duration(st.args.CordonedNodeGraceSeconds) * time.Second
+	now := st.now()
+	cordoned := sets.NewString()
+	for _, node := range nodes {
+		if !node.Spec.Unschedulable {
+			continue
+		}
+		cordoned.Insert(node.Name)
+		since, ok := st.cordoned.since[node.Name]
+		if !ok {
+			since = now
+			st.cordoned.since[node.Name] = since
+		}
+		if st.cordoned.cleared.Has(node.Name) || now.Sub(since) < grace {
+			continue
+		}
+		for _, pod := range st.clearNodeRecords(ctx, node.Name, "cordoned") {
+			st.suggestEviction(pod.Namespace, pod.Name, node.Name)
+		}
+		st.cordoned.cleared.Insert(node.Name)
+	}
+	for nodeName := range st.cordoned.since {
+		if !cordoned.Has(nodeName) {
+			// uncordoned or deleted, a new cordon starts a new grace period
+			delete(st.cordoned.since, nodeName)
+			st.cordoned.cleared.Delete(nodeName)
+		}
+	}
+}
+
+// suggestEviction emits an event on a pod still running on the cordoned node its record was
+// cleared for.
+func (st *Stable) suggestEviction(namespace, name, nodeName string) {
+	if st.eventRecorder == nil || st.podLister == nil {
+		return
+	}
+	pod, err := st.podLister.Pods(namespace).Get(name)
+	if err != nil || pod.Spec.NodeName != nodeName {
+		return
+	}
+	st.eventRecorder.Eventf(pod, v1.EventTypeNormal, "CordonedNodeRecordCleared",
+		"Node %s is cordoned, the record pinning the pod to it was cleared. Evict the pod to move it to another node", nodeName)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestClearCordonedNodeRecords(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1","web-1":"node2","web-2":"node3"}}`,
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	nodeInformer := informers.Core().V1().Nodes()
+	podInformer := informers.Core().V1().Pods()
+	fakeClock := clock.NewFakeClock(time.Now())
+	recorder := record.NewFakeRecorder(10)
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		nodeLister:        nodeInformer.Lister(),
+		podLister:         podInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{ClearCordonedNodeRecords: true, CordonedNodeGraceSeconds: 60},
+		clock:             fakeClock,
+		eventRecorder:     recorder,
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	if err := podInformer.Informer().GetIndexer().Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	nodes := map[string]*corev1.Node{
+		"node1": {ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+		"node2": {ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+		"node3": {ObjectMeta: metav1.ObjectMeta{Name: "node3"}},
+	}
+	for _, node := range nodes {
+		if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.TODO()
+	getRecord := func() string {
+		s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+			t.Fatal(err)
+		}
+		return s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]
+	}
+
+	stableSchedule.clearCordonedNodeRecords(ctx)
+	if got := getRecord(); got != statefulset.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"] {
+		t.Errorf("expected the records to be kept within the grace period, got %v", got)
+	}
+
+	// node2 is uncordoned within the grace period
+	uncordoned := nodes["node2"].DeepCopy()
+	uncordoned.Spec.Unschedulable = false
+	if err := nodeInformer.Informer().GetIndexer().Update(uncordoned); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Step(61 * time.Second)
+	stableSchedule.clearCordonedNodeRecords(ctx)
+	expected := `{"Records":{"web-1":"node2","web-2":"node3"}}`
+	if got := getRecord(); got != expected {
+		t.Errorf("expected %v after the grace period, got %v", expected, got)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "CordonedNodeRecordCleared") {
+		t.Errorf("expected an event suggesting the eviction, got %v", event)
+	}
+
+	// node2 is cordoned again, its grace period starts over
+	if err := nodeInformer.Informer().GetIndexer().Update(nodes["node2"]); err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule.clearCordonedNodeRecords(ctx)
+	if got := getRecord(); got != expected {
+		t.Errorf("expected %v within the new grace period, got %v", expected, got)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no new event, got %d", len(recorder.Events))
+	}
+}
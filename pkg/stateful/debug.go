@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// DebugPinsPath is the path the debug server serves the pins of all statefulsets on.
+	DebugPinsPath = "/debug/pins"
+	// debugReadTimeout bounds the time the debug server waits for a request.
+	debugReadTimeout = 10 * time.Second
+)
+
+// serveDebug serves the read-only debug endpoints on the address until the scheduler exits.
+func (st *Stable) serveDebug(address string) {
+	mux := http.NewServeMux()
+	mux.Handle(DebugPinsPath, st.pinsHandler())
+	server := &http.Server{Addr: address, Handler: mux, ReadTimeout: debugReadTimeout}
+	klog.Infof("Serving the %s debug endpoints on %s", Name, address)
+	if err := server.ListenAndServe(); err != nil {
+		klog.Errorf("The %s debug server stopped: %v", Name, err)
+	}
+}
+
+// pinsHandler serves the current pins as a JSON map of "namespace/statefulset/key" to the
+// recorded node, the key being the record key of the pod. The records are read from the
+// record store of the statefulsets in the lister cache, unreadable records are left out.
+func (st *Stable) pinsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		statefulsets, err := st.statefulSetLister.List(labels.Everything())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pins := make(map[string]string)
+		for _, statefulset := range statefulsets {
+			record, err := st.store.Get(r.Context(), statefulset)
+			if err != nil {
+				klog.V(4).Infof("Leaving out the record of statefulset %s/%s from the pins: %v",
+					statefulset.Namespace, statefulset.Name, err)
+				continue
+			}
+			if record == nil {
+				continue
+			}
+			for key, node := range record.nodes() {
+				pins[statefulset.Namespace+"/"+statefulset.Name+"/"+key] = node
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pins); err != nil {
+			klog.V(4).Infof("Failed to write the pins: %v", err)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPinsHandler(t *testing.T) {
+	newStatefulSet := func(namespace, name, record string) *appsv1.StatefulSet {
+		statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		if record != "" {
+			statefulset.Annotations = map[string]string{"statefulset-stable.scheduling.sigs.k8s.io/record": record}
+		}
+		return statefulset
+	}
+	tests := []struct {
+		name         string
+		method       string
+		statefulsets []*appsv1.StatefulSet
+		expectedCode int
+		expected     map[string]string
+	}{
+		{
+			name:   "pins of all statefulsets",
+			method: http.MethodGet,
+			statefulsets: []*appsv1.StatefulSet{
+				newStatefulSet("n1", "web", `{"Records":{"web-0":"node1","web-1":"node2"}}`),
+				newStatefulSet("n2", "db", `{"Records":{"db-0":"node3"}}`),
+				newStatefulSet("n2", "cache", ""),
+			},
+			expectedCode: http.StatusOK,
+			expected: map[string]string{
+				"n1/web/web-0": "node1",
+				"n1/web/web-1": "node2",
+				"n2/db/db-0":   "node3",
+			},
+		},
+		{
+			name:   "invalid records are left out",
+			method: http.MethodGet,
+			statefulsets: []*appsv1.StatefulSet{
+				newStatefulSet("n1", "web", `{"Records":{"web-0":"node1"}}`),
+				newStatefulSet("n1", "db", `not json`),
+			},
+			expectedCode: http.StatusOK,
+			expected:     map[string]string{"n1/web/web-0": "node1"},
+		},
+		{
+			name:         "no statefulsets",
+			method:       http.MethodGet,
+			expectedCode: http.StatusOK,
+			expected:     map[string]string{},
+		},
+		{
+			name:         "read-only",
+			method:       http.MethodPost,
+			statefulsets: []*appsv1.StatefulSet{newStatefulSet("n1", "web", `{"Records":{"web-0":"node1"}}`)},
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			for _, statefulset := range tt.statefulsets {
+				if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+			}
+
+			recorder := httptest.NewRecorder()
+			stableSchedule.pinsHandler().ServeHTTP(recorder, httptest.NewRequest(tt.method, DebugPinsPath, nil))
+			if recorder.Code != tt.expectedCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedCode, recorder.Code, recorder.Body.String())
+			}
+			if tt.expected == nil {
+				return
+			}
+			var pins map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &pins); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pins, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, pins)
+			}
+			if len(clientset.Actions()) != 0 {
+				t.Errorf("expected no API requests, got %v", clientset.Actions())
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// StatefulsetStableDiagnose is the pod annotation that, set to "true", logs every decision of
+// the plugin for the pod regardless of the log verbosity, like the DiagnosePod arg.
+const StatefulsetStableDiagnose = "statefulset-stable.scheduling.sigs.k8s.io/diagnose"
+
+// diagnosed checks whether the decisions for the pod are logged in detail.
+func (st *Stable) diagnosed(pod *v1.Pod) bool {
+	if st.args.DiagnosePod != "" && st.args.DiagnosePod == pod.Namespace+"/"+pod.Name {
+		return true
+	}
+	return pod.GetAnnotations()[StatefulsetStableDiagnose] == "true"
+}
+
+// diagnosef logs a decision for a diagnosed pod. Callers check diagnosed first, so the
+// details are only computed for the diagnosed pod.
+func diagnosef(pod *v1.Pod, format string, args ...interface{}) {
+	klog.Infof("Diagnosing pod %s/%s: %s", pod.Namespace, pod.Name, fmt.Sprintf(format, args...))
+}
+
+// describeState returns the details of the prefilter state the decisions for the pod depend on.
+func (st *Stable) describeState(s *preFilterState, pod *v1.Pod) string {
+	if s.statefulset == nil {
+		return "not stable scheduled"
+	}
+	key := st.keyOf(pod)
+	details := []string{
+		fmt.Sprintf("statefulset=%s/%s", s.statefulset.Namespace, s.statefulset.Name),
+		fmt.Sprintf("generation=%d", s.statefulset.Generation),
+		fmt.Sprintf("key=%q", key),
+		fmt.Sprintf("enforce=%v", s.enforce),
+	}
+	if s.recordErr != nil {
+		details = append(details, fmt.Sprintf("recordErr=%q", s.recordErr.Error()))
+	}
+	if s.record != nil {
+		if node, ok := s.record.nodeOf(key); ok {
+			details = append(details, fmt.Sprintf("recordedNode=%q", node), "owner="+s.record.ownerOf(key),
+				"source="+s.record.sourceOf(key), fmt.Sprintf("recordedGeneration=%d", s.record.Records[key].Generation))
+		} else {
+			details = append(details, "recordedNode=none")
+		}
+		if returnNode := s.record.returnNodeOf(key, st.now()); returnNode != "" {
+			details = append(details, "returnNode="+returnNode)
+		}
+	}
+	if s.advisory {
+		details = append(details, "advisory=true")
+	}
+	if s.group != nil {
+		details = append(details, fmt.Sprintf("group=%s groupNode=%q", s.group.name, s.group.node))
+	}
+	if s.fallbackLabels != nil {
+		details = append(details, fmt.Sprintf("fallbackLabels=%v", s.fallbackLabels))
+	}
+	if s.imageNodes != nil {
+		details = append(details, fmt.Sprintf("imageNodes=%v", s.imageNodes.List()))
+	}
+	return strings.Join(details, " ")
+}
+
+// describeStatus returns the code and the message of the status.
+func describeStatus(status *framework.Status) string {
+	if status.Message() == "" {
+		return status.Code().String()
+	}
+	return fmt.Sprintf("%s (%s)", status.Code(), status.Message())
+}
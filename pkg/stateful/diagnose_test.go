@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestDiagnosed(t *testing.T) {
+	tests := []struct {
+		name        string
+		diagnosePod string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name: "pod is not diagnosed by default",
+		},
+		{
+			name:        "pod named by the args",
+			diagnosePod: "n1/web-0",
+			expected:    true,
+		},
+		{
+			name:        "pod with the same name in another namespace",
+			diagnosePod: "n2/web-0",
+		},
+		{
+			name:        "pod with the diagnose annotation",
+			annotations: map[string]string{StatefulsetStableDiagnose: "true"},
+			expected:    true,
+		},
+		{
+			name:        "pod with the diagnose annotation disabled",
+			annotations: map[string]string{StatefulsetStableDiagnose: "false"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stableSchedule := &Stable{args: StableArgs{DiagnosePod: tt.diagnosePod}}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1", Annotations: tt.annotations}}
+			if got := stableSchedule.diagnosed(pod); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDiagnoseDoesNotChangeDecisions(t *testing.T) {
+	for _, diagnosePod := range []string{"", "n1/web-0"} {
+		statefulset := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+				},
+			},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-0",
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+		clientset := fake.NewSimpleClientset(statefulset)
+		informers := informers.NewSharedInformerFactory(clientset, 0)
+		statefulsetInformer := informers.Apps().V1().StatefulSets()
+		stableSchedule := &Stable{
+			statefulSetLister: statefulsetInformer.Lister(),
+			clientset:         clientset,
+			store:             newAnnotationStore(clientset),
+			args:              StableArgs{DiagnosePod: diagnosePod},
+		}
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := context.TODO()
+		for node, expected := range map[string]framework.Code{"node1": framework.Success, "node2": framework.Unschedulable} {
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, pod, nodeInfo).Code(); code != expected {
+				t.Errorf("diagnosePod %q: expected %v on %s, got %v", diagnosePod, expected, node, code)
+			}
+		}
+		stableSchedule.PostBind(ctx, nil, pod, "node1")
+		s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != `{"Records":{"web-0":"node1"}}` {
+			t.Errorf("diagnosePod %q: expected the record to be unchanged, got %v", diagnosePod, got)
+		}
+	}
+}
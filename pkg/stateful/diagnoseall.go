@@ -0,0 +1,182 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+// RecordStatus is the result of verifying an entry of a record.
+type RecordStatus string
+
+// Statuses of the RecordReport, an entry has the first status that applies.
+const (
+	// RecordDangling is an entry without a live pod of its key, e.g. of a pod scaled down.
+	RecordDangling RecordStatus = "Dangling"
+	// RecordStale is an entry whose node no longer exists, or whose pod is bound to another node.
+	RecordStale RecordStatus = "Stale"
+	// RecordOversubscribed is an entry of a node pinning more pods than the node can run.
+	RecordOversubscribed RecordStatus = "Oversubscribed"
+	// RecordHealthy is an entry whose pod is bound to the existing recorded node, or pending.
+	RecordHealthy RecordStatus = "Healthy"
+)
+
+// Report is the result of DiagnoseAll, the verified entries of the records of all statefulsets.
+type Report struct {
+	Records []RecordReport `json:"records"`
+	// Counts is the number of entries by status.
+	Counts map[RecordStatus]int `json:"counts"`
+	// UnreadableStatefulSets are the namespace/name of the statefulsets whose record can't be read.
+	UnreadableStatefulSets []string `json:"unreadableStatefulSets,omitempty"`
+}
+
+// RecordReport is a verified entry of the record of a statefulset.
+type RecordReport struct {
+	Namespace   string `json:"namespace"`
+	StatefulSet string `json:"statefulSet"`
+	Key         string `json:"key"`
+	Node        string `json:"node"`
+	// Pod is the name of the live pod of the key, empty if there is none.
+	Pod    string       `json:"pod,omitempty"`
+	Status RecordStatus `json:"status"`
+	// Reason explains the status of entries that are not healthy.
+	Reason string `json:"reason,omitempty"`
+}
+
+// DiagnoseAll verifies the entries of the records of all statefulsets against the live pods,
+// listed from the API server, and the nodes, e.g. for a kubectl plugin or operator diagnostics.
+// It only reads, the records are left unchanged. An error is returned when the statefulsets,
+// pods or nodes can't be listed, statefulsets whose record can't be read are reported.
+func (st *Stable) DiagnoseAll(ctx context.Context) (Report, error) {
+	report := Report{Records: []RecordReport{}, Counts: map[RecordStatus]int{}}
+	if st.nodeLister == nil || st.clientset == nil {
+		return report, fmt.Errorf("%s requires a node lister and a clientset to diagnose the records", Name)
+	}
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		return report, fmt.Errorf("failed to list statefulsets: %v", err)
+	}
+	podsByNamespace := map[string][]v1.Pod{}
+	// livePods holds the live pod of each entry of the report, nil if there is none
+	var livePods []*v1.Pod
+	for _, statefulset := range statefulsets {
+		record, err := st.store.Get(ctx, statefulset)
+		if err != nil {
+			klog.V(3).Infof("Failed to read the record of statefulset %s/%s: %v", statefulset.Namespace, statefulset.Name, err)
+			report.UnreadableStatefulSets = append(report.UnreadableStatefulSets, statefulset.Namespace+"/"+statefulset.Name)
+			continue
+		}
+		if record == nil || len(record.Records) == 0 {
+			continue
+		}
+		pods, ok := podsByNamespace[statefulset.Namespace]
+		if !ok {
+			podList, err := st.clientset.CoreV1().Pods(statefulset.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return report, fmt.Errorf("failed to list the pods of namespace %s: %v", statefulset.Namespace, err)
+			}
+			pods = podList.Items
+			podsByNamespace[statefulset.Namespace] = pods
+		}
+		podsOfKeys := st.podsByKey(statefulset, pods)
+		for key, node := range record.nodes() {
+			entry := RecordReport{Namespace: statefulset.Namespace, StatefulSet: statefulset.Name, Key: key, Node: node}
+			pod := podsOfKeys[key]
+			if pod != nil {
+				entry.Pod = pod.Name
+			}
+			report.Records = append(report.Records, entry)
+			livePods = append(livePods, pod)
+		}
+	}
+
+	nodes := map[string]*v1.Node{}
+	pins := map[string]int{}
+	for _, entry := range report.Records {
+		pins[entry.Node]++
+		if _, ok := nodes[entry.Node]; ok {
+			continue
+		}
+		node, err := st.nodeLister.Get(entry.Node)
+		if err != nil && !errors.IsNotFound(err) {
+			return report, fmt.Errorf("failed to get node %s: %v", entry.Node, err)
+		}
+		nodes[entry.Node] = node
+	}
+	for i := range report.Records {
+		entry := &report.Records[i]
+		entry.Status, entry.Reason = st.verifyRecordEntry(entry.Node, livePods[i], nodes[entry.Node], pins[entry.Node])
+		report.Counts[entry.Status]++
+	}
+	sort.Slice(report.Records, func(i, j int) bool {
+		a, b := report.Records[i], report.Records[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.StatefulSet != b.StatefulSet {
+			return a.StatefulSet < b.StatefulSet
+		}
+		return a.Key < b.Key
+	})
+	return report, nil
+}
+
+// podsByKey returns the live pods of the statefulset by their record key.
+func (st *Stable) podsByKey(statefulset *appsv1.StatefulSet, pods []v1.Pod) map[string]*v1.Pod {
+	byKey := map[string]*v1.Pod{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, ow := range pod.GetOwnerReferences() {
+			if ow.Kind == Kind && ow.Name == statefulset.Name {
+				byKey[st.keyOf(pod)] = pod
+				break
+			}
+		}
+	}
+	return byKey
+}
+
+// verifyRecordEntry returns the status of an entry recording the node and its reason, given the
+// live pod of the entry, the node, nil if either doesn't exist, and the number of entries
+// pinning the node.
+func (st *Stable) verifyRecordEntry(recorded string, pod *v1.Pod, node *v1.Node, pins int) (RecordStatus, string) {
+	if pod == nil {
+		return RecordDangling, "no live pod of the key"
+	}
+	if node == nil {
+		return RecordStale, "the recorded node doesn't exist"
+	}
+	if pod.Spec.NodeName != "" && !st.matchesNode(recorded, pod.Spec.NodeName) {
+		return RecordStale, fmt.Sprintf("the pod is bound to node %s", pod.Spec.NodeName)
+	}
+	if capacity, ok := node.Status.Allocatable[v1.ResourcePods]; ok && int64(pins) > capacity.Value() {
+		return RecordOversubscribed, fmt.Sprintf("%d pods are pinned to the node, it can run %d", pins, capacity.Value())
+	}
+	return RecordHealthy, ""
+}
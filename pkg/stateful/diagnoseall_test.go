@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiagnoseAll(t *testing.T) {
+	newStatefulSet := func(name, record string) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": record,
+				},
+			},
+		}
+	}
+	newPod := func(name, statefulset, nodeName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: statefulset,
+					},
+				},
+			},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+		}
+	}
+	newNode := func(name string, pods int64) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if pods > 0 {
+			node.Status.Allocatable = corev1.ResourceList{corev1.ResourcePods: *resource.NewQuantity(pods, resource.DecimalSI)}
+		}
+		return node
+	}
+	statefulsets := []*appsv1.StatefulSet{
+		newStatefulSet("web", `{"Records":{"web-0":"node1","web-1":"node2","web-2":"gone","web-3":"node1"}}`),
+		newStatefulSet("small", `{"Records":{"small-0":"tiny","small-1":"tiny"}}`),
+		newStatefulSet("broken", `{"Records":`),
+		newStatefulSet("empty", `{}`),
+	}
+	nodes := []*corev1.Node{newNode("node1", 0), newNode("node2", 110), newNode("tiny", 1)}
+	objects := []runtime.Object{
+		newPod("web-0", "web", "node1"),
+		newPod("web-1", "web", "node3"),
+		newPod("web-2", "web", ""),
+		newPod("small-0", "small", "tiny"),
+		newPod("small-1", "small", ""),
+		// a pod of another statefulset with the key of a dangling entry
+		newPod("web-3", "other", "node1"),
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	nodeInformer := informers.Core().V1().Nodes()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		nodeLister:        nodeInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	for _, statefulset := range statefulsets {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, node := range nodes {
+		if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := stableSchedule.DiagnoseAll(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RecordReport{
+		{Namespace: "n1", StatefulSet: "small", Key: "small-0", Node: "tiny", Pod: "small-0", Status: RecordOversubscribed,
+			Reason: "2 pods are pinned to the node, it can run 1"},
+		{Namespace: "n1", StatefulSet: "small", Key: "small-1", Node: "tiny", Pod: "small-1", Status: RecordOversubscribed,
+			Reason: "2 pods are pinned to the node, it can run 1"},
+		{Namespace: "n1", StatefulSet: "web", Key: "web-0", Node: "node1", Pod: "web-0", Status: RecordHealthy},
+		{Namespace: "n1", StatefulSet: "web", Key: "web-1", Node: "node2", Pod: "web-1", Status: RecordStale,
+			Reason: "the pod is bound to node node3"},
+		{Namespace: "n1", StatefulSet: "web", Key: "web-2", Node: "gone", Pod: "web-2", Status: RecordStale,
+			Reason: "the recorded node doesn't exist"},
+		{Namespace: "n1", StatefulSet: "web", Key: "web-3", Node: "node1", Status: RecordDangling,
+			Reason: "no live pod of the key"},
+	}
+	if !reflect.DeepEqual(expected, report.Records) {
+		t.Errorf("expected records %+v, got %+v", expected, report.Records)
+	}
+	expectedCounts := map[RecordStatus]int{RecordHealthy: 1, RecordStale: 2, RecordDangling: 1, RecordOversubscribed: 2}
+	if !reflect.DeepEqual(expectedCounts, report.Counts) {
+		t.Errorf("expected counts %v, got %v", expectedCounts, report.Counts)
+	}
+	if expectedUnreadable := []string{"n1/broken"}; !reflect.DeepEqual(expectedUnreadable, report.UnreadableStatefulSets) {
+		t.Errorf("expected unreadable statefulsets %v, got %v", expectedUnreadable, report.UnreadableStatefulSets)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"k8s.io/klog"
+)
+
+// nodeTopology returns the value of the TopologyKey label of the node, empty if the node can't
+// be found or has no such label.
+func (st *Stable) nodeTopology(nodeName string) string {
+	if st.nodeLister == nil {
+		return ""
+	}
+	node, err := st.nodeLister.Get(nodeName)
+	if err != nil {
+		klog.V(4).Infof("Failed to get node %s for its topology: %v", nodeName, err)
+		return ""
+	}
+	return node.Labels[st.args.TopologyKey]
+}
+
+// topologyOf returns the topology domain the pod of the key is stable within, empty when
+// TopologyKey is not set or the node of the key was recorded without a domain, e.g. before
+// TopologyKey was set or on a node without the label. Such entries pin the pod to the node.
+func (st *Stable) topologyOf(record *ScheduleRecord, key string) string {
+	if st.args.TopologyKey == "" || record == nil {
+		return ""
+	}
+	return record.Records[key].Zone
+}
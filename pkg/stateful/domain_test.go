@@ -0,0 +1,170 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestTopologyKey(t *testing.T) {
+	const zoneLabel = "topology.kubernetes.io/zone"
+	tests := []struct {
+		name           string
+		record         string
+		bindNode       string
+		expectedCodes  map[string]framework.Code
+		expectedRecord string
+	}{
+		{
+			name:   "nodes sharing the topology domain are admitted",
+			record: `{"Records":{"web-0":"node1"},"Topologies":{"web-0":"a"}}`,
+			expectedCodes: map[string]framework.Code{
+				"node1": framework.Success,
+				"node2": framework.Success,
+				"node3": framework.Unschedulable,
+				// a node without the label is outside of every domain
+				"node4": framework.Unschedulable,
+			},
+			bindNode:       "node2",
+			expectedRecord: `{"Records":{"web-0":{"Node":"node2","Zone":"a"}}}`,
+		},
+		{
+			name:   "entry without a topology domain pins the pod to the node",
+			record: `{"Records":{"web-0":"node1"}}`,
+			expectedCodes: map[string]framework.Code{
+				"node1": framework.Success,
+				"node2": framework.Unschedulable,
+				"node3": framework.Unschedulable,
+				"node4": framework.Unschedulable,
+			},
+			bindNode:       "node1",
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Zone":"a"}}}`,
+		},
+		{
+			name:   "first placement records the topology domain",
+			record: `{"Records":{}}`,
+			expectedCodes: map[string]framework.Code{
+				"node1": framework.Success,
+				"node2": framework.Success,
+				"node3": framework.Success,
+				"node4": framework.Success,
+			},
+			bindNode:       "node3",
+			expectedRecord: `{"Records":{"web-0":{"Node":"node3","Zone":"b"}}}`,
+		},
+		{
+			name:   "first placement on a node without the label",
+			record: `{"Records":{}}`,
+			expectedCodes: map[string]framework.Code{
+				"node1": framework.Success,
+				"node2": framework.Success,
+				"node3": framework.Success,
+				"node4": framework.Success,
+			},
+			bindNode:       "node4",
+			expectedRecord: `{"Records":{"web-0":"node4"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			nodes := []*corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{zoneLabel: "a"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{zoneLabel: "a"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{zoneLabel: "b"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "node4"}},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args: StableArgs{
+					TopologyKey:        zoneLabel,
+					RecordUpdatePolicy: RecordUpdateImmutable,
+				},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range nodes {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			for _, node := range nodes {
+				nodeInfo := schedulernodeinfo.NewNodeInfo()
+				if err := nodeInfo.SetNode(node); err != nil {
+					t.Fatal(err)
+				}
+				if got := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); got != tt.expectedCodes[node.Name] {
+					t.Errorf("expected code %v on node %s, got %v", tt.expectedCodes[node.Name], node.Name, got)
+				}
+			}
+
+			stableSchedule.PostBind(ctx, state, pod, tt.bindNode)
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
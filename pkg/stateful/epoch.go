@@ -0,0 +1,61 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// nodeEpoch returns the token of the lifecycle of the node, its UID or, when it has none, its
+// creation timestamp. A node recreated under the same name starts a new epoch.
+func nodeEpoch(node *v1.Node) string {
+	if node.UID != "" {
+		return string(node.UID)
+	}
+	if !node.CreationTimestamp.IsZero() {
+		return node.CreationTimestamp.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// currentNodeEpoch returns the epoch of the node, empty if the node can't be found.
+func (st *Stable) currentNodeEpoch(nodeName string) string {
+	if st.nodeLister == nil {
+		return ""
+	}
+	node, err := st.nodeLister.Get(nodeName)
+	if err != nil {
+		klog.V(4).Infof("Failed to get node %s for its epoch: %v", nodeName, err)
+		return ""
+	}
+	return nodeEpoch(node)
+}
+
+// isOtherNodeEpoch checks whether the node recorded under the key was recreated since, its
+// current epoch differs from the recorded one. Keys recorded without an epoch and nodes that
+// can't be found are not checked.
+func (st *Stable) isOtherNodeEpoch(record *ScheduleRecord, key string) bool {
+	recorded := record.Records[key].Epoch
+	if recorded == "" {
+		return false
+	}
+	current := st.currentNodeEpoch(record.Records[key].Node)
+	return current != "" && current != recorded
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestNodeEpoch(t *testing.T) {
+	created := metav1.NewTime(time.Date(2020, 4, 1, 8, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name     string
+		node     *corev1.Node
+		expected string
+	}{
+		{
+			name:     "node with uid",
+			node:     &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid-1", CreationTimestamp: created}},
+			expected: "uid-1",
+		},
+		{
+			name:     "node without uid",
+			node:     &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", CreationTimestamp: created}},
+			expected: "2020-04-01T08:00:00Z",
+		},
+		{
+			name: "node without uid and creation timestamp",
+			node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeEpoch(tt.node); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnforceNodeEpoch(t *testing.T) {
+	tests := []struct {
+		name           string
+		record         string
+		node1UID       string
+		expectedCode   framework.Code
+		expectedRecord string
+	}{
+		{
+			name:           "recorded node of the same epoch",
+			record:         `{"Records":{"web-0":{"Node":"node1","Epoch":"uid-1"}}}`,
+			node1UID:       "uid-1",
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Epoch":"uid-1"}}}`,
+		},
+		{
+			name:           "recorded node recreated under the same name",
+			record:         `{"Records":{"web-0":{"Node":"node1","Epoch":"uid-1"}}}`,
+			node1UID:       "uid-3",
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node2","Epoch":"uid-2"}}}`,
+		},
+		{
+			name:           "entry recorded without epoch",
+			record:         `{"Records":{"web-0":"node1"}}`,
+			node1UID:       "uid-3",
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			node1 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: types.UID(tt.node1UID)}}
+			node2 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", UID: "uid-2"}}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{EnforceNodeEpoch: true, RecordUpdatePolicy: RecordUpdateImmutable},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range []*corev1.Node{node1, node2} {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(node2); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedCode == framework.Success {
+				stableSchedule.PostBind(ctx, state, pod, "node2")
+			}
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// StableDecision is the result of evaluating a pod and a node against the records of the
+// statefulset of the pod.
+type StableDecision struct {
+	// Allowed is whether the pod may be scheduled to the node.
+	Allowed bool
+	// RecordedNode is the node recorded for the pod, empty if the pod has no record.
+	RecordedNode string
+	Reason       string
+}
+
+// Reasons of the StableDecision.
+const (
+	ReasonNotOptedIn        = "pod is not stable scheduled"
+	ReasonNotSticky         = "ordinal of the pod is not sticky"
+	ReasonNotRecorded       = "pod has no record"
+	ReasonRecordedOnNode    = "pod is recorded on the node"
+	ReasonRecordedElsewhere = "pod is recorded on another node"
+)
+
+// EvaluateAgainst decides whether the pod may be scheduled to the node given the records of its
+// statefulset, mapping record keys to nodes, and the plugin args. It runs the decision of Filter
+// without any cluster access, for offline what-if analysis of records. The state that lives in
+// the cluster is not considered: the sticky ordinals annotation of the statefulset, the
+// sentinel, the node allow-list, groups, generations, fallbacks, return nodes and the node
+// readiness selector. An invalid StickyOrdinals makes all ordinals sticky.
+func EvaluateAgainst(records map[string]string, pod *v1.Pod, nodeName string, args StableArgs) StableDecision {
+	if !isEligible(pod, args) {
+		return StableDecision{Allowed: true, Reason: ReasonNotOptedIn}
+	}
+	if args.StickyOrdinals != "" {
+		if ranges, err := parseOrdinalRanges(args.StickyOrdinals); err == nil && !isStickyOrdinal(ranges, pod.GetName()) {
+			return StableDecision{Allowed: true, Reason: ReasonNotSticky}
+		}
+	}
+	return evaluateRecord(records, recordKeyOf(pod, args), nodeName, args)
+}
+
+// evaluateRecord decides whether the pod of the key may be scheduled to the node given the
+// records, comparing node names following the NodeNameMatch mode and NodeDomainSuffix.
+func evaluateRecord(records map[string]string, key, nodeName string, args StableArgs) StableDecision {
+	recorded, ok := records[key]
+	if !ok {
+		return StableDecision{Allowed: true, Reason: ReasonNotRecorded}
+	}
+	if !nodeNameMatches(args, recorded, nodeName) {
+		return StableDecision{RecordedNode: recorded, Reason: ReasonRecordedElsewhere}
+	}
+	return StableDecision{Allowed: true, RecordedNode: recorded, Reason: ReasonRecordedOnNode}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func newEvaluatedPod(name, optIn string, annotations map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "n1",
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	if optIn != "" {
+		pod.Labels = map[string]string{"statefulset-stable.scheduling.sigs.k8s.io": optIn}
+	}
+	return pod
+}
+
+func TestEvaluateAgainst(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  map[string]string
+		pod      *corev1.Pod
+		node     string
+		args     func(args *StableArgs)
+		expected StableDecision
+	}{
+		{
+			name:     "pod not opted in",
+			records:  map[string]string{"web-0": "node1"},
+			pod:      newEvaluatedPod("web-0", "", nil),
+			node:     "node2",
+			expected: StableDecision{Allowed: true, Reason: ReasonNotOptedIn},
+		},
+		{
+			name:     "lenient opt-in value in strict mode",
+			records:  map[string]string{"web-0": "node1"},
+			pod:      newEvaluatedPod("web-0", "yes", nil),
+			node:     "node2",
+			expected: StableDecision{Allowed: true, Reason: ReasonNotOptedIn},
+		},
+		{
+			name:     "lenient opt-in value in lenient mode",
+			records:  map[string]string{"web-0": "node1"},
+			pod:      newEvaluatedPod("web-0", "yes", nil),
+			node:     "node2",
+			args:     func(args *StableArgs) { args.OptInLabelValue = OptInLabelValueLenient },
+			expected: StableDecision{RecordedNode: "node1", Reason: ReasonRecordedElsewhere},
+		},
+		{
+			name:     "no records",
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node2",
+			expected: StableDecision{Allowed: true, Reason: ReasonNotRecorded},
+		},
+		{
+			name:     "pod without entry",
+			records:  map[string]string{"web-1": "node1"},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node2",
+			expected: StableDecision{Allowed: true, Reason: ReasonNotRecorded},
+		},
+		{
+			name:     "recorded on the node",
+			records:  map[string]string{"web-0": "node1"},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node1",
+			expected: StableDecision{Allowed: true, RecordedNode: "node1", Reason: ReasonRecordedOnNode},
+		},
+		{
+			name:     "recorded on another node",
+			records:  map[string]string{"web-0": "node1"},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node2",
+			expected: StableDecision{RecordedNode: "node1", Reason: ReasonRecordedElsewhere},
+		},
+		{
+			name:     "ordinal not sticky",
+			records:  map[string]string{"web-3": "node1"},
+			pod:      newEvaluatedPod("web-3", "true", nil),
+			node:     "node2",
+			args:     func(args *StableArgs) { args.StickyOrdinals = "0-2" },
+			expected: StableDecision{Allowed: true, Reason: ReasonNotSticky},
+		},
+		{
+			name:     "sticky ordinal",
+			records:  map[string]string{"web-2": "node1"},
+			pod:      newEvaluatedPod("web-2", "true", nil),
+			node:     "node2",
+			args:     func(args *StableArgs) { args.StickyOrdinals = "0-2" },
+			expected: StableDecision{RecordedNode: "node1", Reason: ReasonRecordedElsewhere},
+		},
+		{
+			name:     "recorded by ordinal",
+			records:  map[string]string{"0": "node1"},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node1",
+			args:     func(args *StableArgs) { args.RecordKey = RecordKeyOrdinal },
+			expected: StableDecision{Allowed: true, RecordedNode: "node1", Reason: ReasonRecordedOnNode},
+		},
+		{
+			name:     "recorded by name with ordinal key",
+			records:  map[string]string{"web-0": "node1"},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node2",
+			args:     func(args *StableArgs) { args.RecordKey = RecordKeyOrdinal },
+			expected: StableDecision{Allowed: true, Reason: ReasonNotRecorded},
+		},
+		{
+			name:     "recorded by identity annotation",
+			records:  map[string]string{"shard-a": "node1"},
+			pod:      newEvaluatedPod("web-0", "true", map[string]string{"example.com/identity": "shard-a"}),
+			node:     "node2",
+			args:     func(args *StableArgs) { args.IdentityAnnotation = "example.com/identity" },
+			expected: StableDecision{RecordedNode: "node1", Reason: ReasonRecordedElsewhere},
+		},
+		{
+			name:     "whitespace with exact match",
+			records:  map[string]string{"web-0": " node1 "},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node1",
+			expected: StableDecision{RecordedNode: " node1 ", Reason: ReasonRecordedElsewhere},
+		},
+		{
+			name:     "whitespace and case ignoring case",
+			records:  map[string]string{"web-0": " Node1 "},
+			pod:      newEvaluatedPod("web-0", "true", nil),
+			node:     "node1",
+			args:     func(args *StableArgs) { args.NodeNameMatch = NodeNameMatchTrimIgnoreCase },
+			expected: StableDecision{Allowed: true, RecordedNode: " Node1 ", Reason: ReasonRecordedOnNode},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := defaultStableArgs()
+			if tt.args != nil {
+				tt.args(args)
+			}
+			if got := EvaluateAgainst(tt.records, tt.pod, tt.node, *args); got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestEvaluateAgainstMatchesFilter checks that the offline evaluation agrees with Filter on a
+// cluster holding the same records.
+func TestEvaluateAgainstMatchesFilter(t *testing.T) {
+	records := map[string]string{"web-0": "node1", "web-1": "node2", "web-3": "node1"}
+	record := &ScheduleRecord{}
+	for key, node := range records {
+		record.setEntry(key, key, node, RecordSourceAuto)
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sticky := range []string{"", "0-1"} {
+		args := defaultStableArgs()
+		args.StickyOrdinals = sticky
+		statefulset := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   "n1",
+				Annotations: map[string]string{"statefulset-stable.scheduling.sigs.k8s.io/record": string(recordBytes)},
+			},
+		}
+		clientset := fake.NewSimpleClientset(statefulset)
+		informers := informers.NewSharedInformerFactory(clientset, 0)
+		statefulsetInformer := informers.Apps().V1().StatefulSets()
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+		stableSchedule, err := NewWithOptions(
+			WithArgs(args),
+			WithStatefulSetLister(statefulsetInformer.Lister()),
+			WithClientSet(clientset),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, podName := range []string{"web-0", "web-1", "web-2", "web-3"} {
+			for _, optIn := range []string{"", "true"} {
+				for _, nodeName := range []string{"node1", "node2"} {
+					pod := newEvaluatedPod(podName, optIn, nil)
+					nodeInfo := schedulernodeinfo.NewNodeInfo()
+					if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+						t.Fatal(err)
+					}
+					filtered := stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code() == framework.Success
+					if evaluated := EvaluateAgainst(records, pod, nodeName, *args); evaluated.Allowed != filtered {
+						t.Errorf("sticky %q, pod %s opted in %q on %s: Filter allowed %v, EvaluateAgainst %+v",
+							sticky, podName, optIn, nodeName, filtered, evaluated)
+					}
+				}
+			}
+		}
+	}
+}
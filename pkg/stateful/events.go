@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// EventReasonFailedScheduling is the reason of the Warning event on a pod whose node is
+	// filtered out because the pod is pinned to another node.
+	EventReasonFailedScheduling = "FailedScheduling"
+	// EventReasonPinned is the reason of the Normal event on a pod recorded on a new node.
+	EventReasonPinned = "Pinned"
+	// EventReasonInvalidRecord is the reason of the Warning event on a statefulset whose record
+	// can't be decoded and is ignored.
+	EventReasonInvalidRecord = "InvalidRecord"
+	// pinEventInterval is the minimum interval between two events of the same reason on a pod,
+	// a pod filtered against many nodes gets a single event.
+	pinEventInterval = time.Minute
+	// maxTrackedPinEvents bounds the pods whose last event is tracked before old ones are pruned.
+	maxTrackedPinEvents = 10000
+)
+
+// pinEvents rate limits the events of pods and statefulsets.
+type pinEvents struct {
+	lock sync.Mutex
+	// last maps the UID and the reason of an event to the time it was last emitted.
+	last map[string]time.Time
+}
+
+// allow checks whether an event of the reason may be emitted on the object, and if so records it.
+func (e *pinEvents) allow(obj metav1.Object, reason string, now time.Time) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.last == nil {
+		e.last = make(map[string]time.Time)
+	}
+	key := string(obj.GetUID()) + "/" + obj.GetNamespace() + "/" + obj.GetName() + "/" + reason
+	if last, ok := e.last[key]; ok && now.Sub(last) < pinEventInterval {
+		return false
+	}
+	if len(e.last) >= maxTrackedPinEvents {
+		for k, last := range e.last {
+			if now.Sub(last) >= pinEventInterval {
+				delete(e.last, k)
+			}
+		}
+	}
+	e.last[key] = now
+	return true
+}
+
+// emitPinEvent emits an event on the pod with PinEvents, at most one per pinEventInterval for
+// each reason.
+func (st *Stable) emitPinEvent(pod *v1.Pod, eventType, reason, format string, args ...interface{}) {
+	if !st.args.PinEvents || st.eventRecorder == nil || !st.pinEvents.allow(pod, reason, st.now()) {
+		return
+	}
+	st.eventRecorder.Event(pod, eventType, reason, fmt.Sprintf(format, args...))
+}
+
+// emitInvalidRecordEvent emits a Warning event on the statefulset whose record is ignored, at
+// most one per pinEventInterval.
+func (st *Stable) emitInvalidRecordEvent(statefulset *appsv1.StatefulSet, err error) {
+	if st.eventRecorder == nil || !st.pinEvents.allow(statefulset, EventReasonInvalidRecord, st.now()) {
+		return
+	}
+	st.eventRecorder.Eventf(statefulset, v1.EventTypeWarning, EventReasonInvalidRecord,
+		"Ignoring the record of the statefulset, its pods are scheduled as if it had none: %v", err)
+}
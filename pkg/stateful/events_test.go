@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestPinEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		pinEvents      bool
+		expectedEvents []string
+	}{
+		{
+			name:      "pin events are disabled by default",
+			pinEvents: false,
+		},
+		{
+			name:      "filtering against many nodes emits a single warning, recording emits pinned",
+			pinEvents: true,
+			expectedEvents: []string{
+				corev1.EventTypeWarning + " " + EventReasonFailedScheduling + " pinned to node1 by statefulset-stable",
+				corev1.EventTypeNormal + " " + EventReasonPinned + " recorded on node2 by statefulset-stable",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			recorder := record.NewFakeRecorder(10)
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{PinEvents: tt.pinEvents},
+				clock:             clock.NewFakeClock(time.Now()),
+				eventRecorder:     recorder,
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			nodes := []*corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "node3"}},
+			}
+			for _, node := range nodes {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newPod := func(name string) *corev1.Pod {
+				return &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: "n1",
+						UID:       types.UID("uid-" + name),
+						Labels: map[string]string{
+							"statefulset-stable.scheduling.sigs.k8s.io": "true",
+						},
+						OwnerReferences: []metav1.OwnerReference{
+							{
+								Kind: "StatefulSet",
+								Name: "web",
+							},
+						},
+					},
+				}
+			}
+
+			// web-0 is pinned to node1, the other nodes are filtered out
+			ctx := context.TODO()
+			pod := newPod("web-0")
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			for _, node := range nodes[1:] {
+				nodeInfo := schedulernodeinfo.NewNodeInfo()
+				if err := nodeInfo.SetNode(node); err != nil {
+					t.Fatal(err)
+				}
+				if stableSchedule.Filter(ctx, state, pod, nodeInfo).IsSuccess() {
+					t.Fatalf("expected %s to be filtered out", node.Name)
+				}
+			}
+
+			// web-1 has no record yet, binding it writes a new record
+			stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			if len(events) != len(tt.expectedEvents) {
+				t.Fatalf("expected events %v, got %v", tt.expectedEvents, events)
+			}
+			for i, event := range events {
+				if !strings.HasPrefix(event, tt.expectedEvents[i]) {
+					t.Errorf("expected event %q, got %q", tt.expectedEvents[i], event)
+				}
+			}
+		})
+	}
+}
+
+func TestPinEventsRateLimit(t *testing.T) {
+	now := time.Now()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1", UID: "uid-web-0"}}
+	var e pinEvents
+	if !e.allow(pod, EventReasonFailedScheduling, now) {
+		t.Error("expected the first event to be allowed")
+	}
+	if e.allow(pod, EventReasonFailedScheduling, now.Add(time.Second)) {
+		t.Error("expected a repeated event within the interval to be dropped")
+	}
+	if !e.allow(pod, EventReasonPinned, now.Add(time.Second)) {
+		t.Error("expected an event of another reason to be allowed")
+	}
+	if !e.allow(pod, EventReasonFailedScheduling, now.Add(pinEventInterval)) {
+		t.Error("expected the event to be allowed again after the interval")
+	}
+}
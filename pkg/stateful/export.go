@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// pinsExportInterval is the interval between two exports of the records.
+	pinsExportInterval = 30 * time.Second
+	// PinsExportVersion is the version of the format of the exported records. A new format is
+	// exported under a new key, so readers of an older version keep working.
+	PinsExportVersion = "v1"
+	// PinsExportKey is the key of the exported records in the export ConfigMap.
+	PinsExportKey = "pins." + PinsExportVersion
+)
+
+// PinsExport is the projection of the records exported for the descheduler and other
+// components that shouldn't fight the pins, e.g. by evicting pods that are correctly pinned.
+type PinsExport struct {
+	// Version is the version of the format, PinsExportVersion.
+	Version string `json:"version"`
+	// RecordKey tells how the pods are identified by Key, by podName or by ordinal.
+	RecordKey string      `json:"recordKey"`
+	Pins      []PinExport `json:"pins"`
+}
+
+// PinExport is a pod pinned to a node.
+type PinExport struct {
+	Namespace   string `json:"namespace"`
+	StatefulSet string `json:"statefulSet"`
+	// Key identifies the pod within its statefulset, see RecordKey.
+	Key  string `json:"key"`
+	Node string `json:"node"`
+	// Advisory is set when the node is only preferred, e.g. for a record of an older
+	// generation of the statefulset.
+	Advisory bool `json:"advisory,omitempty"`
+}
+
+// exportPins writes the records of all statefulsets to the export ConfigMap, creating it when
+// it doesn't exist. The ConfigMap is only updated when the records changed, the keys of other
+// versions are left alone.
+func (st *Stable) exportPins(ctx context.Context) {
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list statefulsets to export the records: %v", err)
+		return
+	}
+	export := PinsExport{Version: PinsExportVersion, RecordKey: st.args.RecordKey, Pins: []PinExport{}}
+	for _, statefulset := range statefulsets {
+		record, err := st.store.Get(ctx, statefulset)
+		if err != nil || record == nil {
+			continue
+		}
+		for key, node := range record.nodes() {
+			export.Pins = append(export.Pins, PinExport{
+				Namespace:   statefulset.Namespace,
+				StatefulSet: statefulset.Name,
+				Key:         key,
+				Node:        node,
+				Advisory:    record.isStale(key, statefulset.Generation),
+			})
+		}
+	}
+	sort.Slice(export.Pins, func(i, j int) bool {
+		a, b := export.Pins[i], export.Pins[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.StatefulSet != b.StatefulSet {
+			return a.StatefulSet < b.StatefulSet
+		}
+		return a.Key < b.Key
+	})
+	exportBytes, err := json.Marshal(export)
+	if err != nil {
+		klog.Errorf("Failed to encode the exported records: %v", err)
+		return
+	}
+	namespace, name, _ := cache.SplitMetaNamespaceKey(st.args.PinsExportConfigMap)
+	configMap, err := st.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Data:       map[string]string{PinsExportKey: string(exportBytes)},
+		}
+		if _, err := st.clientset.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+			klog.Errorf("Failed to create the export ConfigMap %s: %v", st.args.PinsExportConfigMap, err)
+		}
+		return
+	}
+	if err != nil {
+		klog.Errorf("Failed to get the export ConfigMap %s: %v", st.args.PinsExportConfigMap, err)
+		return
+	}
+	if value, ok := configMap.Data[PinsExportKey]; ok && value == string(exportBytes) {
+		return
+	}
+	configMapCopy := configMap.DeepCopy()
+	if configMapCopy.Data == nil {
+		configMapCopy.Data = make(map[string]string)
+	}
+	configMapCopy.Data[PinsExportKey] = string(exportBytes)
+	if _, err := st.clientset.CoreV1().ConfigMaps(namespace).Update(ctx, configMapCopy, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Failed to update the export ConfigMap %s: %v", st.args.PinsExportConfigMap, err)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExportPins(t *testing.T) {
+	statefulsets := []*appsv1.StatefulSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "web",
+				Namespace:  "n1",
+				Generation: 2,
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":{"Node":"node1","Generation":2},"web-1":{"Node":"node2","Generation":1}}}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"db-0":"node3"}}`,
+				},
+			},
+		},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "n2"}},
+	}
+	tests := []struct {
+		name      string
+		configMap *corev1.ConfigMap
+	}{
+		{
+			name: "creates the ConfigMap",
+		},
+		{
+			name: "updates the ConfigMap and keeps other versions",
+			configMap: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "pins", Namespace: "kube-system"},
+				Data:       map[string]string{"pins.v0": "old", PinsExportKey: `{"version":"v1"}`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tt.configMap != nil {
+				clientset = fake.NewSimpleClientset(tt.configMap)
+			}
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			for _, statefulset := range statefulsets {
+				if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RecordKey: RecordKeyPodName, PinsExportConfigMap: "kube-system/pins"},
+			}
+
+			ctx := context.TODO()
+			stableSchedule.exportPins(ctx)
+			configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "pins", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			export := PinsExport{}
+			if err := json.Unmarshal([]byte(configMap.Data[PinsExportKey]), &export); err != nil {
+				t.Fatal(err)
+			}
+			expected := PinsExport{
+				Version:   PinsExportVersion,
+				RecordKey: RecordKeyPodName,
+				Pins: []PinExport{
+					{Namespace: "n1", StatefulSet: "db", Key: "db-0", Node: "node3"},
+					{Namespace: "n1", StatefulSet: "web", Key: "web-0", Node: "node1"},
+					{Namespace: "n1", StatefulSet: "web", Key: "web-1", Node: "node2", Advisory: true},
+				},
+			}
+			if !reflect.DeepEqual(export, expected) {
+				t.Errorf("expected %+v, got %+v", expected, export)
+			}
+			if tt.configMap != nil && configMap.Data["pins.v0"] != "old" {
+				t.Errorf("expected the other versions to be kept, got %v", configMap.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+const (
+	// FallbackPreferred schedules a pod whose recorded node is unavailable to any node,
+	// preferring the nodes sharing the most fallback labels with the recorded node.
+	FallbackPreferred = "Preferred"
+	// FallbackRequired only schedules a pod whose recorded node is unavailable to nodes
+	// sharing all fallback labels with the recorded node.
+	FallbackRequired = "Required"
+)
+
+// recordedNodeAvailable checks whether the recorded node still exists and is schedulable. Like
+// the other node checks of a scheduling cycle, it gets the node by name from the lister index,
+// in constant time regardless of the cluster size.
+func (st *Stable) recordedNodeAvailable(nodeName string) bool {
+	if st.nodeLister == nil {
+		return true
+	}
+	node, err := st.nodeLister.Get(nodeName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.V(4).Infof("Failed to get recorded node %s: %v", nodeName, err)
+			return true
+		}
+		return false
+	}
+	return !node.Spec.Unschedulable
+}
+
+// recordedNodeDeleted checks whether the recorded node is confirmed deleted, unlike a node that
+// is only NotReady or cordoned it won't come back under the same name.
+func (st *Stable) recordedNodeDeleted(nodeName string) bool {
+	if st.nodeLister == nil {
+		return false
+	}
+	_, err := st.nodeLister.Get(nodeName)
+	return errors.IsNotFound(err)
+}
+
+// clearRecordEntry removes the entry of the pod recorded on the node from the record of its
+// statefulset, so the pod is recorded anew wherever it is bound, e.g. when the node was deleted.
+// It is best-effort, a failure is only logged since the entry is advisory already and PostBind
+// overwrites it. why explains the clearing in the logs.
+func (st *Stable) clearRecordEntry(ctx context.Context, statefulset *appsv1.StatefulSet, pod *v1.Pod, nodeName, why string) {
+	if isFrozen(statefulset) {
+		return
+	}
+	key := st.keyOf(pod)
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		current, err := st.statefulSetLister.StatefulSets(statefulset.Namespace).Get(statefulset.Name)
+		if err != nil {
+			// the sets of custom owners and owner kinds aren't in the lister
+			current = statefulset
+		}
+		record, err := st.store.Get(ctx, current)
+		if err != nil || record == nil {
+			return err
+		}
+		if record.Records[key].Node != nodeName || record.ownerOf(key) != pod.GetName() {
+			return nil
+		}
+		record.deleteEntry(key)
+		return st.store.Set(ctx, current, record)
+	})
+	if err != nil {
+		klog.Warningf("Failed to clear the record of pod %s/%s on node %s, %s: %v", pod.Namespace, pod.Name, nodeName, why, err)
+		return
+	}
+	klog.V(3).Infof("Cleared the record of pod %s/%s on node %s, %s", pod.Namespace, pod.Name, nodeName, why)
+}
+
+// nodeLabelSnapshot returns the fallback labels of the node, nil if the node can't be found.
+func (st *Stable) nodeLabelSnapshot(nodeName string) map[string]string {
+	if st.nodeLister == nil {
+		return nil
+	}
+	node, err := st.nodeLister.Get(nodeName)
+	if err != nil {
+		klog.V(4).Infof("Failed to get node %s for its label snapshot: %v", nodeName, err)
+		return nil
+	}
+	snapshot := make(map[string]string, len(st.args.FallbackLabelKeys))
+	for _, key := range st.args.FallbackLabelKeys {
+		if value, ok := node.Labels[key]; ok {
+			snapshot[key] = value
+		}
+	}
+	return snapshot
+}
+
+// matchingLabels counts the labels of the snapshot the node labels match.
+func matchingLabels(snapshot, nodeLabels map[string]string) int {
+	matched := 0
+	for key, value := range snapshot {
+		if nodeValue, ok := nodeLabels[key]; ok && nodeValue == value {
+			matched++
+		}
+	}
+	return matched
+}
+
+// Score prefers the node a relocated pod may return to within the grace window, the node of an
+// advisory record, weighted by tenure with TenureSaturationSeconds, the nodes that ran the image of the pod with ImageLocality, and the nodes
+// sharing the fallback labels of the recorded node of the pod, when the recorded node is
+// unavailable and FallbackPreferred is set. In ModeSoft the other nodes of a recorded pod score
+// OtherNodeScore. All nodes score 0 otherwise.
+func (st *Stable) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	if st.idle() {
+		return 0, framework.NewStatus(framework.Success, "")
+	}
+	s := st.getPreFilterState(ctx, state, pod)
+	if s.enforce && s.advisory {
+		if recorded := s.record.Records[s.key].Node; st.matchesNode(recorded, nodeName) {
+			return st.recordedNodeScore(s.record, s.key), framework.NewStatus(framework.Success, "")
+		}
+		if st.args.Mode == ModeSoft && !s.imageNodes.Has(nodeName) {
+			return st.args.OtherNodeScore, framework.NewStatus(framework.Success, "")
+		}
+	} else if s.enforce && s.record != nil {
+		if returnNode := s.record.returnNodeOf(s.key, st.now()); returnNode != "" && st.matchesNode(returnNode, nodeName) {
+			return framework.MaxNodeScore, framework.NewStatus(framework.Success, "")
+		}
+	}
+	if s.imageNodes.Has(nodeName) {
+		return framework.MaxNodeScore, framework.NewStatus(framework.Success, "")
+	}
+	if st.args.SiblingPlacement != "" && s.enforce && !s.recorded(s.key) {
+		return st.siblingScore(s.record, s.key, nodeName), framework.NewStatus(framework.Success, "")
+	}
+	if st.args.Fallback != FallbackPreferred || len(s.fallbackLabels) == 0 {
+		return 0, framework.NewStatus(framework.Success, "")
+	}
+	node, err := st.nodeLister.Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	matched := matchingLabels(s.fallbackLabels, node.Labels)
+	return framework.MaxNodeScore * int64(matched) / int64(len(s.fallbackLabels)), framework.NewStatus(framework.Success, "")
+}
+
+// ScoreExtensions of the Score plugin.
+func (st *Stable) ScoreExtensions() framework.ScoreExtensions {
+	return st
+}
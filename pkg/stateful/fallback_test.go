@@ -0,0 +1,480 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestFallback(t *testing.T) {
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"zone": "a", "rack": "r2"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{"zone": "b", "rack": "r1"}}},
+	}
+	tests := []struct {
+		name           string
+		fallback       string
+		record         string
+		expectedCodes  map[string]framework.Code
+		expectedScores map[string]int64
+	}{
+		{
+			name:           "no fallback, the recorded node was deleted",
+			record:         `{"Records":{"web-0":{"Node":"node1","Labels":{"rack":"r1","zone":"a"}}}}`,
+			expectedCodes:  map[string]framework.Code{"node2": framework.UnschedulableAndUnresolvable, "node3": framework.UnschedulableAndUnresolvable},
+			expectedScores: map[string]int64{"node2": 0, "node3": 0},
+		},
+		{
+			name:           "required fallback only admits nodes matching all labels",
+			fallback:       FallbackRequired,
+			record:         `{"Records":{"web-0":{"Node":"node1","Labels":{"zone":"a"}}}}`,
+			expectedCodes:  map[string]framework.Code{"node2": framework.Success, "node3": framework.Unschedulable},
+			expectedScores: map[string]int64{"node2": 0, "node3": 0},
+		},
+		{
+			name:           "preferred fallback scores nodes by the share of matching labels",
+			fallback:       FallbackPreferred,
+			record:         `{"Records":{"web-0":{"Node":"node1","Labels":{"rack":"r1","zone":"a"}}}}`,
+			expectedCodes:  map[string]framework.Code{"node2": framework.Success, "node3": framework.Success},
+			expectedScores: map[string]int64{"node2": 50, "node3": 50},
+		},
+		{
+			name:           "preferred fallback prefers the node matching the most labels",
+			fallback:       FallbackPreferred,
+			record:         `{"Records":{"web-0":{"Node":"node1","Labels":{"rack":"r2","zone":"a"}}}}`,
+			expectedCodes:  map[string]framework.Code{"node2": framework.Success, "node3": framework.Success},
+			expectedScores: map[string]int64{"node2": framework.MaxNodeScore, "node3": 0},
+		},
+		{
+			name:           "recorded node is available",
+			fallback:       FallbackRequired,
+			record:         `{"Records":{"web-0":{"Node":"node3","Labels":{"zone":"a"}}}}`,
+			expectedCodes:  map[string]framework.Code{"node2": framework.Unschedulable, "node3": framework.Success},
+			expectedScores: map[string]int64{"node2": 0, "node3": 0},
+		},
+		{
+			name:           "record without label snapshot, the recorded node was deleted",
+			fallback:       FallbackRequired,
+			record:         `{"Records":{"web-0":"node1"}}`,
+			expectedCodes:  map[string]framework.Code{"node2": framework.UnschedulableAndUnresolvable, "node3": framework.UnschedulableAndUnresolvable},
+			expectedScores: map[string]int64{"node2": 0, "node3": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{Fallback: tt.fallback, FallbackLabelKeys: []string{"zone", "rack"}, PinToDeletedNodes: true},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range nodes {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			for _, node := range nodes {
+				nodeInfo := schedulernodeinfo.NewNodeInfo()
+				if err := nodeInfo.SetNode(node); err != nil {
+					t.Fatal(err)
+				}
+				if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCodes[node.Name] {
+					t.Errorf("expected %v on %s, got %v", tt.expectedCodes[node.Name], node.Name, code)
+				}
+				score, status := stableSchedule.Score(ctx, state, pod, node.Name)
+				if !status.IsSuccess() {
+					t.Fatal(status.Message())
+				}
+				if score != tt.expectedScores[node.Name] {
+					t.Errorf("expected score %v on %s, got %v", tt.expectedScores[node.Name], node.Name, score)
+				}
+			}
+		})
+	}
+}
+
+func TestPostBindSavesLabelSnapshot(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	nodeInformer := informers.Core().V1().Nodes()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		nodeLister:        nodeInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{Fallback: FallbackRequired, FallbackLabelKeys: []string{"zone", "rack"}},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"zone": "a", "disk": "ssd"}}}
+	if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+
+	ctx := context.TODO()
+	stableSchedule.PostBind(ctx, nil, pod, "node1")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":{"Node":"node1","Labels":{"zone":"a"}}}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestFilterDeletedRecordedNode(t *testing.T) {
+	notReady := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}},
+		},
+	}
+	cordoned := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Spec: corev1.NodeSpec{Unschedulable: true}}
+	other := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}
+	tests := []struct {
+		name         string
+		nodes        []*corev1.Node
+		pin          bool
+		expectedCode framework.Code
+		// expectedRecord is the record after binding the pod to node2, empty if it isn't bound
+		expectedRecord string
+	}{
+		{
+			name:         "recorded node is not ready, pinned",
+			nodes:        []*corev1.Node{notReady, other},
+			pin:          true,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "recorded node was deleted, pinned",
+			nodes:        []*corev1.Node{other},
+			pin:          true,
+			expectedCode: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name:         "recorded node is not ready, not relocated",
+			nodes:        []*corev1.Node{notReady, other},
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "recorded node is cordoned, not relocated",
+			nodes:        []*corev1.Node{cordoned, other},
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:           "recorded node was deleted, the pod is relocated",
+			nodes:          []*corev1.Node{other},
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"web-0":"node2"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{PinToDeletedNodes: tt.pin},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range tt.nodes {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			// the entry of a deleted node is cleared by the node delete event handler, not on the
+			// scheduling path
+			expectedPreFilterRecord := `{"Records":{"web-0":"node1"}}`
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expectedPreFilterRecord {
+				t.Errorf("expected %v after PreFilter, got %v", expectedPreFilterRecord, got)
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(other); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedRecord == "" {
+				return
+			}
+
+			stableSchedule.PostBind(ctx, state, pod, "node2")
+			s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
+
+// BenchmarkRecordedNodeDeleted shows that the node check of a scheduling cycle takes the same
+// time regardless of the number of nodes in the cluster.
+func BenchmarkRecordedNodeDeleted(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("%d nodes", size), func(b *testing.B) {
+			informers := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+			nodeInformer := informers.Core().V1().Nodes()
+			for i := 0; i < size; i++ {
+				node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%d", i)}}
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					b.Fatal(err)
+				}
+			}
+			stableSchedule := &Stable{nodeLister: nodeInformer.Lister()}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if stableSchedule.recordedNodeDeleted(fmt.Sprintf("node%d", i%size)) {
+					b.Fatal("expected the node to exist")
+				}
+			}
+		})
+	}
+}
+
+func TestSoftMode(t *testing.T) {
+	node1 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	node2 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}
+	tests := []struct {
+		name           string
+		mode           string
+		otherNodeScore int64
+		nodes          []*corev1.Node
+		expectedCode   framework.Code
+		// expectedScores are the scores of node1, the recorded node, and node2
+		expectedScores []int64
+	}{
+		{
+			name:           "hard mode filters the other nodes",
+			mode:           ModeHard,
+			nodes:          []*corev1.Node{node1, node2},
+			expectedCode:   framework.Unschedulable,
+			expectedScores: []int64{0, 0},
+		},
+		{
+			name:           "soft mode prefers the recorded node",
+			mode:           ModeSoft,
+			nodes:          []*corev1.Node{node1, node2},
+			expectedCode:   framework.Success,
+			expectedScores: []int64{framework.MaxNodeScore, 0},
+		},
+		{
+			name:           "soft mode scores the other nodes lower",
+			mode:           ModeSoft,
+			otherNodeScore: 40,
+			nodes:          []*corev1.Node{node1, node2},
+			expectedCode:   framework.Success,
+			expectedScores: []int64{framework.MaxNodeScore, 40},
+		},
+		{
+			name:           "hard mode keeps the pod pending when the recorded node no longer exists",
+			mode:           ModeHard,
+			nodes:          []*corev1.Node{node2},
+			expectedCode:   framework.UnschedulableAndUnresolvable,
+			expectedScores: []int64{0, 0},
+		},
+		{
+			name:           "soft mode schedules the pod elsewhere when the recorded node no longer exists",
+			mode:           ModeSoft,
+			nodes:          []*corev1.Node{node2},
+			expectedCode:   framework.Success,
+			expectedScores: []int64{framework.MaxNodeScore, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{Mode: tt.mode, OtherNodeScore: tt.otherNodeScore, PinToDeletedNodes: true},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range tt.nodes {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(node2); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			for i, nodeName := range []string{"node1", "node2"} {
+				score, status := stableSchedule.Score(ctx, state, pod, nodeName)
+				if !status.IsSuccess() {
+					t.Fatal(status.Message())
+				}
+				if score != tt.expectedScores[i] {
+					t.Errorf("expected score %d for %s, got %d", tt.expectedScores[i], nodeName, score)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// StatefulsetStableFrozen is the statefulset annotation freezing its record when "true": pods
+// are still pinned to their recorded nodes, but the record is neither extended, updated nor
+// pruned until the annotation is removed.
+const StatefulsetStableFrozen = "statefulset-stable.scheduling.sigs.k8s.io/frozen"
+
+// isFrozen checks whether the record of the statefulset is frozen.
+func isFrozen(statefulset *appsv1.StatefulSet) bool {
+	return statefulset.GetAnnotations()[StatefulsetStableFrozen] == "true"
+}
+
+// frozenStatefulSets tracks the keys of the frozen statefulsets seen by reconcile, for the
+// frozen statefulsets gauge. It is safe for concurrent use by the reconcile workers.
+type frozenStatefulSets struct {
+	lock sync.Mutex
+	keys sets.String
+}
+
+// track records whether the statefulset with the key is frozen and updates the gauge.
+func (f *frozenStatefulSets) track(key string, frozen bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.keys == nil {
+		f.keys = sets.NewString()
+	}
+	if frozen {
+		f.keys.Insert(key)
+	} else {
+		f.keys.Delete(key)
+	}
+	frozenStatefulSetsGauge.Set(float64(f.keys.Len()))
+}
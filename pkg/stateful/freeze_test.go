@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestReconcileFrozen(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record":   `{"Records":{"web-0":"node1","web-3":"node2"}}`,
+				"statefulset-stable.scheduling.sigs.k8s.io/ordinals": "0-1",
+				"statefulset-stable.scheduling.sigs.k8s.io/frozen":   "true",
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	frozen, err := testutil.GetGaugeMetricValue(frozenStatefulSetsGauge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stableSchedule.reconcile(ctx, "n1/web"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testutil.GetGaugeMetricValue(frozenStatefulSetsGauge); err != nil || got-frozen != 1 {
+		t.Errorf("expected freezing to increment the gauge, got %v (%v)", got-frozen, err)
+	}
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1","web-3":"node2"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected the frozen record %v to be kept, got %v", expected, got)
+	}
+	// reconciling a frozen statefulset again doesn't count it twice
+	if err := stableSchedule.reconcile(ctx, "n1/web"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testutil.GetGaugeMetricValue(frozenStatefulSetsGauge); err != nil || got-frozen != 1 {
+		t.Errorf("expected the gauge to stay incremented, got %v (%v)", got-frozen, err)
+	}
+
+	unfrozen := s.DeepCopy()
+	delete(unfrozen.Annotations, "statefulset-stable.scheduling.sigs.k8s.io/frozen")
+	if err := statefulsetInformer.Informer().GetIndexer().Update(unfrozen); err != nil {
+		t.Fatal(err)
+	}
+	if err := stableSchedule.reconcile(ctx, "n1/web"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testutil.GetGaugeMetricValue(frozenStatefulSetsGauge); err != nil || got != frozen {
+		t.Errorf("expected unfreezing to decrement the gauge, got %v (%v)", got-frozen, err)
+	}
+	s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestPostBindFrozen(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+				"statefulset-stable.scheduling.sigs.k8s.io/frozen": "true",
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-1",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+
+	ctx := context.TODO()
+	stableSchedule.PostBind(ctx, nil, pod, "node2")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
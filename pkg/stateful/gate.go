@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// recordsSyncedFor returns the functions reporting whether the caches the records are read from
+// with the args have synced: the statefulsets, the ConfigMaps of the ConfigMap stores and the
+// ReplicaSets the pods of Deployments are recorded under.
+func recordsSyncedFor(args StableArgs, informerFactory informers.SharedInformerFactory) []cache.InformerSynced {
+	synced := []cache.InformerSynced{informerFactory.Apps().V1().StatefulSets().Informer().HasSynced}
+	if args.ClusterRecordConfigMap != "" || args.StoreType == StoreTypeConfigMap {
+		synced = append(synced, informerFactory.Core().V1().ConfigMaps().Informer().HasSynced)
+	}
+	if hasOwnerKind(args.OwnerKinds, OwnerKindDeployment) {
+		synced = append(synced, informerFactory.Apps().V1().ReplicaSets().Informer().HasSynced)
+	}
+	return synced
+}
+
+// recordsLoadable checks whether the caches the records are read from have synced. Until then
+// a missing statefulset or record can't be told apart from one that is not cached yet.
+func (st *Stable) recordsLoadable() bool {
+	for _, synced := range st.recordsSynced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// ownedByStatefulSet checks whether the pod has a statefulset owner reference.
+func ownedByStatefulSet(pod *v1.Pod) bool {
+	for _, ow := range pod.GetOwnerReferences() {
+		if ow.Kind == Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// gateUnloadableRecord returns the reason to defer scheduling the pod until its record can be
+// read, empty if the pod may be scheduled. The framework of this version has no PreEnqueue
+// extension point, so PreFilter rejects the pod and the scheduling queue retries it later.
+func (st *Stable) gateUnloadableRecord(pod *v1.Pod) string {
+	if !st.eligible(pod) || !hasRecordOwner(pod, st.args) || st.recordsLoadable() {
+		return ""
+	}
+	return "waiting for the statefulset caches to sync before reading the record of the pod"
+}
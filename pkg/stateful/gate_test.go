@@ -0,0 +1,198 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestPreFilterWaitsForRecords(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	newPod := func(optedIn, owned bool) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-0",
+				Namespace: "n1",
+				Labels:    map[string]string{},
+			},
+		}
+		if optedIn {
+			pod.Labels["statefulset-stable.scheduling.sigs.k8s.io"] = "true"
+		}
+		if owned {
+			pod.OwnerReferences = []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			}
+		}
+		return pod
+	}
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		synced       bool
+		expectedCode framework.Code
+	}{
+		{
+			name:         "caches not synced",
+			pod:          newPod(true, true),
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "caches synced",
+			pod:          newPod(true, true),
+			synced:       true,
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "pod not opted in",
+			pod:          newPod(false, true),
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "pod without statefulset",
+			pod:          newPod(true, false),
+			expectedCode: framework.Success,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				recordsSynced: []cache.InformerSynced{
+					func() bool { return true },
+					func() bool { return tt.synced },
+				},
+			}
+			if code := stableSchedule.PreFilter(context.TODO(), framework.NewCycleState(), tt.pod).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestPreFilterDefersUntilRecordReadable(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	synced := false
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		recordsSynced:     []cache.InformerSynced{func() bool { return synced }},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the statefulset is not cached yet, without the gate the pod would go anywhere
+	if code := stableSchedule.PreFilter(context.TODO(), framework.NewCycleState(), pod).Code(); code != framework.Unschedulable {
+		t.Errorf("expected the pod to be deferred before the caches synced, got %v", code)
+	}
+
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	synced = true
+	state := framework.NewCycleState()
+	if code := stableSchedule.PreFilter(context.TODO(), state, pod).Code(); code != framework.Success {
+		t.Errorf("expected the pod to pass PreFilter once the caches synced, got %v", code)
+	}
+	if code := stableSchedule.Filter(context.TODO(), state, pod, nodeInfo).Code(); code != framework.Unschedulable {
+		t.Errorf("expected the record to pin the pod to node1, got %v", code)
+	}
+}
+
+func TestRecordsSyncedWaitsForReplicaSets(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+	stableSchedule := &Stable{
+		recordsSynced: recordsSyncedFor(StableArgs{OwnerKinds: []string{OwnerKindDeployment}}, informerFactory),
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+
+	statefulsetInformer := informerFactory.Apps().V1().StatefulSets().Informer()
+	go statefulsetInformer.Run(stop)
+	if !cache.WaitForCacheSync(stop, statefulsetInformer.HasSynced) {
+		t.Fatal("the statefulset informer didn't sync")
+	}
+	if stableSchedule.recordsLoadable() {
+		t.Error("expected the gate to stay closed until the ReplicaSet informer synced")
+	}
+
+	replicaSetInformer := informerFactory.Apps().V1().ReplicaSets().Informer()
+	go replicaSetInformer.Run(stop)
+	if !cache.WaitForCacheSync(stop, replicaSetInformer.HasSynced) {
+		t.Fatal("the ReplicaSet informer didn't sync")
+	}
+	if !stableSchedule.recordsLoadable() {
+		t.Error("expected the gate to open once the ReplicaSet informer synced")
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	// StatefulsetStableGroup is the statefulset annotation naming the stability group of the
+	// statefulset. The pods of the statefulsets of a group in the same namespace share one node
+	// per ordinal: the first pod bound with an ordinal assigns its node to the ordinal, the pods
+	// of the other statefulsets with the same ordinal are pinned to it, and pods of ordinals
+	// without a node avoid the nodes of the other ordinals.
+	StatefulsetStableGroup = "statefulset-stable.scheduling.sigs.k8s.io/group"
+	// StatefulsetStableGroupRecord is the annotation of the anchor statefulset of a group,
+	// holding the record of the group that maps ordinals to nodes.
+	StatefulsetStableGroupRecord = "statefulset-stable.scheduling.sigs.k8s.io/group-record"
+)
+
+// groupState is the stability group of a pod computed at PreFilter.
+type groupState struct {
+	name string
+	// anchor is the statefulset holding the group record.
+	anchor *appsv1.StatefulSet
+	// node is the node of the ordinal of the pod, empty if the ordinal has no node yet.
+	node string
+	// reserved is set when node is only reserved by another pod of the group being bound.
+	reserved bool
+	// otherNodes are the nodes of the other ordinals of the group.
+	otherNodes sets.String
+}
+
+// groupAnchor returns the statefulset holding the record of the group, the oldest statefulset
+// of the group in the namespace. Ties are broken by name, so all members agree on the anchor.
+func (st *Stable) groupAnchor(namespace, group string) (*appsv1.StatefulSet, error) {
+	statefulsets, err := st.statefulSetLister.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var members []*appsv1.StatefulSet
+	for _, statefulset := range statefulsets {
+		if statefulset.GetAnnotations()[StatefulsetStableGroup] == group {
+			members = append(members, statefulset)
+		}
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no statefulset of group %q in namespace %s", group, namespace)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].CreationTimestamp.Equal(&members[j].CreationTimestamp) {
+			return members[i].CreationTimestamp.Before(&members[j].CreationTimestamp)
+		}
+		return members[i].Name < members[j].Name
+	})
+	return members[0], nil
+}
+
+// getGroupRecord decodes the group record of the anchor statefulset, nil if it has none.
+func getGroupRecord(anchor *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	rec, ok := anchor.GetAnnotations()[StatefulsetStableGroupRecord]
+	if !ok {
+		return nil, nil
+	}
+	return decodeScheduleRecord(rec, maxRecordSize)
+}
+
+// computeGroupState resolves the stability group of the pod, nil if its statefulset is in no group.
+func (st *Stable) computeGroupState(statefulset *appsv1.StatefulSet, pod *v1.Pod) *groupState {
+	group, ok := statefulset.GetAnnotations()[StatefulsetStableGroup]
+	if !ok || group == "" {
+		return nil
+	}
+	ordinal, ok := parseOrdinal(pod.GetName())
+	if !ok {
+		return nil
+	}
+	anchor, err := st.groupAnchor(statefulset.Namespace, group)
+	if err != nil {
+		klog.V(3).Infof("Failed to get the anchor of group %q of pod %s/%s: %v", group, pod.Namespace, pod.Name, err)
+		return nil
+	}
+	g := &groupState{name: group, anchor: anchor, otherNodes: sets.NewString()}
+	record, err := getGroupRecord(anchor)
+	if err != nil {
+		klog.V(3).Infof("Ignoring record of group %q in statefulset %s/%s: %v", group, anchor.Namespace, anchor.Name, err)
+		return g
+	}
+	// the nodes reserved by the pods of the group being bound count as assigned, the
+	// recorded nodes take precedence
+	reserved := st.reservations.groupNodes(statefulset.Namespace, group, pod.UID)
+	nodes := make(map[string]string, len(reserved))
+	for key, node := range reserved {
+		nodes[key] = node
+	}
+	if record != nil {
+		for key, node := range record.nodes() {
+			nodes[key] = node
+			delete(reserved, key)
+		}
+	}
+	slot := strconv.Itoa(ordinal)
+	for key, node := range nodes {
+		if key == slot {
+			g.node = node
+			_, g.reserved = reserved[key]
+		} else {
+			g.otherNodes.Insert(node)
+		}
+	}
+	return g
+}
+
+// filterGroup enforces the placement of the stability group of the pod, nil when the node fits.
+func (st *Stable) filterGroup(g *groupState, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	if g == nil {
+		return nil
+	}
+	nodeName := nodeInfo.Node().GetName()
+	if g.node != "" {
+		if !st.matchesNode(g.node, nodeName) {
+			return framework.NewStatus(framework.Unschedulable, fmt.Sprintf("ordinal of group %q is on another node", g.name))
+		}
+		return nil
+	}
+	if g.otherNodes.Has(nodeName) {
+		return framework.NewStatus(framework.Unschedulable, fmt.Sprintf("node is used by another ordinal of group %q", g.name))
+	}
+	return nil
+}
+
+// setGroupRecord assigns the node to the ordinal of the pod in the group record if the ordinal has no node yet.
+func (st *Stable) setGroupRecord(ctx context.Context, g *groupState, pod *v1.Pod, nodeName string) error {
+	ordinal, ok := parseOrdinal(pod.GetName())
+	if !ok {
+		return nil
+	}
+	slot := strconv.Itoa(ordinal)
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		anchor, err := st.clientset.AppsV1().StatefulSets(g.anchor.Namespace).Get(ctx, g.anchor.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		record, err := getGroupRecord(anchor)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			record = new(ScheduleRecord)
+		}
+		if _, ok := record.Records[slot]; ok {
+			return nil
+		}
+		record.setEntry(slot, pod.GetName(), nodeName, RecordSourceAuto)
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if anchor.Annotations == nil {
+			anchor.Annotations = make(map[string]string)
+		}
+		anchor.Annotations[StatefulsetStableGroupRecord] = string(value)
+		_, err = st.clientset.AppsV1().StatefulSets(anchor.Namespace).Update(ctx, anchor, metav1.UpdateOptions{})
+		return err
+	})
+}
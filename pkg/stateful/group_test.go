@@ -0,0 +1,156 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestStabilityGroup(t *testing.T) {
+	created := time.Now()
+	statefulsets := []*appsv1.StatefulSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "shard-a",
+				Namespace:         "n1",
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations:       map[string]string{StatefulsetStableGroup: "shards"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "shard-b",
+				Namespace:         "n1",
+				CreationTimestamp: metav1.NewTime(created.Add(time.Minute)),
+				Annotations:       map[string]string{StatefulsetStableGroup: "shards"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "n1",
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulsets[0], statefulsets[1], statefulsets[2])
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	for _, statefulset := range statefulsets {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newPod := func(owner, name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: owner,
+					},
+				},
+			},
+		}
+	}
+	filter := func(pod *corev1.Pod, nodeName string) framework.Code {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+			t.Fatal(err)
+		}
+		return stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code()
+	}
+
+	// shard-b bootstraps ordinal 0 of the group, the record is kept by the anchor shard-a
+	ctx := context.TODO()
+	stableSchedule.PostBind(ctx, nil, newPod("shard-b", "shard-b-0"), "node1")
+	anchor, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "shard-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"0":{"Node":"node1","Owner":"shard-b-0"}}}`
+	if got := anchor.Annotations[StatefulsetStableGroupRecord]; got != expected {
+		t.Fatalf("expected group record %v, got %v", expected, got)
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Update(anchor); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		nodeName     string
+		expectedCode framework.Code
+	}{
+		{
+			name:         "same ordinal of another statefulset is pinned to the node of the ordinal",
+			pod:          newPod("shard-a", "shard-a-0"),
+			nodeName:     "node2",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "same ordinal of another statefulset on the node of the ordinal",
+			pod:          newPod("shard-a", "shard-a-0"),
+			nodeName:     "node1",
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "other ordinal avoids the node of ordinal 0",
+			pod:          newPod("shard-a", "shard-a-1"),
+			nodeName:     "node1",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "other ordinal on a free node",
+			pod:          newPod("shard-a", "shard-a-1"),
+			nodeName:     "node2",
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "statefulset without group is unaffected",
+			pod:          newPod("web", "web-1"),
+			nodeName:     "node1",
+			expectedCode: framework.Success,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := filter(tt.pod, tt.nodeName); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// registerEventHandlers adds the event handlers of the enabled features to the shared informers.
+// It is called once by New, so every handler is registered once per plugin instance. Requesting
+// the informers here also makes the scheduler start them and wait for their caches to sync.
+func (st *Stable) registerEventHandlers(informerFactory informers.SharedInformerFactory) {
+	statefulsetInformer := informerFactory.Apps().V1().StatefulSets().Informer()
+	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
+
+	if stores := outlivingStores(st.store); len(stores) > 0 {
+		statefulsetInformer.AddEventHandler(st.statefulSetDeleteEventHandler(stores))
+	}
+	if st.reconcileQueue != nil {
+		statefulsetInformer.AddEventHandler(st.reconcileEventHandler())
+	}
+	if st.args.DeleteTerminatingRecords {
+		statefulsetInformer.AddEventHandler(st.terminatingEventHandler())
+	}
+	if st.args.EnforceAfterReady {
+		statefulsetInformer.AddEventHandler(st.readinessEventHandler())
+	}
+	if st.delaysRecords() {
+		informerFactory.Core().V1().Pods().Informer().AddEventHandler(st.pendingRecordsEventHandler())
+	}
+	if st.args.PreemptedPodPolicy == PreemptedPodMove {
+		informerFactory.Core().V1().Pods().Informer().AddEventHandler(st.preemptedEventHandler())
+	}
+	if st.args.NodeIdentityLabel != "" {
+		nodeInformer.AddEventHandler(st.nodeIdentityEventHandler())
+	}
+	if st.optIns != nil {
+		statefulsetInformer.AddEventHandler(st.optInEventHandler())
+	}
+	if !st.args.PinToDeletedNodes {
+		nodeInformer.AddEventHandler(st.nodeDeleteEventHandler())
+	}
+}
+
+// statefulSetDeleteEventHandler deletes the record of deleted statefulsets from the given stores,
+// see outlivingStores. Records kept in the statefulset annotation are gone with the statefulset
+// already, and deleting them would patch a statefulset that doesn't exist anymore. Resyncs don't
+// deliver deletes, so the handler runs once.
+func (st *Stable) statefulSetDeleteEventHandler(stores []RecordStore) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			statefulset, ok := obj.(*appsv1.StatefulSet)
+			if !ok {
+				return
+			}
+			for _, store := range stores {
+				if err := store.Delete(context.TODO(), statefulset); err != nil && !errors.IsNotFound(err) {
+					klog.Warningf("Failed to delete the record of deleted statefulset %s/%s: %v",
+						statefulset.Namespace, statefulset.Name, err)
+				}
+			}
+		},
+	}
+}
+
+// nodeDeleteEventHandler clears the records of deleted nodes, so the pods pinned to them
+// can be scheduled again.
+func (st *Stable) nodeDeleteEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*v1.Node); ok {
+				st.clearNodeRecords(context.TODO(), node.Name, "deleted")
+			}
+		},
+	}
+}
+
+// clearNodeRecords removes the entries of the node from the records of all statefulsets, the
+// reason describes the node in the logs. It returns the pods whose entry was cleared.
+func (st *Stable) clearNodeRecords(ctx context.Context, nodeName, reason string) []types.NamespacedName {
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list statefulsets to clear the records of node %s: %v", nodeName, err)
+		return nil
+	}
+	var clearedPods []types.NamespacedName
+	for _, statefulset := range statefulsets {
+		namespace, name := statefulset.Namespace, statefulset.Name
+		var pods []types.NamespacedName
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			pods = nil
+			statefulset, err := st.statefulSetLister.StatefulSets(namespace).Get(name)
+			if err != nil {
+				return err
+			}
+			record, err := st.store.Get(ctx, statefulset)
+			if err != nil || record == nil {
+				return err
+			}
+			for key, node := range record.nodes() {
+				if st.matchesNode(node, nodeName) {
+					klog.V(3).Infof("Clearing the record of pod %s/%s on %s node %s", namespace, record.ownerOf(key), reason, nodeName)
+					pods = append(pods, types.NamespacedName{Namespace: namespace, Name: record.ownerOf(key)})
+					record.deleteEntry(key)
+				}
+			}
+			if len(pods) == 0 {
+				return nil
+			}
+			return st.store.Set(ctx, statefulset, record)
+		})
+		if err != nil {
+			if !errors.IsNotFound(err) && !isInvalidRecord(err) {
+				klog.Warningf("Failed to clear the records of node %s in statefulset %s/%s: %v", nodeName, namespace, name, err)
+			}
+			continue
+		}
+		clearedPods = append(clearedPods, pods...)
+	}
+	return clearedPods
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNodeDeleteEventHandler(t *testing.T) {
+	statefulsets := []*appsv1.StatefulSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db",
+				Namespace: "n2",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"db-0":"node1"}}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cache",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"cache-0":"node3"}}`,
+				},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulsets[0], statefulsets[1], statefulsets[2])
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	for _, statefulset := range statefulsets {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	stableSchedule.nodeDeleteEventHandler().OnDelete(cache.DeletedFinalStateUnknown{Key: "node1", Obj: node})
+
+	expected := map[string]string{
+		"n1/web":   `{"Records":{"web-1":"node2"}}`,
+		"n2/db":    `{"Records":{}}`,
+		"n1/cache": `{"Records":{"cache-0":"node3"}}`,
+	}
+	ctx := context.TODO()
+	for _, statefulset := range statefulsets {
+		s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := statefulset.Namespace + "/" + statefulset.Name
+		if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected[key] {
+			t.Errorf("expected %v for %s, got %v", expected[key], key, got)
+		}
+	}
+}
+
+func TestNodeDeleteEventHandlerDomainSuffix(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1.example.internal","web-1":"node2"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{NodeDomainSuffix: "example.internal"},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	// the record has the fully qualified name of the node, the node its short name
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	stableSchedule.nodeDeleteEventHandler().OnDelete(node)
+
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-1":"node2"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestStatefulSetDeleteEventHandler(t *testing.T) {
+	store := newMemoryStore()
+	stableSchedule := &Stable{store: store}
+	ctx := context.TODO()
+	web := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	db := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "n1"}}
+	for _, statefulset := range []*appsv1.StatefulSet{web, db} {
+		if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{statefulset.Name + "-0": {Node: "node1"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := stableSchedule.statefulSetDeleteEventHandler(outlivingStores(store))
+	// resyncs are delivered as updates and keep the record
+	handler.OnUpdate(web, web)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "n1/db", Obj: db})
+
+	if record, err := store.Get(ctx, web); err != nil || record == nil {
+		t.Errorf("expected the record of web to be kept, got %v, %v", record, err)
+	}
+	if record, err := store.Get(ctx, db); err != nil || record != nil {
+		t.Errorf("expected the record of db to be deleted, got %v, %v", record, err)
+	}
+}
+
+func TestStatefulSetDeleteEventHandlerAnnotationStore(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	annotations := newAnnotationStore(clientset)
+	secondary := newMemoryStore()
+	ctx := context.TODO()
+	web := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	if err := secondary.Set(ctx, web, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stores := outlivingStores(annotations); len(stores) != 0 {
+		t.Errorf("expected no store to outlive the statefulset, got %v", stores)
+	}
+	stores := outlivingStores(newMultiStore(annotations, secondary))
+	if len(stores) != 1 || stores[0] != secondary {
+		t.Fatalf("expected the secondary store to outlive the statefulset, got %v", stores)
+	}
+	stableSchedule := &Stable{clientset: clientset, store: newMultiStore(annotations, secondary)}
+	stableSchedule.statefulSetDeleteEventHandler(stores).OnDelete(web)
+
+	if record, err := secondary.Get(ctx, web); err != nil || record != nil {
+		t.Errorf("expected the record of web to be deleted, got %v, %v", record, err)
+	}
+	// the deleted statefulset is not patched
+	if actions := clientset.Actions(); len(actions) != 0 {
+		t.Errorf("expected no API calls, got %v", actions)
+	}
+}
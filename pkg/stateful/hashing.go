@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"hash/fnv"
+
+	v1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+var _ framework.ScoreExtensions = &Stable{}
+
+// hashNode returns the rendezvous hash of the pod identity on the node.
+func hashNode(identity, nodeName string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(identity))
+	h.Write([]byte{0})
+	h.Write([]byte(nodeName))
+	return h.Sum64()
+}
+
+// hashedNode returns the node with the highest rendezvous hash for the pod identity among the
+// nodes. Removing or adding a node only moves the pods that hashed to that node.
+func hashedNode(identity string, nodes framework.NodeScoreList) string {
+	var selected string
+	var selectedHash uint64
+	for _, node := range nodes {
+		if hash := hashNode(identity, node.Name); selected == "" || hash > selectedHash || (hash == selectedHash && node.Name < selected) {
+			selected, selectedHash = node.Name, hash
+		}
+	}
+	return selected
+}
+
+// NormalizeScore prefers the node the identity of a pod without a recorded node hashes to among
+// the feasible nodes, with ConsistentHashing, so the first placement of the pod is deterministic.
+// With RecordedNodeScoreFloor, the recorded node of a pod scores at least as high as any other
+// node. The other scores are left unchanged.
+func (st *Stable) NormalizeScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod, scores framework.NodeScoreList) *framework.Status {
+	if (!st.args.ConsistentHashing && !st.args.RecordedNodeScoreFloor) || len(scores) == 0 || st.idle() {
+		return framework.NewStatus(framework.Success, "")
+	}
+	s := st.getPreFilterState(ctx, state, pod)
+	if s.statefulset == nil || !s.enforce {
+		return framework.NewStatus(framework.Success, "")
+	}
+	if s.recorded(s.key) {
+		if st.args.RecordedNodeScoreFloor {
+			st.floorRecordedNodeScore(s.record.Records[s.key].Node, scores)
+		}
+		return framework.NewStatus(framework.Success, "")
+	}
+	if !st.args.ConsistentHashing {
+		return framework.NewStatus(framework.Success, "")
+	}
+	identity := s.statefulset.Namespace + "/" + s.statefulset.Name + "/" + s.key
+	selected := hashedNode(identity, scores)
+	for i := range scores {
+		if scores[i].Name == selected {
+			scores[i].Score = framework.MaxNodeScore
+		}
+	}
+	return framework.NewStatus(framework.Success, "")
+}
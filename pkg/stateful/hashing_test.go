@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func newNodeScores(names ...string) framework.NodeScoreList {
+	scores := make(framework.NodeScoreList, 0, len(names))
+	for _, name := range names {
+		scores = append(scores, framework.NodeScore{Name: name})
+	}
+	return scores
+}
+
+func TestHashedNode(t *testing.T) {
+	nodes := newNodeScores("node1", "node2", "node3", "node4", "node5")
+	moved := 0
+	for i := 0; i < 100; i++ {
+		identity := fmt.Sprintf("n1/web/web-%d", i)
+		selected := hashedNode(identity, nodes)
+		if reversed := hashedNode(identity, newNodeScores("node5", "node4", "node3", "node2", "node1")); reversed != selected {
+			t.Errorf("expected %s to hash to %s regardless of the node order, got %s", identity, selected, reversed)
+		}
+		// removing another node never moves the pod
+		var remaining framework.NodeScoreList
+		for _, node := range nodes {
+			if node.Name != "node3" {
+				remaining = append(remaining, node)
+			}
+		}
+		got := hashedNode(identity, remaining)
+		if selected != "node3" && got != selected {
+			t.Errorf("expected %s to stay on %s after removing node3, got %s", identity, selected, got)
+		}
+		if selected == "node3" {
+			moved++
+		}
+	}
+	if moved == 0 || moved == 100 {
+		t.Errorf("expected the identities to spread over the nodes, %d hashed to node3", moved)
+	}
+}
+
+func TestNormalizeScoreConsistentHashing(t *testing.T) {
+	identity := "n1/web/web-0"
+	nodes := []string{"node1", "node2", "node3"}
+	expected := hashedNode(identity, newNodeScores(nodes...))
+
+	tests := []struct {
+		name              string
+		consistentHashing bool
+		record            string
+		expectedNode      string
+	}{
+		{
+			name:              "pod without record prefers the hashed node",
+			consistentHashing: true,
+			expectedNode:      expected,
+		},
+		{
+			name:              "recorded pod is not hashed",
+			consistentHashing: true,
+			record:            `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name: "pod is not hashed without consistent hashing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+			if tt.record != "" {
+				statefulset.Annotations = map[string]string{"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{ConsistentHashing: tt.consistentHashing},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			scores := newNodeScores(nodes...)
+			if status := stableSchedule.ScoreExtensions().NormalizeScore(context.TODO(), nil, pod, scores); !status.IsSuccess() {
+				t.Fatal(status.Message())
+			}
+			for _, score := range scores {
+				want := int64(0)
+				if score.Name == tt.expectedNode {
+					want = framework.MaxNodeScore
+				}
+				if score.Score != want {
+					t.Errorf("expected score %d for %s, got %d", want, score.Name, score.Score)
+				}
+			}
+		})
+	}
+}
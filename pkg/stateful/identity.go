@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+const (
+	// NodeIdentitySameNode treats a node whose identity label changed as the same node, the
+	// entries on it keep pinning their pods and are updated to the new identity.
+	NodeIdentitySameNode = "SameNode"
+	// NodeIdentityChangedNode treats a node whose identity label changed as another node, the
+	// entries on it are released.
+	NodeIdentityChangedNode = "ChangedNode"
+)
+
+// currentNodeIdentity returns the value of the NodeIdentityLabel of the node, empty if the node
+// can't be found or has no such label.
+func (st *Stable) currentNodeIdentity(nodeName string) string {
+	if st.nodeLister == nil {
+		return ""
+	}
+	node, err := st.nodeLister.Get(nodeName)
+	if err != nil {
+		klog.V(4).Infof("Failed to get node %s for its identity: %v", nodeName, err)
+		return ""
+	}
+	return node.Labels[st.args.NodeIdentityLabel]
+}
+
+// setIdentity saves the identity of the node recorded under the key, empty removes it.
+func (r *ScheduleRecord) setIdentity(key, identity string) {
+	r.updateEntry(key, func(entry *RecordEntry) {
+		entry.Identity = identity
+	})
+}
+
+// isOtherNodeIdentity checks whether the node recorded under the key is treated as another
+// node, with NodeIdentityChangedNode and an identity that changed since recording. Keys
+// recorded without an identity and nodes without the label are not checked.
+func (st *Stable) isOtherNodeIdentity(record *ScheduleRecord, key string) bool {
+	if st.args.NodeIdentityLabel == "" || st.args.NodeIdentityChangePolicy != NodeIdentityChangedNode {
+		return false
+	}
+	recorded := record.Records[key].Identity
+	if recorded == "" {
+		return false
+	}
+	current := st.currentNodeIdentity(record.Records[key].Node)
+	return current != "" && current != recorded
+}
+
+// nodeIdentityEventHandler reconciles the records of nodes whose identity label changes
+// following the NodeIdentityChangePolicy.
+func (st *Stable) nodeIdentityEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			identity := newNode.Labels[st.args.NodeIdentityLabel]
+			if identity == "" || identity == oldNode.Labels[st.args.NodeIdentityLabel] {
+				return
+			}
+			st.reconcileNodeIdentity(context.TODO(), newNode.Name, identity)
+		},
+	}
+}
+
+// reconcileNodeIdentity updates the entries of the node in the records of all statefulsets
+// after its identity label changed to identity. With NodeIdentitySameNode the entries take the
+// new identity, with NodeIdentityChangedNode the entries recorded with another identity are
+// removed, so their pods can be scheduled again.
+func (st *Stable) reconcileNodeIdentity(ctx context.Context, nodeName, identity string) {
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list statefulsets to reconcile the identity of node %s: %v", nodeName, err)
+		return
+	}
+	for _, statefulset := range statefulsets {
+		namespace, name := statefulset.Namespace, statefulset.Name
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			statefulset, err := st.statefulSetLister.StatefulSets(namespace).Get(name)
+			if err != nil {
+				return err
+			}
+			record, err := st.store.Get(ctx, statefulset)
+			if err != nil || record == nil {
+				return err
+			}
+			changed := false
+			for key, node := range record.nodes() {
+				recorded := record.Records[key].Identity
+				if node != nodeName || recorded == identity {
+					continue
+				}
+				if st.args.NodeIdentityChangePolicy == NodeIdentityChangedNode {
+					if recorded == "" {
+						// recorded before the identity was saved, it can't be told apart
+						continue
+					}
+					klog.V(3).Infof("Releasing pod %s/%s from node %s, its identity changed from %s to %s",
+						namespace, record.ownerOf(key), nodeName, recorded, identity)
+					record.deleteEntry(key)
+				} else {
+					record.setIdentity(key, identity)
+				}
+				changed = true
+			}
+			if !changed {
+				return nil
+			}
+			return st.store.Set(ctx, statefulset, record)
+		})
+		if err != nil && !errors.IsNotFound(err) && !isInvalidRecord(err) {
+			klog.Warningf("Failed to reconcile the identity of node %s in statefulset %s/%s: %v", nodeName, namespace, name, err)
+		}
+	}
+}
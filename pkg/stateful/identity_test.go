@@ -0,0 +1,196 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestNodeIdentityChange(t *testing.T) {
+	const identityLabel = "example.com/instance-id"
+	tests := []struct {
+		name           string
+		policy         string
+		newLabels      map[string]string
+		expectedRecord string
+		expectedCode   framework.Code
+	}{
+		{
+			name:           "same node takes the new identity",
+			policy:         NodeIdentitySameNode,
+			newLabels:      map[string]string{identityLabel: "i-2"},
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Identity":"i-2"},"web-1":{"Node":"node1","Identity":"i-2"}}}`,
+			expectedCode:   framework.Unschedulable,
+		},
+		{
+			name:      "changed node releases the entries recorded with the old identity",
+			policy:    NodeIdentityChangedNode,
+			newLabels: map[string]string{identityLabel: "i-2"},
+			// web-1 was recorded without identity, it can't be told apart and is kept
+			expectedRecord: `{"Records":{"web-1":"node1"}}`,
+			expectedCode:   framework.Success,
+		},
+		{
+			name:           "other label changes keep the record",
+			policy:         NodeIdentityChangedNode,
+			newLabels:      map[string]string{identityLabel: "i-1", "zone": "b"},
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Identity":"i-1"},"web-1":"node1"}}`,
+			expectedCode:   framework.Unschedulable,
+		},
+		{
+			name:           "removed label keeps the record",
+			policy:         NodeIdentityChangedNode,
+			newLabels:      map[string]string{},
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Identity":"i-1"},"web-1":"node1"}}`,
+			expectedCode:   framework.Unschedulable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":{"Node":"node1","Identity":"i-1"},"web-1":"node1"}}`,
+					},
+				},
+			}
+			node1 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{identityLabel: "i-1"}}}
+			node2 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{identityLabel: "i-3"}}}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args: StableArgs{
+					NodeIdentityLabel:        identityLabel,
+					NodeIdentityChangePolicy: tt.policy,
+					RecordUpdatePolicy:       RecordUpdateImmutable,
+				},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range []*corev1.Node{node1, node2} {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the identity label of node1 changes mid-life
+			updated := node1.DeepCopy()
+			updated.Labels = tt.newLabels
+			if err := nodeInformer.Informer().GetIndexer().Update(updated); err != nil {
+				t.Fatal(err)
+			}
+			stableSchedule.nodeIdentityEventHandler().OnUpdate(node1, updated)
+
+			ctx := context.TODO()
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+				t.Fatal(err)
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(node2); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestIsOtherNodeIdentity(t *testing.T) {
+	const identityLabel = "example.com/instance-id"
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	nodeInformer := informers.Core().V1().Nodes()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{identityLabel: "i-2"}}}
+	if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+		t.Fatal(err)
+	}
+	record := &ScheduleRecord{
+		Records: map[string]RecordEntry{
+			"web-0": {Node: "node1", Identity: "i-1"},
+			"web-1": {Node: "node1"},
+			"web-2": {Node: "node9", Identity: "i-1"},
+		},
+	}
+	tests := []struct {
+		name     string
+		policy   string
+		key      string
+		expected bool
+	}{
+		{name: "changed identity with changed node policy", policy: NodeIdentityChangedNode, key: "web-0", expected: true},
+		{name: "changed identity with same node policy", policy: NodeIdentitySameNode, key: "web-0"},
+		{name: "entry recorded without identity", policy: NodeIdentityChangedNode, key: "web-1"},
+		{name: "node that can't be found", policy: NodeIdentityChangedNode, key: "web-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stableSchedule := &Stable{
+				nodeLister: nodeInformer.Lister(),
+				args:       StableArgs{NodeIdentityLabel: identityLabel, NodeIdentityChangePolicy: tt.policy},
+			}
+			if got := stableSchedule.isOtherNodeIdentity(record, tt.key); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
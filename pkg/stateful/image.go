@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	// ImageLocalityPreferred prefers the nodes that ran the primary image of a pod without a
+	// recorded node, according to the record of its statefulset.
+	ImageLocalityPreferred = "Preferred"
+	// ImageLocalityRequired only schedules a pod without a recorded node to the nodes that ran
+	// its primary image, when the record of its statefulset has any.
+	ImageLocalityRequired = "Required"
+)
+
+// primaryImage returns the image of the first container of the pod.
+func primaryImage(pod *v1.Pod) string {
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[0].Image
+}
+
+// computeImageNodes returns the nodes the record saw running the primary image of the pod.
+func computeImageNodes(record *ScheduleRecord, pod *v1.Pod) sets.String {
+	image := primaryImage(pod)
+	if image == "" {
+		return nil
+	}
+	nodes := sets.NewString()
+	for _, entry := range record.Records {
+		if entry.Image == image {
+			nodes.Insert(entry.Node)
+		}
+	}
+	return nodes
+}
+
+// filterImage rejects the nodes that didn't run the primary image of the pod with
+// ImageLocalityRequired, and returns nil when the node passes.
+func (st *Stable) filterImage(s *preFilterState, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	if st.args.ImageLocality != ImageLocalityRequired || s.imageNodes.Len() == 0 {
+		return nil
+	}
+	if !s.imageNodes.Has(nodeInfo.Node().GetName()) {
+		return framework.NewStatus(framework.Unschedulable, "node didn't run the image of the pod")
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func newImagePod(name, image string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "db", Image: image}},
+		},
+	}
+}
+
+func TestImageLocality(t *testing.T) {
+	tests := []struct {
+		name          string
+		imageLocality string
+		record        string
+		pod           *corev1.Pod
+		node          string
+		expectedCode  framework.Code
+		expectedScore int64
+	}{
+		{
+			name:          "node that ran the image is preferred",
+			imageLocality: ImageLocalityPreferred,
+			record:        `{"Records":{"web-0":{"Node":"node1","Image":"db:1"},"web-1":{"Node":"node2","Image":"db:2"}}}`,
+			pod:           newImagePod("web-2", "db:1"),
+			node:          "node1",
+			expectedCode:  framework.Success,
+			expectedScore: framework.MaxNodeScore,
+		},
+		{
+			name:          "node that ran another image is not preferred",
+			imageLocality: ImageLocalityPreferred,
+			record:        `{"Records":{"web-0":{"Node":"node1","Image":"db:1"},"web-1":{"Node":"node2","Image":"db:2"}}}`,
+			pod:           newImagePod("web-2", "db:1"),
+			node:          "node2",
+			expectedCode:  framework.Success,
+			expectedScore: 0,
+		},
+		{
+			name:          "node that ran the image is required",
+			imageLocality: ImageLocalityRequired,
+			record:        `{"Records":{"web-0":{"Node":"node1","Image":"db:1"},"web-1":{"Node":"node2","Image":"db:2"}}}`,
+			pod:           newImagePod("web-2", "db:1"),
+			node:          "node2",
+			expectedCode:  framework.Unschedulable,
+		},
+		{
+			name:          "any node when no node ran the image",
+			imageLocality: ImageLocalityRequired,
+			record:        `{"Records":{"web-0":{"Node":"node1","Image":"db:1"},"web-1":{"Node":"node2","Image":"db:2"}}}`,
+			pod:           newImagePod("web-2", "db:3"),
+			node:          "node3",
+			expectedCode:  framework.Success,
+			expectedScore: 0,
+		},
+		{
+			name:          "recorded node takes precedence over the image",
+			imageLocality: ImageLocalityRequired,
+			record:        `{"Records":{"web-0":{"Node":"node1","Image":"db:1"},"web-1":{"Node":"node2","Image":"db:2"}}}`,
+			pod:           newImagePod("web-1", "db:1"),
+			node:          "node2",
+			expectedCode:  framework.Success,
+			expectedScore: 0,
+		},
+		{
+			name:          "images are ignored without image locality",
+			record:        `{"Records":{"web-0":{"Node":"node1","Image":"db:1"},"web-1":{"Node":"node2","Image":"db:2"}}}`,
+			pod:           newImagePod("web-2", "db:1"),
+			node:          "node1",
+			expectedCode:  framework.Success,
+			expectedScore: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{ImageLocality: tt.imageLocality},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, tt.pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.node}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, tt.pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedCode != framework.Success {
+				return
+			}
+			score, status := stableSchedule.Score(ctx, state, tt.pod, tt.node)
+			if !status.IsSuccess() {
+				t.Fatal(status.Message())
+			}
+			if score != tt.expectedScore {
+				t.Errorf("expected score %v, got %v", tt.expectedScore, score)
+			}
+		})
+	}
+}
+
+func TestPostBindRecordsImage(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":{"Node":"node1","Image":"db:1"}}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{ImageLocality: ImageLocalityPreferred},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	// the recorded pod runs a new image on its node, the new pod runs on another node
+	stableSchedule.PostBind(ctx, nil, newImagePod("web-0", "db:2"), "node1")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule.PostBind(ctx, nil, newImagePod("web-1", "db:2"), "node2")
+
+	s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":{"Node":"node1","Image":"db:2"},"web-1":{"Node":"node2","Image":"db:2"}}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
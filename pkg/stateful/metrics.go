@@ -0,0 +1,265 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+)
+
+const (
+	// metricsSubsystem is the prefix of the metrics of the plugin.
+	metricsSubsystem = "statefulset_stable"
+	// autoAggregateMetricsStatefulSets is the number of statefulsets beyond which MetricLabelsAuto
+	// only reports aggregate metrics.
+	autoAggregateMetricsStatefulSets = 100
+	// metricLabelsRefreshInterval is how long the MetricLabelsAuto choice is kept before the
+	// statefulsets are counted again.
+	metricLabelsRefreshInterval = time.Minute
+)
+
+var (
+	storeInconsistentStatefulSets = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "store_inconsistent_statefulsets",
+			Help:           "Number of statefulsets whose record differs between the primary and the secondary record stores at the last comparison.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	topologySpreadViolated = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "topology_spread_violated",
+			Help:           "Set to 1 for statefulsets whose records violate the max skew of their topology spread constraints.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"namespace", "statefulset"})
+
+	topologySpreadViolatedStatefulSets = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "topology_spread_violated_statefulsets",
+			Help:           "Number of statefulsets whose records violate the max skew of their topology spread constraints.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	// placementsHonored and placementsNotHonored form the placement honored rate, the
+	// share of pods with a recorded node that bound to it.
+	placementsHonored = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "placements_honored_total",
+			Help:           "Number of pods with a recorded node that bound to the recorded node.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	placementsNotHonored = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "placements_not_honored_total",
+			Help:           "Number of pods with a recorded node that bound to another node, e.g. because the record was not enforced.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	firstPlacements = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "first_placements_total",
+			Help:           "Number of stable scheduled pods bound without a record entry, whose node is recorded as their first placement.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	stuckPendingPods = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "stuck_pods",
+			Help:           "Number of pending pods pinned to a recorded node for longer than the stuck pending threshold.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	frozenStatefulSetsGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "frozen_statefulsets",
+			Help:           "Number of statefulsets whose record is frozen, as of their last reconciliation.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	recordEntriesSkipped = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "record_entries_skipped_total",
+			Help:           "Number of new record entries not written because the record entries of all statefulsets reached the cap.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	recordEntriesEvicted = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "record_entries_evicted_total",
+			Help:           "Number of least recently recorded entries evicted to keep the record entries of all statefulsets within the cap.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	filterResults = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "filter_total",
+			Help:           "Number of nodes filtered for stable scheduled pods by result: pinned to the recorded node, passed without being pinned or rejected.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"result"})
+
+	recordsWritten = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "postbind_records_written_total",
+			Help:           "Number of records written after binding a stable scheduled pod, by statefulset.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"namespace", "statefulset"})
+
+	trackedStatefulSetsGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "tracked_statefulsets",
+			Help:           "Number of statefulsets with record entries, as of their last reconciliation or record write.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	filterRejections = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "filter_rejections_total",
+			Help:           "Number of nodes rejected by Filter for stable scheduled pods, by statefulset.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"namespace", "statefulset"})
+
+	recordConflicts = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "record_conflicts_total",
+			Help:           "Number of record writes retried after a conflict, by statefulset.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"namespace", "statefulset"})
+
+	metricsList = []metrics.Registerable{
+		storeInconsistentStatefulSets,
+		topologySpreadViolated,
+		topologySpreadViolatedStatefulSets,
+		placementsHonored,
+		placementsNotHonored,
+		firstPlacements,
+		stuckPendingPods,
+		frozenStatefulSetsGauge,
+		recordEntriesSkipped,
+		recordEntriesEvicted,
+		filterResults,
+		recordsWritten,
+		trackedStatefulSetsGauge,
+		filterRejections,
+		recordConflicts,
+	}
+
+	registerMetrics sync.Once
+)
+
+// The results of filterResults.
+const (
+	filterResultPinned   = "pinned"
+	filterResultPassed   = "passed"
+	filterResultRejected = "rejected"
+)
+
+// trackedStatefulSets tracks the keys of the statefulsets with record entries, for the tracked
+// statefulsets gauge. It is safe for concurrent use by the reconcile workers and PostBind.
+type trackedStatefulSets struct {
+	lock sync.Mutex
+	keys sets.String
+}
+
+// track records whether the statefulset with the key has record entries and updates the gauge.
+func (t *trackedStatefulSets) track(key string, tracked bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.keys == nil {
+		t.keys = sets.NewString()
+	}
+	if tracked {
+		t.keys.Insert(key)
+	} else {
+		t.keys.Delete(key)
+	}
+	trackedStatefulSetsGauge.Set(float64(t.keys.Len()))
+}
+
+// metricLabelsChoice caches the MetricLabelsAuto choice of perStatefulSetMetrics, so the
+// statefulsets are counted once per metricLabelsRefreshInterval instead of on every call.
+type metricLabelsChoice struct {
+	lock           sync.Mutex
+	perStatefulSet bool
+	checkedAt      time.Time
+}
+
+// perStatefulSetMetrics checks whether metrics are labeled with the namespace and name of
+// statefulsets following MetricLabels.
+func (st *Stable) perStatefulSetMetrics() bool {
+	switch st.args.MetricLabels {
+	case MetricLabelsPerStatefulSet:
+		return true
+	case MetricLabelsAggregate:
+		return false
+	}
+	choice := &st.metricLabels
+	choice.lock.Lock()
+	defer choice.lock.Unlock()
+	now := st.now()
+	if !choice.checkedAt.IsZero() && now.Sub(choice.checkedAt) < metricLabelsRefreshInterval {
+		return choice.perStatefulSet
+	}
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		klog.V(4).Infof("Failed to list statefulsets to choose the metric labels: %v", err)
+		return false
+	}
+	choice.perStatefulSet = len(statefulsets) <= autoAggregateMetricsStatefulSets
+	choice.checkedAt = now
+	return choice.perStatefulSet
+}
+
+// statefulSetLabelValues returns the namespace and statefulset label values of the metrics of the
+// statefulset, both empty when the metrics are aggregated.
+func statefulSetLabelValues(statefulset *appsv1.StatefulSet, perStatefulSet bool) []string {
+	if !perStatefulSet {
+		return []string{"", ""}
+	}
+	return []string{statefulset.Namespace, statefulset.Name}
+}
+
+// RegisterMetrics registers the metrics of the plugin to the legacy registry served by the scheduler.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		for _, metric := range metricsList {
+			legacyregistry.MustRegister(metric)
+		}
+	})
+}
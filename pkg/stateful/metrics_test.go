@@ -0,0 +1,272 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestFilterAndRecordMetrics(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	nodeInformer := informers.Core().V1().Nodes()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		nodeLister:        nodeInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+	}
+	for _, node := range nodes {
+		if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+
+	RegisterMetrics()
+	counters := map[string]float64{}
+	for _, result := range []string{filterResultPinned, filterResultPassed, filterResultRejected} {
+		value, err := testutil.GetCounterMetricValue(filterResults.WithLabelValues(result))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters[result] = value
+	}
+	written, err := testutil.GetCounterMetricValue(recordsWritten.WithLabelValues("n1", "web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// web-0 is pinned to node1 and rejected by node2, web-1 has no record and passes both nodes
+	ctx := context.TODO()
+	for _, pod := range []*corev1.Pod{newPod("web-0"), newPod("web-1")} {
+		state := framework.NewCycleState()
+		stableSchedule.PreFilter(ctx, state, pod)
+		for _, node := range nodes {
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(node); err != nil {
+				t.Fatal(err)
+			}
+			stableSchedule.Filter(ctx, state, pod, nodeInfo)
+		}
+	}
+	expected := map[string]float64{filterResultPinned: 1, filterResultPassed: 2, filterResultRejected: 1}
+	for result, count := range expected {
+		if got, err := testutil.GetCounterMetricValue(filterResults.WithLabelValues(result)); err != nil || got-counters[result] != count {
+			t.Errorf("expected %v more %s filter results, got %v (%v)", count, result, got-counters[result], err)
+		}
+	}
+
+	stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+	if got, err := testutil.GetCounterMetricValue(recordsWritten.WithLabelValues("n1", "web")); err != nil || got-written != 1 {
+		t.Errorf("expected 1 more record written, got %v (%v)", got-written, err)
+	}
+	if got, err := testutil.GetGaugeMetricValue(trackedStatefulSetsGauge); err != nil || got != 1 {
+		t.Errorf("expected 1 tracked statefulset, got %v (%v)", got, err)
+	}
+}
+
+func TestStatefulSetMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		metricLabels string
+		labelValues  []string
+	}{
+		{
+			name:         "labeled by statefulset",
+			metricLabels: MetricLabelsPerStatefulSet,
+			labelValues:  []string{"n1", "web"},
+		},
+		{
+			name:         "aggregated",
+			metricLabels: MetricLabelsAggregate,
+			labelValues:  []string{"", ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			args := defaultStableArgs()
+			args.MetricLabels = test.metricLabels
+			stableSchedule := &Stable{
+				args:              *args,
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}
+			for _, node := range []*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}, node} {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newPod := func(name string) *corev1.Pod {
+				return &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: "n1",
+						Labels: map[string]string{
+							"statefulset-stable.scheduling.sigs.k8s.io": "true",
+						},
+						OwnerReferences: []metav1.OwnerReference{
+							{
+								Kind: "StatefulSet",
+								Name: "web",
+							},
+						},
+					},
+				}
+			}
+
+			RegisterMetrics()
+			counters := map[*metrics.CounterVec]float64{}
+			for _, counter := range []*metrics.CounterVec{filterRejections, recordsWritten, recordConflicts} {
+				value, err := testutil.GetCounterMetricValue(counter.WithLabelValues(test.labelValues...))
+				if err != nil {
+					t.Fatal(err)
+				}
+				counters[counter] = value
+			}
+
+			// web-0 is pinned to node1 and rejected by node2
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, newPod("web-0"))
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(node); err != nil {
+				t.Fatal(err)
+			}
+			stableSchedule.Filter(ctx, state, newPod("web-0"), nodeInfo)
+
+			// the record of web-1 is written after a conflict
+			conflicts := 1
+			clientset.PrependReactor("patch", "statefulsets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, errors.NewConflict(appsv1.Resource("statefulsets"), "web", fmt.Errorf("the object has been modified"))
+				}
+				return false, nil, nil
+			})
+			stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+
+			expected := map[*metrics.CounterVec]float64{filterRejections: 1, recordsWritten: 1, recordConflicts: 1}
+			for counter, count := range expected {
+				got, err := testutil.GetCounterMetricValue(counter.WithLabelValues(test.labelValues...))
+				if err != nil || got-counters[counter] != count {
+					t.Errorf("expected %v more %s, got %v (%v)", count, counter.Name, got-counters[counter], err)
+				}
+			}
+		})
+	}
+}
+
+func TestPerStatefulSetMetricsRefresh(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	fakeClock := clock.NewFakeClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		args:              StableArgs{MetricLabels: MetricLabelsAuto},
+		clock:             fakeClock,
+	}
+	if !stableSchedule.perStatefulSetMetrics() {
+		t.Fatal("expected metrics labeled by statefulset in a small cluster")
+	}
+	for i := 0; i <= autoAggregateMetricsStatefulSets; i++ {
+		statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("web-%d", i), Namespace: "n1"}}
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the choice is kept until the statefulsets are counted again
+	fakeClock.Step(metricLabelsRefreshInterval / 2)
+	if !stableSchedule.perStatefulSetMetrics() {
+		t.Error("expected the cached choice before the refresh interval")
+	}
+	fakeClock.Step(metricLabelsRefreshInterval)
+	if stableSchedule.perStatefulSetMetrics() {
+		t.Error("expected aggregated metrics in a large cluster after the refresh interval")
+	}
+}
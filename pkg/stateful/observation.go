@@ -0,0 +1,157 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// pendingRecordsCheckInterval is how often the pending records are checked for the end of
+	// their observation period.
+	pendingRecordsCheckInterval = 10 * time.Second
+	// pendingRecordsFlushTimeout bounds the final check of the pending records at shutdown.
+	pendingRecordsFlushTimeout = 5 * time.Second
+)
+
+// pendingRecord is a pod bound to a node that is not recorded yet.
+type pendingRecord struct {
+	namespace, name string
+	uid             types.UID
+	nodeName        string
+	boundAt         time.Time
+}
+
+// pendingRecords holds the pods waiting for the observation period before their first record, by pod UID.
+type pendingRecords struct {
+	lock    sync.Mutex
+	records map[types.UID]pendingRecord
+}
+
+func (p *pendingRecords) add(pod *v1.Pod, nodeName string, now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.records == nil {
+		p.records = make(map[types.UID]pendingRecord)
+	}
+	p.records[pod.UID] = pendingRecord{
+		namespace: pod.Namespace,
+		name:      pod.Name,
+		uid:       pod.UID,
+		nodeName:  nodeName,
+		boundAt:   now,
+	}
+}
+
+// put adds the pending record back, keeping the time it was bound.
+func (p *pendingRecords) put(record pendingRecord) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.records == nil {
+		p.records = make(map[types.UID]pendingRecord)
+	}
+	p.records[record.uid] = record
+}
+
+// popDue removes and returns the pending records bound for at least the period.
+func (p *pendingRecords) popDue(now time.Time, period time.Duration) []pendingRecord {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var due []pendingRecord
+	for uid, record := range p.records {
+		if now.Sub(record.boundAt) >= period {
+			due = append(due, record)
+			delete(p.records, uid)
+		}
+	}
+	return due
+}
+
+// pendingRecordsEventHandler adds the bound pods to the pending records when the pod informer
+// lists them at startup, so the pods that were waiting for the observation period when the
+// scheduler restarted are still recorded. Their observation period starts over, and pods that
+// are recorded already leave their record unchanged.
+func (st *Stable) pendingRecordsEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			pod, ok := obj.(*v1.Pod)
+			if !ok || pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil || !st.eligible(pod) {
+				return
+			}
+			st.pendingRecords.add(pod, pod.Spec.NodeName, st.now())
+		},
+	}
+}
+
+// runPendingRecords checks the pending records periodically until ctx is cancelled, then
+// checks them a last time, so the pods whose observation period ended since the previous check
+// are recorded before the scheduler exits. The last check is bounded by
+// pendingRecordsFlushTimeout. Pods still within their observation period are picked up again
+// from the bound pods by the next scheduler.
+func (st *Stable) runPendingRecords(ctx context.Context) {
+	wait.UntilWithContext(ctx, st.confirmPendingRecords, pendingRecordsCheckInterval)
+	flushCtx, cancel := context.WithTimeout(context.Background(), pendingRecordsFlushTimeout)
+	defer cancel()
+	klog.V(2).Infof("Flushing the pending records before shutdown")
+	st.confirmPendingRecords(flushCtx)
+}
+
+// delaysRecords checks whether the first record of a pod waits in the pending records.
+func (st *Stable) delaysRecords() bool {
+	return st.args.ObservationPeriodSeconds > 0 || st.args.RecordAfterPodCondition != ""
+}
+
+// podConditionTrue checks whether the condition of the type is True on the pod.
+func podConditionTrue(pod *v1.Pod, conditionType v1.PodConditionType) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// confirmPendingRecords records the pods whose observation period is over, whose
+// RecordAfterPodCondition is True and that are still running on the node they were bound to.
+// Pods whose condition is not True yet stay pending. Pods that were deleted or recreated in
+// the meantime are dropped without a record.
+func (st *Stable) confirmPendingRecords(ctx context.Context) {
+	period := time.Duration(st.args.ObservationPeriodSeconds) * time.Second
+	for _, record := range st.pendingRecords.popDue(st.clock.Now(), period) {
+		pod, err := st.podLister.Pods(record.namespace).Get(record.name)
+		if err != nil || pod.UID != record.uid || pod.DeletionTimestamp != nil || pod.Spec.NodeName != record.nodeName {
+			klog.V(4).Infof("Pod %s/%s didn't stay on node %s for the observation period, not recording it",
+				record.namespace, record.name, record.nodeName)
+			continue
+		}
+		if condition := st.args.RecordAfterPodCondition; condition != "" && !podConditionTrue(pod, v1.PodConditionType(condition)) {
+			klog.V(5).Infof("Pod %s/%s on node %s is waiting for condition %s to be recorded",
+				record.namespace, record.name, record.nodeName, condition)
+			st.pendingRecords.put(record)
+			continue
+		}
+		st.recordPod(ctx, pod, record.nodeName)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestObservationPeriod(t *testing.T) {
+	newPod := func(uid, nodeName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-0",
+				Namespace: "n1",
+				UID:       types.UID(uid),
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		current  *corev1.Pod
+		expected string
+	}{
+		{
+			name:     "pod stayed on the node",
+			current:  newPod("uid-1", "node1"),
+			expected: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:    "pod recreated on another node",
+			current: newPod("uid-2", "node2"),
+		},
+		{
+			name: "pod deleted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			podInformer := informers.Core().V1().Pods()
+			fakeClock := clock.NewFakeClock(time.Now())
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				podLister:         podInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{ObservationPeriodSeconds: 60},
+				clock:             fakeClock,
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			if tt.current != nil {
+				if err := podInformer.Informer().GetIndexer().Add(tt.current); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx := context.TODO()
+			getRecord := func() string {
+				s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]
+			}
+
+			stableSchedule.PostBind(ctx, nil, newPod("uid-1", ""), "node1")
+			if got := getRecord(); got != "" {
+				t.Errorf("expected no record right after binding, got %v", got)
+			}
+
+			fakeClock.Step(30 * time.Second)
+			stableSchedule.confirmPendingRecords(ctx)
+			if got := getRecord(); got != "" {
+				t.Errorf("expected no record within the observation period, got %v", got)
+			}
+
+			fakeClock.Step(30 * time.Second)
+			stableSchedule.confirmPendingRecords(ctx)
+			if got := getRecord(); got != tt.expected {
+				t.Errorf("expected %v after the observation period, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecordAfterPodCondition(t *testing.T) {
+	newPod := func(uid, nodeName string, conditions ...corev1.PodCondition) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-0",
+				Namespace: "n1",
+				UID:       types.UID(uid),
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+			Spec:   corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{Conditions: conditions},
+		}
+	}
+	healthy := func(status corev1.ConditionStatus) corev1.PodCondition {
+		return corev1.PodCondition{Type: "example.com/healthy", Status: status}
+	}
+
+	tests := []struct {
+		name     string
+		updates  []*corev1.Pod
+		expected string
+	}{
+		{
+			name: "condition becomes true",
+			updates: []*corev1.Pod{
+				newPod("uid-1", "node1", healthy(corev1.ConditionFalse)),
+				newPod("uid-1", "node1", healthy(corev1.ConditionTrue)),
+			},
+			expected: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name: "condition never true",
+			updates: []*corev1.Pod{
+				newPod("uid-1", "node1"),
+				newPod("uid-1", "node1", healthy(corev1.ConditionFalse)),
+			},
+		},
+		{
+			name: "pod rescheduled before the condition is true",
+			updates: []*corev1.Pod{
+				newPod("uid-1", "node1", healthy(corev1.ConditionFalse)),
+				newPod("uid-2", "node2", healthy(corev1.ConditionTrue)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			podInformer := informers.Core().V1().Pods()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				podLister:         podInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RecordAfterPodCondition: "example.com/healthy"},
+				clock:             clock.NewFakeClock(time.Now()),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			getRecord := func() string {
+				s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]
+			}
+
+			stableSchedule.PostBind(ctx, nil, newPod("uid-1", ""), "node1")
+			if got := getRecord(); got != "" {
+				t.Errorf("expected no record right after binding, got %v", got)
+			}
+			for i, pod := range tt.updates {
+				if err := podInformer.Informer().GetIndexer().Update(pod); err != nil {
+					t.Fatal(err)
+				}
+				stableSchedule.confirmPendingRecords(ctx)
+				if i < len(tt.updates)-1 {
+					if got := getRecord(); got != "" {
+						t.Errorf("expected no record before the condition is true, got %v", got)
+					}
+				}
+			}
+			if got := getRecord(); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunPendingRecordsFlush(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+		},
+	}
+	newPod := func(name, uid string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				UID:       types.UID(uid),
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+			Spec: corev1.PodSpec{NodeName: "node1"},
+		}
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	podInformer := informers.Core().V1().Pods()
+	fakeClock := clock.NewFakeClock(time.Now())
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		podLister:         podInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{ObservationPeriodSeconds: 60},
+		clock:             fakeClock,
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range []*corev1.Pod{newPod("web-0", "uid-0"), newPod("web-1", "uid-1")} {
+		if err := podInformer.Informer().GetIndexer().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	stableSchedule.PostBind(ctx, nil, newPod("web-0", "uid-0"), "node1")
+	fakeClock.Step(time.Minute)
+	// web-1 is still within its observation period at shutdown
+	stableSchedule.PostBind(ctx, nil, newPod("web-1", "uid-1"), "node1")
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		stableSchedule.runPendingRecords(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("expected the pending records loop to stop")
+	}
+
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(context.TODO(), statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected the flush to record the due pod %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// optInCheckInterval is the interval between two checks whether any statefulset opts in.
+const optInCheckInterval = time.Minute
+
+// optInCircuit tracks whether any statefulset opts in to stable scheduling, for
+// SkipWithoutOptIns. It is active until a check finds no opt-ins, and an opt-in seen by the
+// event handler activates it again right away.
+type optInCircuit struct {
+	lock   sync.RWMutex
+	active bool
+	// activations counts the activations, so a check that started before an activation
+	// doesn't deactivate the circuit.
+	activations uint64
+}
+
+func newOptInCircuit() *optInCircuit {
+	return &optInCircuit{active: true}
+}
+
+// isActive checks whether the plugin does its work.
+func (c *optInCircuit) isActive() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.active
+}
+
+// activate makes the plugin do its work, it returns whether the plugin was idle.
+func (c *optInCircuit) activate() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	wasIdle := !c.active
+	c.active = true
+	c.activations++
+	return wasIdle
+}
+
+// idle checks whether the hot paths of the plugin return right away, with SkipWithoutOptIns
+// while no statefulset opts in.
+func (st *Stable) idle() bool {
+	return st.optIns != nil && !st.optIns.isActive()
+}
+
+// statefulSetOptsIn checks whether the pods of the statefulset are stable scheduled, following
+// the labels of its pod template and its opt-in annotation.
+func statefulSetOptsIn(statefulset *appsv1.StatefulSet, args StableArgs) bool {
+	return isStableEnabled(&v1.Pod{ObjectMeta: statefulset.Spec.Template.ObjectMeta}, statefulset, args)
+}
+
+// checkOptIns updates the circuit with whether any statefulset in the cache opts in. The
+// circuit stays active until the caches have synced, and with CustomOwners or OwnerKinds, whose
+// pods don't belong to statefulsets.
+func (st *Stable) checkOptIns() {
+	if len(st.args.CustomOwners) > 0 || len(st.args.OwnerKinds) > 0 || !st.recordsLoadable() {
+		return
+	}
+	st.optIns.lock.RLock()
+	activations := st.optIns.activations
+	st.optIns.lock.RUnlock()
+
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list statefulsets to check the opt-ins: %v", err)
+		return
+	}
+	active := false
+	for _, statefulset := range statefulsets {
+		if statefulSetOptsIn(statefulset, st.args) {
+			active = true
+			break
+		}
+	}
+
+	st.optIns.lock.Lock()
+	defer st.optIns.lock.Unlock()
+	if st.optIns.activations != activations {
+		return
+	}
+	if st.optIns.active && !active {
+		klog.V(2).Infof("No statefulset opts in to %s, the plugin is idle", Name)
+	}
+	st.optIns.active = active
+}
+
+// optInEventHandler activates the circuit when a statefulset opting in is added or updated.
+// Every opt-in counts as an activation, so a check running concurrently keeps the plugin active.
+func (st *Stable) optInEventHandler() cache.ResourceEventHandler {
+	activate := func(obj interface{}) {
+		statefulset, ok := obj.(*appsv1.StatefulSet)
+		if !ok || !statefulSetOptsIn(statefulset, st.args) {
+			return
+		}
+		if st.optIns.activate() {
+			klog.V(2).Infof("Statefulset %s/%s opts in to %s, activating the plugin", statefulset.Namespace, statefulset.Name, Name)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: activate,
+		UpdateFunc: func(_, newObj interface{}) {
+			activate(newObj)
+		},
+	}
+}
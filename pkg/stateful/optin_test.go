@@ -0,0 +1,142 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestSkipWithoutOptIns(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{SkipWithoutOptIns: true},
+		optIns:            newOptInCircuit(),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+		t.Fatal(err)
+	}
+	filter := func() framework.Code {
+		ctx := context.TODO()
+		state := framework.NewCycleState()
+		if status := stableSchedule.PreFilter(ctx, state, pod); !status.IsSuccess() {
+			t.Fatal(status.Message())
+		}
+		return stableSchedule.Filter(ctx, state, pod, nodeInfo).Code()
+	}
+
+	// the pod template of the statefulset doesn't opt in, the hot paths short-circuit
+	stableSchedule.checkOptIns()
+	if !stableSchedule.idle() {
+		t.Fatal("expected the plugin to be idle without opt-ins")
+	}
+	if code := filter(); code != framework.Success {
+		t.Errorf("expected %v while idle, got %v", framework.Success, code)
+	}
+	stableSchedule.PostBind(context.TODO(), nil, pod, "node2")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(context.TODO(), statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected PostBind to leave the record %v while idle, got %v", expected, got)
+	}
+
+	// the statefulset opts in, the plugin enforces the record again
+	optedIn := statefulset.DeepCopy()
+	optedIn.Spec.Template.Labels = map[string]string{"statefulset-stable.scheduling.sigs.k8s.io": "true"}
+	if err := statefulsetInformer.Informer().GetIndexer().Update(optedIn); err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule.optInEventHandler().OnUpdate(statefulset, optedIn)
+	if stableSchedule.idle() {
+		t.Fatal("expected an opt-in to activate the plugin")
+	}
+	if code := filter(); code != framework.Unschedulable {
+		t.Errorf("expected %v once active, got %v", framework.Unschedulable, code)
+	}
+	// the next check finds the opt-in and keeps the plugin active
+	stableSchedule.checkOptIns()
+	if stableSchedule.idle() {
+		t.Error("expected the plugin to stay active with an opt-in")
+	}
+}
+
+func TestCheckOptInsWaitsForSync(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	synced := false
+	stableSchedule := &Stable{
+		statefulSetLister: informers.Apps().V1().StatefulSets().Lister(),
+		args:              StableArgs{SkipWithoutOptIns: true},
+		optIns:            newOptInCircuit(),
+		recordsSynced:     []cache.InformerSynced{func() bool { return synced }},
+	}
+	// an empty cache that has not synced yet doesn't tell whether statefulsets opt in
+	stableSchedule.checkOptIns()
+	if stableSchedule.idle() {
+		t.Error("expected the plugin to stay active until the caches have synced")
+	}
+	synced = true
+	stableSchedule.checkOptIns()
+	if !stableSchedule.idle() {
+		t.Error("expected the plugin to be idle without opt-ins")
+	}
+}
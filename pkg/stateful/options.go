@@ -0,0 +1,232 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	clientset "k8s.io/client-go/kubernetes"
+	statefulsetlisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Option configures a Stable built by NewWithOptions.
+type Option func(st *Stable)
+
+// WithArgs sets the args of the plugin. Empty fields are not defaulted, the default args are
+// used when the option is not given.
+func WithArgs(args *StableArgs) Option {
+	return func(st *Stable) {
+		st.args = *args
+	}
+}
+
+// WithClientSet sets the clientset the records are written with.
+func WithClientSet(clientset clientset.Interface) Option {
+	return func(st *Stable) {
+		st.clientset = clientset
+	}
+}
+
+// WithStatefulSetLister sets the lister the statefulsets of pods are read from.
+func WithStatefulSetLister(lister statefulsetlisters.StatefulSetLister) Option {
+	return func(st *Stable) {
+		st.statefulSetLister = lister
+	}
+}
+
+// WithPVCLister sets the lister the volume claims of pods are read from.
+func WithPVCLister(lister corelisters.PersistentVolumeClaimLister) Option {
+	return func(st *Stable) {
+		st.pvcLister = lister
+	}
+}
+
+// WithPVLister sets the lister the volumes bound to the claims of pods are read from.
+func WithPVLister(lister corelisters.PersistentVolumeLister) Option {
+	return func(st *Stable) {
+		st.pvLister = lister
+	}
+}
+
+// WithNodeLister sets the lister recorded nodes are read from. The node checks of a scheduling
+// cycle only get nodes by name from its index, they never list nodes. Without a node lister,
+// recorded nodes are assumed to exist and the fallback and deleted node handling are disabled.
+func WithNodeLister(lister corelisters.NodeLister) Option {
+	return func(st *Stable) {
+		st.nodeLister = lister
+	}
+}
+
+// WithPodLister sets the lister pending records and stuck pods are checked with.
+func WithPodLister(lister corelisters.PodLister) Option {
+	return func(st *Stable) {
+		st.podLister = lister
+	}
+}
+
+// WithReplicaSetLister sets the lister the ReplicaSets of pods are resolved to their
+// Deployment with, required by OwnerKindDeployment.
+func WithReplicaSetLister(lister statefulsetlisters.ReplicaSetLister) Option {
+	return func(st *Stable) {
+		st.replicaSetLister = lister
+	}
+}
+
+// WithConfigMapLister sets the lister the sentinel, allow-list and cluster record ConfigMaps
+// configured by the args are read from.
+func WithConfigMapLister(lister corelisters.ConfigMapLister) Option {
+	return func(st *Stable) {
+		st.configMapLister = lister
+	}
+}
+
+// WithStore sets the record store, replacing the store selected by the args.
+func WithStore(store RecordStore) Option {
+	return func(st *Stable) {
+		st.store = store
+	}
+}
+
+// WithClock sets the clock of the plugin.
+func WithClock(clock clock.Clock) Option {
+	return func(st *Stable) {
+		st.clock = clock
+	}
+}
+
+// WithAuditSink sets the sink receiving the decisions of the plugin.
+func WithAuditSink(sink AuditSink) Option {
+	return func(st *Stable) {
+		st.auditSink = sink
+	}
+}
+
+// WithTracer sets the tracer starting the spans of the plugin, e.g. backed by OpenTelemetry.
+// No spans are started without it.
+func WithTracer(tracer Tracer) Option {
+	return func(st *Stable) {
+		st.tracer = tracer
+	}
+}
+
+// WithNodeAllowList sets the allow-list of nodes, replacing the allow-list ConfigMap of the args.
+func WithNodeAllowList(allowList NodeAllowList) Option {
+	return func(st *Stable) {
+		st.allowList = allowList
+	}
+}
+
+// WithRecordsSynced sets the functions reporting whether the caches the records are read from
+// have synced. Opted in pods of statefulsets are not scheduled before.
+func WithRecordsSynced(synced ...cache.InformerSynced) Option {
+	return func(st *Stable) {
+		st.recordsSynced = synced
+	}
+}
+
+// NewWithOptions builds the plugin from options instead of a plugin configuration, for
+// embedders and tests. It validates the args and selects the record store, but unlike New it
+// registers no event handlers and starts no background loops. The metrics are registered with
+// the legacy registry.
+func NewWithOptions(opts ...Option) (*Stable, error) {
+	st := &Stable{
+		args:      *defaultStableArgs(),
+		clock:     clock.RealClock{},
+		auditSink: noopAuditSink{},
+	}
+	for _, opt := range opts {
+		opt(st)
+	}
+	if err := validateStableArgs(&st.args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	stickyOrdinals, nodeReadinessSelector, err := parsePolicy(stablePolicy{
+		StickyOrdinals:        st.args.StickyOrdinals,
+		NodeReadinessSelector: st.args.NodeReadinessSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	st.stickyOrdinals, st.nodeReadinessSelector = stickyOrdinals, nodeReadinessSelector
+	if st.statefulSetLister == nil {
+		return nil, fmt.Errorf("%s requires a statefulset lister", Name)
+	}
+
+	// namespaced names are already validated by validateStableArgs
+	if st.args.SentinelConfigMap != "" {
+		st.sentinelNamespace, st.sentinelName, _ = cache.SplitMetaNamespaceKey(st.args.SentinelConfigMap)
+	}
+	if st.allowList == nil && st.args.NodeAllowListConfigMap != "" && st.configMapLister != nil {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(st.args.NodeAllowListConfigMap)
+		st.allowList = newConfigMapAllowList(st.configMapLister, namespace, name)
+	}
+	if st.store == nil {
+		if st.clientset == nil {
+			return nil, fmt.Errorf("%s requires a clientset or a record store", Name)
+		}
+		if st.args.ClusterRecordConfigMap != "" {
+			if st.configMapLister == nil {
+				return nil, fmt.Errorf("%s requires a ConfigMap lister for clusterRecordConfigMap", Name)
+			}
+			namespace, name, _ := cache.SplitMetaNamespaceKey(st.args.ClusterRecordConfigMap)
+			st.store = newClusterStore(st.clientset, st.configMapLister, namespace, name, st.args.FederateClusterRecords)
+		} else {
+			store, err := st.newStore(st.args.StoreType)
+			if err != nil {
+				return nil, err
+			}
+			st.store = store
+		}
+		if len(st.args.SecondaryStores) > 0 {
+			secondaries := make([]RecordStore, 0, len(st.args.SecondaryStores))
+			for _, storeType := range st.args.SecondaryStores {
+				store, err := st.newStore(storeType)
+				if err != nil {
+					return nil, err
+				}
+				secondaries = append(secondaries, store)
+			}
+			st.store = newMultiStore(st.store, secondaries...)
+		}
+	}
+	if hasOwnerKind(st.args.OwnerKinds, OwnerKindDeployment) && st.replicaSetLister == nil {
+		return nil, fmt.Errorf("%s requires a ReplicaSet lister for ownerKinds %s", Name, OwnerKindDeployment)
+	}
+	if _, ok := st.store.(*annotationStore); ok && (len(st.args.CustomOwners) > 0 || len(st.args.OwnerKinds) > 0) {
+		// custom owners and owner kinds have no statefulset object to keep the record annotation on
+		return nil, fmt.Errorf("%s requires clusterRecordConfigMap or a record store for customOwners and ownerKinds", Name)
+	}
+	RegisterMetrics()
+	return st, nil
+}
+
+// newStore returns the record store of the store type.
+func (st *Stable) newStore(storeType string) (RecordStore, error) {
+	if storeType == StoreTypeConfigMap {
+		if st.configMapLister == nil {
+			return nil, fmt.Errorf("%s requires a ConfigMap lister for storeType %s", Name, StoreTypeConfigMap)
+		}
+		return newConfigMapStore(st.clientset, st.configMapLister), nil
+	}
+	store := newAnnotationStore(st.clientset)
+	store.key = recordAnnotationKey(st.args)
+	store.compress = st.args.Compress
+	return store, nil
+}
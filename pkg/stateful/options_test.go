@@ -0,0 +1,139 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryStore()
+	args := defaultStableArgs()
+	args.StickyOrdinals = "0"
+	stableSchedule, err := NewWithOptions(
+		WithArgs(args),
+		WithStatefulSetLister(statefulsetInformer.Lister()),
+		WithStore(store),
+		WithClock(clock.NewFakeClock(time.Now())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	stableSchedule.PostBind(ctx, nil, newPod("web-0"), "node1")
+	stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+	record, err := store.Get(ctx, statefulset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || len(record.Records) != 1 || record.Records["web-0"].Node != "node1" {
+		t.Errorf("expected only the sticky pod to be recorded, got %v", record)
+	}
+
+	for node, expected := range map[string]framework.Code{"node1": framework.Success, "node2": framework.Unschedulable} {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}}); err != nil {
+			t.Fatal(err)
+		}
+		if code := stableSchedule.Filter(ctx, nil, newPod("web-0"), nodeInfo).Code(); code != expected {
+			t.Errorf("expected %v on %s, got %v", expected, node, code)
+		}
+	}
+}
+
+func TestNewWithOptionsErrors(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulSetLister := informers.Apps().V1().StatefulSets().Lister()
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{
+			name: "no statefulset lister",
+			opts: []Option{WithClientSet(clientset)},
+		},
+		{
+			name: "no clientset nor store",
+			opts: []Option{WithStatefulSetLister(statefulSetLister)},
+		},
+		{
+			name: "invalid args",
+			opts: []Option{
+				WithStatefulSetLister(statefulSetLister),
+				WithClientSet(clientset),
+				WithArgs(&StableArgs{StickyOrdinals: "2-0"}),
+			},
+		},
+		{
+			name: "cluster records without ConfigMap lister",
+			opts: []Option{
+				WithStatefulSetLister(statefulSetLister),
+				WithClientSet(clientset),
+				WithArgs(func() *StableArgs {
+					args := defaultStableArgs()
+					args.ClusterRecordConfigMap = "kube-system/statefulset-records"
+					return args
+				}()),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWithOptions(tt.opts...); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
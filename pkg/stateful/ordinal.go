@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// parseOrdinal parses the ordinal from the name of a pod created by a statefulset,
+// e.g. "web-2" returns 2. The second return value is false if the name has no ordinal suffix.
+func parseOrdinal(name string) (int, bool) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 || i == len(name)-1 {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(name[i+1:])
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// ordinalRange is an inclusive range of statefulset pod ordinals.
+type ordinalRange struct {
+	start, end int
+}
+
+// ordinalRanges is a list of ordinal ranges, e.g. "0-2,5".
+type ordinalRanges []ordinalRange
+
+// Has checks whether the ordinal is within any of the ranges.
+func (r ordinalRanges) Has(ordinal int) bool {
+	for _, rg := range r {
+		if ordinal >= rg.start && ordinal <= rg.end {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOrdinalRanges parses a comma separated list of ordinals and inclusive
+// ranges, e.g. "0-2,5" matches the ordinals 0, 1, 2 and 5. A value without any
+// ordinal is rejected, so that blanking the value never turns off stable scheduling.
+func parseOrdinalRanges(s string) (ordinalRanges, error) {
+	var ranges ordinalRanges
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid ordinal %q in %q", bounds[0], s)
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid ordinal range %q in %q", part, s)
+			}
+		}
+		ranges = append(ranges, ordinalRange{start: start, end: end})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ordinal in %q", s)
+	}
+	return ranges, nil
+}
+
+// stickyOrdinals returns the sticky ordinal ranges of the statefulset. The StatefulsetStableOrdinals
+// annotation overrides the global ranges from the plugin args. If the annotation cannot be parsed,
+// the global ranges are returned together with the parse error. Nil ranges mean every pod is sticky.
+func stickyOrdinals(statefulset *appsv1.StatefulSet, global ordinalRanges) (ordinalRanges, error) {
+	value, ok := statefulset.GetAnnotations()[StatefulsetStableOrdinals]
+	if !ok {
+		return global, nil
+	}
+	ranges, err := parseOrdinalRanges(value)
+	if err != nil {
+		return global, err
+	}
+	return ranges, nil
+}
+
+// isStickyOrdinal checks whether the pod name is within the sticky ordinal ranges.
+// Nil ranges and names without an ordinal suffix are always sticky.
+func isStickyOrdinal(ranges ordinalRanges, name string) bool {
+	if ranges == nil {
+		return true
+	}
+	ordinal, ok := parseOrdinal(name)
+	if !ok {
+		return true
+	}
+	return ranges.Has(ordinal)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseOrdinalRanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		included    []int
+		excluded    []int
+		expectError bool
+	}{
+		{
+			name:     "single ordinals and ranges",
+			value:    "0-2,5",
+			included: []int{0, 1, 2, 5},
+			excluded: []int{3, 4, 6},
+		},
+		{
+			name:     "whitespace is ignored",
+			value:    " 1 , 3 - 4 ",
+			included: []int{1, 3, 4},
+			excluded: []int{0, 2, 5},
+		},
+		{
+			name:        "empty value",
+			value:       "",
+			expectError: true,
+		},
+		{
+			name:        "only separators",
+			value:       ",",
+			expectError: true,
+		},
+		{
+			name:        "reversed range",
+			value:       "3-1",
+			expectError: true,
+		},
+		{
+			name:        "not a number",
+			value:       "0,a",
+			expectError: true,
+		},
+		{
+			name:        "negative ordinal",
+			value:       "-1",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := parseOrdinalRanges(tt.value)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %v", ranges)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.included {
+				if !ranges.Has(i) {
+					t.Errorf("expected ordinal %d to be included", i)
+				}
+			}
+			for _, i := range tt.excluded {
+				if ranges.Has(i) {
+					t.Errorf("expected ordinal %d to be excluded", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStickyOrdinals(t *testing.T) {
+	global, err := parseOrdinalRanges("0-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		global      ordinalRanges
+		podName     string
+		expected    bool
+		expectError bool
+	}{
+		{
+			name:     "no annotation and no global ordinals, every pod is sticky",
+			podName:  "web-7",
+			expected: true,
+		},
+		{
+			name:     "no annotation, global ordinals apply",
+			global:   global,
+			podName:  "web-2",
+			expected: false,
+		},
+		{
+			name:        "ordinal within the annotation",
+			annotations: map[string]string{StatefulsetStableOrdinals: "0-2,5"},
+			podName:     "web-5",
+			expected:    true,
+		},
+		{
+			name:        "ordinal outside the annotation",
+			annotations: map[string]string{StatefulsetStableOrdinals: "0-2,5"},
+			podName:     "web-3",
+			expected:    false,
+		},
+		{
+			name:        "annotation overrides the global ordinals",
+			annotations: map[string]string{StatefulsetStableOrdinals: "2"},
+			global:      global,
+			podName:     "web-2",
+			expected:    true,
+		},
+		{
+			name:        "annotation overrides the global ordinals, excluded by the annotation",
+			annotations: map[string]string{StatefulsetStableOrdinals: "2"},
+			global:      global,
+			podName:     "web-0",
+			expected:    false,
+		},
+		{
+			name:        "invalid annotation falls back to the global ordinals",
+			annotations: map[string]string{StatefulsetStableOrdinals: "x"},
+			global:      global,
+			podName:     "web-3",
+			expected:    false,
+			expectError: true,
+		},
+		{
+			name:        "invalid annotation without global ordinals, every pod is sticky",
+			annotations: map[string]string{StatefulsetStableOrdinals: "x"},
+			podName:     "web-3",
+			expected:    true,
+			expectError: true,
+		},
+		{
+			name:        "empty annotation is invalid",
+			annotations: map[string]string{StatefulsetStableOrdinals: " , "},
+			podName:     "web-3",
+			expected:    true,
+			expectError: true,
+		},
+		{
+			name:        "pod name without ordinal",
+			annotations: map[string]string{StatefulsetStableOrdinals: "0"},
+			podName:     "web",
+			expected:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", Annotations: tt.annotations},
+			}
+			ranges, err := stickyOrdinals(statefulset, tt.global)
+			if (err != nil) != tt.expectError {
+				t.Errorf("expected error %v, got %v", tt.expectError, err)
+			}
+			if got := isStickyOrdinal(ranges, tt.podName); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// CustomOwner is an owner kind of pods other than StatefulSet, e.g. the custom resource of an
+// operator creating the pods of a set directly, whose pods are stable scheduled like the pods
+// of statefulsets.
+type CustomOwner struct {
+	// APIVersion is the group/version of the owner, e.g. "example.com/v1".
+	APIVersion string `json:"apiVersion"`
+	// Kind is the kind of the owner.
+	Kind string `json:"kind"`
+	// IdentityLabel is the label of the pods holding the identity of their set. Pods without
+	// the label, or with an empty IdentityLabel, are recorded under the name of their owner.
+	IdentityLabel string `json:"identityLabel,omitempty"`
+}
+
+// matches checks whether the owner reference is of the custom owner kind.
+func (o CustomOwner) matches(ow metav1.OwnerReference) bool {
+	return ow.APIVersion == o.APIVersion && ow.Kind == o.Kind
+}
+
+// ownedByCustomOwner checks whether the pod has an owner reference of a custom owner kind.
+func ownedByCustomOwner(pod *v1.Pod, owners []CustomOwner) bool {
+	for _, ow := range pod.GetOwnerReferences() {
+		for _, owner := range owners {
+			if owner.matches(ow) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createByCustomOwner returns the set of a pod owned by a custom owner kind, nil if the pod
+// has none. There is no statefulset object for these pods, the set is a statefulset without
+// spec named after the identity of the set, so the record store keeps its record under that
+// identity. The UID of the owner guards the record only when the set is identified by its
+// owner, sets identified by a label may span several owners.
+func (st *Stable) createByCustomOwner(pod *v1.Pod) *appsv1.StatefulSet {
+	for _, ow := range pod.GetOwnerReferences() {
+		for _, owner := range st.args.CustomOwners {
+			if !owner.matches(ow) {
+				continue
+			}
+			set := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: ow.Name, UID: ow.UID}}
+			if owner.IdentityLabel != "" {
+				if identity := pod.GetLabels()[owner.IdentityLabel]; identity != "" {
+					set.Name, set.UID = identity, ""
+				} else {
+					klog.V(4).Infof("Pod %s/%s has no %s label, recording it under its %s %s",
+						pod.Namespace, pod.Name, owner.IdentityLabel, ow.Kind, ow.Name)
+				}
+			}
+			return set
+		}
+	}
+	return nil
+}
+
+const (
+	// OwnerKindReplicaSet is the OwnerKinds value stable scheduling the pods of ReplicaSets,
+	// recorded under the ReplicaSet, so a rollout of a Deployment starts a new record.
+	OwnerKindReplicaSet = "ReplicaSet"
+	// OwnerKindDeployment is the OwnerKinds value stable scheduling the pods of the ReplicaSets of
+	// Deployments, recorded under the Deployment, so the record outlives rollouts.
+	OwnerKindDeployment = "Deployment"
+)
+
+// ownerOfKind returns the owner reference of the pod of one of the apps/v1 owner kinds. The pods
+// of Deployments are owned by a ReplicaSet.
+func ownerOfKind(pod *v1.Pod, kinds []string) (metav1.OwnerReference, bool) {
+	for _, ow := range pod.GetOwnerReferences() {
+		if ow.APIVersion != appsv1.SchemeGroupVersion.String() {
+			continue
+		}
+		for _, kind := range kinds {
+			if ow.Kind == kind || kind == OwnerKindDeployment && ow.Kind == OwnerKindReplicaSet {
+				return ow, true
+			}
+		}
+	}
+	return metav1.OwnerReference{}, false
+}
+
+// hasOwnerKind checks whether the kind is one of the OwnerKinds.
+func hasOwnerKind(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentOf returns the Deployment controlling the ReplicaSet of the owner reference, false
+// when the ReplicaSet isn't found or isn't controlled by a Deployment.
+func (st *Stable) deploymentOf(namespace string, ow metav1.OwnerReference) (*metav1.OwnerReference, bool) {
+	if st.replicaSetLister == nil || ow.Kind != OwnerKindReplicaSet {
+		return nil, false
+	}
+	replicaSet, err := st.replicaSetLister.ReplicaSets(namespace).Get(ow.Name)
+	if err != nil {
+		return nil, false
+	}
+	if ow.UID != "" && replicaSet.UID != ow.UID {
+		klog.V(4).Infof("ReplicaSet %s/%s has UID %s instead of UID %s of the owner reference, skipping it",
+			namespace, ow.Name, replicaSet.UID, ow.UID)
+		return nil, false
+	}
+	controller := metav1.GetControllerOf(replicaSet)
+	if controller == nil || controller.Kind != OwnerKindDeployment || controller.APIVersion != appsv1.SchemeGroupVersion.String() {
+		return nil, false
+	}
+	return controller, true
+}
+
+// createByOwnerKind returns the set of a pod owned by one of the OwnerKinds, nil if the pod has
+// none or lacks the OwnerIdentityLabel. Like for custom owners, the set is a statefulset
+// without spec named after the owner, the Deployment of the ReplicaSet of the pod with
+// OwnerKindDeployment. The names of the pods are random, so the pods are recorded under their
+// OwnerIdentityLabel and pods without it are not stable scheduled.
+func (st *Stable) createByOwnerKind(pod *v1.Pod) *appsv1.StatefulSet {
+	ow, ok := ownerOfKind(pod, st.args.OwnerKinds)
+	if !ok {
+		return nil
+	}
+	if pod.GetLabels()[st.args.OwnerIdentityLabel] == "" {
+		klog.V(4).Infof("Pod %s/%s of %s %s has no %s label, not stable scheduling it",
+			pod.Namespace, pod.Name, ow.Kind, ow.Name, st.args.OwnerIdentityLabel)
+		return nil
+	}
+	if hasOwnerKind(st.args.OwnerKinds, OwnerKindDeployment) {
+		if deployment, ok := st.deploymentOf(pod.Namespace, ow); ok {
+			return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: deployment.Name, UID: deployment.UID}}
+		}
+		if !hasOwnerKind(st.args.OwnerKinds, ow.Kind) {
+			klog.V(4).Infof("%s %s of pod %s/%s has no Deployment, not stable scheduling it",
+				ow.Kind, ow.Name, pod.Namespace, pod.Name)
+			return nil
+		}
+	}
+	return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: ow.Name, UID: ow.UID}}
+}
+
+// hasRecordOwner checks whether the pod has an owner whose pods are stable scheduled: a
+// statefulset, one of the CustomOwners or one of the OwnerKinds.
+func hasRecordOwner(pod *v1.Pod, args StableArgs) bool {
+	if ownedByStatefulSet(pod) || ownedByCustomOwner(pod, args.CustomOwners) {
+		return true
+	}
+	_, ok := ownerOfKind(pod, args.OwnerKinds)
+	return ok
+}
@@ -0,0 +1,374 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestCustomOwnerRecords(t *testing.T) {
+	newPod := func(name, apiVersion, kind, owner string, labels map[string]string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: apiVersion,
+						Kind:       kind,
+						Name:       owner,
+						UID:        types.UID(owner + "-uid"),
+					},
+				},
+			},
+		}
+		for k, v := range labels {
+			pod.Labels[k] = v
+		}
+		return pod
+	}
+	tests := []struct {
+		name string
+		// writer is bound to node1 and recorded, reader is scheduled afterwards
+		writer, reader *corev1.Pod
+		recreated      bool
+		expectedSet    string
+		expectedNode   string
+	}{
+		{
+			name:         "pods of the custom owner share its record",
+			writer:       newPod("db-0", "example.com/v1", "Database", "db", nil),
+			reader:       newPod("db-0", "example.com/v1", "Database", "db", nil),
+			expectedSet:  "db",
+			expectedNode: "node1",
+		},
+		{
+			name:        "recreated custom owner doesn't read the record of the old one",
+			writer:      newPod("db-0", "example.com/v1", "Database", "db", nil),
+			reader:      newPod("db-0", "example.com/v1", "Database", "db", nil),
+			recreated:   true,
+			expectedSet: "db",
+		},
+		{
+			name:         "pods are recorded under the identity label",
+			writer:       newPod("east-0", "example.com/v1", "Database", "east-a", map[string]string{"example.com/cluster": "east"}),
+			reader:       newPod("east-0", "example.com/v1", "Database", "east-b", map[string]string{"example.com/cluster": "east"}),
+			expectedSet:  "east",
+			expectedNode: "node1",
+		},
+		{
+			name:   "pods of another api version are not recorded",
+			writer: newPod("db-0", "example.com/v2", "Database", "db", nil),
+			reader: newPod("db-0", "example.com/v2", "Database", "db", nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			configMapInformer := informers.Core().V1().ConfigMaps()
+			args := defaultStableArgs()
+			args.ClusterRecordConfigMap = "kube-system/statefulset-records"
+			args.CustomOwners = []CustomOwner{{APIVersion: "example.com/v1", Kind: "Database", IdentityLabel: "example.com/cluster"}}
+			stableSchedule, err := NewWithOptions(
+				WithArgs(args),
+				WithClientSet(clientset),
+				WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+				WithConfigMapLister(configMapInformer.Lister()),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			stableSchedule.recordPod(ctx, tt.writer, "node1")
+			if configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "statefulset-records", metav1.GetOptions{}); err == nil {
+				if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.recreated {
+				tt.reader.OwnerReferences[0].UID = "db-recreated-uid"
+			}
+
+			s := stableSchedule.computePreFilterState(ctx, tt.reader)
+			if tt.expectedSet == "" {
+				if s.statefulset != nil {
+					t.Errorf("expected no set, got %s", s.statefulset.Name)
+				}
+				return
+			}
+			if s.statefulset == nil || s.statefulset.Name != tt.expectedSet {
+				t.Fatalf("expected set %s, got %v", tt.expectedSet, s.statefulset)
+			}
+			var recorded string
+			if s.record != nil {
+				recorded = s.record.Records[stableSchedule.keyOf(tt.reader)].Node
+			}
+			if recorded != tt.expectedNode {
+				t.Errorf("expected recorded node %q, got %q", tt.expectedNode, recorded)
+			}
+		})
+	}
+}
+
+func TestCustomOwnersRequireRecordStore(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	args := defaultStableArgs()
+	args.CustomOwners = []CustomOwner{{APIVersion: "example.com/v1", Kind: "Database"}}
+	if _, err := NewWithOptions(
+		WithArgs(args),
+		WithClientSet(clientset),
+		WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+	); err == nil {
+		t.Error("expected an error without a record store for the custom owners")
+	}
+}
+
+func TestReplicaSetOwnerRecords(t *testing.T) {
+	newPod := func(name string, labels map[string]string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: "apps/v1",
+						Kind:       "ReplicaSet",
+						Name:       "cache-5d8f7",
+						UID:        "cache-5d8f7-uid",
+					},
+				},
+			},
+		}
+		for k, v := range labels {
+			pod.Labels[k] = v
+		}
+		return pod
+	}
+	tests := []struct {
+		name string
+		// writer is bound to node1 and recorded, reader is scheduled afterwards
+		writer, reader *corev1.Pod
+		expectedCodes  map[string]framework.Code
+	}{
+		{
+			name:          "a recreated pod is pinned by its identity label",
+			writer:        newPod("cache-5d8f7-x2k9p", map[string]string{"example.com/shard": "a"}),
+			reader:        newPod("cache-5d8f7-q7m4t", map[string]string{"example.com/shard": "a"}),
+			expectedCodes: map[string]framework.Code{"node1": framework.Success, "node2": framework.Unschedulable},
+		},
+		{
+			name:          "a pod of another identity is not pinned",
+			writer:        newPod("cache-5d8f7-x2k9p", map[string]string{"example.com/shard": "a"}),
+			reader:        newPod("cache-5d8f7-q7m4t", map[string]string{"example.com/shard": "b"}),
+			expectedCodes: map[string]framework.Code{"node1": framework.Success, "node2": framework.Success},
+		},
+		{
+			name:          "pods without the identity label are not stable scheduled",
+			writer:        newPod("cache-5d8f7-x2k9p", nil),
+			reader:        newPod("cache-5d8f7-x2k9p", nil),
+			expectedCodes: map[string]framework.Code{"node1": framework.Success, "node2": framework.Success},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			store := newMemoryStore()
+			args := defaultStableArgs()
+			args.OwnerKinds = []string{OwnerKindReplicaSet}
+			args.OwnerIdentityLabel = "example.com/shard"
+			stableSchedule, err := NewWithOptions(
+				WithArgs(args),
+				WithClientSet(clientset),
+				WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+				WithStore(store),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			stableSchedule.recordPod(ctx, tt.writer, "node1")
+			if _, ok := tt.writer.Labels["example.com/shard"]; !ok && len(store.records) != 0 {
+				t.Errorf("expected no record of a pod without the identity label, got %v", store.records)
+			}
+			for node, expected := range tt.expectedCodes {
+				nodeInfo := schedulernodeinfo.NewNodeInfo()
+				if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}}); err != nil {
+					t.Fatal(err)
+				}
+				if code := stableSchedule.Filter(ctx, nil, tt.reader, nodeInfo).Code(); code != expected {
+					t.Errorf("expected %v on %s, got %v", expected, node, code)
+				}
+			}
+		})
+	}
+}
+
+func TestOwnerKindsRequireRecordStore(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	args := defaultStableArgs()
+	args.OwnerKinds = []string{OwnerKindReplicaSet}
+	args.OwnerIdentityLabel = "example.com/shard"
+	if _, err := NewWithOptions(
+		WithArgs(args),
+		WithClientSet(clientset),
+		WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+	); err == nil {
+		t.Error("expected an error without a record store for the owner kinds")
+	}
+}
+
+func TestDeploymentOwnerResolution(t *testing.T) {
+	controller := true
+	newReplicaSet := func(name string, owners ...metav1.OwnerReference) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "n1",
+				UID:             types.UID(name + "-uid"),
+				OwnerReferences: owners,
+			},
+		}
+	}
+	deployment := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "cache", UID: "cache-uid", Controller: &controller}
+	tests := []struct {
+		name        string
+		kinds       []string
+		replicaSets []*appsv1.ReplicaSet
+		expected    string
+	}{
+		{
+			name:        "replicaset resolves to its deployment",
+			kinds:       []string{OwnerKindDeployment},
+			replicaSets: []*appsv1.ReplicaSet{newReplicaSet("cache-5d8f7", deployment)},
+			expected:    "cache",
+		},
+		{
+			name:        "replicaset without a deployment",
+			kinds:       []string{OwnerKindDeployment},
+			replicaSets: []*appsv1.ReplicaSet{newReplicaSet("cache-5d8f7")},
+		},
+		{
+			name:        "replicaset without a deployment falls back to the replicaset",
+			kinds:       []string{OwnerKindDeployment, OwnerKindReplicaSet},
+			replicaSets: []*appsv1.ReplicaSet{newReplicaSet("cache-5d8f7")},
+			expected:    "cache-5d8f7",
+		},
+		{
+			name:  "replicaset not found",
+			kinds: []string{OwnerKindDeployment},
+		},
+		{
+			name:        "replicaset kind keeps the replicaset",
+			kinds:       []string{OwnerKindReplicaSet},
+			replicaSets: []*appsv1.ReplicaSet{newReplicaSet("cache-5d8f7", deployment)},
+			expected:    "cache-5d8f7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			replicaSetInformer := informers.Apps().V1().ReplicaSets()
+			for _, replicaSet := range tt.replicaSets {
+				if err := replicaSetInformer.Informer().GetIndexer().Add(replicaSet); err != nil {
+					t.Fatal(err)
+				}
+			}
+			args := defaultStableArgs()
+			args.OwnerKinds = tt.kinds
+			args.OwnerIdentityLabel = "example.com/shard"
+			stableSchedule, err := NewWithOptions(
+				WithArgs(args),
+				WithClientSet(clientset),
+				WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+				WithReplicaSetLister(replicaSetInformer.Lister()),
+				WithStore(newMemoryStore()),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cache-5d8f7-x2k9p",
+					Namespace: "n1",
+					Labels:    map[string]string{"example.com/shard": "a"},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							APIVersion: "apps/v1",
+							Kind:       "ReplicaSet",
+							Name:       "cache-5d8f7",
+							UID:        "cache-5d8f7-uid",
+							Controller: &controller,
+						},
+					},
+				},
+			}
+
+			var got string
+			if set := stableSchedule.createByStatefulset(pod); set != nil {
+				got = set.Name
+			}
+			if got != tt.expected {
+				t.Errorf("expected set %q, got %q", tt.expected, got)
+			}
+			if key := stableSchedule.keyOf(pod); key != "a" {
+				t.Errorf("expected key a, got %s", key)
+			}
+		})
+	}
+}
+
+func TestDeploymentOwnersRequireReplicaSetLister(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	args := defaultStableArgs()
+	args.OwnerKinds = []string{OwnerKindDeployment}
+	args.OwnerIdentityLabel = "example.com/shard"
+	if _, err := NewWithOptions(
+		WithArgs(args),
+		WithClientSet(clientset),
+		WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+		WithStore(newMemoryStore()),
+	); err == nil {
+		t.Error("expected an error without a ReplicaSet lister for the Deployment owners")
+	}
+}
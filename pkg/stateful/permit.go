@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+var _ framework.PermitPlugin = &Stable{}
+
+const (
+	// orderedPlacementPollInterval is how often a waiting pod checks the pods of its lower ordinals.
+	orderedPlacementPollInterval = time.Second
+	// orderedPlacementTimeoutMargin extends the timeout the framework waits for a pod beyond
+	// OrderedPlacementTimeoutSeconds, so the plugin allows the pod before the framework rejects it.
+	orderedPlacementTimeoutMargin = 5 * time.Second
+	// waitingPodPollInterval is how often a pod whose lower ordinals are placed is looked up
+	// until the framework registers it as waiting.
+	waitingPodPollInterval = 100 * time.Millisecond
+	// maxOrderedPlacementTimeoutSeconds keeps the timeout with its margin below the 15 minutes
+	// the framework waits for at most.
+	maxOrderedPlacementTimeoutSeconds = 600
+)
+
+// Permit holds a stable scheduled pod of a statefulset with OrderedReady pod management until
+// the pods of its lower ordinals are recorded or terminal, so the ordinals are placed in order
+// even when the scheduler processes the pods out of order. The pod is allowed after
+// OrderedPlacementTimeoutSeconds at the latest. Pods are permitted right away without
+// OrderedPlacementTimeoutSeconds.
+func (st *Stable) Permit(ctx context.Context, _ *framework.CycleState, pod *v1.Pod, nodeName string) (*framework.Status, time.Duration) {
+	if st.args.OrderedPlacementTimeoutSeconds == 0 || st.idle() || !st.eligible(pod) {
+		return framework.NewStatus(framework.Success, ""), 0
+	}
+	statefulset := st.orderedStatefulSet(pod)
+	if statefulset == nil {
+		return framework.NewStatus(framework.Success, ""), 0
+	}
+	ordinal, ok := parseOrdinal(pod.Name)
+	if !ok || ordinal == 0 {
+		return framework.NewStatus(framework.Success, ""), 0
+	}
+	if unplaced := st.unplacedLowerOrdinal(ctx, statefulset, ordinal); unplaced == "" {
+		return framework.NewStatus(framework.Success, ""), 0
+	}
+	timeout := time.Duration(st.args.OrderedPlacementTimeoutSeconds) * time.Second
+	go st.waitForLowerOrdinals(pod, statefulset.Namespace, statefulset.Name, ordinal, timeout)
+	return framework.NewStatus(framework.Wait, ""), timeout + orderedPlacementTimeoutMargin
+}
+
+// orderedStatefulSet returns the statefulset of the pod when its pods are created in order, nil
+// for statefulsets with Parallel pod management and the sets of custom owners and owner kinds.
+func (st *Stable) orderedStatefulSet(pod *v1.Pod) *appsv1.StatefulSet {
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil || controller.Kind != Kind {
+		return nil
+	}
+	statefulset := st.createByStatefulset(pod)
+	if statefulset == nil || statefulset.Spec.PodManagementPolicy == appsv1.ParallelPodManagement {
+		return nil
+	}
+	return statefulset
+}
+
+// unplacedLowerOrdinal returns the name of the first pod of a lower ordinal than the ordinal that
+// is neither recorded nor terminal, empty when there is none. Ordinals outside the sticky
+// ordinals are never recorded and don't hold the pod.
+func (st *Stable) unplacedLowerOrdinal(ctx context.Context, statefulset *appsv1.StatefulSet, ordinal int) string {
+	if st.podLister == nil {
+		return ""
+	}
+	record, err := st.store.Get(ctx, statefulset)
+	if err != nil {
+		// an unreadable record is reported by PreFilter, holding the pod won't fix it
+		klog.V(4).Infof("Failed to read the record of statefulset %s/%s to order its pods: %v",
+			statefulset.Namespace, statefulset.Name, err)
+		return ""
+	}
+	ranges, _ := stickyOrdinals(statefulset, st.globalStickyOrdinals())
+	for i := 0; i < ordinal; i++ {
+		name := fmt.Sprintf("%s-%d", statefulset.Name, i)
+		if !isStickyOrdinal(ranges, name) {
+			continue
+		}
+		lower, err := st.podLister.Pods(statefulset.Namespace).Get(name)
+		if err != nil {
+			// the pod isn't created yet, its key follows from its name
+			lower = &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: statefulset.Namespace}}
+		} else if lower.Status.Phase == v1.PodSucceeded || lower.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if record == nil {
+			return name
+		}
+		if _, ok := record.Records[st.keyOf(lower)]; !ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// waitForLowerOrdinals allows the waiting pod once the pods of its lower ordinals are placed, or
+// after the timeout. It starts before Permit returns, while the framework only registers the
+// waiting pod once all Permit plugins returned, so the pod is looked up until it is found or the
+// framework rejects it.
+func (st *Stable) waitForLowerOrdinals(pod *v1.Pod, namespace, name string, ordinal int, timeout time.Duration) {
+	start := time.Now()
+	var unplaced string
+	err := wait.PollImmediate(orderedPlacementPollInterval, timeout, func() (bool, error) {
+		statefulset, err := st.statefulSetLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			// the statefulset is gone, nothing to wait for
+			return true, nil
+		}
+		unplaced = st.unplacedLowerOrdinal(context.TODO(), statefulset, ordinal)
+		return unplaced == "", nil
+	})
+	if err != nil {
+		klog.V(3).Infof("Allowing pod %s/%s after waiting %v for pod %s of a lower ordinal to be placed",
+			pod.Namespace, pod.Name, timeout, unplaced)
+	}
+	remaining := timeout + orderedPlacementTimeoutMargin - time.Since(start)
+	if remaining < waitingPodPollInterval {
+		// a timeout of 0 would poll forever
+		remaining = waitingPodPollInterval
+	}
+	err = wait.PollImmediate(waitingPodPollInterval, remaining, func() (bool, error) {
+		return st.allowWaitingPod(pod.UID), nil
+	})
+	if err != nil {
+		klog.V(4).Infof("Pod %s/%s was not waiting in Permit anymore", pod.Namespace, pod.Name)
+	}
+}
+
+// allowWaitingPod lets the pod waiting in Permit continue to bind, it returns false while the
+// framework doesn't know the pod as waiting.
+func (st *Stable) allowWaitingPod(uid types.UID) bool {
+	if st.allowPod == nil {
+		return true
+	}
+	return st.allowPod(uid)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func newOrderedPod(name string, phase corev1.PodPhase) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "n1",
+			UID:       types.UID(name + "-uid"),
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind:       "StatefulSet",
+					Name:       "web",
+					Controller: &controller,
+				},
+			},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestPermit(t *testing.T) {
+	tests := []struct {
+		name          string
+		record        string
+		policy        appsv1.PodManagementPolicyType
+		pods          []*corev1.Pod
+		pod           *corev1.Pod
+		expectedCode  framework.Code
+		expectAllowed bool
+	}{
+		{
+			name:         "first ordinal",
+			record:       `{"Records":{}}`,
+			pod:          newOrderedPod("web-0", corev1.PodPending),
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "in order arrival",
+			record:       `{"Records":{"web-0":"node1"}}`,
+			pods:         []*corev1.Pod{newOrderedPod("web-0", corev1.PodRunning)},
+			pod:          newOrderedPod("web-1", corev1.PodPending),
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "terminal lower ordinal",
+			record:       `{"Records":{}}`,
+			pods:         []*corev1.Pod{newOrderedPod("web-0", corev1.PodFailed)},
+			pod:          newOrderedPod("web-1", corev1.PodPending),
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "parallel pod management",
+			record:       `{"Records":{}}`,
+			policy:       appsv1.ParallelPodManagement,
+			pods:         []*corev1.Pod{newOrderedPod("web-0", corev1.PodPending)},
+			pod:          newOrderedPod("web-1", corev1.PodPending),
+			expectedCode: framework.Success,
+		},
+		{
+			name:          "out of order arrival",
+			record:        `{"Records":{"web-0":"node1"}}`,
+			pods:          []*corev1.Pod{newOrderedPod("web-0", corev1.PodRunning), newOrderedPod("web-1", corev1.PodPending)},
+			pod:           newOrderedPod("web-2", corev1.PodPending),
+			expectedCode:  framework.Wait,
+			expectAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+				Spec: appsv1.StatefulSetSpec{PodManagementPolicy: tt.policy},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			podInformer := informers.Core().V1().Pods()
+			allowed := make(chan types.UID, 1)
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				podLister:         podInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{OrderedPlacementTimeoutSeconds: 30},
+				allowPod: func(uid types.UID) bool {
+					allowed <- uid
+					return true
+				},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			for _, pod := range tt.pods {
+				if err := podInformer.Informer().GetIndexer().Add(pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			status, timeout := stableSchedule.Permit(context.TODO(), nil, tt.pod, "node1")
+			if status.Code() != tt.expectedCode {
+				t.Fatalf("expected code %v, got %v", tt.expectedCode, status.Code())
+			}
+			if !tt.expectAllowed {
+				return
+			}
+			if expected := 30*time.Second + orderedPlacementTimeoutMargin; timeout != expected {
+				t.Errorf("expected timeout %v, got %v", expected, timeout)
+			}
+			select {
+			case uid := <-allowed:
+				t.Fatalf("expected pod %v to wait for web-1, it was allowed", uid)
+			case <-time.After(2 * orderedPlacementPollInterval):
+			}
+
+			// web-1 is recorded, which lets web-2 through
+			updated := statefulset.DeepCopy()
+			updated.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"] = `{"Records":{"web-0":"node1","web-1":"node2"}}`
+			if err := statefulsetInformer.Informer().GetIndexer().Update(updated); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case uid := <-allowed:
+				if uid != tt.pod.UID {
+					t.Errorf("expected pod %v to be allowed, got %v", tt.pod.UID, uid)
+				}
+			case <-time.After(5 * orderedPlacementPollInterval):
+				t.Error("expected the pod to be allowed once the lower ordinals are recorded")
+			}
+		})
+	}
+}
+
+func TestPermitTimeout(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	podInformer := informers.Core().V1().Pods()
+	allowed := make(chan types.UID, 1)
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		podLister:         podInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{OrderedPlacementTimeoutSeconds: 1},
+		allowPod: func(uid types.UID) bool {
+			allowed <- uid
+			return true
+		},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	// web-0 is never recorded, web-1 goes through after the timeout
+	pod := newOrderedPod("web-1", corev1.PodPending)
+	if status, _ := stableSchedule.Permit(context.TODO(), nil, pod, "node1"); status.Code() != framework.Wait {
+		t.Fatalf("expected the pod to wait, got %v", status.Code())
+	}
+	select {
+	case uid := <-allowed:
+		if uid != pod.UID {
+			t.Errorf("expected pod %v to be allowed, got %v", pod.UID, uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the pod to be allowed after the timeout")
+	}
+}
+
+func TestWaitForLowerOrdinalsBeforeThePodWaits(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	podInformer := informers.Core().V1().Pods()
+	// the framework registers the pod as waiting only after a few lookups
+	lookups := 0
+	allowed := make(chan types.UID, 1)
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		podLister:         podInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{OrderedPlacementTimeoutSeconds: 30},
+		allowPod: func(uid types.UID) bool {
+			lookups++
+			if lookups < 3 {
+				return false
+			}
+			allowed <- uid
+			return true
+		},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	// web-0 is already placed when the wait starts
+	pod := newOrderedPod("web-1", corev1.PodPending)
+	go stableSchedule.waitForLowerOrdinals(pod, "n1", "web", 1, 30*time.Second)
+	select {
+	case uid := <-allowed:
+		if uid != pod.UID {
+			t.Errorf("expected pod %v to be allowed, got %v", pod.UID, uid)
+		}
+	case <-time.After(10 * waitingPodPollInterval):
+		t.Error("expected the pod to be allowed once the framework registers it as waiting")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// policyReloadInterval is the interval between two reads of the policy file.
+const policyReloadInterval = 10 * time.Second
+
+// stablePolicy is the part of the args that can be read from the policy file and
+// changed at runtime. The fields set in the file override the plugin args.
+type stablePolicy struct {
+	StickyOrdinals        string `json:"stickyOrdinals,omitempty"`
+	NodeReadinessSelector string `json:"nodeReadinessSelector,omitempty"`
+}
+
+// parsePolicy parses the sticky ordinal ranges and the node readiness selector of the policy.
+func parsePolicy(policy stablePolicy) (ordinalRanges, labels.Selector, error) {
+	var stickyOrdinals ordinalRanges
+	if policy.StickyOrdinals != "" {
+		ranges, err := parseOrdinalRanges(policy.StickyOrdinals)
+		if err != nil {
+			return nil, nil, fmt.Errorf("stickyOrdinals: %v", err)
+		}
+		stickyOrdinals = ranges
+	}
+	var nodeReadinessSelector labels.Selector
+	if policy.NodeReadinessSelector != "" {
+		selector, err := labels.Parse(policy.NodeReadinessSelector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("nodeReadinessSelector: %v", err)
+		}
+		nodeReadinessSelector = selector
+	}
+	return stickyOrdinals, nodeReadinessSelector, nil
+}
+
+// reloadPolicy reads the policy file and applies it when its content changed. An invalid
+// file is reported and the current policy is kept.
+func (st *Stable) reloadPolicy() error {
+	content, err := ioutil.ReadFile(st.args.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file %s: %v", st.args.ConfigFile, err)
+	}
+	st.policyLock.Lock()
+	defer st.policyLock.Unlock()
+	if st.policyContent != nil && bytes.Equal(content, st.policyContent) {
+		return nil
+	}
+	policy := stablePolicy{
+		StickyOrdinals:        st.args.StickyOrdinals,
+		NodeReadinessSelector: st.args.NodeReadinessSelector,
+	}
+	if err := yaml.UnmarshalStrict(content, &policy); err != nil {
+		return fmt.Errorf("failed to decode policy file %s: %v", st.args.ConfigFile, err)
+	}
+	stickyOrdinals, nodeReadinessSelector, err := parsePolicy(policy)
+	if err != nil {
+		return fmt.Errorf("invalid policy file %s: %v", st.args.ConfigFile, err)
+	}
+	st.stickyOrdinals, st.nodeReadinessSelector = stickyOrdinals, nodeReadinessSelector
+	st.policyContent = content
+	klog.V(2).Infof("Loaded policy file %s: %+v", st.args.ConfigFile, policy)
+	return nil
+}
+
+// globalStickyOrdinals returns the global sticky ordinal ranges of the current policy.
+func (st *Stable) globalStickyOrdinals() ordinalRanges {
+	st.policyLock.RLock()
+	defer st.policyLock.RUnlock()
+	return st.stickyOrdinals
+}
+
+// readinessSelector returns the node readiness selector of the current policy.
+func (st *Stable) readinessSelector() labels.Selector {
+	st.policyLock.RLock()
+	defer st.policyLock.RUnlock()
+	return st.nodeReadinessSelector
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestReloadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefulset-stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stableSchedule := &Stable{
+		args: StableArgs{StickyOrdinals: "0", ConfigFile: path},
+	}
+	write("nodeReadinessSelector: example.com/initialized=true\n")
+	if err := stableSchedule.reloadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	// the args apply to the fields the file doesn't set
+	if ranges := stableSchedule.globalStickyOrdinals(); !ranges.Has(0) || ranges.Has(1) {
+		t.Errorf("expected the sticky ordinals of the args, got %v", ranges)
+	}
+	initialized := labels.Set{"example.com/initialized": "true"}
+	if selector := stableSchedule.readinessSelector(); selector == nil || !selector.Matches(initialized) || selector.Matches(labels.Set{}) {
+		t.Errorf("expected the node readiness selector of the file, got %v", selector)
+	}
+
+	// the file changes
+	write("stickyOrdinals: 0-1\n")
+	if err := stableSchedule.reloadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if ranges := stableSchedule.globalStickyOrdinals(); !ranges.Has(1) {
+		t.Errorf("expected the reloaded sticky ordinals, got %v", ranges)
+	}
+	if selector := stableSchedule.readinessSelector(); selector != nil {
+		t.Errorf("expected no node readiness selector after it was removed from the file, got %v", selector)
+	}
+
+	// an invalid file keeps the current policy
+	for _, content := range []string{"stickyOrdinals: x\n", "unknownField: true\n", "stickyOrdinals: ["} {
+		write(content)
+		if err := stableSchedule.reloadPolicy(); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+		if ranges := stableSchedule.globalStickyOrdinals(); !ranges.Has(1) {
+			t.Errorf("expected the sticky ordinals to be kept after %q, got %v", content, ranges)
+		}
+	}
+
+	// a missing file keeps the current policy
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := stableSchedule.reloadPolicy(); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	if ranges := stableSchedule.globalStickyOrdinals(); !ranges.Has(1) {
+		t.Errorf("expected the sticky ordinals to be kept, got %v", ranges)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// PreemptedPodReclaim keeps enforcing the record of a preempted pod, the pod returns to its
+	// recorded node, preempting the preemptor when its priority allows.
+	PreemptedPodReclaim = "Reclaim"
+	// PreemptedPodMove relaxes the record of a preempted pod, the recorded node is preferred but
+	// the pod may move, and the record follows the pod to its new node.
+	PreemptedPodMove = "Move"
+)
+
+// preemptedPods tracks the stable pods deleted by preemption by namespace/name, until the
+// recreated pods are recorded again.
+type preemptedPods struct {
+	lock sync.Mutex
+	keys sets.String
+}
+
+func (p *preemptedPods) add(pod *v1.Pod) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.keys == nil {
+		p.keys = sets.NewString()
+	}
+	p.keys.Insert(pod.Namespace + "/" + pod.Name)
+}
+
+func (p *preemptedPods) has(pod *v1.Pod) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.keys.Has(pod.Namespace + "/" + pod.Name)
+}
+
+func (p *preemptedPods) remove(pod *v1.Pod) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.keys.Delete(pod.Namespace + "/" + pod.Name)
+}
+
+// podPriority returns the priority of the pod, 0 when it has none.
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	return 0
+}
+
+// wasPreempted checks whether the deleted pod was preempted. The framework of this version
+// leaves no trace of the preemption on the victim, a pod of higher priority nominated to the
+// node of the victim is taken as its preemptor.
+func (st *Stable) wasPreempted(victim *v1.Pod) bool {
+	if victim.Spec.NodeName == "" {
+		return false
+	}
+	pods, err := st.podLister.List(labels.Everything())
+	if err != nil {
+		klog.V(4).Infof("Failed to list pods to find the preemptor of pod %s/%s: %v", victim.Namespace, victim.Name, err)
+		return false
+	}
+	for _, pod := range pods {
+		if pod.Status.NominatedNodeName == victim.Spec.NodeName && podPriority(pod) > podPriority(victim) {
+			return true
+		}
+	}
+	return false
+}
+
+// preemptedEventHandler tracks the stable pods deleted by preemption for PreemptedPodMove.
+func (st *Stable) preemptedEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			pod, ok := obj.(*v1.Pod)
+			if !ok || !st.eligible(pod) || !st.wasPreempted(pod) {
+				return
+			}
+			klog.V(3).Infof("Pod %s/%s was preempted on node %s, relaxing its record", pod.Namespace, pod.Name, pod.Spec.NodeName)
+			st.preempted.add(pod)
+		},
+	}
+}
+
+// relaxedAfterPreemption checks whether the record of the pod is relaxed because the pod was
+// preempted.
+func (st *Stable) relaxedAfterPreemption(pod *v1.Pod) bool {
+	return st.args.PreemptedPodPolicy == PreemptedPodMove && st.preempted.has(pod)
+}
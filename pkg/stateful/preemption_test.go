@@ -0,0 +1,158 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestPreemptedPodPolicy(t *testing.T) {
+	high := int32(1000)
+	tests := []struct {
+		name string
+		// preemptorPriority is the priority of the pod nominated to node1, nil for no preemptor.
+		preemptorPriority *int32
+		policy            string
+		expectedCode      framework.Code
+		expectedRecord    string
+	}{
+		{
+			name:              "preempted pod reclaims its recorded node",
+			preemptorPriority: &high,
+			policy:            PreemptedPodReclaim,
+			expectedCode:      framework.Unschedulable,
+			expectedRecord:    `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:              "preempted pod moves",
+			preemptorPriority: &high,
+			policy:            PreemptedPodMove,
+			expectedCode:      framework.Success,
+			expectedRecord:    `{"Records":{"web-0":"node2"}}`,
+		},
+		{
+			name:           "deleted pod without preemptor",
+			policy:         PreemptedPodMove,
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:              "deleted pod with a nominated pod of the same priority",
+			preemptorPriority: new(int32),
+			policy:            PreemptedPodMove,
+			expectedCode:      framework.Unschedulable,
+			expectedRecord:    `{"Records":{"web-0":"node1"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			podInformer := informers.Core().V1().Pods()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				podLister:         podInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{PreemptedPodPolicy: tt.policy, RecordUpdatePolicy: RecordUpdateImmutable},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			if tt.preemptorPriority != nil {
+				preemptor := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "preemptor", Namespace: "n2"},
+					Spec:       corev1.PodSpec{Priority: tt.preemptorPriority},
+					Status:     corev1.PodStatus{NominatedNodeName: "node1"},
+				}
+				if err := podInformer.Informer().GetIndexer().Add(preemptor); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newPod := func(nodeName string) *corev1.Pod {
+				return &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "web-0",
+						Namespace: "n1",
+						Labels: map[string]string{
+							"statefulset-stable.scheduling.sigs.k8s.io": "true",
+						},
+						OwnerReferences: []metav1.OwnerReference{
+							{
+								Kind: "StatefulSet",
+								Name: "web",
+							},
+						},
+					},
+					Spec: corev1.PodSpec{NodeName: nodeName},
+				}
+			}
+
+			// the victim is deleted, the statefulset recreates it
+			stableSchedule.preemptedEventHandler().OnDelete(cache.DeletedFinalStateUnknown{Key: "n1/web-0", Obj: newPod("node1")})
+			pod := newPod("")
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if score, _ := stableSchedule.Score(ctx, state, pod, "node1"); tt.expectedCode == framework.Success && score != framework.MaxNodeScore {
+				t.Errorf("expected the recorded node to be preferred, got score %d", score)
+			}
+			if tt.expectedCode == framework.Success {
+				stableSchedule.PostBind(ctx, state, pod, "node2")
+			}
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+			if tt.expectedCode == framework.Success && stableSchedule.preempted.has(pod) {
+				t.Errorf("expected the recorded pod not to be tracked as preempted anymore")
+			}
+		})
+	}
+}
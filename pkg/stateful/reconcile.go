@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+// newReconcileQueue returns a work queue of statefulset keys, rate limited per item
+// with an exponential backoff and overall with a token bucket.
+func newReconcileQueue(qps float64, burst int) workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(5*time.Millisecond, 1000*time.Second),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	), Name)
+}
+
+// reconcileEventHandler enqueues the statefulsets that carry a record annotation, and with
+// StoreTypeConfigMap all statefulsets, whose record isn't visible on the statefulset.
+func (st *Stable) reconcileEventHandler() cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			statefulset, ok := obj.(*appsv1.StatefulSet)
+			if !ok {
+				return false
+			}
+			_, ok = statefulset.GetAnnotations()[recordAnnotationKey(st.args)]
+			return ok || st.args.StoreType == StoreTypeConfigMap
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: st.enqueueStatefulSet,
+			UpdateFunc: func(_, newObj interface{}) {
+				st.enqueueStatefulSet(newObj)
+			},
+		},
+	}
+}
+
+func (st *Stable) enqueueStatefulSet(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	st.reconcileQueue.Add(key)
+}
+
+// runReconcile starts the reconcile workers and blocks until stopCh is closed,
+// then shuts down the queue so the workers exit.
+func (st *Stable) runReconcile(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer st.reconcileQueue.ShutDown()
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(st.reconcileWorker, time.Second, stopCh)
+	}
+	<-stopCh
+}
+
+func (st *Stable) reconcileWorker() {
+	for st.processNextReconcileItem() {
+	}
+}
+
+func (st *Stable) processNextReconcileItem() bool {
+	key, quit := st.reconcileQueue.Get()
+	if quit {
+		return false
+	}
+	defer st.reconcileQueue.Done(key)
+
+	if err := st.reconcile(context.TODO(), key.(string)); err != nil {
+		klog.V(3).Infof("Failed to reconcile the schedule record of statefulset %v, requeuing: %v", key, err)
+		st.reconcileQueue.AddRateLimited(key)
+		return true
+	}
+	st.reconcileQueue.Forget(key)
+	return true
+}
+
+// reconcile garbage collects the records of pods outside the sticky ordinals of the statefulset,
+// the expired entries, the entries of ordinals out of the replicas and the entries of old revisions, and checks the records against the
+// topology spread constraints of the statefulset. Frozen records are not garbage collected.
+func (st *Stable) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return nil
+	}
+	statefulset, err := st.statefulSetLister.StatefulSets(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		st.frozen.track(key, false)
+		st.tracked.track(key, false)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st.frozen.track(key, isFrozen(statefulset))
+	record, err := st.store.Get(ctx, statefulset)
+	if isInvalidRecord(err) {
+		// retrying won't fix the record, it is reported by PreFilter
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st.tracked.track(key, record != nil && len(record.Records) > 0)
+	if record == nil {
+		return nil
+	}
+	ranges, _ := stickyOrdinals(statefulset, st.globalStickyOrdinals())
+	pruned := pruneScheduleRecord(record, ranges)
+	pruned = record.pruneExpired(st.now(), st.recordTTL(statefulset)) || pruned
+	pruned = record.pruneScaledDown(statefulset, st.args.RecordKey == RecordKeyOrdinal) || pruned
+	if st.args.ScopeRecordsByRevision {
+		pruned = record.pruneOldRevisions(statefulset) || pruned
+	}
+	st.checkTopologySpread(statefulset, record)
+	if !pruned || isFrozen(statefulset) {
+		return nil
+	}
+	if err := validateRecordSet(record.nodes(), statefulset, st.args.RecordKey == RecordKeyOrdinal); err != nil {
+		// retrying won't fix the record, the pruned entries are written once it is fixed
+		klog.Warningf("Not writing the inconsistent schedule record of statefulset %s: %v", key, err)
+		return nil
+	}
+	return st.store.Set(ctx, statefulset, record)
+}
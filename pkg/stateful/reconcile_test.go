@@ -0,0 +1,109 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcile(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record":   `{"Records":{"web-0":"node1","web-3":"node2"}}`,
+				"statefulset-stable.scheduling.sigs.k8s.io/ordinals": "0-1",
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		reconcileQueue:    newReconcileQueue(defaultReconcileQPS, defaultReconcileBurst),
+	}
+	defer stableSchedule.reconcileQueue.ShutDown()
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	stableSchedule.reconcileEventHandler().OnAdd(statefulset)
+	if got := stableSchedule.reconcileQueue.Len(); got != 1 {
+		t.Fatalf("expected 1 queued statefulset, got %d", got)
+	}
+	// statefulsets without a record are not queued
+	stableSchedule.reconcileEventHandler().OnAdd(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "n1"}})
+	if got := stableSchedule.reconcileQueue.Len(); got != 1 {
+		t.Fatalf("expected 1 queued statefulset, got %d", got)
+	}
+
+	if !stableSchedule.processNextReconcileItem() {
+		t.Fatal("expected the queue to be running")
+	}
+	ctx := context.TODO()
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// a deleted statefulset is not requeued
+	if err := stableSchedule.reconcile(ctx, "n1/deleted"); err != nil {
+		t.Errorf("expected no error for a deleted statefulset, got %v", err)
+	}
+}
+
+func TestRunReconcileShutdown(t *testing.T) {
+	stableSchedule := &Stable{
+		reconcileQueue: newReconcileQueue(defaultReconcileQPS, defaultReconcileBurst),
+	}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		stableSchedule.runReconcile(2, stopCh)
+		close(done)
+	}()
+	close(stopCh)
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		select {
+		case <-done:
+			return true, nil
+		default:
+			return false, nil
+		}
+	}); err != nil {
+		t.Fatal("expected runReconcile to return after stop")
+	}
+	if !stableSchedule.reconcileQueue.ShuttingDown() {
+		t.Error("expected the queue to be shut down")
+	}
+}
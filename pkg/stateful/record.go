@@ -0,0 +1,253 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// maxRecordSize is the maximum size in bytes of a record annotation the plugin decodes,
+// it matches the total size limit of the annotations of an object.
+const maxRecordSize = 256 * 1024
+
+// maxConfigMapRecordSize is the maximum size in bytes of a record the ConfigMap stores decode
+// and write, the 1MiB size limit of an object less room for the metadata of the ConfigMap.
+const maxConfigMapRecordSize = 1024*1024 - 16*1024
+
+// ScheduleRecord is the schedule record of the pods of a statefulset, mapping the record key
+// of a pod, its name by default, to the entry of its node.
+type ScheduleRecord struct {
+	Records map[string]RecordEntry
+	// Ready is whether the statefulset has been ready, saved for EnforceAfterReady so a
+	// restarted scheduler keeps enforcing the record of an unready statefulset.
+	Ready bool `json:",omitempty"`
+
+	// stored is the value the ConfigMap stores read the record from, empty for a new record.
+	// They write the record only while they still hold that value, see recordChangedError.
+	stored string
+	// overwrite writes the record whatever the store holds, for the copies of multiStore.
+	overwrite bool
+}
+
+// ReturnEntry is the node a relocated pod may return to until the end of the grace window.
+type ReturnEntry struct {
+	Node  string
+	Until metav1.Time
+}
+
+const (
+	// RecordSourceAuto is the source of a node recorded after binding the pod to it.
+	RecordSourceAuto = "auto"
+	// RecordSourceOverride is the source of a node recorded instead of the node the pod was bound
+	// to, e.g. the node of the volume of the pod.
+	RecordSourceOverride = "override"
+)
+
+// ownerOf returns the name of the pod that recorded the key.
+func (r *ScheduleRecord) ownerOf(key string) string {
+	if owner := r.Records[key].Owner; owner != "" {
+		return owner
+	}
+	return key
+}
+
+// sourceOf returns the source of the node recorded under the key.
+func (r *ScheduleRecord) sourceOf(key string) string {
+	if source := r.Records[key].Source; source != "" {
+		return source
+	}
+	return RecordSourceAuto
+}
+
+// setEntry records the node of the pod under the key, together with the source of the node.
+// The other metadata of the entry is kept.
+func (r *ScheduleRecord) setEntry(key, podName, nodeName, source string) {
+	if r.Records == nil {
+		r.Records = make(map[string]RecordEntry)
+	}
+	entry := r.Records[key]
+	entry.Node, entry.Source, entry.Owner = nodeName, "", ""
+	if source != RecordSourceAuto {
+		entry.Source = source
+	}
+	if key != podName {
+		entry.Owner = podName
+	}
+	r.Records[key] = entry
+}
+
+// deleteEntry removes the record of the key together with its metadata.
+func (r *ScheduleRecord) deleteEntry(key string) {
+	delete(r.Records, key)
+}
+
+// updateEntry applies the update to the entry of the key, it does nothing if the key has no
+// entry.
+func (r *ScheduleRecord) updateEntry(key string, update func(entry *RecordEntry)) {
+	entry, ok := r.Records[key]
+	if !ok {
+		return
+	}
+	update(&entry)
+	r.Records[key] = entry
+}
+
+// nodeOf returns the node recorded under the key, false if the key has no entry.
+func (r *ScheduleRecord) nodeOf(key string) (string, bool) {
+	entry, ok := r.Records[key]
+	return entry.Node, ok
+}
+
+// nodes returns the nodes of the entries by key.
+func (r *ScheduleRecord) nodes() map[string]string {
+	nodes := make(map[string]string, len(r.Records))
+	for key, entry := range r.Records {
+		nodes[key] = entry.Node
+	}
+	return nodes
+}
+
+// isExpired checks whether the entry of the key is older than the ttl. Entries without a
+// recorded time and all entries with a ttl of 0 never expire.
+func (r *ScheduleRecord) isExpired(key string, now time.Time, ttl time.Duration) bool {
+	at := r.Records[key].RecordedAt
+	return !at.IsZero() && ttl > 0 && now.Sub(at.Time) > ttl
+}
+
+// pruneExpired removes the entries older than the ttl, it returns whether any was removed.
+func (r *ScheduleRecord) pruneExpired(now time.Time, ttl time.Duration) bool {
+	pruned := false
+	for key := range r.Records {
+		if r.isExpired(key, now, ttl) {
+			r.deleteEntry(key)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// isStale checks whether the key was recorded at an older generation of the statefulset than
+// the given one. Keys recorded without a generation are never stale.
+func (r *ScheduleRecord) isStale(key string, generation int64) bool {
+	recorded := r.Records[key].Generation
+	return recorded != 0 && recorded < generation
+}
+
+// returnNodeOf returns the node the pod of the key may return to, empty if there is none
+// or the grace window has ended.
+func (r *ScheduleRecord) returnNodeOf(key string, now time.Time) string {
+	ret := r.Records[key].Return
+	if ret == nil || !now.Before(ret.Until.Time) {
+		return ""
+	}
+	return ret.Node
+}
+
+// setReturn keeps the node as the return node of the key until the end of the grace window.
+func (r *ScheduleRecord) setReturn(key, nodeName string, until time.Time) {
+	r.updateEntry(key, func(entry *RecordEntry) {
+		entry.Return = &ReturnEntry{Node: nodeName, Until: metav1.NewTime(until)}
+	})
+}
+
+// deleteReturn removes the return node of the key.
+func (r *ScheduleRecord) deleteReturn(key string) {
+	r.updateEntry(key, func(entry *RecordEntry) {
+		entry.Return = nil
+	})
+}
+
+// pruneReturns removes the return nodes whose grace window has ended.
+func (r *ScheduleRecord) pruneReturns(now time.Time) {
+	for key, entry := range r.Records {
+		if entry.Return != nil && r.returnNodeOf(key, now) == "" {
+			r.deleteReturn(key)
+		}
+	}
+}
+
+// InvalidRecordError is returned when a record annotation is too large or malformed.
+type InvalidRecordError struct {
+	Reason string
+}
+
+func (e *InvalidRecordError) Error() string {
+	return fmt.Sprintf("invalid schedule record: %s", e.Reason)
+}
+
+// validateRecord handles the result of reading the record of the statefulset. A record that
+// can't be decoded can't pin the pods, with StrictRecordParsing the error is kept and fails the
+// pods of the statefulset until the record is fixed. Otherwise the record is ignored with a
+// Warning event on the statefulset, and the pods are scheduled as if it had no record.
+func (st *Stable) validateRecord(statefulset *appsv1.StatefulSet, record *ScheduleRecord, err error) (*ScheduleRecord, error) {
+	if !isInvalidRecord(err) || st.args.StrictRecordParsing {
+		return record, err
+	}
+	klog.V(3).Infof("Ignoring schedule record of statefulset %s/%s: %v", statefulset.Namespace, statefulset.Name, err)
+	st.emitInvalidRecordEvent(statefulset, err)
+	return nil, nil
+}
+
+// isInvalidRecord checks whether the error is an InvalidRecordError.
+func isInvalidRecord(err error) bool {
+	var invalid *InvalidRecordError
+	return errors.As(err, &invalid)
+}
+
+// getScheduleRecord reads the record from the annotation of the key of the statefulset, nil
+// when the statefulset has no record.
+func getScheduleRecord(statefulset *appsv1.StatefulSet, key string) (*ScheduleRecord, error) {
+	rec, ok := statefulset.GetAnnotations()[key]
+	if !ok {
+		return nil, nil
+	}
+	return decodeScheduleRecord(rec, maxRecordSize)
+}
+
+// decodeScheduleRecord decodes a record, refusing values larger than the size limit of their
+// store before any of it is decoded. Compressed records are decompressed first.
+func decodeScheduleRecord(rec string, maxSize int) (*ScheduleRecord, error) {
+	if len(rec) > maxSize {
+		return nil, &InvalidRecordError{Reason: fmt.Sprintf("size %d exceeds the limit of %d bytes", len(rec), maxSize)}
+	}
+	limit := int64(maxSize)
+	if strings.HasPrefix(rec, compressedRecordPrefix) {
+		decompressed, err := decompressRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		rec, limit = decompressed, maxDecompressedRecordSize
+	}
+	var record *ScheduleRecord
+	decoder := json.NewDecoder(io.LimitReader(strings.NewReader(rec), limit))
+	if err := decoder.Decode(&record); err != nil {
+		return nil, &InvalidRecordError{Reason: err.Error()}
+	}
+	if decoder.More() {
+		return nil, &InvalidRecordError{Reason: "unexpected data after the record"}
+	}
+	return record, nil
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestDecodeScheduleRecord(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		expected      *ScheduleRecord
+		expectInvalid bool
+	}{
+		{
+			name:     "valid record",
+			value:    `{"Records":{"web-0":"node1"}}`,
+			expected: &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}},
+		},
+		{
+			name:     "null record",
+			value:    `null`,
+			expected: nil,
+		},
+		{
+			name:          "malformed record",
+			value:         `{"Records":{"web-0":`,
+			expectInvalid: true,
+		},
+		{
+			name:          "empty record",
+			value:         ``,
+			expectInvalid: true,
+		},
+		{
+			name:          "data after the record",
+			value:         `{"Records":{}} {}`,
+			expectInvalid: true,
+		},
+		{
+			name:          "oversized record",
+			value:         `{"Records":{"web-0":"` + strings.Repeat("n", maxRecordSize) + `"}}`,
+			expectInvalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := decodeScheduleRecord(tt.value, maxRecordSize)
+			if tt.expectInvalid {
+				if !isInvalidRecord(err) {
+					t.Errorf("expected an invalid record error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.expected, record) {
+				t.Errorf("expected %v, got %v", tt.expected, record)
+			}
+		})
+	}
+}
+
+func TestRecordEntrySource(t *testing.T) {
+	record, err := decodeScheduleRecord(`{"Records":{"web-0":"node1"}}`, maxRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := record.sourceOf("web-0"); got != RecordSourceAuto {
+		t.Errorf("expected entries without source to be %v, got %v", RecordSourceAuto, got)
+	}
+
+	record.setEntry("web-1", "web-1", "node2", RecordSourceOverride)
+	if got := record.sourceOf("web-1"); got != RecordSourceOverride {
+		t.Errorf("expected %v, got %v", RecordSourceOverride, got)
+	}
+
+	record.setEntry("web-1", "web-1", "node3", RecordSourceAuto)
+	if got := record.sourceOf("web-1"); got != RecordSourceAuto {
+		t.Errorf("expected %v, got %v", RecordSourceAuto, got)
+	}
+	if source := record.Records["web-1"].Source; source != "" {
+		t.Errorf("expected no source for auto entries, got %v", source)
+	}
+
+	record.setEntry("web-2", "web-2", "node2", RecordSourceOverride)
+	record.deleteEntry("web-2")
+	record.setEntry("web-2", "web-2", "node2", RecordSourceAuto)
+	if got := record.sourceOf("web-2"); got != RecordSourceAuto {
+		t.Errorf("expected the source to be deleted with the entry, got %v", got)
+	}
+}
+
+func TestRecordEntryGeneration(t *testing.T) {
+	record, err := decodeScheduleRecord(`{"Records":{"web-0":"node1","web-1":"node2"},"Generations":{"web-1":2}}`, maxRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.isStale("web-0", 3) {
+		t.Errorf("expected entries without generation to never be stale")
+	}
+	if record.isStale("web-1", 2) {
+		t.Errorf("expected entries of the current generation not to be stale")
+	}
+	if !record.isStale("web-1", 3) {
+		t.Errorf("expected entries of an older generation to be stale")
+	}
+
+	record.updateEntry("web-1", func(entry *RecordEntry) {
+		entry.Generation = 3
+	})
+	if record.isStale("web-1", 3) {
+		t.Errorf("expected the entry to be confirmed for generation 3")
+	}
+	record.deleteEntry("web-1")
+	record.setEntry("web-1", "web-1", "node2", RecordSourceAuto)
+	if generation := record.Records["web-1"].Generation; generation != 0 {
+		t.Errorf("expected the generation to be deleted with the entry, got %v", generation)
+	}
+}
+
+func TestStrictRecordParsing(t *testing.T) {
+	tests := []struct {
+		name          string
+		record        string
+		strict        bool
+		expectedCode  framework.Code
+		expectedEvent bool
+	}{
+		{
+			name:          "corrupted record is ignored",
+			record:        `{"Records":"web-0=node1"}`,
+			expectedCode:  framework.Success,
+			expectedEvent: true,
+		},
+		{
+			name:          "partial record is ignored",
+			record:        `{"Records":{"web-0":"node1"`,
+			expectedCode:  framework.Success,
+			expectedEvent: true,
+		},
+		{
+			name:          "empty record is ignored",
+			record:        ``,
+			expectedCode:  framework.Success,
+			expectedEvent: true,
+		},
+		{
+			name:         "corrupted record fails the pods with strict parsing",
+			record:       `{"Records":"web-0=node1"}`,
+			strict:       true,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "partial record fails the pods with strict parsing",
+			record:       `{"Records":{"web-0":"node1"`,
+			strict:       true,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "empty record fails the pods with strict parsing",
+			record:       ``,
+			strict:       true,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "valid record pins the pod",
+			record:       `{"Records":{"web-0":"node1"}}`,
+			expectedCode: framework.Unschedulable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			recorder := record.NewFakeRecorder(10)
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{StrictRecordParsing: tt.strict},
+				eventRecorder:     recorder,
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			// the pod is scheduled twice, the event is only emitted once
+			for i := 0; i < 2; i++ {
+				state := framework.NewCycleState()
+				stableSchedule.PreFilter(context.TODO(), state, pod)
+				if got := stableSchedule.Filter(context.TODO(), state, pod, nodeInfo).Code(); got != tt.expectedCode {
+					t.Errorf("expected code %v, got %v", tt.expectedCode, got)
+				}
+			}
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			if tt.expectedEvent {
+				if len(events) != 1 || !strings.HasPrefix(events[0], "Warning "+EventReasonInvalidRecord) {
+					t.Errorf("expected a single %s event, got %v", EventReasonInvalidRecord, events)
+				}
+			} else if len(events) != 0 {
+				t.Errorf("expected no events, got %v", events)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RecordEntry is the node recorded under a key together with its metadata. An entry without
+// metadata is encoded as the name of its node, the format of the records of versions of the
+// plugin before entries, which keep reading these records.
+type RecordEntry struct {
+	Node string
+	// RecordedAt is the time the node was last recorded or confirmed by binding the pod to it,
+	// zero when unknown. The entry expires a record TTL later, entries without a time never
+	// expire.
+	RecordedAt metav1.Time
+	// Zone is the TopologyKey label of the node at record time, the pod is stable within the
+	// domain instead of on the node. It is empty when unknown.
+	Zone string
+	// Owner is the name of the pod that recorded a key that is not a pod name, empty when the
+	// key is the name of the pod.
+	Owner string
+	// Source is the source of the node when it was not recorded by RecordSourceAuto.
+	Source string
+	// Labels are the fallback labels of the node at record time.
+	Labels map[string]string
+	// Return is the node a relocated pod was recorded on before, which it may return to until
+	// the grace window ends.
+	Return *ReturnEntry
+	// Generation is the generation of the statefulset the node was recorded at.
+	Generation int64
+	// Image is the primary image the pod ran on the node.
+	Image string
+	// Since is the time the pod was first recorded on the node, used to weight the score of
+	// the node by tenure.
+	Since metav1.Time
+	// Revision is the controller revision of the statefulset the pod was recorded with, used
+	// by ScopeRecordsByRevision.
+	Revision string
+	// Epoch is the epoch of the node at record time, used by EnforceNodeEpoch.
+	Epoch string
+	// Identity is the NodeIdentityLabel of the node at record time.
+	Identity string
+}
+
+// encodedRecordEntry is the encoding of a RecordEntry with metadata. Timestamp is the former
+// name of RecordedAt, which is only read.
+type encodedRecordEntry struct {
+	Node       string
+	RecordedAt *metav1.Time      `json:",omitempty"`
+	Timestamp  *metav1.Time      `json:",omitempty"`
+	Zone       string            `json:",omitempty"`
+	Owner      string            `json:",omitempty"`
+	Source     string            `json:",omitempty"`
+	Labels     map[string]string `json:",omitempty"`
+	Return     *ReturnEntry      `json:",omitempty"`
+	Generation int64             `json:",omitempty"`
+	Image      string            `json:",omitempty"`
+	Since      *metav1.Time      `json:",omitempty"`
+	Revision   string            `json:",omitempty"`
+	Epoch      string            `json:",omitempty"`
+	Identity   string            `json:",omitempty"`
+}
+
+// hasMetadata checks whether the entry has more than its node.
+func (e RecordEntry) hasMetadata() bool {
+	return !e.RecordedAt.IsZero() || e.Zone != "" || e.Owner != "" || e.Source != "" || len(e.Labels) > 0 ||
+		e.Return != nil || e.Generation != 0 || e.Image != "" || !e.Since.IsZero() || e.Revision != "" ||
+		e.Epoch != "" || e.Identity != ""
+}
+
+// MarshalJSON encodes the entry as the name of its node when it has no metadata.
+func (e RecordEntry) MarshalJSON() ([]byte, error) {
+	if !e.hasMetadata() {
+		return json.Marshal(e.Node)
+	}
+	encoded := encodedRecordEntry{
+		Node:       e.Node,
+		Zone:       e.Zone,
+		Owner:      e.Owner,
+		Source:     e.Source,
+		Labels:     e.Labels,
+		Return:     e.Return,
+		Generation: e.Generation,
+		Image:      e.Image,
+		Revision:   e.Revision,
+		Epoch:      e.Epoch,
+		Identity:   e.Identity,
+	}
+	if !e.RecordedAt.IsZero() {
+		encoded.RecordedAt = &e.RecordedAt
+	}
+	if !e.Since.IsZero() {
+		encoded.Since = &e.Since
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes an entry encoded either as the name of its node or with its metadata.
+func (e *RecordEntry) UnmarshalJSON(data []byte) error {
+	var node string
+	if err := json.Unmarshal(data, &node); err == nil {
+		*e = RecordEntry{Node: node}
+		return nil
+	}
+	var encoded encodedRecordEntry
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("neither a node name nor a record entry: %v", err)
+	}
+	*e = RecordEntry{
+		Node:       encoded.Node,
+		Zone:       encoded.Zone,
+		Owner:      encoded.Owner,
+		Source:     encoded.Source,
+		Labels:     encoded.Labels,
+		Return:     encoded.Return,
+		Generation: encoded.Generation,
+		Image:      encoded.Image,
+		Revision:   encoded.Revision,
+		Epoch:      encoded.Epoch,
+		Identity:   encoded.Identity,
+	}
+	if encoded.RecordedAt != nil {
+		e.RecordedAt = *encoded.RecordedAt
+	} else if encoded.Timestamp != nil {
+		e.RecordedAt = *encoded.Timestamp
+	}
+	if encoded.Since != nil {
+		e.Since = *encoded.Since
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a record, upgrading the per-key fields of records written before the
+// entries kept their metadata, e.g. Owners or Generations, to the metadata of the entries. The
+// metadata of an entry takes precedence over these fields, and the fields of keys without an
+// entry are dropped.
+func (r *ScheduleRecord) UnmarshalJSON(data []byte) error {
+	// plain has the fields but not the methods of ScheduleRecord
+	type plain ScheduleRecord
+	var raw struct {
+		plain
+		RecordedAt  map[string]metav1.Time
+		Topologies  map[string]string
+		Owners      map[string]string
+		Sources     map[string]string
+		Labels      map[string]map[string]string
+		Returns     map[string]ReturnEntry
+		Generations map[string]int64
+		Images      map[string]string
+		Since       map[string]metav1.Time
+		Revisions   map[string]string
+		Epochs      map[string]string
+		Identities  map[string]string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = ScheduleRecord(raw.plain)
+	for key, entry := range r.Records {
+		if at, ok := raw.RecordedAt[key]; ok && entry.RecordedAt.IsZero() {
+			entry.RecordedAt = at
+		}
+		if entry.Zone == "" {
+			entry.Zone = raw.Topologies[key]
+		}
+		if entry.Owner == "" && raw.Owners[key] != key {
+			entry.Owner = raw.Owners[key]
+		}
+		if entry.Source == "" && raw.Sources[key] != RecordSourceAuto {
+			entry.Source = raw.Sources[key]
+		}
+		if entry.Labels == nil {
+			entry.Labels = raw.Labels[key]
+		}
+		if ret, ok := raw.Returns[key]; ok && entry.Return == nil {
+			entry.Return = &ret
+		}
+		if entry.Generation == 0 {
+			entry.Generation = raw.Generations[key]
+		}
+		if entry.Image == "" {
+			entry.Image = raw.Images[key]
+		}
+		if since, ok := raw.Since[key]; ok && entry.Since.IsZero() {
+			entry.Since = since
+		}
+		if entry.Revision == "" {
+			entry.Revision = raw.Revisions[key]
+		}
+		if entry.Epoch == "" {
+			entry.Epoch = raw.Epochs[key]
+		}
+		if entry.Identity == "" {
+			entry.Identity = raw.Identities[key]
+		}
+		r.Records[key] = entry
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecordEntryFormats(t *testing.T) {
+	// metav1.Time decodes to the local time zone
+	recordedAt := metav1.NewTime(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC).Local())
+	tests := []struct {
+		name            string
+		value           string
+		expectedEntries map[string]RecordEntry
+		// expectedValue is the encoded record, entries without metadata are encoded as node names
+		expectedValue string
+		expectInvalid bool
+	}{
+		{
+			name:            "node names",
+			value:           `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+			expectedEntries: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}},
+			expectedValue:   `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+		},
+		{
+			name:            "node names with metadata next to them",
+			value:           `{"Records":{"web-0":"node1","web-1":"node2"},"RecordedAt":{"web-0":"2020-06-01T00:00:00Z"},"Topologies":{"web-1":"b"}}`,
+			expectedEntries: map[string]RecordEntry{"web-0": {Node: "node1", RecordedAt: recordedAt}, "web-1": {Node: "node2", Zone: "b"}},
+			expectedValue:   `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z"},"web-1":{"Node":"node2","Zone":"b"}}}`,
+		},
+		{
+			name:            "record entries",
+			value:           `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z","Zone":"a"},"web-1":{"Node":"node2"}}}`,
+			expectedEntries: map[string]RecordEntry{"web-0": {Node: "node1", RecordedAt: recordedAt, Zone: "a"}, "web-1": {Node: "node2"}},
+			expectedValue:   `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z","Zone":"a"},"web-1":"node2"}}`,
+		},
+		{
+			name:            "record entries with a timestamp",
+			value:           `{"Records":{"web-0":{"Node":"node1","Timestamp":"2020-06-01T00:00:00Z"}},"Owners":{"web-0":"web-0a"}}`,
+			expectedEntries: map[string]RecordEntry{"web-0": {Node: "node1", RecordedAt: recordedAt, Owner: "web-0a"}},
+			expectedValue:   `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z","Owner":"web-0a"}}}`,
+		},
+		{
+			name: "per-key fields next to the records",
+			value: `{"Records":{"web-0":"node1","web-1":"node2"},"Owners":{"web-0":"web-0a"},"Sources":{"web-0":"override"},` +
+				`"Labels":{"web-0":{"zone":"a"}},"Returns":{"web-0":{"Node":"node3","Until":"2020-06-01T00:00:00Z"}},` +
+				`"Generations":{"web-0":2},"Images":{"web-0":"db:1"},"Since":{"web-0":"2020-06-01T00:00:00Z"},` +
+				`"Revisions":{"web-0":"web-rev1"},"Epochs":{"web-0":"uid-1"},"Identities":{"web-0":"i-1","web-2":"i-2"}}`,
+			expectedEntries: map[string]RecordEntry{
+				"web-0": {
+					Node:       "node1",
+					Owner:      "web-0a",
+					Source:     RecordSourceOverride,
+					Labels:     map[string]string{"zone": "a"},
+					Return:     &ReturnEntry{Node: "node3", Until: recordedAt},
+					Generation: 2,
+					Image:      "db:1",
+					Since:      recordedAt,
+					Revision:   "web-rev1",
+					Epoch:      "uid-1",
+					Identity:   "i-1",
+				},
+				"web-1": {Node: "node2"},
+			},
+			expectedValue: `{"Records":{"web-0":{"Node":"node1","Owner":"web-0a","Source":"override","Labels":{"zone":"a"},` +
+				`"Return":{"Node":"node3","Until":"2020-06-01T00:00:00Z"},"Generation":2,"Image":"db:1",` +
+				`"Since":"2020-06-01T00:00:00Z","Revision":"web-rev1","Epoch":"uid-1","Identity":"i-1"},"web-1":"node2"}}`,
+		},
+		{
+			name:            "metadata of the entry takes precedence over the record",
+			value:           `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z"}},"RecordedAt":{"web-0":"2020-05-01T00:00:00Z"}}`,
+			expectedEntries: map[string]RecordEntry{"web-0": {Node: "node1", RecordedAt: recordedAt}},
+			expectedValue:   `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+		},
+		{
+			name:          "no records",
+			value:         `{"Ready":true}`,
+			expectedValue: `{"Records":null,"Ready":true}`,
+		},
+		{
+			name:          "entry of another type",
+			value:         `{"Records":{"web-0":1}}`,
+			expectInvalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := decodeScheduleRecord(tt.value, maxRecordSize)
+			if tt.expectInvalid {
+				if !isInvalidRecord(err) {
+					t.Errorf("expected an invalid record error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(record.Records, tt.expectedEntries) {
+				t.Errorf("expected entries %v, got %v", tt.expectedEntries, record.Records)
+			}
+			value, err := json.Marshal(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(value) != tt.expectedValue {
+				t.Errorf("expected %s, got %s", tt.expectedValue, value)
+			}
+
+			// the encoded record decodes to the same record
+			decoded, err := decodeScheduleRecord(string(value), maxRecordSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, record) {
+				t.Errorf("expected %v after a round trip, got %v", record, decoded)
+			}
+		})
+	}
+}
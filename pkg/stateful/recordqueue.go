@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+// maxRecordRequeues bounds the retries of a record write in the record queue, the backoff of
+// the last retries is over a minute.
+const maxRecordRequeues = 15
+
+// recordItem is a record write that failed after the inline retries of PostBind.
+type recordItem struct {
+	namespace   string
+	statefulset string
+	pod         string
+	node        string
+}
+
+// newRecordQueue returns a work queue of the failed record writes, rate limited per item with
+// an exponential backoff.
+func newRecordQueue() workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), Name+"-records")
+}
+
+// requeueRecord queues the record write of the pod of the statefulset on the node, when the
+// record queue is running.
+func (st *Stable) requeueRecord(namespace, statefulset, pod, node string) {
+	if st.recordQueue == nil {
+		return
+	}
+	klog.V(3).Infof("Queuing scheduling result: statefulset %s/%s, pod %s/%s, node %s", namespace, statefulset, namespace, pod, node)
+	st.recordQueue.AddRateLimited(recordItem{namespace: namespace, statefulset: statefulset, pod: pod, node: node})
+}
+
+// runRecordQueue starts the record worker and blocks until stopCh is closed, then shuts down
+// the queue so the worker exits.
+func (st *Stable) runRecordQueue(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer st.recordQueue.ShutDown()
+
+	go wait.Until(st.recordWorker, time.Second, stopCh)
+	<-stopCh
+}
+
+func (st *Stable) recordWorker() {
+	for st.processNextRecordItem() {
+	}
+}
+
+func (st *Stable) processNextRecordItem() bool {
+	obj, quit := st.recordQueue.Get()
+	if quit {
+		return false
+	}
+	defer st.recordQueue.Done(obj)
+
+	item := obj.(recordItem)
+	if err := st.writeQueuedRecord(context.TODO(), item); err != nil {
+		if st.recordQueue.NumRequeues(item) >= maxRecordRequeues {
+			klog.Errorf("Dropping scheduling result after %d retries: statefulset %s/%s, pod %s/%s, node %s: %v",
+				maxRecordRequeues, item.namespace, item.statefulset, item.namespace, item.pod, item.node, err)
+			st.recordQueue.Forget(item)
+			return true
+		}
+		klog.V(3).Infof("Failed to record scheduling result, requeuing: statefulset %s/%s, pod %s/%s, node %s: %v",
+			item.namespace, item.statefulset, item.namespace, item.pod, item.node, err)
+		st.recordQueue.AddRateLimited(item)
+		return true
+	}
+	st.recordQueue.Forget(item)
+	return true
+}
+
+// writeQueuedRecord records the pod of the item on its node. The item is dropped without a
+// record when the pod or the statefulset was deleted or the pod doesn't run on the node anymore.
+func (st *Stable) writeQueuedRecord(ctx context.Context, item recordItem) error {
+	pod, err := st.clientset.CoreV1().Pods(item.namespace).Get(ctx, item.pod, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pod.DeletionTimestamp != nil || pod.Spec.NodeName != item.node {
+		klog.V(4).Infof("Pod %s/%s left node %s, not recording it", item.namespace, item.pod, item.node)
+		return nil
+	}
+	statefulset, err := st.statefulSetLister.StatefulSets(item.namespace).Get(item.statefulset)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if statefulset.DeletionTimestamp != nil {
+		return nil
+	}
+	if err := st.setScheduleRecord(ctx, statefulset, pod, item.node); err != nil {
+		if isInvalidRecord(err) {
+			// retrying won't fix the record, it is reported by PreFilter
+			return nil
+		}
+		return err
+	}
+	st.preempted.remove(pod)
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestRecordQueue(t *testing.T) {
+	tests := []struct {
+		name string
+		// conflicts is the number of record writes that fail with a conflict, the inline retry
+		// of PostBind makes 4 attempts
+		conflicts int
+		// podNode is the node of the pod when the queued record is written
+		podNode  string
+		expected string
+	}{
+		{
+			name:      "recorded by the queue after the inline retry",
+			conflicts: 5,
+			podNode:   "node1",
+			expected:  `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:      "recorded by the first queued retry after a conflict",
+			conflicts: 6,
+			podNode:   "node1",
+			expected:  `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:      "pod moved to another node is not recorded",
+			conflicts: 4,
+			podNode:   "node2",
+			expected:  "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+				},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+				Spec: corev1.PodSpec{NodeName: test.podNode},
+			}
+			clientset := fake.NewSimpleClientset(statefulset, pod)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				recordQueue:       newRecordQueue(),
+			}
+			defer stableSchedule.recordQueue.ShutDown()
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			conflicts := test.conflicts
+			clientset.PrependReactor("patch", "statefulsets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, errors.NewConflict(appsv1.Resource("statefulsets"), "web", fmt.Errorf("the object has been modified"))
+				}
+				return false, nil, nil
+			})
+
+			ctx := context.TODO()
+			stableSchedule.PostBind(ctx, nil, pod, "node1")
+			// the queued item is retried until it is written or dropped
+			item := recordItem{namespace: "n1", statefulset: "web", pod: "web-0", node: "node1"}
+			for stableSchedule.processNextRecordItem() {
+				if stableSchedule.recordQueue.NumRequeues(item) == 0 {
+					break
+				}
+			}
+
+			s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != test.expected {
+				t.Errorf("expected record %q, got %q", test.expected, got)
+			}
+			if got := stableSchedule.recordQueue.Len(); got != 0 {
+				t.Errorf("expected no queued records, got %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// validateRecordSet checks that the records, mapping record keys to nodes, are consistent with
+// the statefulset before they are written. Every entry needs a node, and keys naming a pod of
+// the statefulset, e.g. "web-2", or an ordinal, e.g. "2", when the pods are keyed by ordinal,
+// need an ordinal below the replicas of the statefulset. The ordinals are not checked when the
+// statefulset has no replicas set, and other keys, e.g. the values of an identity annotation,
+// are not checked. The error lists every inconsistent entry.
+func validateRecordSet(records map[string]string, statefulset *appsv1.StatefulSet, ordinalKeys bool) error {
+	replicas, ok := replicasOf(statefulset)
+	if !ok {
+		replicas = -1
+	}
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		if key == "" {
+			errs = append(errs, fmt.Errorf("entry of node %q has an empty key", records[key]))
+			continue
+		}
+		if strings.TrimSpace(records[key]) == "" {
+			errs = append(errs, fmt.Errorf("entry %q has no node", key))
+		}
+		ordinal, ok := recordKeyOrdinal(key, statefulset.Name, ordinalKeys)
+		if !ok {
+			if strings.HasPrefix(key, statefulset.Name+"-") {
+				errs = append(errs, fmt.Errorf("entry %q names a pod of statefulset %s without an ordinal", key, statefulset.Name))
+			}
+			continue
+		}
+		if replicas >= 0 && ordinal >= replicas {
+			errs = append(errs, fmt.Errorf("entry %q has ordinal %d, out of the %d replicas of statefulset %s",
+				key, ordinal, replicas, statefulset.Name))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// replicasOf returns the desired replicas of the statefulset, false when it has no replicas set.
+func replicasOf(statefulset *appsv1.StatefulSet) (int, bool) {
+	if statefulset.Spec.Replicas == nil {
+		return 0, false
+	}
+	return int(*statefulset.Spec.Replicas), true
+}
+
+// isScaledDown checks whether the record key has an ordinal out of the replicas of the
+// statefulset, e.g. "web-3" of a statefulset scaled down to 3 replicas. Keys without an ordinal,
+// see recordKeyOrdinal, and statefulsets without replicas set are never scaled down.
+func isScaledDown(key string, statefulset *appsv1.StatefulSet, ordinalKeys bool) bool {
+	replicas, ok := replicasOf(statefulset)
+	if !ok {
+		return false
+	}
+	ordinal, ok := recordKeyOrdinal(key, statefulset.Name, ordinalKeys)
+	return ok && ordinal >= replicas
+}
+
+// pruneScaledDown removes the entries of the ordinals out of the replicas of the statefulset, so
+// they can't pin the pods to a stale node once the statefulset scales up again. It returns
+// whether any entry was removed.
+func (r *ScheduleRecord) pruneScaledDown(statefulset *appsv1.StatefulSet, ordinalKeys bool) bool {
+	pruned := false
+	for key := range r.Records {
+		if isScaledDown(key, statefulset, ordinalKeys) {
+			r.deleteEntry(key)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// recordKeyOrdinal returns the ordinal of a record key that is a pod name of the statefulset, or
+// an ordinal when the pods are keyed by ordinal, false for other keys. Other numeric keys, e.g.
+// the values of an identity annotation, are not ordinals.
+func recordKeyOrdinal(key, statefulsetName string, ordinalKeys bool) (int, bool) {
+	if trimmed := strings.TrimPrefix(key, statefulsetName+"-"); trimmed != key {
+		key = trimmed
+	} else if !ordinalKeys {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(key)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateRecordSet(t *testing.T) {
+	three := int32(3)
+	tests := []struct {
+		name        string
+		records     map[string]string
+		replicas    *int32
+		ordinalKeys bool
+		expectedErr string
+	}{
+		{
+			name:     "valid pod name keys",
+			records:  map[string]string{"web-0": "node1", "web-2": "node2"},
+			replicas: &three,
+		},
+		{
+			name:        "valid ordinal keys",
+			records:     map[string]string{"0": "node1", "2": "node2"},
+			replicas:    &three,
+			ordinalKeys: true,
+		},
+		{
+			name:     "identity keys",
+			records:  map[string]string{"shard-7": "node1", "eu-west": "node2"},
+			replicas: &three,
+		},
+		{
+			name:     "numeric identity keys above the replicas",
+			records:  map[string]string{"7": "node1", "12": "node2"},
+			replicas: &three,
+		},
+		{
+			name:    "statefulset without replicas",
+			records: map[string]string{"web-0": "node1", "web-9": "node2"},
+		},
+		{
+			name:     "empty records",
+			replicas: &three,
+		},
+		{
+			name:        "ordinal out of range",
+			records:     map[string]string{"web-0": "node1", "web-3": "node2"},
+			replicas:    &three,
+			expectedErr: `entry "web-3" has ordinal 3, out of the 3 replicas of statefulset web`,
+		},
+		{
+			name:        "ordinal key out of range",
+			records:     map[string]string{"5": "node1"},
+			replicas:    &three,
+			ordinalKeys: true,
+			expectedErr: `entry "5" has ordinal 5, out of the 3 replicas of statefulset web`,
+		},
+		{
+			name:        "pod name without ordinal",
+			records:     map[string]string{"web-a": "node1"},
+			replicas:    &three,
+			expectedErr: `entry "web-a" names a pod of statefulset web without an ordinal`,
+		},
+		{
+			name:        "empty node",
+			records:     map[string]string{"web-0": " "},
+			replicas:    &three,
+			expectedErr: `entry "web-0" has no node`,
+		},
+		{
+			name:        "empty key",
+			records:     map[string]string{"": "node1"},
+			replicas:    &three,
+			expectedErr: `entry of node "node1" has an empty key`,
+		},
+		{
+			name:        "several inconsistent entries",
+			records:     map[string]string{"web-0": "", "web-1": "node1", "web-4": "node2"},
+			replicas:    &three,
+			expectedErr: `[entry "web-0" has no node, entry "web-4" has ordinal 4, out of the 3 replicas of statefulset web]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"},
+				Spec:       appsv1.StatefulSetSpec{Replicas: tt.replicas},
+			}
+			err := validateRecordSet(tt.records, statefulset, tt.ordinalKeys)
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestReconcileSkipsInconsistentRecord(t *testing.T) {
+	replicas := int32(2)
+	record := `{"Records":{"web-0":"node1","web-1":"","web-3":"node2"}}`
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record":   record,
+				"statefulset-stable.scheduling.sigs.k8s.io/ordinals": "0-2",
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	// web-3 is pruned, but web-1 has no node, the record is not written
+	ctx := context.TODO()
+	if err := stableSchedule.reconcile(ctx, "n1/web"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != record {
+		t.Errorf("expected the record to be left as is %v, got %v", record, got)
+	}
+}
+
+func TestPruneScaledDown(t *testing.T) {
+	three, five := int32(3), int32(5)
+	tests := []struct {
+		name            string
+		args            StableArgs
+		replicas        *int32
+		records         string
+		pod             string
+		podAnnotations  map[string]string
+		node            string
+		expectedRecords map[string]RecordEntry
+	}{
+		{
+			name:            "scaling down prunes the ordinals out of the replicas",
+			replicas:        &three,
+			records:         `{"Records":{"web-0":"node1","web-1":"node2","web-3":"node3","web-4":"node4"}}`,
+			pod:             "web-0",
+			node:            "node1",
+			expectedRecords: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}},
+		},
+		{
+			name:            "a pod bound while scaling down is not recorded",
+			replicas:        &three,
+			records:         `{"Records":{"web-0":"node1","web-4":"node4"}}`,
+			pod:             "web-3",
+			node:            "node3",
+			expectedRecords: map[string]RecordEntry{"web-0": {Node: "node1"}},
+		},
+		{
+			name:            "scaling up records the new ordinals anew",
+			replicas:        &five,
+			records:         `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+			pod:             "web-3",
+			node:            "node1",
+			expectedRecords: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}, "web-3": {Node: "node1"}},
+		},
+		{
+			name:            "no replicas set prunes nothing",
+			records:         `{"Records":{"web-0":"node1","web-4":"node4"}}`,
+			pod:             "web-0",
+			node:            "node1",
+			expectedRecords: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-4": {Node: "node4"}},
+		},
+		{
+			name:            "ordinal keys out of the replicas are pruned",
+			args:            StableArgs{RecordKey: RecordKeyOrdinal},
+			replicas:        &three,
+			records:         `{"Records":{"0":"node1","4":"node4"}}`,
+			pod:             "web-0",
+			node:            "node1",
+			expectedRecords: map[string]RecordEntry{"0": {Node: "node1"}},
+		},
+		{
+			name:            "numeric identity keys above the replicas are not ordinals",
+			args:            StableArgs{RecordKey: RecordKeyPodName, IdentityAnnotation: "example.com/shard"},
+			replicas:        &three,
+			records:         `{"Records":{"7":"node1"}}`,
+			pod:             "web-0",
+			podAnnotations:  map[string]string{"example.com/shard": "8"},
+			node:            "node2",
+			expectedRecords: map[string]RecordEntry{"7": {Node: "node1"}, "8": {Node: "node2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.records,
+					},
+				},
+				Spec: appsv1.StatefulSetSpec{Replicas: tt.replicas},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			stableSchedule := &Stable{
+				clientset: clientset,
+				store:     newAnnotationStore(clientset),
+				args:      tt.args,
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      tt.pod,
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					Annotations: tt.podAnnotations,
+				},
+			}
+
+			ctx := context.TODO()
+			if err := stableSchedule.setScheduleRecord(ctx, statefulset, pod, tt.node); err != nil {
+				t.Fatal(err)
+			}
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, err := stableSchedule.store.Get(ctx, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(record.Records, tt.expectedRecords) {
+				t.Errorf("expected records %v, got %v", tt.expectedRecords, record.Records)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ErrReasonNotRecordedNode is the reason Filter rejects the nodes other than the recorded node of
+// a pod with.
+const ErrReasonNotRecordedNode = "node(s) didn't match the recorded node of the pod"
+
+// notRecordedNodeCount matches the number of nodes rejected for ErrReasonNotRecordedNode in the
+// message of a failed scheduling cycle.
+var notRecordedNodeCount = regexp.MustCompile(`(\d+) ` + regexp.QuoteMeta(ErrReasonNotRecordedNode))
+
+// unschedulableOnlyByRecord checks whether the last scheduling cycle of the pod failed only
+// because Filter pinned the pod to its recorded node: every node but the recorded node was
+// rejected for ErrReasonNotRecordedNode. The framework of this version has no PostFilter
+// extension point, so the failure is read back from the PodScheduled condition the scheduler
+// sets on the pod, e.g. "0/3 nodes are available: 1 node(s) were unschedulable, 2 node(s)
+// didn't match the recorded node of the pod.". Filter plugins stop at the first rejection of a
+// node, so the other filters must run before the plugin to be counted. The message is the
+// FitError of the 1.18 scheduler, a message of another format is never parsed as relaxable.
+func unschedulableOnlyByRecord(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type != v1.PodScheduled || condition.Status != v1.ConditionFalse || condition.Reason != v1.PodReasonUnschedulable {
+			continue
+		}
+		var total int
+		if _, err := fmt.Sscanf(condition.Message, "0/%d nodes are available:", &total); err != nil || total == 0 {
+			return false
+		}
+		match := notRecordedNodeCount.FindStringSubmatch(condition.Message)
+		if match == nil {
+			return false
+		}
+		rejected, err := strconv.Atoi(match[1])
+		return err == nil && rejected >= total-1
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/core"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func unschedulableCondition(message string) corev1.PodCondition {
+	return corev1.PodCondition{
+		Type:    corev1.PodScheduled,
+		Status:  corev1.ConditionFalse,
+		Reason:  corev1.PodReasonUnschedulable,
+		Message: message,
+	}
+}
+
+func TestUnschedulableOnlyByRecord(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []corev1.PodCondition
+		expected   bool
+	}{
+		{
+			name: "never scheduled",
+		},
+		{
+			name:       "every node rejected by the record",
+			conditions: []corev1.PodCondition{unschedulableCondition("0/3 nodes are available: 3 node(s) didn't match the recorded node of the pod.")},
+			expected:   true,
+		},
+		{
+			name: "recorded node unavailable, the others rejected by the record",
+			conditions: []corev1.PodCondition{unschedulableCondition(
+				"0/3 nodes are available: 1 node(s) were unschedulable, 2 node(s) didn't match the recorded node of the pod.")},
+			expected: true,
+		},
+		{
+			name: "other nodes rejected by other filters",
+			conditions: []corev1.PodCondition{unschedulableCondition(
+				"0/3 nodes are available: 1 Insufficient cpu, 1 node(s) were unschedulable, 1 node(s) didn't match the recorded node of the pod.")},
+		},
+		{
+			name:       "not rejected by the record",
+			conditions: []corev1.PodCondition{unschedulableCondition("0/3 nodes are available: 3 Insufficient cpu.")},
+		},
+		{
+			name: "scheduled",
+			conditions: []corev1.PodCondition{{
+				Type:   corev1.PodScheduled,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Status: corev1.PodStatus{Conditions: tt.conditions}}
+			if got := unschedulableOnlyByRecord(pod); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestUnschedulableOnlyByFitError checks the condition messages the scheduler builds from its
+// FitError, so a change of their format fails here rather than in a cluster.
+func TestUnschedulableOnlyByFitError(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses framework.NodeToStatusMap
+		expected bool
+	}{
+		{
+			name: "recorded node unschedulable, the others rejected by the record",
+			statuses: framework.NodeToStatusMap{
+				"node1": framework.NewStatus(framework.UnschedulableAndUnresolvable, "node(s) were unschedulable"),
+				"node2": framework.NewStatus(framework.Unschedulable, ErrReasonNotRecordedNode),
+				"node3": framework.NewStatus(framework.Unschedulable, ErrReasonNotRecordedNode),
+			},
+			expected: true,
+		},
+		{
+			name: "another node rejected by another filter",
+			statuses: framework.NodeToStatusMap{
+				"node1": framework.NewStatus(framework.UnschedulableAndUnresolvable, "node(s) were unschedulable"),
+				"node2": framework.NewStatus(framework.Unschedulable, ErrReasonNotRecordedNode),
+				"node3": framework.NewStatus(framework.Unschedulable, "Insufficient cpu"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			fitErr := &core.FitError{Pod: pod, NumAllNodes: len(tt.statuses), FilteredNodesStatuses: tt.statuses}
+			pod.Status.Conditions = []corev1.PodCondition{unschedulableCondition(fitErr.Error())}
+			if got := unschedulableOnlyByRecord(pod); got != tt.expected {
+				t.Errorf("expected %v for %q, got %v", tt.expected, fitErr.Error(), got)
+			}
+		})
+	}
+}
+
+func TestRelaxWhenUnschedulable(t *testing.T) {
+	tests := []struct {
+		name           string
+		relax          bool
+		conditions     []corev1.PodCondition
+		expectedCode   framework.Code
+		expectedRecord string
+	}{
+		{
+			name:  "relaxed after failing only because of the record",
+			relax: true,
+			conditions: []corev1.PodCondition{unschedulableCondition(
+				"0/2 nodes are available: 1 node(s) were unschedulable, 1 node(s) didn't match the recorded node of the pod.")},
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{}}`,
+		},
+		{
+			name:  "not relaxed after failing for other reasons",
+			relax: true,
+			conditions: []corev1.PodCondition{unschedulableCondition(
+				"0/2 nodes are available: 1 node(s) were unschedulable, 1 Insufficient cpu.")},
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name: "not relaxed without relaxWhenUnschedulable",
+			conditions: []corev1.PodCondition{unschedulableCondition(
+				"0/2 nodes are available: 1 node(s) were unschedulable, 1 node(s) didn't match the recorded node of the pod.")},
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RelaxWhenUnschedulable: tt.relax},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+				Status: corev1.PodStatus{Conditions: tt.conditions},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+			status := stableSchedule.Filter(ctx, state, pod, nodeInfo)
+			if status.Code() != tt.expectedCode {
+				t.Errorf("expected code %v, got %v", tt.expectedCode, status.Code())
+			}
+			if status.Code() == framework.Unschedulable && status.Message() != ErrReasonNotRecordedNode {
+				t.Errorf("expected reason %q, got %q", ErrReasonNotRecordedNode, status.Message())
+			}
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+var _ framework.ReservePlugin = &Stable{}
+var _ framework.UnreservePlugin = &Stable{}
+
+// reservation is the node a stable pod is assumed on between Reserve and PostBind, before its
+// record is written.
+type reservation struct {
+	namespace string
+	node      string
+	// group and slot are the stability group and ordinal the pod assigns its node to, empty when
+	// the pod assigns no ordinal of a group.
+	group, slot string
+}
+
+// reservations tracks the reservations of the pods being bound by their UID.
+type reservations struct {
+	lock  sync.RWMutex
+	byUID map[types.UID]reservation
+}
+
+func (r *reservations) reserve(uid types.UID, res reservation) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.byUID == nil {
+		r.byUID = make(map[types.UID]reservation)
+	}
+	r.byUID[uid] = res
+}
+
+// release removes the reservation of the pod, it returns whether the pod had one.
+func (r *reservations) release(uid types.UID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.byUID[uid]
+	delete(r.byUID, uid)
+	return ok
+}
+
+// groupNodes returns the nodes reserved for the ordinals of the group in the namespace by the
+// pods other than the given one, by ordinal.
+func (r *reservations) groupNodes(namespace, group string, except types.UID) map[string]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var nodes map[string]string
+	for uid, res := range r.byUID {
+		if uid == except || res.group == "" || res.namespace != namespace || res.group != group {
+			continue
+		}
+		if nodes == nil {
+			nodes = make(map[string]string)
+		}
+		nodes[res.slot] = res.node
+	}
+	return nodes
+}
+
+// Reserve keeps the node of a stable pod in memory until PostBind promotes it to the record or
+// Unreserve releases it. The pods of a stability group scheduled meanwhile treat the node of an
+// ordinal without a node yet as assigned, so two pods of the group binding concurrently can't
+// split the ordinal across nodes or share a node across ordinals.
+func (st *Stable) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	if st.idle() || !st.eligible(pod) {
+		return framework.NewStatus(framework.Success, "")
+	}
+	s := st.getPreFilterState(ctx, state, pod)
+	if s.statefulset == nil {
+		return framework.NewStatus(framework.Success, "")
+	}
+	res := reservation{namespace: pod.Namespace, node: nodeName}
+	if s.group != nil && (s.group.node == "" || s.group.reserved) {
+		if ordinal, ok := parseOrdinal(pod.GetName()); ok {
+			res.group, res.slot = s.group.name, strconv.Itoa(ordinal)
+		}
+	}
+	klog.V(5).Infof("Reserving node %s for pod %s/%s", nodeName, pod.Namespace, pod.Name)
+	st.reservations.reserve(pod.UID, res)
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve releases the reservation of a pod that failed to bind, nothing was persisted for it.
+func (st *Stable) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	if st.reservations.release(pod.UID) {
+		klog.V(4).Infof("Released the reservation of node %s for pod %s/%s", nodeName, pod.Namespace, pod.Name)
+	}
+}
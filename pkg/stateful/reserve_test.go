@@ -0,0 +1,240 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestReserveGroup(t *testing.T) {
+	created := time.Now()
+	statefulsets := []*appsv1.StatefulSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "shard-a",
+				Namespace:         "n1",
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations:       map[string]string{StatefulsetStableGroup: "shards"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "shard-b",
+				Namespace:         "n1",
+				CreationTimestamp: metav1.NewTime(created.Add(time.Minute)),
+				Annotations:       map[string]string{StatefulsetStableGroup: "shards"},
+			},
+		},
+	}
+	newPod := func(owner, name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				UID:       types.UID(name + "-uid"),
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: owner,
+					},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		// reserved is reserved on node1 before shard-b-0 is filtered
+		reserved *corev1.Pod
+		// unreserve releases the reservation before shard-b-0 is filtered
+		unreserve     bool
+		expectedCodes map[string]framework.Code
+	}{
+		{
+			name:          "no reservation",
+			expectedCodes: map[string]framework.Code{"node1": framework.Success, "node2": framework.Success},
+		},
+		{
+			name:          "the ordinal reserved by another statefulset of the group is pinned to its node",
+			reserved:      newPod("shard-a", "shard-a-0"),
+			expectedCodes: map[string]framework.Code{"node1": framework.Success, "node2": framework.Unschedulable},
+		},
+		{
+			name:          "the node reserved by another ordinal of the group is avoided",
+			reserved:      newPod("shard-a", "shard-a-1"),
+			expectedCodes: map[string]framework.Code{"node1": framework.Unschedulable, "node2": framework.Success},
+		},
+		{
+			name:          "an unreserved ordinal doesn't pin",
+			reserved:      newPod("shard-a", "shard-a-0"),
+			unreserve:     true,
+			expectedCodes: map[string]framework.Code{"node1": framework.Success, "node2": framework.Success},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(statefulsets[0], statefulsets[1])
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+			}
+			for _, statefulset := range statefulsets {
+				if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx := context.TODO()
+			if tt.reserved != nil {
+				state := framework.NewCycleState()
+				stableSchedule.PreFilter(ctx, state, tt.reserved)
+				if status := stableSchedule.Reserve(ctx, state, tt.reserved, "node1"); !status.IsSuccess() {
+					t.Fatalf("unexpected Reserve status %v", status.Code())
+				}
+				if tt.unreserve {
+					stableSchedule.Unreserve(ctx, state, tt.reserved, "node1")
+				}
+			}
+
+			pod := newPod("shard-b", "shard-b-0")
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			for nodeName, expected := range tt.expectedCodes {
+				nodeInfo := schedulernodeinfo.NewNodeInfo()
+				if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+					t.Fatal(err)
+				}
+				if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != expected {
+					t.Errorf("expected %v on %s, got %v", expected, nodeName, code)
+				}
+			}
+		})
+	}
+}
+
+// newReserveTestPlugin returns the plugin for the web statefulset, in no stability group, and its
+// pod web-0.
+func newReserveTestPlugin(t *testing.T) (*Stable, *fake.Clientset, *corev1.Pod) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			UID:       "web-0-uid",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	return stableSchedule, clientset, pod
+}
+
+func TestUnreserveWritesNoRecord(t *testing.T) {
+	stableSchedule, clientset, pod := newReserveTestPlugin(t)
+
+	// the binding fails after Reserve, the framework calls Unreserve instead of PostBind
+	ctx := context.TODO()
+	state := framework.NewCycleState()
+	stableSchedule.PreFilter(ctx, state, pod)
+	if status := stableSchedule.Reserve(ctx, state, pod, "node1"); !status.IsSuccess() {
+		t.Fatalf("unexpected Reserve status %v", status.Code())
+	}
+	if res, ok := stableSchedule.reservations.byUID[pod.UID]; !ok || res.node != "node1" || res.group != "" {
+		t.Errorf("expected pod %s to reserve node1 in no group, got %+v", pod.Name, stableSchedule.reservations.byUID)
+	}
+	stableSchedule.Unreserve(ctx, state, pod, "node1")
+
+	if len(stableSchedule.reservations.byUID) != 0 {
+		t.Errorf("expected no reservation left, got %v", stableSchedule.reservations.byUID)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" && action.GetVerb() != "watch" {
+			t.Errorf("expected no write, got %v", action)
+		}
+	}
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; ok {
+		t.Errorf("expected no record, got %v", record)
+	}
+}
+
+func TestPostBindPromotesReservation(t *testing.T) {
+	stableSchedule, clientset, pod := newReserveTestPlugin(t)
+
+	ctx := context.TODO()
+	state := framework.NewCycleState()
+	stableSchedule.PreFilter(ctx, state, pod)
+	if status := stableSchedule.Reserve(ctx, state, pod, "node2"); !status.IsSuccess() {
+		t.Fatalf("unexpected Reserve status %v", status.Code())
+	}
+	if res, ok := stableSchedule.reservations.byUID[pod.UID]; !ok || res.node != "node2" {
+		t.Errorf("expected pod %s to reserve node2, got %+v", pod.Name, stableSchedule.reservations.byUID)
+	}
+	stableSchedule.PostBind(ctx, state, pod, "node2")
+
+	if len(stableSchedule.reservations.byUID) != 0 {
+		t.Errorf("expected the reservation to be released by PostBind, got %v", stableSchedule.reservations.byUID)
+	}
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := decodeScheduleRecord(s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"], maxRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node := record.Records[stableSchedule.keyOf(pod)].Node; node != "node2" {
+		t.Errorf("expected the reservation promoted to a record on node2, got %q", node)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// startScheduler returns a new plugin instance with empty memory whose caches are filled from
+// the API server, like a restarted scheduler.
+func startScheduler(t *testing.T, clientset kubernetes.Interface, args StableArgs, schedulerClock clock.Clock) *Stable {
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	podInformer := informers.Core().V1().Pods()
+	statefulsets, err := clientset.AppsV1().StatefulSets("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range statefulsets.Items {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(&statefulsets.Items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pods, err := clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range pods.Items {
+		if err := podInformer.Informer().GetIndexer().Add(&pods.Items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		podLister:         podInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              args,
+		clock:             schedulerClock,
+	}
+}
+
+func newRestartPod(nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			UID:       "web-0-uid",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func filterCode(t *testing.T, st *Stable, pod *corev1.Pod, nodeName string) framework.Code {
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+		t.Fatal(err)
+	}
+	return st.Filter(context.TODO(), nil, pod, nodeInfo).Code()
+}
+
+func TestRestartKeepsRecords(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+
+	before := startScheduler(t, clientset, StableArgs{}, clock.RealClock{})
+	before.PostBind(context.TODO(), nil, newRestartPod(""), "node1")
+
+	after := startScheduler(t, clientset, StableArgs{}, clock.RealClock{})
+	if code := filterCode(t, after, newRestartPod(""), "node2"); code != framework.Unschedulable {
+		t.Errorf("expected the record to be enforced after the restart, got %v", code)
+	}
+	if code := filterCode(t, after, newRestartPod(""), "node1"); code != framework.Success {
+		t.Errorf("expected the recorded node to be admitted after the restart, got %v", code)
+	}
+}
+
+func TestRestartEnforceAfterReady(t *testing.T) {
+	replicas := int32(1)
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			UID:       "web-uid",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+		Spec:   appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status: appsv1.StatefulSetStatus{ReadyReplicas: 1},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	args := StableArgs{EnforceAfterReady: true}
+
+	before := startScheduler(t, clientset, args, clock.RealClock{})
+	before.readinessEventHandler().OnAdd(statefulset)
+
+	// the pod is evicted while the scheduler restarts, the statefulset is unready
+	ctx := context.TODO()
+	unready, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unready.Status.ReadyReplicas = 0
+	if _, err := clientset.AppsV1().StatefulSets("n1").Update(ctx, unready, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	after := startScheduler(t, clientset, args, clock.RealClock{})
+	if code := filterCode(t, after, newRestartPod(""), "node2"); code != framework.Unschedulable {
+		t.Errorf("expected the record to be enforced after the restart, got %v", code)
+	}
+}
+
+func TestRestartPendingRecords(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	clientset := fake.NewSimpleClientset(statefulset, newRestartPod("node1"))
+	args := StableArgs{ObservationPeriodSeconds: 60}
+	fakeClock := clock.NewFakeClock(time.Now())
+
+	before := startScheduler(t, clientset, args, fakeClock)
+	ctx := context.TODO()
+	before.PostBind(ctx, nil, newRestartPod(""), "node1")
+
+	// the scheduler restarts within the observation period, the pod informer lists the pod
+	fakeClock.Step(30 * time.Second)
+	after := startScheduler(t, clientset, args, fakeClock)
+	after.pendingRecordsEventHandler().OnAdd(newRestartPod("node1"))
+	fakeClock.Step(60 * time.Second)
+	after.confirmPendingRecords(ctx)
+
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v after the observation period, got %v", expected, got)
+	}
+}
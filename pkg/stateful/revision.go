@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// podRevision returns the controller revision of the statefulset the pod was created from,
+// empty if the pod has no controller-revision-hash label.
+func podRevision(pod *v1.Pod) string {
+	return pod.GetLabels()[appsv1.ControllerRevisionHashLabelKey]
+}
+
+// isOtherRevision checks whether the key was recorded with another controller revision than
+// the given one. Keys recorded without a revision and pods without a revision match any.
+func (r *ScheduleRecord) isOtherRevision(key, revision string) bool {
+	recorded := r.Records[key].Revision
+	return recorded != "" && revision != "" && recorded != revision
+}
+
+// pruneOldRevisions removes the entries recorded with a controller revision that is neither
+// the current nor the update revision of the statefulset, it returns whether any was removed.
+// Nothing is removed while the statefulset reports no revision.
+func (r *ScheduleRecord) pruneOldRevisions(statefulset *appsv1.StatefulSet) bool {
+	current, update := statefulset.Status.CurrentRevision, statefulset.Status.UpdateRevision
+	if current == "" && update == "" {
+		return false
+	}
+	pruned := false
+	for key, entry := range r.Records {
+		if entry.Revision != "" && entry.Revision != current && entry.Revision != update {
+			r.deleteEntry(key)
+			pruned = true
+		}
+	}
+	return pruned
+}
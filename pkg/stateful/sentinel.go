@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// sentinelPaused in the sentinel ConfigMap pauses the plugin, records are neither enforced nor written.
+	sentinelPaused = "paused"
+	// sentinelPauseNewPins in the sentinel ConfigMap stops writing new records, existing records are still enforced.
+	sentinelPauseNewPins = "pauseNewPins"
+)
+
+// sentinelFlag reads a boolean flag of the sentinel ConfigMap from the informer cache.
+// It is false when no sentinel is configured, the ConfigMap doesn't exist or the value is invalid.
+func (st *Stable) sentinelFlag(key string) bool {
+	if st.configMapLister == nil || st.sentinelName == "" {
+		return false
+	}
+	configMap, err := st.configMapLister.ConfigMaps(st.sentinelNamespace).Get(st.sentinelName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.V(4).Infof("Failed to get sentinel ConfigMap %s/%s: %v", st.sentinelNamespace, st.sentinelName, err)
+		}
+		return false
+	}
+	value, ok := configMap.Data[key]
+	if !ok {
+		return false
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		klog.V(3).Infof("Ignoring %s of sentinel ConfigMap %s/%s: %v", key, st.sentinelNamespace, st.sentinelName, err)
+		return false
+	}
+	return flag
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestSentinel(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           map[string]string
+		expectedCode   framework.Code
+		expectedRecord string
+	}{
+		{
+			name:           "no sentinel ConfigMap",
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+		},
+		{
+			name:           "flags disabled",
+			data:           map[string]string{"paused": "false", "pauseNewPins": "false"},
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+		},
+		{
+			name:           "new pins paused, existing records are enforced",
+			data:           map[string]string{"pauseNewPins": "true"},
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:           "paused",
+			data:           map[string]string{"paused": "true"},
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:           "invalid flag is ignored",
+			data:           map[string]string{"paused": "yes please"},
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1","web-1":"node2"}}`,
+		},
+	}
+
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			configMapInformer := informers.Core().V1().ConfigMaps()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				configMapLister:   configMapInformer.Lister(),
+				sentinelNamespace: "kube-system",
+				sentinelName:      "statefulset-stable",
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			if tt.data != nil {
+				configMap := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "statefulset-stable", Namespace: "kube-system"},
+					Data:       tt.data,
+				}
+				if err := configMapInformer.Informer().GetIndexer().Add(configMap); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx := context.TODO()
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, newPod("web-0"), nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+
+			stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
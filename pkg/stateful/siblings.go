@@ -0,0 +1,54 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+const (
+	// SiblingPlacementSpread prefers nodes hosting fewer recorded siblings of the pod.
+	SiblingPlacementSpread = "Spread"
+	// SiblingPlacementCluster prefers nodes hosting more recorded siblings of the pod, for apps
+	// whose replicas perform better close to each other.
+	SiblingPlacementCluster = "Cluster"
+)
+
+// siblingScore scores the node for a pod without a recorded node by the share of the recorded
+// siblings of the pod on it, in the SiblingPlacement mode.
+func (st *Stable) siblingScore(record *ScheduleRecord, key, nodeName string) int64 {
+	var siblings, onNode int64
+	if record != nil {
+		for k, recorded := range record.nodes() {
+			if k == key {
+				continue
+			}
+			siblings++
+			if st.matchesNode(recorded, nodeName) {
+				onNode++
+			}
+		}
+	}
+	if siblings == 0 {
+		return 0
+	}
+	score := framework.MaxNodeScore * onNode / siblings
+	if st.args.SiblingPlacement == SiblingPlacementSpread {
+		return framework.MaxNodeScore - score
+	}
+	return score
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func TestSiblingScore(t *testing.T) {
+	record := &ScheduleRecord{Records: map[string]RecordEntry{
+		"web-0": {Node: "node1"},
+		"web-1": {Node: "node1"},
+		"web-2": {Node: "node1"},
+		"web-3": {Node: "node2"},
+	}}
+	tests := []struct {
+		name      string
+		placement string
+		key       string
+		expected  map[string]int64
+	}{
+		{
+			name:      "cluster prefers the node with more siblings",
+			placement: SiblingPlacementCluster,
+			key:       "web-4",
+			expected:  map[string]int64{"node1": 75, "node2": 25, "node3": 0},
+		},
+		{
+			name:      "spread prefers the node with fewer siblings",
+			placement: SiblingPlacementSpread,
+			key:       "web-4",
+			expected:  map[string]int64{"node1": 25, "node2": 75, "node3": framework.MaxNodeScore},
+		},
+		{
+			name:      "the entry of the pod is not a sibling",
+			placement: SiblingPlacementCluster,
+			key:       "web-3",
+			expected:  map[string]int64{"node1": framework.MaxNodeScore, "node2": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stableSchedule := &Stable{args: StableArgs{SiblingPlacement: tt.placement}}
+			for nodeName, expected := range tt.expected {
+				if got := stableSchedule.siblingScore(record, tt.key, nodeName); got != expected {
+					t.Errorf("%s: expected %v, got %v", nodeName, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestScoreSiblingPlacement(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "n1"}}
+	state := framework.NewCycleState()
+	state.Write(preFilterStateKey, &preFilterState{
+		key:     "web-2",
+		enforce: true,
+		record:  &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node1"}}},
+	})
+	stableSchedule := &Stable{args: StableArgs{SiblingPlacement: SiblingPlacementCluster}}
+
+	ctx := context.TODO()
+	more, status := stableSchedule.Score(ctx, state, pod, "node1")
+	if !status.IsSuccess() {
+		t.Fatal(status.Message())
+	}
+	fewer, status := stableSchedule.Score(ctx, state, pod, "node2")
+	if !status.IsSuccess() {
+		t.Fatal(status.Message())
+	}
+	if more <= fewer {
+		t.Errorf("expected the node with more siblings to score higher, got %v and %v", more, fewer)
+	}
+}
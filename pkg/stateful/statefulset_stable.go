@@ -19,19 +19,37 @@ package stateful
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	statefulsetlisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
 	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
 	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
 )
 
+var _ framework.PreFilterPlugin = &Stable{}
+var _ framework.ScorePlugin = &Stable{}
 var _ framework.FilterPlugin = &Stable{}
 var _ framework.PostBindPlugin = &Stable{}
 
@@ -41,16 +59,140 @@ const (
 	Kind                    = "StatefulSet"
 	StatefulsetStableRecord = "statefulset-stable.scheduling.sigs.k8s.io/record"
 	StatefulsetStable       = "statefulset-stable.scheduling.sigs.k8s.io"
+	// StatefulsetStableEnabled is the statefulset annotation opting all its pods in to stable
+	// scheduling without the opt-in label on the pod template. Its value follows OptInLabelValue.
+	StatefulsetStableEnabled = "statefulset-stable.scheduling.sigs.k8s.io/enabled"
+	// StatefulsetStableOrdinals is the statefulset annotation limiting stable scheduling
+	// to the listed pod ordinals, e.g. "0-2,5". It overrides StableArgs.StickyOrdinals.
+	// An empty or invalid value is ignored in favor of StableArgs.StickyOrdinals.
+	StatefulsetStableOrdinals = "statefulset-stable.scheduling.sigs.k8s.io/ordinals"
+
+	preFilterStateKey = "PreFilter" + Name
 )
 
 // Stable is a plugin that implements statefulset stable schedule
 type Stable struct {
 	statefulSetLister statefulsetlisters.StatefulSetLister
+	pvcLister         corelisters.PersistentVolumeClaimLister
+	pvLister          corelisters.PersistentVolumeLister
+	nodeLister        corelisters.NodeLister
 	clientset         clientset.Interface
+	store             RecordStore
+	args              StableArgs
+	// policyLock guards the policy fields, which are reloaded from args.ConfigFile.
+	policyLock sync.RWMutex
+	// policyContent is the content of the policy file that was applied last.
+	policyContent []byte
+	// stickyOrdinals are the global sticky ordinal ranges, nil means all ordinals are sticky.
+	stickyOrdinals ordinalRanges
+	// nodeReadinessSelector must match a recorded node before pods are pinned to it, nil means no check.
+	nodeReadinessSelector labels.Selector
+	// reconcileQueue holds the keys of statefulsets whose records are reconciled, nil when disabled.
+	reconcileQueue workqueue.RateLimitingInterface
+	// recordQueue holds the record writes that failed in PostBind, nil when they are dropped.
+	recordQueue workqueue.RateLimitingInterface
+	// recordKey overrides the key of the pod in the schedule record, keyOf follows args.RecordKey when nil.
+	recordKey func(pod *v1.Pod) string
+	// configMapLister caches the sentinel ConfigMap sentinelNamespace/sentinelName and the other configured
+	// ConfigMaps, nil when none is configured.
+	configMapLister   corelisters.ConfigMapLister
+	sentinelNamespace string
+	sentinelName      string
+	// podLister is used to confirm pending records, nil when the first records are not delayed.
+	podLister corelisters.PodLister
+	// replicaSetLister resolves the ReplicaSets of pods to their Deployment, nil without
+	// OwnerKindDeployment.
+	replicaSetLister statefulsetlisters.ReplicaSetLister
+	// pendingRecords holds the pods waiting for the observation period before their first record.
+	pendingRecords pendingRecords
+	clock          clock.Clock
+	// allowList constrains the nodes pods may be pinned to, nil allows all nodes.
+	allowList NodeAllowList
+	// eventRecorder emits the events of the plugin, nil when no events are emitted.
+	eventRecorder record.EventRecorder
+	// stuckPodUIDs are the UIDs of the pods found stuck by the last check, only used by checkStuckPods.
+	stuckPodUIDs sets.String
+	// auditSink receives the decisions of the plugin.
+	auditSink AuditSink
+	// cordoned tracks the cordoned nodes for ClearCordonedNodeRecords.
+	cordoned cordonedNodes
+	// readyStatefulSets caches the UIDs of the statefulsets that have been ready, used by EnforceAfterReady.
+	// The Ready flag of the record survives a restart of the scheduler.
+	readyStatefulSets sync.Map
+	// frozen tracks the frozen statefulsets for the frozen statefulsets gauge.
+	frozen frozenStatefulSets
+	// tracked tracks the statefulsets with record entries for the tracked statefulsets gauge.
+	tracked trackedStatefulSets
+	// recordEntries counts the record entries of the statefulsets for MaxTotalRecordEntries.
+	recordEntries recordEntryCounts
+	// recordEntriesExceeded wakes up the eviction of the entries beyond MaxTotalRecordEntries,
+	// nil without eviction.
+	recordEntriesExceeded chan struct{}
+	// recordsSynced reports whether the caches the records are read from have synced, pods are
+	// not scheduled before.
+	recordsSynced []cache.InformerSynced
+	// preempted tracks the pods deleted by preemption for PreemptedPodMove.
+	preempted preemptedPods
+	// spreadViolated tracks the statefulsets violating their topology spread constraints.
+	spreadViolated spreadViolations
+	// metricLabels caches whether the metrics are labeled by statefulset with MetricLabelsAuto.
+	metricLabels metricLabelsChoice
+	// tracer starts the spans of the plugin, nil when no spans are started.
+	tracer Tracer
+	// placementNotifier sends the placement changes to the placement webhook, nil when disabled.
+	placementNotifier *placementNotifier
+	// optIns tracks whether any statefulset opts in for SkipWithoutOptIns, nil when disabled.
+	optIns *optInCircuit
+	// pinEvents rate limits the events of PinEvents.
+	pinEvents pinEvents
+	// reservations tracks the nodes of the pods between Reserve and PostBind.
+	reservations reservations
+	// allowPod allows a pod waiting in Permit and returns whether the pod was waiting, nil when
+	// no pods wait.
+	allowPod func(uid types.UID) bool
 }
 
-type ScheduleRecord struct {
-	Records map[string]string
+// keyOf returns the key of the pod in the schedule record. Pods of the OwnerKinds are keyed by
+// their OwnerIdentityLabel. Otherwise the value of the IdentityAnnotation takes precedence,
+// then with RecordKeyOrdinal the key is the ordinal of the pod, pods whose name has no ordinal
+// suffix fall back to the pod name. The key of a scheduling cycle is cached by PreFilter.
+func (st *Stable) keyOf(pod *v1.Pod) string {
+	if st.recordKey != nil {
+		return st.recordKey(pod)
+	}
+	return recordKeyOf(pod, st.args)
+}
+
+// recordKeyOf returns the key of the pod in the schedule record following the args.
+func recordKeyOf(pod *v1.Pod, args StableArgs) string {
+	key, _ := recordKeyFallback(pod, args)
+	return key
+}
+
+// recordKeyFallback returns the key of the pod in the schedule record following the args, and
+// what the pod lacks when the key fell back to its ordinal or name, empty otherwise.
+func recordKeyFallback(pod *v1.Pod, args StableArgs) (string, string) {
+	if args.OwnerIdentityLabel != "" {
+		if _, ok := ownerOfKind(pod, args.OwnerKinds); ok {
+			if identity := pod.GetLabels()[args.OwnerIdentityLabel]; identity != "" {
+				return identity, ""
+			}
+		}
+	}
+	var missing []string
+	if args.IdentityAnnotation != "" {
+		if identity := pod.GetAnnotations()[args.IdentityAnnotation]; identity != "" {
+			return identity, ""
+		}
+		missing = append(missing, args.IdentityAnnotation+" annotation")
+	}
+	if args.RecordKey == RecordKeyOrdinal {
+		if ordinal, ok := parseOrdinal(pod.GetName()); ok {
+			return strconv.Itoa(ordinal), strings.Join(missing, " and ")
+		}
+		missing = append(missing, "ordinal")
+	}
+	return pod.GetName(), strings.Join(missing, " and ")
 }
 
 // Name returns name of the plugin.
@@ -58,102 +200,844 @@ func (st *Stable) Name() string {
 	return Name
 }
 
+// preFilterState computed at PreFilter and used at Filter.
+type preFilterState struct {
+	// statefulset is the owner of the pod, nil if the pod is not stable scheduled.
+	statefulset *appsv1.StatefulSet
+	// key is the key of the pod in the schedule record.
+	key       string
+	record    *ScheduleRecord
+	recordErr error
+	// enforce is whether the record of the pod is enforced.
+	enforce bool
+	// group is the stability group of the pod, nil if its statefulset is in no group.
+	group *groupState
+	// fallbackLabels is the label snapshot of the recorded node of the pod when the recorded
+	// node is unavailable and a fallback is configured, nil otherwise.
+	fallbackLabels map[string]string
+	// advisory is whether the record of the pod is from an older generation of its statefulset,
+	// the recorded node is then preferred but not required.
+	advisory bool
+	// recordedNodeDeleted is set when the recorded node of the pod is confirmed deleted, there
+	// is no fallback and PinToDeletedNodes is set, the pod can't be scheduled without operator
+	// intervention.
+	recordedNodeDeleted bool
+	// relaxedNode is the recorded node of a pod relaxed by RelaxWhenUnschedulable.
+	relaxedNode string
+	// perStatefulSetMetrics is whether the metrics of Filter are labeled with the statefulset,
+	// decided once per cycle.
+	perStatefulSetMetrics bool
+	// imageNodes are the nodes that ran the primary image of a pod without a recorded node or
+	// with an advisory record, nil when ImageLocality is not set.
+	imageNodes sets.String
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+// recorded checks whether the record has an entry for the key.
+func (s *preFilterState) recorded(key string) bool {
+	if s.record == nil {
+		return false
+	}
+	_, ok := s.record.Records[key]
+	return ok
+}
+
 // New initializes a new plugin and returns it.
-func New(_ *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
-	statefulsetLister := handle.SharedInformerFactory().Apps().V1().StatefulSets().Lister()
+func New(obj *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	return newPlugin(obj, handle)
+}
+
+// NewWithTracer returns the factory of the plugin starting its spans with the tracer, for
+// scheduler commands registering the plugin with a tracer of their own, e.g. backed by
+// OpenTelemetry.
+func NewWithTracer(tracer Tracer) framework.PluginFactory {
+	return func(obj *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+		return newPlugin(obj, handle, WithTracer(tracer))
+	}
+}
+
+// newPlugin initializes the plugin from its args and the framework, the options are applied
+// last.
+func newPlugin(obj *runtime.Unknown, handle framework.FrameworkHandle, extra ...Option) (framework.Plugin, error) {
+	args, err := getStableArgs(obj)
+	if err != nil {
+		return nil, err
+	}
+	// the args hold no secrets, log them in full to show the defaults that were applied
+	if argsJSON, err := json.Marshal(args); err == nil {
+		klog.V(2).Infof("Creating %s plugin with args %s", Name, argsJSON)
+	}
+	auditSink, err := auditSinkFor(args.AuditLog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s audit log: %v", Name, err)
+	}
 	clientset := handle.ClientSet()
-	return &Stable{
-		statefulSetLister: statefulsetLister,
-		clientset:         clientset,
-	}, nil
+	opts := []Option{
+		WithArgs(args),
+		WithClientSet(clientset),
+		WithStatefulSetLister(handle.SharedInformerFactory().Apps().V1().StatefulSets().Lister()),
+		WithPVCLister(handle.SharedInformerFactory().Core().V1().PersistentVolumeClaims().Lister()),
+		WithNodeLister(handle.SharedInformerFactory().Core().V1().Nodes().Lister()),
+		WithAuditSink(auditSink),
+	}
+	if args.SentinelConfigMap != "" || args.NodeAllowListConfigMap != "" || args.ClusterRecordConfigMap != "" || args.StoreType == StoreTypeConfigMap ||
+		hasStoreType(args.SecondaryStores, StoreTypeConfigMap) {
+		// only watch ConfigMaps when one is configured
+		opts = append(opts, WithConfigMapLister(handle.SharedInformerFactory().Core().V1().ConfigMaps().Lister()))
+	}
+	opts = append(opts, WithRecordsSynced(recordsSyncedFor(args, handle.SharedInformerFactory())...))
+	if args.ObservationPeriodSeconds > 0 || args.RecordAfterPodCondition != "" || args.StuckPendingSeconds > 0 || args.ClearCordonedNodeRecords ||
+		args.PreemptedPodPolicy == PreemptedPodMove || args.OrderedPlacementTimeoutSeconds > 0 {
+		opts = append(opts, WithPodLister(handle.SharedInformerFactory().Core().V1().Pods().Lister()))
+	}
+	if args.EnforceAfterVolumeBound {
+		opts = append(opts, WithPVLister(handle.SharedInformerFactory().Core().V1().PersistentVolumes().Lister()))
+	}
+	if hasOwnerKind(args.OwnerKinds, OwnerKindDeployment) {
+		opts = append(opts, WithReplicaSetLister(handle.SharedInformerFactory().Apps().V1().ReplicaSets().Lister()))
+	}
+	st, err := NewWithOptions(append(opts, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if args.ConfigFile != "" {
+		if err := st.reloadPolicy(); err != nil {
+			return nil, err
+		}
+		go wait.Until(func() {
+			if err := st.reloadPolicy(); err != nil {
+				klog.Errorf("Keeping the current policy: %v", err)
+			}
+		}, policyReloadInterval, wait.NeverStop)
+	}
+	if st.delaysRecords() {
+		// the framework doesn't stop plugins, the loop runs as long as the scheduler
+		go st.runPendingRecords(context.Background())
+	}
+	// invalid records are reported with events unless StrictRecordParsing fails their pods instead
+	if args.StuckPendingSeconds > 0 || args.ClearCordonedNodeRecords || args.PinEvents || !args.StrictRecordParsing {
+		st.eventRecorder = newEventRecorder(clientset.CoreV1())
+	}
+	if args.StuckPendingSeconds > 0 {
+		go wait.Until(func() { st.checkStuckPods(context.TODO()) }, stuckPodsCheckInterval, wait.NeverStop)
+	}
+	if args.ClearCordonedNodeRecords {
+		go wait.Until(func() { st.clearCordonedNodeRecords(context.TODO()) }, cordonedNodesCheckInterval, wait.NeverStop)
+	}
+	if args.PinsExportConfigMap != "" {
+		go wait.Until(func() { st.exportPins(context.TODO()) }, pinsExportInterval, wait.NeverStop)
+	}
+	if args.DebugListenAddress != "" {
+		go st.serveDebug(args.DebugListenAddress)
+	}
+	if args.SkipWithoutOptIns {
+		st.optIns = newOptInCircuit()
+		go wait.Until(st.checkOptIns, optInCheckInterval, wait.NeverStop)
+	}
+	if args.PlacementWebhookURL != "" {
+		st.placementNotifier = newPlacementNotifier(args.PlacementWebhookURL)
+		go st.placementNotifier.run(wait.NeverStop)
+	}
+	if args.ReconcileWorkers > 0 {
+		st.reconcileQueue = newReconcileQueue(args.ReconcileQPS, args.ReconcileBurst)
+		// the framework doesn't stop plugins, the workers run as long as the scheduler
+		go st.runReconcile(args.ReconcileWorkers, wait.NeverStop)
+	}
+	if args.OrderedPlacementTimeoutSeconds > 0 {
+		st.allowPod = func(uid types.UID) bool {
+			waitingPod := handle.GetWaitingPod(uid)
+			if waitingPod == nil {
+				return false
+			}
+			waitingPod.Allow(Name)
+			return true
+		}
+	}
+	if args.MaxTotalRecordEntries > 0 {
+		st.recordEntriesExceeded = make(chan struct{}, 1)
+		// the framework doesn't stop plugins, the loop runs as long as the scheduler
+		go st.runRecordEntriesCap(context.Background())
+	}
+	st.recordQueue = newRecordQueue()
+	go st.runRecordQueue(wait.NeverStop)
+	st.registerEventHandlers(handle.SharedInformerFactory())
+	if store, ok := st.store.(*multiStore); ok {
+		go wait.Until(func() { st.compareStores(context.TODO(), store) }, storeCompareInterval, wait.NeverStop)
+	}
+	return st, nil
+}
+
+// PreFilter resolves the statefulset and the schedule record of the pod once per scheduling
+// cycle and saves them in the cycle state for Filter.
+func (st *Stable) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	if st.idle() {
+		return framework.NewStatus(framework.Success, "")
+	}
+	ctx, span := st.startSpan(ctx, "PreFilter", pod)
+	defer span.End()
+	if reason := st.gateUnloadableRecord(pod); reason != "" {
+		klog.V(4).Infof("Deferring pod %s/%s: %s", pod.Namespace, pod.Name, reason)
+		span.SetAttribute(SpanAttributeDecision, framework.Unschedulable.String())
+		return framework.NewStatus(framework.Unschedulable, reason)
+	}
+	s := st.computePreFilterState(ctx, pod)
+	state.Write(preFilterStateKey, s)
+	// once per scheduling cycle rather than for every node in Filter
+	if s.relaxedNode != "" {
+		st.clearRecordEntry(ctx, s.statefulset, pod, s.relaxedNode, "the pod was unschedulable only because of it")
+	}
+	span.SetAttribute(SpanAttributeRecorded, strconv.FormatBool(s.recorded(s.key)))
+	span.SetAttribute(SpanAttributeDecision, framework.Success.String())
+	return framework.NewStatus(framework.Success, "")
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (st *Stable) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+func (st *Stable) computePreFilterState(ctx context.Context, pod *v1.Pod) *preFilterState {
+	s := &preFilterState{}
+	// the key is logged once per scheduling cycle rather than for every lookup
+	var missing string
+	if st.recordKey != nil {
+		s.key = st.recordKey(pod)
+	} else {
+		s.key, missing = recordKeyFallback(pod, st.args)
+	}
+	if !st.eligible(pod) {
+		return s
+	}
+	statefulset := st.createByStatefulset(pod)
+	if statefulset == nil {
+		return s
+	}
+	s.statefulset = statefulset
+	if missing != "" {
+		klog.V(4).Infof("Pod %s/%s has no %s, recording it by key %q", pod.Namespace, pod.Name, missing, s.key)
+	}
+	s.perStatefulSetMetrics = st.perStatefulSetMetrics()
+	if statefulset.DeletionTimestamp != nil {
+		klog.V(4).Infof("Statefulset %s/%s is terminating, not enforcing the record of pod %s",
+			statefulset.Namespace, statefulset.Name, pod.GetName())
+		return s
+	}
+	s.record, s.recordErr = st.store.Get(ctx, statefulset)
+	s.record, s.recordErr = st.validateRecord(statefulset, s.record, s.recordErr)
+	if s.record != nil {
+		// expired entries don't pin their pods, they are removed with the next write
+		s.record.pruneExpired(st.now(), st.recordTTL(statefulset))
+	}
+	if s.record != nil && st.args.ScopeRecordsByRevision && s.record.isOtherRevision(s.key, podRevision(pod)) {
+		klog.V(4).Infof("Pod %s/%s is of revision %s, ignoring its entry of revision %s",
+			pod.Namespace, pod.Name, podRevision(pod), s.record.Records[s.key].Revision)
+		s.record.deleteEntry(s.key)
+	}
+	if s.record != nil && st.args.EnforceNodeEpoch && st.isOtherNodeEpoch(s.record, s.key) {
+		klog.V(4).Infof("Node %s of pod %s/%s was recreated, releasing the pin",
+			s.record.Records[s.key].Node, pod.Namespace, pod.Name)
+		s.record.deleteEntry(s.key)
+	}
+	if s.record != nil && st.isOtherNodeIdentity(s.record, s.key) {
+		klog.V(4).Infof("The identity of node %s of pod %s/%s changed, releasing the pin",
+			s.record.Records[s.key].Node, pod.Namespace, pod.Name)
+		s.record.deleteEntry(s.key)
+	}
+	ranges, err := stickyOrdinals(statefulset, st.globalStickyOrdinals())
+	if err != nil {
+		klog.V(3).Infof("Ignoring annotation %s of statefulset %s/%s: %v",
+			StatefulsetStableOrdinals, statefulset.Namespace, statefulset.Name, err)
+	}
+	s.enforce = isStickyOrdinal(ranges, pod.GetName())
+	if s.enforce && st.sentinelFlag(sentinelPaused) {
+		klog.V(4).Infof("Stable scheduling is paused, not enforcing the record of pod %s/%s", pod.Namespace, pod.Name)
+		s.enforce = false
+	}
+	if s.enforce && st.args.EnforceAfterReady && !st.hasBeenReady(statefulset, s.record) {
+		klog.V(4).Infof("Statefulset %s/%s is not ready, not enforcing the record of pod %s",
+			statefulset.Namespace, statefulset.Name, pod.GetName())
+		s.enforce = false
+	}
+	if s.enforce {
+		s.group = st.computeGroupState(statefulset, pod)
+	}
+	if s.enforce && s.record != nil && s.record.isStale(s.key, statefulset.Generation) {
+		klog.V(4).Infof("Pod %s/%s was recorded at an older generation of statefulset %s/%s, its record is advisory",
+			pod.Namespace, pod.Name, statefulset.Namespace, statefulset.Name)
+		s.advisory = true
+	}
+	if s.enforce && !s.advisory && s.recorded(s.key) && st.relaxedAfterPreemption(pod) {
+		klog.V(4).Infof("Pod %s/%s was preempted, its record is advisory", pod.Namespace, pod.Name)
+		s.advisory = true
+	}
+	if s.enforce && !s.advisory && s.recorded(s.key) && st.args.Mode == ModeSoft {
+		// the recorded node is only preferred by Score
+		s.advisory = true
+	}
+	if s.enforce && st.args.ImageLocality != "" && s.record != nil && (s.advisory || !s.recorded(s.key)) {
+		s.imageNodes = computeImageNodes(s.record, pod)
+	}
+	if s.enforce && !s.advisory && st.args.Fallback != "" && s.record != nil {
+		if node, ok := s.record.nodeOf(s.key); ok && !st.recordedNodeAvailable(node) {
+			s.fallbackLabels = s.record.Records[s.key].Labels
+		}
+	}
+	if s.enforce && !s.advisory && s.fallbackLabels == nil && s.record != nil {
+		if node, ok := s.record.nodeOf(s.key); ok && st.recordedNodeDeleted(node) {
+			if st.args.PinToDeletedNodes {
+				s.recordedNodeDeleted = true
+			} else {
+				klog.V(4).Infof("Recorded node %s of pod %s/%s was deleted, its record is advisory", node, pod.Namespace, pod.Name)
+				s.advisory = true
+			}
+		}
+	}
+	if s.enforce && !s.advisory && st.args.RelaxWhenUnschedulable && s.record != nil {
+		if node, ok := s.record.nodeOf(s.key); ok && unschedulableOnlyByRecord(pod) {
+			klog.V(3).Infof("Pod %s/%s was unschedulable only because of its recorded node %s, its record is advisory",
+				pod.Namespace, pod.Name, node)
+			s.advisory, s.relaxedNode = true, node
+		}
+	}
+	return s
+}
+
+// getPreFilterState reads the state written by PreFilter, and computes it when
+// PreFilter did not run for the pod.
+func (st *Stable) getPreFilterState(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *preFilterState {
+	if state != nil {
+		if c, err := state.Read(preFilterStateKey); err == nil {
+			if s, ok := c.(*preFilterState); ok {
+				return s
+			}
+		}
+	}
+	return st.computePreFilterState(ctx, pod)
 }
 
 // Filter checks whether the pod meets the current plugin conditions and
 // restores the last scheduled record. Filters out unmatched nodes.
 func (st *Stable) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
-	if !containStatefulsetStableLabel(pod) {
+	if st.idle() {
 		return framework.NewStatus(framework.Success, "")
 	}
-	if statefulset := st.createByStatefulset(pod); statefulset != nil {
-		// try get the pod schedule record
-		record, err := getScheduleRecord(statefulset)
-		if err != nil {
-			return framework.NewStatus(framework.Unschedulable, err.Error())
-		}
-		if record != nil {
-			if node, ok := record.Records[pod.GetName()]; ok {
-				// want to schedule to the original node, if the node is different, filter directly
-				if node != nodeInfo.Node().GetName() {
-					return framework.NewStatus(framework.Unschedulable, "")
+	ctx, span := st.startSpan(ctx, "Filter", pod)
+	defer span.End()
+	s := st.getPreFilterState(ctx, state, pod)
+	status := st.filter(s, pod, nodeInfo)
+	span.SetAttribute(SpanAttributeNode, nodeInfo.Node().GetName())
+	span.SetAttribute(SpanAttributeRecorded, strconv.FormatBool(s.recorded(s.key)))
+	span.SetAttribute(SpanAttributeDecision, status.Code().String())
+	st.observeFilter(s, pod, nodeInfo.Node().GetName(), status)
+	if st.diagnosed(pod) {
+		diagnosef(pod, "Filter node %s: %s, %s", nodeInfo.Node().GetName(), describeStatus(status), st.describeState(s, pod))
+	}
+	return status
+}
+
+func (st *Stable) filter(s *preFilterState, pod *v1.Pod, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	if s.statefulset == nil || st.args.Mode == ModeSoft {
+		return framework.NewStatus(framework.Success, "")
+	}
+	if s.recordErr != nil {
+		return framework.NewStatus(framework.Unschedulable, s.recordErr.Error())
+	}
+	if !s.enforce {
+		return framework.NewStatus(framework.Success, "")
+	}
+	if st.args.EnforceAfterVolumeBound && s.recorded(s.key) && !st.localVolumesBound(pod) {
+		// the data of the pod is not on a node yet, enforcing the record could deadlock its placement
+		return framework.NewStatus(framework.Success, "")
+	}
+	if status := st.filterGroup(s.group, nodeInfo); status != nil {
+		return status
+	}
+	if status := st.filterImage(s, nodeInfo); status != nil {
+		return status
+	}
+	if !s.recorded(s.key) {
+		// first placement: the pod has no entry yet, e.g. the record is empty after garbage
+		// collection, it may go anywhere and PostBind records its node
+		return framework.NewStatus(framework.Success, "")
+	}
+	if s.record != nil {
+		if node, ok := s.record.nodeOf(s.key); ok {
+			decision := Decision{
+				Type:         DecisionReject,
+				Namespace:    pod.Namespace,
+				StatefulSet:  s.statefulset.Name,
+				Pod:          pod.Name,
+				Node:         nodeInfo.Node().GetName(),
+				RecordedNode: node,
+			}
+			if !st.nodeAllowed(node) {
+				// the pin is released, the pod may go anywhere until it is recorded on an allowed node
+				decision.Type, decision.Reason = DecisionAdmit, "recorded node is not allowed"
+				st.audit(decision)
+				return framework.NewStatus(framework.Success, "")
+			}
+			if s.advisory {
+				// the node suited an older pod template, it is only preferred by Score
+				decision.Type, decision.Reason = DecisionAdmit, "record is from an older generation"
+				st.audit(decision)
+				return framework.NewStatus(framework.Success, "")
+			}
+			if topology := st.topologyOf(s.record, s.key); topology != "" {
+				// the pod is stable within the topology domain of its recorded node, any node of
+				// the domain will do, nodes without the label are outside of it
+				if nodeInfo.Node().GetLabels()[st.args.TopologyKey] != topology {
+					decision.Reason = "node is outside the topology domain of the recorded node"
+					st.audit(decision)
+					st.emitPinEvent(pod, v1.EventTypeWarning, EventReasonFailedScheduling, "pinned to %s=%s by %s",
+						st.args.TopologyKey, topology, Name)
+					return framework.NewStatus(framework.Unschedulable, decision.Reason)
 				}
+				decision.Type, decision.Reason = DecisionAdmit, "node is in the topology domain of the recorded node"
+				st.audit(decision)
+				return framework.NewStatus(framework.Success, "")
+			}
+			if s.fallbackLabels != nil {
+				// the recorded node is unavailable, fall back to the nodes sharing its labels
+				if st.args.Fallback == FallbackRequired && matchingLabels(s.fallbackLabels, nodeInfo.Node().GetLabels()) < len(s.fallbackLabels) {
+					decision.Reason = "node doesn't match the labels of the unavailable recorded node"
+					st.audit(decision)
+					return framework.NewStatus(framework.Unschedulable, decision.Reason)
+				}
+				decision.Type, decision.Reason = DecisionAdmit, "recorded node is unavailable"
+				st.audit(decision)
+				return framework.NewStatus(framework.Success, "")
+			}
+			if s.recordedNodeDeleted {
+				// retrying won't help and scaling up the cluster won't bring the node back
+				decision.Reason = "recorded node was deleted"
+				st.audit(decision)
+				return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+					fmt.Sprintf("recorded node %s was deleted, clear the record of the pod to schedule it elsewhere", node))
 			}
+			// want to schedule to the original node, if the node is different, filter directly.
+			// a relocated pod may also return to its previous node within the grace window.
+			returnNode := s.record.returnNodeOf(s.key, st.now())
+			evaluated := evaluateRecord(s.record.nodes(), s.key, nodeInfo.Node().GetName(), st.args)
+			if !evaluated.Allowed && (returnNode == "" || !st.matchesNode(returnNode, nodeInfo.Node().GetName())) {
+				klog.V(5).Infof("Filtering out node, recorded on another node: statefulset %s/%s, pod %s/%s, node %s, recorded node %s",
+					s.statefulset.Namespace, s.statefulset.Name, pod.Namespace, pod.Name, nodeInfo.Node().GetName(), node)
+				decision.Reason = evaluated.Reason
+				st.audit(decision)
+				st.emitPinEvent(pod, v1.EventTypeWarning, EventReasonFailedScheduling, "pinned to %s by %s", node, Name)
+				return framework.NewStatus(framework.Unschedulable, ErrReasonNotRecordedNode)
+			}
+			// the recorded node is not initialized yet, keep the pod pending until it is
+			if selector := st.readinessSelector(); selector != nil && !selector.Matches(labels.Set(nodeInfo.Node().GetLabels())) {
+				decision.Reason = "recorded node is not ready"
+				st.audit(decision)
+				return framework.NewStatus(framework.Unschedulable, "recorded node is not ready")
+			}
+			decision.Type = DecisionAdmit
+			st.audit(decision)
 		}
 	}
 	return framework.NewStatus(framework.Success, "")
 }
 
+// now returns the current time of the plugin clock.
+func (st *Stable) now() time.Time {
+	if st.clock == nil {
+		return time.Now()
+	}
+	return st.clock.Now()
+}
+
+// matchesNode compares a recorded node name with the name of a node. Depending on NodeNameMatch,
+// whitespace and case differences left by manual edits of the record are ignored, and with
+// NodeDomainSuffix short and fully qualified names of the same node match.
+func (st *Stable) matchesNode(recorded, nodeName string) bool {
+	return nodeNameMatches(st.args, recorded, nodeName)
+}
+
+// nodeNameMatches compares a recorded node name with the name of a node following the
+// NodeNameMatch mode and the NodeDomainSuffix of the args.
+func nodeNameMatches(args StableArgs, recorded, nodeName string) bool {
+	if recorded == nodeName {
+		return true
+	}
+	normalized := recorded
+	switch args.NodeNameMatch {
+	case NodeNameMatchTrim:
+		normalized = strings.TrimSpace(recorded)
+	case NodeNameMatchTrimIgnoreCase:
+		normalized = strings.ToLower(strings.TrimSpace(recorded))
+	}
+	matched := normalized == nodeName
+	if !matched && args.NodeDomainSuffix != "" {
+		// the kubelet may register the short name of a node the record holds the fully qualified
+		// name of, or the other way round
+		matched = trimNodeDomain(normalized, args.NodeDomainSuffix) == trimNodeDomain(nodeName, args.NodeDomainSuffix)
+	}
+	if !matched {
+		return false
+	}
+	if normalized != recorded {
+		klog.Warningf("Recorded node %q only matches node %s after normalization, the record was likely edited by hand",
+			recorded, nodeName)
+	}
+	return true
+}
+
+// trimNodeDomain strips the domain suffix from a node name, so the short and the fully qualified
+// name of a node compare equal.
+func trimNodeDomain(nodeName, domainSuffix string) string {
+	return strings.TrimSuffix(nodeName, "."+strings.TrimPrefix(domainSuffix, "."))
+}
+
 // PostBind record the result of the current schedule to the annotation of statefulset
 func (st *Stable) PostBind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
-	if !containStatefulsetStableLabel(pod) {
+	if st.idle() || !st.eligible(pod) {
+		return
+	}
+	// the records written below replace the reservation
+	defer st.reservations.release(pod.UID)
+	ctx, span := st.startSpan(ctx, "PostBind", pod)
+	defer span.End()
+	s := st.getPreFilterState(ctx, state, pod)
+	span.SetAttribute(SpanAttributeNode, nodeName)
+	span.SetAttribute(SpanAttributeRecorded, strconv.FormatBool(s.recorded(s.key)))
+	if st.diagnosed(pod) {
+		diagnosef(pod, "PostBind node %s: %s", nodeName, st.describeState(s, pod))
+	}
+	st.observePlacement(s, pod, nodeName)
+	if s.group != nil && (s.group.node == "" || s.group.reserved) && !st.sentinelFlag(sentinelPaused) && !st.sentinelFlag(sentinelPauseNewPins) {
+		// the first pod bound with an ordinal assigns the node of the ordinal for the group
+		if err := st.setGroupRecord(ctx, s.group, pod, nodeName); err != nil {
+			klog.Warningf("Failed to record pod %s/%s in group %q: %v", pod.Namespace, pod.Name, s.group.name, err)
+		}
+	}
+	if st.delaysRecords() && s.statefulset != nil && !s.recorded(s.key) {
+		// the first record of the pod waits until it stayed on the node for the observation period
+		// and the pod condition is True
+		if st.diagnosed(pod) {
+			diagnosef(pod, "first record on node %s waits for the observation period and pod condition", nodeName)
+		}
+		st.pendingRecords.add(pod, nodeName, st.now())
+		return
+	}
+	st.recordPod(ctx, pod, nodeName)
+}
+
+// recordPod records the node of the pod in the schedule record of its statefulset.
+func (st *Stable) recordPod(ctx context.Context, pod *v1.Pod, nodeName string) {
+	if st.sentinelFlag(sentinelPaused) || st.sentinelFlag(sentinelPauseNewPins) {
+		klog.V(4).Infof("New pins are paused, not recording pod %s/%s on node %s", pod.Namespace, pod.Name, nodeName)
+		if st.diagnosed(pod) {
+			diagnosef(pod, "not recorded on node %s, new pins are paused", nodeName)
+		}
 		return
 	}
 	// although the updates of the pods created by the statefulset are ordered and
 	// can relieve the problem of concurrent updates, but the update operation cannot guarantee success,
 	// should catch error and add retry.
+	var statefulsetName, statefulsetNamespace string
 	retryErr := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		if statefulset := st.createByStatefulset(pod); statefulset != nil {
-			return st.setScheduleRecord(ctx, statefulset, pod, nodeName)
+		statefulset := st.createByStatefulset(pod)
+		if statefulset == nil {
+			return nil
 		}
-		return nil
+		statefulsetName, statefulsetNamespace = statefulset.Name, statefulset.Namespace
+		if statefulset.DeletionTimestamp != nil {
+			klog.V(4).Infof("Statefulset %s/%s is terminating, not recording pod %s",
+				statefulset.Namespace, statefulset.Name, pod.GetName())
+			return nil
+		}
+		err := st.setScheduleRecord(ctx, statefulset, pod, nodeName)
+		if errors.IsConflict(err) {
+			recordConflicts.WithLabelValues(statefulSetLabelValues(statefulset, st.perStatefulSetMetrics())...).Inc()
+		}
+		return err
 	})
 	if retryErr != nil {
-		log.Printf("Failed to record scheduling result: %v\n", retryErr)
+		klog.Errorf("Failed to record scheduling result: statefulset %s/%s, pod %s/%s, node %s: %v",
+			statefulsetNamespace, statefulsetName, pod.Namespace, pod.Name, nodeName, retryErr)
+		if !isInvalidRecord(retryErr) {
+			// the queue retries the write, so the node is recorded before the pod is rescheduled
+			st.requeueRecord(statefulsetNamespace, statefulsetName, pod.Name, nodeName)
+		}
+		return
+	}
+	// the recreated pod of a preempted pod is recorded, later pods enforce the record again
+	st.preempted.remove(pod)
+}
+
+// observePlacement counts whether a pod with a recorded node bound to it. Pods
+// without a recorded node are counted as first placements.
+func (st *Stable) observePlacement(s *preFilterState, pod *v1.Pod, nodeName string) {
+	if s.statefulset == nil {
+		return
+	}
+	if !s.recorded(s.key) {
+		firstPlacements.Inc()
+		return
+	}
+	recorded := s.record.Records[s.key].Node
+	if st.matchesNode(recorded, nodeName) {
+		placementsHonored.Inc()
+	} else {
+		placementsNotHonored.Inc()
+	}
+}
+
+// observeFilter counts whether a node filtered for a stable scheduled pod was rejected, passed
+// as the recorded node of the pod or passed without pinning the pod.
+func (st *Stable) observeFilter(s *preFilterState, pod *v1.Pod, nodeName string, status *framework.Status) {
+	if s.statefulset == nil {
+		return
+	}
+	switch {
+	case !status.IsSuccess():
+		filterResults.WithLabelValues(filterResultRejected).Inc()
+		filterRejections.WithLabelValues(statefulSetLabelValues(s.statefulset, s.perStatefulSetMetrics)...).Inc()
+	case s.enforce && !s.advisory && s.recorded(s.key) && st.matchesNode(s.record.Records[s.key].Node, nodeName):
+		filterResults.WithLabelValues(filterResultPinned).Inc()
+	default:
+		filterResults.WithLabelValues(filterResultPassed).Inc()
+	}
+}
+
+// isStatefulSetReady checks whether all the desired replicas of the statefulset are ready.
+func isStatefulSetReady(statefulset *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if statefulset.Spec.Replicas != nil {
+		replicas = *statefulset.Spec.Replicas
+	}
+	return statefulset.Status.ReadyReplicas >= replicas
+}
+
+// hasBeenReady checks whether the statefulset has been ready once. A rescheduled pod makes
+// its statefulset unready again, so the current status alone can't tell that the rollout is done.
+// The Ready flag of the record is the source of truth, readyStatefulSets only caches it and
+// the statefulsets seen ready before their record was written.
+func (st *Stable) hasBeenReady(statefulset *appsv1.StatefulSet, record *ScheduleRecord) bool {
+	if _, ok := st.readyStatefulSets.Load(statefulset.UID); ok {
+		return true
+	}
+	if (record != nil && record.Ready) || isStatefulSetReady(statefulset) {
+		st.readyStatefulSets.Store(statefulset.UID, struct{}{})
+		return true
+	}
+	return false
+}
+
+// saveReady sets the Ready flag in the record of a statefulset that has been ready. Statefulsets
+// without record are flagged with their first record.
+func (st *Stable) saveReady(ctx context.Context, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		statefulset, err := st.statefulSetLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return err
+		}
+		record, err := st.store.Get(ctx, statefulset)
+		if err != nil || record == nil || record.Ready {
+			return err
+		}
+		record.Ready = true
+		return st.store.Set(ctx, statefulset, record)
+	})
+}
+
+// readinessEventHandler remembers the statefulsets that become ready in their record.
+func (st *Stable) readinessEventHandler() cache.ResourceEventHandler {
+	markReady := func(statefulset *appsv1.StatefulSet) {
+		if !st.hasBeenReady(statefulset, nil) {
+			return
+		}
+		if err := st.saveReady(context.TODO(), statefulset.Namespace, statefulset.Name); err != nil && !errors.IsNotFound(err) {
+			klog.Warningf("Failed to save that statefulset %s/%s has been ready: %v", statefulset.Namespace, statefulset.Name, err)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if statefulset, ok := obj.(*appsv1.StatefulSet); ok {
+				markReady(statefulset)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if statefulset, ok := newObj.(*appsv1.StatefulSet); ok {
+				markReady(statefulset)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if statefulset, ok := obj.(*appsv1.StatefulSet); ok {
+				st.readyStatefulSets.Delete(statefulset.UID)
+			}
+		},
+	}
+}
+
+// terminatingEventHandler deletes the records of the statefulsets that are terminating.
+func (st *Stable) terminatingEventHandler() cache.ResourceEventHandler {
+	deleteRecord := func(obj interface{}) {
+		statefulset, ok := obj.(*appsv1.StatefulSet)
+		if !ok || statefulset.DeletionTimestamp == nil {
+			return
+		}
+		if err := st.store.Delete(context.TODO(), statefulset); err != nil {
+			klog.Warningf("Failed to delete the record of terminating statefulset %s/%s: %v",
+				statefulset.Namespace, statefulset.Name, err)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: deleteRecord,
+		UpdateFunc: func(_, newObj interface{}) {
+			deleteRecord(newObj)
+		},
 	}
 }
 
-func containStatefulsetStableLabel(pod *v1.Pod) bool {
+// containStatefulsetStableLabel checks the opt-in label key of the pod. Only "true" opts in
+// unless lenient.
+func containStatefulsetStableLabel(pod *v1.Pod, key string, lenient bool) bool {
 	label := pod.GetLabels()
 	if label == nil {
 		return false
 	}
-	if label[StatefulsetStable] == "true" {
+	if label[key] == "true" {
 		return true
 	}
+	if lenient {
+		return isTruthy(label[key])
+	}
 	return false
 }
 
-// createByStatefulset check if the pod belongs to statefulset, if yes, return statefulset object
+// optInLabelKey returns the key of the opt-in label, the LabelKey of the args or, when it is
+// empty, StatefulsetStable.
+func optInLabelKey(args StableArgs) string {
+	if args.LabelKey != "" {
+		return args.LabelKey
+	}
+	return StatefulsetStable
+}
+
+// recordAnnotationKey returns the key of the record annotation, the RecordAnnotationKey of the
+// args or, when it is empty, StatefulsetStableRecord.
+func recordAnnotationKey(args StableArgs) string {
+	if args.RecordAnnotationKey != "" {
+		return args.RecordAnnotationKey
+	}
+	return StatefulsetStableRecord
+}
+
+// lenientTrueValues are the values besides those of strconv.ParseBool that opt pods in with
+// OptInLabelValueLenient, compared ignoring case.
+var lenientTrueValues = sets.NewString("yes", "y", "on", "enabled")
+
+// isTruthy checks whether the label value means true to a lenient reader.
+func isTruthy(value string) bool {
+	if parsed, err := strconv.ParseBool(value); err == nil {
+		return parsed
+	}
+	return lenientTrueValues.Has(strings.ToLower(value))
+}
+
+// isEligible checks whether the pod opted in with the opt-in label following the LabelKey and
+// OptInLabelValue of the args, pods without labels are not eligible. The plugin checks pods with
+// st.eligible, which also follows the opt-in annotation of their statefulset.
+func isEligible(pod *v1.Pod, args StableArgs) bool {
+	if pod == nil {
+		return false
+	}
+	return containStatefulsetStableLabel(pod, optInLabelKey(args), args.OptInLabelValue == OptInLabelValueLenient)
+}
+
+// isStableEnabled checks whether the pod is stable scheduled, either it opted in with the opt-in
+// label or its statefulset opted in all its pods with the StatefulsetStableEnabled annotation. The
+// statefulset may be nil.
+func isStableEnabled(pod *v1.Pod, statefulset *appsv1.StatefulSet, args StableArgs) bool {
+	if isEligible(pod, args) {
+		return true
+	}
+	if pod == nil || statefulset == nil {
+		return false
+	}
+	value := statefulset.GetAnnotations()[StatefulsetStableEnabled]
+	if args.OptInLabelValue == OptInLabelValueLenient {
+		return isTruthy(value)
+	}
+	return value == "true"
+}
+
+// eligible checks whether the pod is stable scheduled following isStableEnabled. The statefulset
+// of the pod is only looked up when the pod doesn't opt in with the label itself.
+func (st *Stable) eligible(pod *v1.Pod) bool {
+	if isEligible(pod, st.args) {
+		return true
+	}
+	if pod == nil || st.statefulSetLister == nil {
+		return false
+	}
+	for _, ow := range pod.GetOwnerReferences() {
+		if ow.Kind != Kind {
+			continue
+		}
+		statefulset, err := st.statefulSetLister.StatefulSets(pod.Namespace).Get(ow.Name)
+		if err != nil || ow.UID != "" && statefulset.UID != ow.UID {
+			return false
+		}
+		return isStableEnabled(pod, statefulset, st.args)
+	}
+	return false
+}
+
+// createByStatefulset check if the pod belongs to statefulset, if yes, return statefulset object.
+// Pods of the CustomOwners kinds return the set of their custom owner, and pods of the OwnerKinds
+// the set of their owner. Owners live in the
+// namespace of the pod, a statefulset found there with another UID than the owner reference is
+// not the owner, e.g. an invalid reference copied from another namespace, and is skipped.
 func (st *Stable) createByStatefulset(pod *v1.Pod) *appsv1.StatefulSet {
-	ows := pod.GetOwnerReferences()
-	for _, ow := range ows {
+	if pod.Namespace == "" {
+		klog.Warningf("Pod %s has no namespace, skipping its statefulset lookup", pod.Name)
+		return nil
+	}
+	for _, ow := range pod.GetOwnerReferences() {
 		if ow.Kind == Kind {
-			statefulset, err := st.statefulSetLister.StatefulSets(pod.Namespace).Get(ows[0].Name)
+			statefulset, err := st.statefulSetLister.StatefulSets(pod.Namespace).Get(ow.Name)
 			if err != nil {
 				return nil
 			}
+			if ow.UID != "" && statefulset.UID != ow.UID {
+				klog.Warningf("Pod %s/%s references statefulset %s with UID %s, but statefulset %s/%s has UID %s, skipping it",
+					pod.Namespace, pod.Name, ow.Name, ow.UID, statefulset.Namespace, statefulset.Name, statefulset.UID)
+				return nil
+			}
 			return statefulset
 		}
 	}
-	return nil
-}
-
-func getScheduleRecord(statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
-	var record *ScheduleRecord
-	var err error
-	ats := statefulset.GetAnnotations()
-	if ats != nil {
-		if rec, ok := ats[StatefulsetStableRecord]; ok {
-			if err := json.Unmarshal([]byte(rec), &record); err != nil {
-				return nil, err
-			}
+	if len(st.args.CustomOwners) > 0 {
+		if set := st.createByCustomOwner(pod); set != nil {
+			return set
 		}
 	}
-	return record, err
+	if len(st.args.OwnerKinds) > 0 {
+		return st.createByOwnerKind(pod)
+	}
+	return nil
 }
 
+// setScheduleRecord records the node of a sticky pod. Records of pods outside the
+// sticky ordinals are pruned, so they can't pin pods once the ordinals are widened again,
+// and so are the records of ordinals out of the replicas once the statefulset scales down.
 func (st *Stable) setScheduleRecord(ctx context.Context, statefulset *appsv1.StatefulSet, pod *v1.Pod, nodeName string) error {
-	needUpdate := false
-	record, err := getScheduleRecord(statefulset)
+	if isFrozen(statefulset) {
+		klog.V(4).Infof("The record of statefulset %s/%s is frozen, not recording pod %s on node %s",
+			statefulset.Namespace, statefulset.Name, pod.Name, nodeName)
+		return nil
+	}
+	record, err := st.store.Get(ctx, statefulset)
 	if err != nil {
 		return err
 	}
@@ -161,29 +1045,243 @@ func (st *Stable) setScheduleRecord(ctx context.Context, statefulset *appsv1.Sta
 		record = new(ScheduleRecord)
 	}
 
-	if record.Records == nil {
-		record.Records = make(map[string]string)
-	}
+	// an invalid ordinals annotation falls back to the global ordinals, the error is
+	// already reported by PreFilter.
+	ranges, _ := stickyOrdinals(statefulset, st.globalStickyOrdinals())
+	needUpdate := pruneScheduleRecord(record, ranges)
+	// expired return nodes are dropped with the next write
+	record.pruneReturns(st.now())
+	ttl := st.recordTTL(statefulset)
+	needUpdate = record.pruneExpired(st.now(), ttl) || needUpdate
+	needUpdate = record.pruneScaledDown(statefulset, st.args.RecordKey == RecordKeyOrdinal) || needUpdate
 
-	if _, ok := record.Records[pod.GetName()]; !ok {
-		record.Records[pod.GetName()] = nodeName
+	key := st.keyOf(pod)
+	if isScaledDown(key, statefulset, st.args.RecordKey == RecordKeyOrdinal) {
+		// the pod is bound while the statefulset scales down, it is deleted soon
+		klog.V(4).Infof("Pod %s/%s is out of the replicas of statefulset %s, not recording it on node %s",
+			pod.Namespace, pod.Name, statefulset.Name, nodeName)
+		if !needUpdate {
+			return nil
+		}
+		if err := st.checkRecordSize(statefulset, record); err != nil {
+			return err
+		}
+		return st.store.Set(ctx, statefulset, record)
+	}
+	if st.args.ScopeRecordsByRevision && record.isOtherRevision(key, podRevision(pod)) && record.ownerOf(key) == pod.GetName() {
+		// the entry belongs to another revision of the pod, the pod is recorded anew
+		record.deleteEntry(key)
+		needUpdate = true
+	}
+	if st.args.EnforceNodeEpoch && st.isOtherNodeEpoch(record, key) && record.ownerOf(key) == pod.GetName() {
+		// the recorded node was recreated under the same name, the pod is recorded anew
+		record.deleteEntry(key)
+		needUpdate = true
+	}
+	if st.isOtherNodeIdentity(record, key) && record.ownerOf(key) == pod.GetName() {
+		// the identity of the recorded node changed, the pod is recorded anew
+		record.deleteEntry(key)
+		needUpdate = true
+	}
+	previous, wasRecorded := record.nodeOf(key)
+	if key != pod.GetName() && record.ownerOf(pod.GetName()) == pod.GetName() {
+		if _, ok := record.Records[pod.GetName()]; ok {
+			// recorded by name before the record key changed, the entry is never read again
+			record.deleteEntry(pod.GetName())
+			needUpdate = true
+		}
+	}
+	if recorded, ok := record.nodeOf(key); ok && record.ownerOf(key) == pod.GetName() && !st.nodeAllowed(recorded) {
+		klog.V(3).Infof("Releasing pod %s/%s from node %s, the node is not allowed anymore", pod.Namespace, pod.Name, recorded)
+		record.deleteEntry(key)
 		needUpdate = true
 	}
+	if !st.nodeAllowed(nodeName) {
+		klog.V(3).Infof("Not recording pod %s/%s on node %s, the node is not allowed", pod.Namespace, pod.Name, nodeName)
+	} else if isStickyOrdinal(ranges, pod.GetName()) {
+		if _, ok := record.Records[key]; !ok {
+			if st.admitStatefulSetEntry(statefulset, record) && st.admitRecordEntry(ctx, statefulset, record) {
+				record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+				needUpdate = true
+			}
+		} else if owner := record.ownerOf(key); owner != pod.GetName() {
+			needUpdate = st.resolveKeyConflict(record, key, owner, pod, nodeName) || needUpdate
+		} else if recorded := record.Records[key].Node; recorded != nodeName && record.isStale(key, statefulset.Generation) {
+			// the record of an older generation was advisory, follow the pod
+			klog.V(3).Infof("Pod %s/%s bound to node %s instead of node %s recorded at an older generation, updating the record",
+				pod.Namespace, pod.Name, nodeName, recorded)
+			record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+			needUpdate = true
+		} else if recorded := record.Records[key].Node; recorded != nodeName && st.relaxedAfterPreemption(pod) {
+			// the record of the preempted pod was advisory, follow the pod
+			klog.V(3).Infof("Preempted pod %s/%s bound to node %s instead of recorded node %s, updating the record",
+				pod.Namespace, pod.Name, nodeName, recorded)
+			record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+			needUpdate = true
+		} else if recorded := record.Records[key].Node; recorded != nodeName && !st.args.PinToDeletedNodes && st.recordedNodeDeleted(recorded) {
+			// the recorded node was deleted, follow the pod
+			klog.V(3).Infof("Pod %s/%s bound to node %s instead of deleted node %s, updating the record",
+				pod.Namespace, pod.Name, nodeName, recorded)
+			record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+			needUpdate = true
+		} else if recorded := record.Records[key].Node; recorded != nodeName && st.topologyOf(record, key) != "" &&
+			st.topologyOf(record, key) == st.nodeTopology(nodeName) {
+			// the pod moved within the topology domain it is stable within, follow the pod
+			klog.V(3).Infof("Pod %s/%s bound to node %s instead of node %s in topology domain %s, updating the record",
+				pod.Namespace, pod.Name, nodeName, recorded, st.topologyOf(record, key))
+			record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+			needUpdate = true
+		} else if recorded := record.Records[key].Node; recorded != nodeName && st.args.RecordUpdatePolicy == RecordUpdateMutable {
+			// the record wasn't enforced, e.g. paused or before the statefulset was ready, follow the pod
+			klog.V(3).Infof("Pod %s/%s bound to node %s instead of recorded node %s, updating the record",
+				pod.Namespace, pod.Name, nodeName, recorded)
+			record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+			if record.returnNodeOf(key, st.now()) == nodeName {
+				// the pod is back on its original node
+				record.deleteReturn(key)
+			} else if st.args.ReturnGraceSeconds > 0 {
+				record.setReturn(key, recorded, st.now().Add(time.Duration(st.args.ReturnGraceSeconds)*time.Second))
+			}
+			needUpdate = true
+		}
+	}
 
-	if needUpdate {
-		statefulsetCopy := statefulset.DeepCopy()
-		recordBytes, err := json.Marshal(record)
-		if err != nil {
-			return err
+	if st.args.PinToVolumeNode && isStickyOrdinal(ranges, pod.GetName()) && record.ownerOf(key) == pod.GetName() {
+		// the volume can't follow the pod to another node, prefer the node of the volume
+		if volumeNode := st.getVolumeNode(pod); volumeNode != "" && volumeNode != record.Records[key].Node {
+			klog.V(3).Infof("Recording node %s of the volume of pod %s/%s instead of node %s",
+				volumeNode, pod.Namespace, pod.Name, record.Records[key].Node)
+			record.setEntry(key, pod.GetName(), volumeNode, RecordSourceOverride)
+			needUpdate = true
 		}
-		if statefulsetCopy.Annotations == nil {
-			statefulsetCopy.Annotations = make(map[string]string)
+	}
+
+	if recorded, ok := record.nodeOf(key); ok && record.ownerOf(key) == pod.GetName() {
+		entry := record.Records[key]
+		st.refreshEntry(&entry, statefulset, pod, nodeName, !wasRecorded || recorded != previous, ttl)
+		if !reflect.DeepEqual(entry, record.Records[key]) {
+			record.Records[key] = entry
+			needUpdate = true
 		}
-		statefulsetCopy.Annotations[StatefulsetStableRecord] = string(recordBytes)
-		_, err = st.clientset.AppsV1().StatefulSets(statefulset.Namespace).Update(ctx, statefulsetCopy, metav1.UpdateOptions{})
-		if err != nil {
-			return err
+	}
+
+	if st.args.EnforceAfterReady && !record.Ready && st.hasBeenReady(statefulset, record) {
+		record.Ready = true
+		needUpdate = true
+	}
+
+	if st.diagnosed(pod) {
+		recorded, ok := record.nodeOf(key)
+		diagnosef(pod, "bound to node %s, recorded node %q (recorded=%v owner=%s source=%s changed=%v)",
+			nodeName, recorded, ok, record.ownerOf(key), record.sourceOf(key), needUpdate)
+	}
+	if !needUpdate {
+		return nil
+	}
+	if err := st.checkRecordSize(statefulset, record); err != nil {
+		return err
+	}
+	if err := st.store.Set(ctx, statefulset, record); err != nil {
+		return err
+	}
+	recordsWritten.WithLabelValues(statefulSetLabelValues(statefulset, st.perStatefulSetMetrics())...).Inc()
+	if key, err := cache.MetaNamespaceKeyFunc(statefulset); err == nil {
+		st.tracked.track(key, len(record.Records) > 0)
+		st.recordEntriesWritten(key, record)
+	}
+	klog.V(4).Infof("Wrote scheduling result: statefulset %s/%s, pod %s/%s, node %s, recorded node %q",
+		statefulset.Namespace, statefulset.Name, pod.Namespace, pod.Name, nodeName, record.Records[key].Node)
+	if recorded, ok := record.nodeOf(key); ok && record.ownerOf(key) == pod.GetName() && (!wasRecorded || recorded != previous) {
+		st.audit(Decision{
+			Type:         DecisionRecord,
+			Namespace:    pod.Namespace,
+			StatefulSet:  statefulset.Name,
+			Pod:          pod.Name,
+			Node:         nodeName,
+			RecordedNode: recorded,
+			Reason:       record.sourceOf(key),
+		})
+		change := PlacementChange{
+			Namespace:   pod.Namespace,
+			StatefulSet: statefulset.Name,
+			Pod:         pod.Name,
+			NewNode:     recorded,
+		}
+		if wasRecorded {
+			change.OldNode = previous
 		}
+		st.notifyPlacement(change)
+		st.emitPinEvent(pod, v1.EventTypeNormal, EventReasonPinned, "recorded on %s by %s", recorded, Name)
 	}
 	return nil
 }
+
+// refreshEntry updates the metadata of the entry of the pod bound to the node, after its node
+// was decided. moved is whether the node of the entry changed with the binding.
+func (st *Stable) refreshEntry(entry *RecordEntry, statefulset *appsv1.StatefulSet, pod *v1.Pod, nodeName string, moved bool, ttl time.Duration) {
+	bound := entry.Node == nodeName
+	if bound || moved {
+		// the node is confirmed for the current generation of the statefulset
+		entry.Generation = statefulset.Generation
+	}
+	if bound && (ttl > 0 || st.args.EvictOldestRecordEntries) {
+		// binding the pod to its node renews the entry
+		entry.RecordedAt = metav1.NewTime(st.now())
+	}
+	if bound && st.args.ScopeRecordsByRevision && podRevision(pod) != "" {
+		entry.Revision = podRevision(pod)
+	}
+	if st.args.EnforceNodeEpoch {
+		if epoch := st.currentNodeEpoch(entry.Node); epoch != "" {
+			entry.Epoch = epoch
+		}
+	}
+	if st.args.NodeIdentityLabel != "" {
+		if identity := st.currentNodeIdentity(entry.Node); identity != "" {
+			entry.Identity = identity
+		}
+	}
+	if st.args.TopologyKey != "" {
+		if topology := st.nodeTopology(entry.Node); topology != "" {
+			entry.Zone = topology
+		}
+	}
+	if st.tenureEnabled() && (entry.Since.IsZero() || moved) {
+		// the tenure starts with the first record on the node
+		entry.Since = metav1.NewTime(st.now())
+	}
+	if bound && st.args.ImageLocality != "" {
+		// the image is cached on the node the pod runs on
+		entry.Image = primaryImage(pod)
+	}
+	if moved && len(st.args.FallbackLabelKeys) > 0 {
+		entry.Labels = st.nodeLabelSnapshot(entry.Node)
+	}
+}
+
+// resolveKeyConflict handles a key recorded by another pod according to the key conflict
+// policy, and returns whether the record changed.
+func (st *Stable) resolveKeyConflict(record *ScheduleRecord, key, owner string, pod *v1.Pod, nodeName string) bool {
+	if st.args.KeyConflictPolicy == KeyConflictReject {
+		klog.Warningf("Not recording pod %s/%s on node %s: key %q is already recorded by pod %s",
+			pod.Namespace, pod.Name, nodeName, key, owner)
+		return false
+	}
+	klog.Warningf("Pod %s/%s takes over key %q recorded by pod %s, recording node %s instead of %s",
+		pod.Namespace, pod.Name, key, owner, nodeName, record.Records[key].Node)
+	record.setEntry(key, pod.GetName(), nodeName, RecordSourceAuto)
+	return true
+}
+
+// pruneScheduleRecord removes the records of pods outside the sticky ordinal ranges
+// and returns whether any record was removed.
+func pruneScheduleRecord(record *ScheduleRecord, ranges ordinalRanges) bool {
+	pruned := false
+	for key := range record.Records {
+		if !isStickyOrdinal(ranges, record.ownerOf(key)) {
+			record.deleteEntry(key)
+			pruned = true
+		}
+	}
+	return pruned
+}
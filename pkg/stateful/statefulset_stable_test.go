@@ -3,13 +3,19 @@ package stateful
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
 	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
 	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
 )
@@ -22,6 +28,7 @@ func TestFilter(t *testing.T) {
 	stableSchedule := &Stable{
 		statefulSetLister: statefulsetLister,
 		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
 	}
 	statefulset := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -36,6 +43,20 @@ func TestFilter(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	ordinalStatefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record":   `{"Records":{"db-0":"node1","db-1":"node1"}}`,
+				"statefulset-stable.scheduling.sigs.k8s.io/ordinals": "0",
+			},
+		},
+	}
+	err = statefulsetInformer.Informer().GetIndexer().Add(ordinalStatefulset)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	tests := []struct {
 		name     string
@@ -91,6 +112,54 @@ func TestFilter(t *testing.T) {
 			},
 			expected: framework.Unschedulable,
 		},
+		{
+			name: "pod db-0 is within the sticky ordinals of the statefulset",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "db-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "db",
+						},
+					},
+				},
+			},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node2",
+				},
+			},
+			expected: framework.Unschedulable,
+		},
+		{
+			name: "pod db-1 is outside the sticky ordinals of the statefulset",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "db-1",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "db",
+						},
+					},
+				},
+			},
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node2",
+				},
+			},
+			expected: framework.Success,
+		},
 		{
 			name: "owner references are not statefulset",
 			pod: &corev1.Pod{
@@ -143,6 +212,192 @@ func TestFilter(t *testing.T) {
 	}
 }
 
+func TestFilterWithStickyOrdinals(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	globalOrdinals, err := parseOrdinalRanges("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		stickyOrdinals:    globalOrdinals,
+	}
+	statefulsets := []*appsv1.StatefulSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1","web-1":"node1"}}`,
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record":   `{"Records":{"db-0":"node1","db-1":"node1"}}`,
+					"statefulset-stable.scheduling.sigs.k8s.io/ordinals": "1",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "broken",
+				Namespace: "n1",
+				Annotations: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":`,
+				},
+			},
+		},
+	}
+	for _, statefulset := range statefulsets {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newPod := func(name, owner string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: owner,
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected framework.Code
+	}{
+		{
+			name:     "pod web-0 is within the global sticky ordinals",
+			pod:      newPod("web-0", "web"),
+			expected: framework.Unschedulable,
+		},
+		{
+			name:     "pod web-1 is outside the global sticky ordinals",
+			pod:      newPod("web-1", "web"),
+			expected: framework.Success,
+		},
+		{
+			name:     "the annotation of db overrides the global sticky ordinals, db-0 is not sticky",
+			pod:      newPod("db-0", "db"),
+			expected: framework.Success,
+		},
+		{
+			name:     "the annotation of db overrides the global sticky ordinals, db-1 is sticky",
+			pod:      newPod("db-1", "db"),
+			expected: framework.Unschedulable,
+		},
+		{
+			name:     "a corrupt record is ignored",
+			pod:      newPod("broken-0", "broken"),
+			expected: framework.Success,
+		},
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(node); err != nil {
+				t.Fatal(err)
+			}
+			state := framework.NewCycleState()
+			if status := stableSchedule.PreFilter(context.TODO(), state, tt.pod); !status.IsSuccess() {
+				t.Fatalf("unexpected PreFilter status %v", status.Code())
+			}
+			if _, err := state.Read(preFilterStateKey); err != nil {
+				t.Fatalf("expected PreFilter to write the cycle state: %v", err)
+			}
+			res := stableSchedule.Filter(context.TODO(), state, tt.pod, nodeInfo)
+			if res.Code() != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, res.Code())
+			}
+		})
+	}
+}
+
+func TestFilterUsesPreFilterState(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if stableSchedule.PreFilterExtensions() != nil {
+		t.Error("expected no PreFilter extensions")
+	}
+
+	ctx := context.TODO()
+	state := framework.NewCycleState()
+	if status := stableSchedule.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("unexpected PreFilter status %v", status.Code())
+	}
+	// the statefulset leaves the cache after PreFilter, Filter keeps using the state of the cycle
+	if err := statefulsetInformer.Informer().GetIndexer().Delete(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != framework.Unschedulable {
+		t.Errorf("expected %v from the cycle state, got %v", framework.Unschedulable, code)
+	}
+
+	// without the state of PreFilter, Filter resolves the statefulset itself
+	for name, state := range map[string]*framework.CycleState{"empty state": framework.NewCycleState(), "nil state": nil} {
+		if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != framework.Success {
+			t.Errorf("expected %v with %s once the statefulset is gone, got %v", framework.Success, name, code)
+		}
+	}
+}
+
 func TestPostBind(t *testing.T) {
 	statefulset := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -158,6 +413,7 @@ func TestPostBind(t *testing.T) {
 	stableSchedule := &Stable{
 		statefulSetLister: statefulsetLister,
 		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
 	}
 
 	err := statefulsetInformer.Informer().GetIndexer().Add(statefulset)
@@ -232,3 +488,1594 @@ func TestPostBind(t *testing.T) {
 		})
 	}
 }
+
+func TestPostBindPrunesExcludedOrdinals(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record":   `{"Records":{"web-0":"node1","web-3":"node2"}}`,
+				"statefulset-stable.scheduling.sigs.k8s.io/ordinals": "0-1",
+			},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-1",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	ctx := context.TODO()
+	stableSchedule.PostBind(ctx, nil, pod, "node1")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1","web-1":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestFilterNodeReadiness(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	selector, err := labels.Parse("example.com/initialized=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule := &Stable{
+		statefulSetLister:     statefulsetInformer.Lister(),
+		clientset:             clientset,
+		store:                 newAnnotationStore(clientset),
+		nodeReadinessSelector: selector,
+	}
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	filter := func() framework.Code {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(node); err != nil {
+			t.Fatal(err)
+		}
+		return stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code()
+	}
+	if code := filter(); code != framework.Unschedulable {
+		t.Errorf("expected %v for the recorded node without the readiness label, got %v", framework.Unschedulable, code)
+	}
+	node.Labels = map[string]string{"example.com/initialized": "true"}
+	if code := filter(); code != framework.Success {
+		t.Errorf("expected %v for the recorded node with the readiness label, got %v", framework.Success, code)
+	}
+	node.Labels = map[string]string{"example.com/initialized": "false"}
+	if code := filter(); code != framework.Unschedulable {
+		t.Errorf("expected %v after the readiness label is unset, got %v", framework.Unschedulable, code)
+	}
+}
+
+func TestFilterEnforceAfterReady(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{EnforceAfterReady: true},
+	}
+	replicas := int32(2)
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			UID:       "web-uid",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+		Spec:   appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status: appsv1.StatefulSetStatus{ReadyReplicas: 1},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+		t.Fatal(err)
+	}
+	handler := stableSchedule.readinessEventHandler()
+	handler.OnAdd(statefulset)
+
+	if code := stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code(); code != framework.Success {
+		t.Errorf("expected %v before the statefulset is ready, got %v", framework.Success, code)
+	}
+
+	ready := statefulset.DeepCopy()
+	ready.Status.ReadyReplicas = 2
+	handler.OnUpdate(statefulset, ready)
+	if code := stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code(); code != framework.Unschedulable {
+		t.Errorf("expected %v once the statefulset is ready, got %v", framework.Unschedulable, code)
+	}
+
+	// the rescheduled pod makes the statefulset unready again, the record is still enforced
+	handler.OnUpdate(ready, statefulset)
+	if code := stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code(); code != framework.Unschedulable {
+		t.Errorf("expected %v after the statefulset has been ready, got %v", framework.Unschedulable, code)
+	}
+
+	handler.OnDelete(statefulset)
+	if code := stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code(); code != framework.Success {
+		t.Errorf("expected %v after the statefulset is deleted and recreated unready, got %v", framework.Success, code)
+	}
+}
+
+func TestPostBindKeyConflict(t *testing.T) {
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					"shard": "a",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		expected string
+	}{
+		{
+			name:     "last writer wins",
+			policy:   KeyConflictLastWriterWins,
+			expected: `{"Records":{"shard-a":{"Node":"node2","Owner":"web-1"}}}`,
+		},
+		{
+			name:     "reject",
+			policy:   KeyConflictReject,
+			expected: `{"Records":{"shard-a":{"Node":"node1","Owner":"web-0"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{KeyConflictPolicy: tt.policy},
+				recordKey: func(pod *corev1.Pod) string {
+					return "shard-" + pod.Labels["shard"]
+				},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			stableSchedule.PostBind(ctx, nil, newPod("web-0"), "node1")
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+				t.Fatal(err)
+			}
+			// web-1 maps to the same key as web-0
+			stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+			s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPostBindPlacementHonored(t *testing.T) {
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name               string
+		pod                *corev1.Pod
+		nodeName           string
+		expectedHonored    float64
+		expectedNotHonored float64
+		expectedFirst      float64
+	}{
+		{
+			name:            "bound to the recorded node",
+			pod:             newPod("web-0"),
+			nodeName:        "node1",
+			expectedHonored: 1,
+		},
+		{
+			name:               "bound to another node",
+			pod:                newPod("web-0"),
+			nodeName:           "node2",
+			expectedNotHonored: 1,
+		},
+		{
+			name:          "no recorded node",
+			pod:           newPod("web-1"),
+			nodeName:      "node2",
+			expectedFirst: 1,
+		},
+	}
+
+	RegisterMetrics()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			honored, err := testutil.GetCounterMetricValue(placementsHonored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notHonored, err := testutil.GetCounterMetricValue(placementsNotHonored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, err := testutil.GetCounterMetricValue(firstPlacements)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stableSchedule.PostBind(context.TODO(), nil, tt.pod, tt.nodeName)
+			if got, err := testutil.GetCounterMetricValue(placementsHonored); err != nil || got-honored != tt.expectedHonored {
+				t.Errorf("expected %v honored placements, got %v (%v)", tt.expectedHonored, got-honored, err)
+			}
+			if got, err := testutil.GetCounterMetricValue(placementsNotHonored); err != nil || got-notHonored != tt.expectedNotHonored {
+				t.Errorf("expected %v placements not honored, got %v (%v)", tt.expectedNotHonored, got-notHonored, err)
+			}
+			if got, err := testutil.GetCounterMetricValue(firstPlacements); err != nil || got-first != tt.expectedFirst {
+				t.Errorf("expected %v first placements, got %v (%v)", tt.expectedFirst, got-first, err)
+			}
+		})
+	}
+}
+
+func TestFirstPlacementEmptyRecords(t *testing.T) {
+	for _, record := range []string{`{"Records":{}}`, `{}`, `null`} {
+		t.Run(record, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			first, err := testutil.GetCounterMetricValue(firstPlacements)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != framework.Success {
+				t.Errorf("expected %v, got %v", framework.Success, code)
+			}
+			stableSchedule.PostBind(ctx, state, pod, "node1")
+			if got, err := testutil.GetCounterMetricValue(firstPlacements); err != nil || got-first != 1 {
+				t.Errorf("expected 1 first placement, got %v (%v)", got-first, err)
+			}
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := `{"Records":{"web-0":"node1"}}`
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func TestRecordKeyOrdinal(t *testing.T) {
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		record         string
+		pod            *corev1.Pod
+		node           string
+		expectedCode   framework.Code
+		expectedRecord string
+	}{
+		{
+			name:         "pod recorded by ordinal",
+			record:       `{"Records":{"0":{"Node":"node1","Owner":"web-0"}}}`,
+			pod:          newPod("web-0"),
+			node:         "node2",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:           "pod recorded by name before the record key changed",
+			record:         `{"Records":{"web-0":"node1"}}`,
+			pod:            newPod("web-0"),
+			node:           "node2",
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"0":{"Node":"node2","Owner":"web-0"}}}`,
+		},
+		{
+			name:         "pod without ordinal falls back to its name",
+			record:       `{"Records":{"web":"node1"}}`,
+			pod:          newPod("web"),
+			node:         "node2",
+			expectedCode: framework.Unschedulable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RecordKey: RecordKeyOrdinal},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.node}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, tt.pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedCode != framework.Success {
+				return
+			}
+			stableSchedule.PostBind(ctx, nil, tt.pod, tt.node)
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
+
+func TestRecordIdentityAnnotation(t *testing.T) {
+	newPod := func(name, shard string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+		if shard != "" {
+			pod.Annotations = map[string]string{"example.com/shard": shard}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name           string
+		record         string
+		pod            *corev1.Pod
+		node           string
+		expectedCode   framework.Code
+		expectedRecord string
+	}{
+		{
+			name:         "pod recorded by identity",
+			record:       `{"Records":{"shard-a":{"Node":"node1","Owner":"web-0"}}}`,
+			pod:          newPod("web-0", "shard-a"),
+			node:         "node2",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:           "pod recorded under its identity",
+			record:         `{"Records":{"shard-b":{"Node":"node1","Owner":"web-1"}}}`,
+			pod:            newPod("web-0", "shard-a"),
+			node:           "node2",
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"shard-a":{"Node":"node2","Owner":"web-0"},"shard-b":{"Node":"node1","Owner":"web-1"}}}`,
+		},
+		{
+			name:           "pod recorded by name before the identity was set",
+			record:         `{"Records":{"web-0":"node1"}}`,
+			pod:            newPod("web-0", "shard-a"),
+			node:           "node2",
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"shard-a":{"Node":"node2","Owner":"web-0"}}}`,
+		},
+		{
+			name:         "pod without identity falls back to its name",
+			record:       `{"Records":{"web-0":"node1"}}`,
+			pod:          newPod("web-0", ""),
+			node:         "node2",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:           "pod without identity recorded under its name",
+			record:         `{"Records":{"shard-b":{"Node":"node1","Owner":"web-1"}}}`,
+			pod:            newPod("web-0", ""),
+			node:           "node2",
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"shard-b":{"Node":"node1","Owner":"web-1"},"web-0":"node2"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RecordKey: RecordKeyPodName, IdentityAnnotation: "example.com/shard"},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.node}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, tt.pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedCode != framework.Success {
+				return
+			}
+			stableSchedule.PostBind(ctx, nil, tt.pod, tt.node)
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
+
+func TestRecordGeneration(t *testing.T) {
+	tests := []struct {
+		name           string
+		record         string
+		node           string
+		expectedCode   framework.Code
+		expectedScore  int64
+		expectedRecord string
+	}{
+		{
+			name:         "record of the current generation is enforced",
+			record:       `{"Records":{"web-0":{"Node":"node1","Generation":2}}}`,
+			node:         "node2",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "record without generation is enforced",
+			record:       `{"Records":{"web-0":"node1"}}`,
+			node:         "node2",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:           "record without generation is confirmed for the current generation",
+			record:         `{"Records":{"web-0":"node1"}}`,
+			node:           "node1",
+			expectedCode:   framework.Success,
+			expectedScore:  0,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Generation":2}}}`,
+		},
+		{
+			name:           "record of an older generation is advisory",
+			record:         `{"Records":{"web-0":{"Node":"node1","Generation":1}}}`,
+			node:           "node2",
+			expectedCode:   framework.Success,
+			expectedScore:  0,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node2","Generation":2}}}`,
+		},
+		{
+			name:           "recorded node of an older generation is preferred",
+			record:         `{"Records":{"web-0":{"Node":"node1","Generation":1}}}`,
+			node:           "node1",
+			expectedCode:   framework.Success,
+			expectedScore:  framework.MaxNodeScore,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node1","Generation":2}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "web",
+					Namespace:  "n1",
+					Generation: 2,
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.node}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedCode != framework.Success {
+				return
+			}
+			score, status := stableSchedule.Score(ctx, state, pod, tt.node)
+			if !status.IsSuccess() {
+				t.Fatal(status.Message())
+			}
+			if score != tt.expectedScore {
+				t.Errorf("expected score %v, got %v", tt.expectedScore, score)
+			}
+			stableSchedule.PostBind(ctx, state, pod, tt.node)
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
+
+func TestPostBindRecordUpdatePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		expected string
+	}{
+		{
+			name:     "immutable record keeps the recorded node",
+			policy:   RecordUpdateImmutable,
+			expected: `{"Records":{"web-0":"node1"}}`,
+		},
+		{
+			name:     "mutable record follows the pod",
+			policy:   RecordUpdateMutable,
+			expected: `{"Records":{"web-0":"node2"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				// the statefulset has never been ready, so the record isn't enforced
+				args: StableArgs{EnforceAfterReady: true, RecordUpdatePolicy: tt.policy},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, pod, nodeInfo).Code(); code != framework.Success {
+				t.Fatalf("expected %v, got %v", framework.Success, code)
+			}
+			stableSchedule.PostBind(ctx, nil, pod, "node2")
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterNodeNameMatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		recorded     string
+		match        string
+		domainSuffix string
+		nodeName     string
+		expectedCode framework.Code
+	}{
+		{
+			name:         "exact match",
+			recorded:     "node1",
+			match:        NodeNameMatchExact,
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "trailing whitespace with exact match",
+			recorded:     "node1 ",
+			match:        NodeNameMatchExact,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "trailing whitespace with trim",
+			recorded:     " node1 ",
+			match:        NodeNameMatchTrim,
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "case difference with trim",
+			recorded:     "Node1",
+			match:        NodeNameMatchTrim,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "case difference and whitespace ignoring case",
+			recorded:     " Node1\t",
+			match:        NodeNameMatchTrimIgnoreCase,
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "other node ignoring case",
+			recorded:     "Node2",
+			match:        NodeNameMatchTrimIgnoreCase,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "fully qualified record without domain suffix",
+			recorded:     "node1.example.internal",
+			match:        NodeNameMatchExact,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "fully qualified record of a short node name",
+			recorded:     "node1.example.internal",
+			match:        NodeNameMatchExact,
+			domainSuffix: "example.internal",
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "short record of a fully qualified node name",
+			recorded:     "node1",
+			match:        NodeNameMatchExact,
+			domainSuffix: ".example.internal",
+			nodeName:     "node1.example.internal",
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "fully qualified record of another domain",
+			recorded:     "node1.example.com",
+			match:        NodeNameMatchExact,
+			domainSuffix: "example.internal",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "fully qualified record of another short node name",
+			recorded:     "node2.example.internal",
+			match:        NodeNameMatchExact,
+			domainSuffix: "example.internal",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "fully qualified record with case difference",
+			recorded:     "Node1.example.internal ",
+			match:        NodeNameMatchTrimIgnoreCase,
+			domainSuffix: "example.internal",
+			expectedCode: framework.Success,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"` + strings.Replace(tt.recorded, "\t", `\t`, -1) + `"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{NodeNameMatch: tt.match, NodeDomainSuffix: tt.domainSuffix},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			nodeName := tt.nodeName
+			if nodeName == "" {
+				nodeName = "node1"
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(context.TODO(), nil, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestTerminatingStatefulSet(t *testing.T) {
+	deletionTimestamp := metav1.Now()
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "web",
+			Namespace:         "n1",
+			DeletionTimestamp: &deletionTimestamp,
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+
+	ctx := context.TODO()
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if code := stableSchedule.Filter(ctx, nil, newPod("web-0"), nodeInfo).Code(); code != framework.Success {
+		t.Errorf("expected %v for a terminating statefulset, got %v", framework.Success, code)
+	}
+
+	stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	stableSchedule.terminatingEventHandler().OnUpdate(statefulset, s)
+	s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; ok {
+		t.Errorf("expected the record to be deleted, got %v", got)
+	}
+}
+
+func TestReturnGraceWindow(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	fakeClock := clock.NewFakeClock(time.Now())
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{RecordUpdatePolicy: RecordUpdateMutable, ReturnGraceSeconds: 600},
+		clock:             fakeClock,
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	ctx := context.TODO()
+	refresh := func() *ScheduleRecord {
+		s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+			t.Fatal(err)
+		}
+		record, err := getScheduleRecord(s, StatefulsetStableRecord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+	check := func(expectedCodes map[string]framework.Code, expectedScores map[string]int64) {
+		t.Helper()
+		for nodeName, expected := range expectedCodes {
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, pod, nodeInfo).Code(); code != expected {
+				t.Errorf("expected %v on %s, got %v", expected, nodeName, code)
+			}
+			score, status := stableSchedule.Score(ctx, nil, pod, nodeName)
+			if !status.IsSuccess() {
+				t.Fatal(status.Message())
+			}
+			if score != expectedScores[nodeName] {
+				t.Errorf("expected score %v on %s, got %v", expectedScores[nodeName], nodeName, score)
+			}
+		}
+	}
+
+	// the pod is relocated to node2, node1 is kept as its return node
+	stableSchedule.PostBind(ctx, nil, pod, "node2")
+	record := refresh()
+	if ret := record.Records["web-0"].Return; record.Records["web-0"].Node != "node2" || ret == nil || ret.Node != "node1" {
+		t.Fatalf("expected web-0 on node2 with return node node1, got %+v", record)
+	}
+	fakeClock.Step(300 * time.Second)
+	check(map[string]framework.Code{"node1": framework.Success, "node2": framework.Success, "node3": framework.Unschedulable},
+		map[string]int64{"node1": framework.MaxNodeScore, "node2": 0, "node3": 0})
+
+	// the pod returns to node1 within the window
+	stableSchedule.PostBind(ctx, nil, pod, "node1")
+	record = refresh()
+	if record.Records["web-0"].Node != "node1" || record.Records["web-0"].Return != nil {
+		t.Fatalf("expected web-0 back on node1 without return node, got %+v", record)
+	}
+
+	// relocated again, the window ends before the pod returns
+	stableSchedule.PostBind(ctx, nil, pod, "node2")
+	refresh()
+	fakeClock.Step(601 * time.Second)
+	check(map[string]framework.Code{"node1": framework.Unschedulable, "node2": framework.Success},
+		map[string]int64{"node1": 0, "node2": 0})
+}
+
+func TestCreateByStatefulsetNamespace(t *testing.T) {
+	statefulsets := []*appsv1.StatefulSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "uid-n1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n2", UID: "uid-n2"}},
+	}
+	tests := []struct {
+		name      string
+		namespace string
+		ownerUID  types.UID
+		expected  types.UID
+	}{
+		{
+			name:      "owner in the pod namespace",
+			namespace: "n2",
+			ownerUID:  "uid-n2",
+			expected:  "uid-n2",
+		},
+		{
+			name:      "owner without UID",
+			namespace: "n1",
+			expected:  "uid-n1",
+		},
+		{
+			name:      "cross-namespace owner is ignored",
+			namespace: "n2",
+			ownerUID:  "uid-n1",
+		},
+		{
+			name:      "statefulset missing from the pod namespace",
+			namespace: "n3",
+			ownerUID:  "uid-n1",
+		},
+		{
+			name:     "pod without namespace",
+			ownerUID: "uid-n1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			for _, statefulset := range statefulsets {
+				if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stableSchedule := &Stable{statefulSetLister: statefulsetInformer.Lister()}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: tt.namespace,
+					OwnerReferences: []metav1.OwnerReference{
+						{Kind: Kind, Name: "web", UID: tt.ownerUID},
+					},
+				},
+			}
+			statefulset := stableSchedule.createByStatefulset(pod)
+			if tt.expected == "" {
+				if statefulset != nil {
+					t.Errorf("expected no statefulset, got %s/%s", statefulset.Namespace, statefulset.Name)
+				}
+				return
+			}
+			if statefulset == nil {
+				t.Fatalf("expected statefulset with UID %s, got none", tt.expected)
+			}
+			if statefulset.Namespace != tt.namespace || statefulset.UID != tt.expected {
+				t.Errorf("expected %s/web with UID %s, got %s/%s with UID %s", tt.namespace, tt.expected, statefulset.Namespace, statefulset.Name, statefulset.UID)
+			}
+		})
+	}
+}
+
+func TestCreateByStatefulsetOwnerReferences(t *testing.T) {
+	statefulsets := []*appsv1.StatefulSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "uid-web"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "adopter", Namespace: "n1", UID: "uid-adopter"}},
+	}
+	tests := []struct {
+		name     string
+		owners   []metav1.OwnerReference
+		expected string
+	}{
+		{
+			name:     "statefulset is the only owner",
+			owners:   []metav1.OwnerReference{{Kind: Kind, Name: "web", UID: "uid-web"}},
+			expected: "web",
+		},
+		{
+			name: "statefulset is not the first owner",
+			owners: []metav1.OwnerReference{
+				{APIVersion: "example.com/v1", Kind: "Adopter", Name: "adopter", UID: "uid-other"},
+				{Kind: Kind, Name: "web", UID: "uid-web"},
+			},
+			expected: "web",
+		},
+		{
+			name: "no statefulset owner",
+			owners: []metav1.OwnerReference{
+				{APIVersion: "example.com/v1", Kind: "Adopter", Name: "adopter", UID: "uid-other"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			for _, statefulset := range statefulsets {
+				if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stableSchedule := &Stable{statefulSetLister: statefulsetInformer.Lister()}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "web-0",
+					Namespace:       "n1",
+					OwnerReferences: tt.owners,
+				},
+			}
+			statefulset := stableSchedule.createByStatefulset(pod)
+			if tt.expected == "" {
+				if statefulset != nil {
+					t.Errorf("expected no statefulset, got %s/%s", statefulset.Namespace, statefulset.Name)
+				}
+				return
+			}
+			if statefulset == nil || statefulset.Name != tt.expected {
+				t.Errorf("expected statefulset %s, got %v", tt.expected, statefulset)
+			}
+		})
+	}
+}
+
+func TestContainStatefulsetStableLabel(t *testing.T) {
+	tests := []struct {
+		value           string
+		strictExpected  bool
+		lenientExpected bool
+	}{
+		{value: "true", strictExpected: true, lenientExpected: true},
+		{value: "True", lenientExpected: true},
+		{value: "TRUE", lenientExpected: true},
+		{value: "1", lenientExpected: true},
+		{value: "t", lenientExpected: true},
+		{value: "yes", lenientExpected: true},
+		{value: "Y", lenientExpected: true},
+		{value: "On", lenientExpected: true},
+		{value: "enabled", lenientExpected: true},
+		{value: "false"},
+		{value: "0"},
+		{value: "no"},
+		{value: "disabled"},
+		{value: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{StatefulsetStable: tt.value}}}
+			if got := containStatefulsetStableLabel(pod, StatefulsetStable, false); got != tt.strictExpected {
+				t.Errorf("strict: expected %v, got %v", tt.strictExpected, got)
+			}
+			if got := containStatefulsetStableLabel(pod, StatefulsetStable, true); got != tt.lenientExpected {
+				t.Errorf("lenient: expected %v, got %v", tt.lenientExpected, got)
+			}
+		})
+	}
+	if containStatefulsetStableLabel(&corev1.Pod{}, StatefulsetStable, true) {
+		t.Errorf("expected a pod without labels not to opt in")
+	}
+}
+
+func TestIsEligible(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		lenient  bool
+		expected bool
+	}{
+		{
+			name: "nil pod",
+		},
+		{
+			name: "pod without labels and annotations",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1"}},
+		},
+		{
+			name:    "pod without labels and annotations with lenient opt-in",
+			pod:     &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1"}},
+			lenient: true,
+		},
+		{
+			name: "pod with annotations only",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1",
+				Annotations: map[string]string{StatefulsetStable: "true"}}},
+		},
+		{
+			name: "pod with labels and without annotations",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1",
+				Labels: map[string]string{StatefulsetStable: "true"}}},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := StableArgs{OptInLabelValue: OptInLabelValueStrict}
+			if tt.lenient {
+				args.OptInLabelValue = OptInLabelValueLenient
+			}
+			if got := isEligible(tt.pod, args); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsEligibleLabelKey(t *testing.T) {
+	const customKey = "example.com/sticky"
+	tests := []struct {
+		name     string
+		labelKey string
+		labels   map[string]string
+		expected bool
+	}{
+		{
+			name:     "default key",
+			labels:   map[string]string{StatefulsetStable: "true"},
+			expected: true,
+		},
+		{
+			name:     "custom key",
+			labelKey: customKey,
+			labels:   map[string]string{customKey: "true"},
+			expected: true,
+		},
+		{
+			name:     "default key is ignored with a custom key",
+			labelKey: customKey,
+			labels:   map[string]string{StatefulsetStable: "true"},
+		},
+		{
+			name:   "custom key is ignored without configuring it",
+			labels: map[string]string{customKey: "true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1", Labels: tt.labels}}
+			args := StableArgs{LabelKey: tt.labelKey, OptInLabelValue: OptInLabelValueStrict}
+			if got := isEligible(pod, args); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsStableEnabled(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		lenient     bool
+		expected    bool
+	}{
+		{
+			name:     "pod label only",
+			labels:   map[string]string{StatefulsetStable: "true"},
+			expected: true,
+		},
+		{
+			name:        "statefulset annotation only",
+			annotations: map[string]string{StatefulsetStableEnabled: "true"},
+			expected:    true,
+		},
+		{
+			name:        "pod label and statefulset annotation",
+			labels:      map[string]string{StatefulsetStable: "true"},
+			annotations: map[string]string{StatefulsetStableEnabled: "true"},
+			expected:    true,
+		},
+		{
+			name: "neither",
+		},
+		{
+			name:        "statefulset annotation set to false",
+			annotations: map[string]string{StatefulsetStableEnabled: "false"},
+		},
+		{
+			name:        "lenient statefulset annotation in strict mode",
+			annotations: map[string]string{StatefulsetStableEnabled: "yes"},
+		},
+		{
+			name:        "lenient statefulset annotation",
+			annotations: map[string]string{StatefulsetStableEnabled: "yes"},
+			lenient:     true,
+			expected:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "web-uid", Annotations: tt.annotations},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels:    tt.labels,
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+							UID:  "web-uid",
+						},
+					},
+				},
+			}
+			args := StableArgs{OptInLabelValue: OptInLabelValueStrict}
+			if tt.lenient {
+				args.OptInLabelValue = OptInLabelValueLenient
+			}
+			if got := isStableEnabled(pod, statefulset, args); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+
+			// the plugin looks up the statefulset of the pod
+			informers := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			stableSchedule := &Stable{statefulSetLister: statefulsetInformer.Lister(), args: args}
+			if got := stableSchedule.eligible(pod); got != tt.expected {
+				t.Errorf("expected the plugin to decide %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNilLabelsAndAnnotations(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	owners := []metav1.OwnerReference{
+		{
+			Kind: "StatefulSet",
+			Name: "web",
+		},
+	}
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		expectedCode framework.Code
+		expectedKey  string
+	}{
+		{
+			name:         "pod without labels and annotations",
+			pod:          &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1", OwnerReferences: owners}},
+			expectedCode: framework.Success,
+			expectedKey:  "web-0",
+		},
+		{
+			name: "eligible pod without annotations",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1", OwnerReferences: owners,
+				Labels: map[string]string{StatefulsetStable: "true"}}},
+			expectedCode: framework.Unschedulable,
+			expectedKey:  "web-0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				// every path reading the labels or annotations of the pod is enabled
+				args: StableArgs{
+					OptInLabelValue:        OptInLabelValueLenient,
+					IdentityAnnotation:     "example.com/shard",
+					ScopeRecordsByRevision: true,
+					DiagnosePod:            "n1/web-1",
+					SiblingPlacement:       SiblingPlacementSpread,
+					ImageLocality:          ImageLocalityPreferred,
+				},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			if code := stableSchedule.PreFilter(ctx, state, tt.pod).Code(); code != framework.Success {
+				t.Errorf("expected PreFilter to succeed, got %v", code)
+			}
+			if code := stableSchedule.Filter(ctx, state, tt.pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if _, status := stableSchedule.Score(ctx, state, tt.pod, "node2"); !status.IsSuccess() {
+				t.Errorf("expected Score to succeed, got %v", status.Message())
+			}
+			if decision := EvaluateAgainst(map[string]string{"web-0": "node1"}, tt.pod, "node2", stableSchedule.args); decision.Allowed != (tt.expectedCode == framework.Success) {
+				t.Errorf("expected EvaluateAgainst to agree with Filter, got %+v", decision)
+			}
+			if key := stableSchedule.keyOf(tt.pod); key != tt.expectedKey {
+				t.Errorf("expected key %s, got %s", tt.expectedKey, key)
+			}
+			if stableSchedule.diagnosed(tt.pod) || podRevision(tt.pod) != "" {
+				t.Errorf("expected a pod without annotations and labels to be neither diagnosed nor of a revision")
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// RecordStore persists the schedule records of statefulsets.
+type RecordStore interface {
+	// Get returns the schedule record of the statefulset, nil if it has none.
+	Get(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error)
+	// GetLatest returns the schedule record of the statefulset read from the API server instead
+	// of a cache, nil if it has none.
+	GetLatest(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error)
+	// Set replaces the schedule record of the statefulset.
+	Set(ctx context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) error
+	// Delete removes the schedule record of the statefulset, if any.
+	Delete(ctx context.Context, statefulset *appsv1.StatefulSet) error
+}
+
+// annotationStore keeps the schedule record in an annotation of the statefulset.
+type annotationStore struct {
+	clientset clientset.Interface
+	// key is the key of the record annotation, StatefulsetStableRecord when empty.
+	key string
+	// compress writes the record compressed, see encodeScheduleRecord.
+	compress bool
+}
+
+var _ RecordStore = &annotationStore{}
+
+func newAnnotationStore(clientset clientset.Interface) *annotationStore {
+	return &annotationStore{clientset: clientset}
+}
+
+// Get reads the record from the given statefulset object, usually from the lister cache.
+func (s *annotationStore) Get(_ context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	return getScheduleRecord(statefulset, s.annotationKey())
+}
+
+// GetLatest reads the record from the statefulset read from the API server.
+func (s *annotationStore) GetLatest(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	latest, err := s.clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return getScheduleRecord(latest, s.annotationKey())
+}
+
+// annotationKey returns the key of the record annotation.
+func (s *annotationStore) annotationKey() string {
+	if s.key != "" {
+		return s.key
+	}
+	return StatefulsetStableRecord
+}
+
+// Set patches the record annotation of the statefulset. The copies of multiStore overwrite the
+// annotation whatever it holds.
+func (s *annotationStore) Set(ctx context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) error {
+	rec, err := encodeScheduleRecord(record, s.compress)
+	if err != nil {
+		return err
+	}
+	if record.overwrite {
+		return s.mergePatchRecord(ctx, statefulset, rec, "")
+	}
+	return s.patchRecord(ctx, statefulset, rec)
+}
+
+// Delete removes the record annotation from the statefulset. The annotation is gone with
+// a deleted statefulset, so the NotFound error of a deleted statefulset can be ignored.
+func (s *annotationStore) Delete(ctx context.Context, statefulset *appsv1.StatefulSet) error {
+	if _, ok := statefulset.GetAnnotations()[s.annotationKey()]; !ok {
+		return nil
+	}
+	return s.patchRecord(ctx, statefulset, nil)
+}
+
+// patchRecord sets the record annotation of the statefulset, a nil value removes it. Unlike an
+// update of the whole statefulset, the patch can't revert a concurrent change of another field.
+// The records are read from the cache, so the patch is conditioned on the record they were read
+// from: a JSON patch tests that the annotation still holds its value in the cached statefulset,
+// and a record written meanwhile fails the patch with a conflict instead of losing its entries.
+// Other changes of the statefulset, e.g. the status updates of its controller, don't fail it.
+// The absence of a first record can't be tested, its merge patch keeps the resourceVersion of
+// the cached statefulset as the precondition instead.
+func (s *annotationStore) patchRecord(ctx context.Context, statefulset *appsv1.StatefulSet, value interface{}) error {
+	key := s.annotationKey()
+	stored, ok := statefulset.GetAnnotations()[key]
+	if !ok {
+		return s.mergePatchRecord(ctx, statefulset, value, statefulset.ResourceVersion)
+	}
+	path := "/metadata/annotations/" + jsonPointerEscaper.Replace(key)
+	operations := []map[string]interface{}{{"op": "test", "path": path, "value": stored}}
+	if value == nil {
+		operations = append(operations, map[string]interface{}{"op": "remove", "path": path})
+	} else {
+		operations = append(operations, map[string]interface{}{"op": "replace", "path": path, "value": value})
+	}
+	patch, err := json.Marshal(operations)
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.AppsV1().StatefulSets(statefulset.Namespace).Patch(ctx, statefulset.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	if err == nil || errors.IsNotFound(err) {
+		return err
+	}
+	// a failed test is not reported as a conflict, tell it apart by the latest record
+	latest, getErr := s.clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return err
+	}
+	if current, ok := latest.Annotations[key]; !ok || current != stored {
+		return errors.NewConflict(appsv1.Resource("statefulsets"), statefulset.Name,
+			fmt.Errorf("the schedule record has been modified"))
+	}
+	return err
+}
+
+// mergePatchRecord sets the record annotation of the statefulset with a JSON merge patch, a nil
+// value removes it. A non-empty resourceVersion is kept as the precondition of the patch.
+func (s *annotationStore) mergePatchRecord(ctx context.Context, statefulset *appsv1.StatefulSet, value interface{}, resourceVersion string) error {
+	metadata := map[string]interface{}{
+		"annotations": map[string]interface{}{s.annotationKey(): value},
+	}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.AppsV1().StatefulSets(statefulset.Namespace).Patch(ctx, statefulset.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// jsonPointerEscaper escapes an annotation key as a JSON pointer reference token, RFC 6901.
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// hasStoreType checks whether the store types include the store type.
+func hasStoreType(storeTypes []string, storeType string) bool {
+	for _, t := range storeTypes {
+		if t == storeType {
+			return true
+		}
+	}
+	return false
+}
+
+// multiStore reads the records from the primary store and writes them to every store,
+// which allows migrating records between backends.
+type multiStore struct {
+	primary     RecordStore
+	secondaries []RecordStore
+}
+
+var _ RecordStore = &multiStore{}
+
+func newMultiStore(primary RecordStore, secondaries ...RecordStore) *multiStore {
+	return &multiStore{primary: primary, secondaries: secondaries}
+}
+
+// Get reads the record from the primary store only.
+func (s *multiStore) Get(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	return s.primary.Get(ctx, statefulset)
+}
+
+// GetLatest reads the latest record from the primary store only.
+func (s *multiStore) GetLatest(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	return s.primary.GetLatest(ctx, statefulset)
+}
+
+// Set writes the record to the primary store first, a failure there is returned without
+// touching the secondary stores. Failures of secondary stores are aggregated. The record was
+// read from the primary store only, so the secondary stores copy it whatever they hold.
+func (s *multiStore) Set(ctx context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) error {
+	if err := s.primary.Set(ctx, statefulset, record); err != nil {
+		return err
+	}
+	mirror := *record
+	mirror.overwrite = true
+	var errs []error
+	for _, store := range s.secondaries {
+		if err := store.Set(ctx, statefulset, &mirror); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Delete removes the record from every store, starting with the primary store like Set.
+func (s *multiStore) Delete(ctx context.Context, statefulset *appsv1.StatefulSet) error {
+	if err := s.primary.Delete(ctx, statefulset); err != nil {
+		return err
+	}
+	var errs []error
+	for _, store := range s.secondaries {
+		if err := store.Delete(ctx, statefulset); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// leafStores returns the stores the store writes the records to, the primary and secondary
+// stores of a multiStore.
+func leafStores(store RecordStore) []RecordStore {
+	multi, ok := store.(*multiStore)
+	if !ok {
+		return []RecordStore{store}
+	}
+	stores := leafStores(multi.primary)
+	for _, secondary := range multi.secondaries {
+		stores = append(stores, leafStores(secondary)...)
+	}
+	return stores
+}
+
+// outlivingStores returns the stores of the store whose records outlive a deleted statefulset:
+// all but the annotation stores, whose records are gone with the statefulset.
+func outlivingStores(store RecordStore) []RecordStore {
+	var stores []RecordStore
+	for _, leaf := range leafStores(store) {
+		if _, ok := leaf.(*annotationStore); !ok {
+			stores = append(stores, leaf)
+		}
+	}
+	return stores
+}
+
+// consistent checks whether every secondary store holds the same record as the primary store.
+func (s *multiStore) consistent(ctx context.Context, statefulset *appsv1.StatefulSet) (bool, error) {
+	expected, err := s.primary.Get(ctx, statefulset)
+	if err != nil {
+		return false, err
+	}
+	for _, store := range s.secondaries {
+		record, err := store.Get(ctx, statefulset)
+		if err != nil {
+			return false, err
+		}
+		if !equalScheduleRecords(expected, record) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// equalScheduleRecords compares the nodes of the entries of the records, it treats a nil record
+// and a record without entries as equal.
+func equalScheduleRecords(a, b *ScheduleRecord) bool {
+	if a == nil || len(a.Records) == 0 {
+		return b == nil || len(b.Records) == 0
+	}
+	return b != nil && reflect.DeepEqual(a.nodes(), b.nodes())
+}
+
+// storeCompareInterval is the interval between two comparisons of the record stores.
+const storeCompareInterval = time.Minute
+
+// compareStores compares the records of every statefulset across the record stores and
+// reports the number of statefulsets with diverging records.
+func (st *Stable) compareStores(ctx context.Context, store *multiStore) {
+	statefulsets, err := st.statefulSetLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list statefulsets to compare the record stores: %v", err)
+		return
+	}
+	inconsistent := 0
+	for _, statefulset := range statefulsets {
+		consistent, err := store.consistent(ctx, statefulset)
+		if err != nil {
+			klog.V(3).Infof("Failed to compare the records of statefulset %s/%s: %v", statefulset.Namespace, statefulset.Name, err)
+			continue
+		}
+		if !consistent {
+			klog.V(3).Infof("The records of statefulset %s/%s differ between the record stores", statefulset.Namespace, statefulset.Name)
+			inconsistent++
+		}
+	}
+	storeInconsistentStatefulSets.Set(float64(inconsistent))
+}
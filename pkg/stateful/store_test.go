@@ -0,0 +1,424 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// memoryStore is an in-memory RecordStore keyed by statefulset namespace/name.
+type memoryStore struct {
+	sync.Mutex
+	records map[string]*ScheduleRecord
+	err     error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]*ScheduleRecord)}
+}
+
+func (s *memoryStore) Get(_ context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	key, _ := cache.MetaNamespaceKeyFunc(statefulset)
+	record, ok := s.records[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := &ScheduleRecord{Records: make(map[string]RecordEntry, len(record.Records))}
+	for key, entry := range record.Records {
+		copied.Records[key] = entry
+	}
+	return copied, nil
+}
+
+func (s *memoryStore) GetLatest(ctx context.Context, statefulset *appsv1.StatefulSet) (*ScheduleRecord, error) {
+	return s.Get(ctx, statefulset)
+}
+
+func (s *memoryStore) Set(_ context.Context, statefulset *appsv1.StatefulSet, record *ScheduleRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	key, _ := cache.MetaNamespaceKeyFunc(statefulset)
+	s.records[key] = record
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, statefulset *appsv1.StatefulSet) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	key, _ := cache.MetaNamespaceKeyFunc(statefulset)
+	delete(s.records, key)
+	return nil
+}
+
+func TestAnnotationStore(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	store := newAnnotationStore(clientset)
+	get := func() *appsv1.StatefulSet {
+		s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if record, err := store.Get(ctx, statefulset); err != nil || record != nil {
+		t.Fatalf("expected no record, got %v (%v)", record, err)
+	}
+	if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := get().Annotations[StatefulsetStableRecord]; got != expected {
+		t.Errorf("expected annotation %v, got %v", expected, got)
+	}
+	record, err := store.Get(ctx, get())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record.Records, map[string]RecordEntry{"web-0": {Node: "node1"}}) {
+		t.Errorf("expected the written record, got %v", record.Records)
+	}
+
+	if err := store.Delete(ctx, get()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := get().Annotations[StatefulsetStableRecord]; ok {
+		t.Error("expected the record annotation to be removed")
+	}
+	// deleting a statefulset without a record is a no-op
+	if err := store.Delete(ctx, get()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestAnnotationStoreKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		expectedKey string
+	}{
+		{
+			name:        "default key",
+			expectedKey: StatefulsetStableRecord,
+		},
+		{
+			name:        "custom key",
+			key:         "example.com/record",
+			expectedKey: "example.com/record",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.TODO()
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						// the record of another profile is ignored
+						"example.com/other-record": `{"Records":{"web-1":"node2"}}`,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			args := defaultStableArgs()
+			args.RecordAnnotationKey = test.key
+			st, err := NewWithOptions(WithArgs(args), WithClientSet(clientset),
+				WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if record, err := st.store.Get(ctx, statefulset); err != nil || record != nil {
+				t.Fatalf("expected no record, got %v (%v)", record, err)
+			}
+			if err := st.store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, expected := s.Annotations[test.expectedKey], `{"Records":{"web-0":"node1"}}`; got != expected {
+				t.Errorf("expected annotation %s=%v, got %v", test.expectedKey, expected, got)
+			}
+			record, err := st.store.Get(ctx, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record == nil || !reflect.DeepEqual(record.Records, map[string]RecordEntry{"web-0": {Node: "node1"}}) {
+				t.Errorf("expected the written record, got %v", record)
+			}
+			if handler, ok := st.reconcileEventHandler().(cache.FilteringResourceEventHandler); !ok || !handler.FilterFunc(s) {
+				t.Errorf("expected the statefulset with a record under %s to be reconciled", test.expectedKey)
+			}
+		})
+	}
+}
+
+func TestAnnotationStorePatchesOnlyTheRecord(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       "n1",
+			ResourceVersion: "42",
+			Annotations:     map[string]string{"example.com/owner": "team-a"},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	store := newAnnotationStore(clientset)
+
+	if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	withRecord, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, withRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first record is conditioned on the resourceVersion, later ones on the record
+	expectedTypes := []types.PatchType{types.MergePatchType, types.JSONPatchType}
+	expected := []string{
+		`{"metadata":{"annotations":{"statefulset-stable.scheduling.sigs.k8s.io/record":"{\"Records\":{\"web-0\":\"node1\"}}"},"resourceVersion":"42"}}`,
+		`[{"op":"test","path":"/metadata/annotations/statefulset-stable.scheduling.sigs.k8s.io~1record","value":"{\"Records\":{\"web-0\":\"node1\"}}"},` +
+			`{"op":"remove","path":"/metadata/annotations/statefulset-stable.scheduling.sigs.k8s.io~1record"}]`,
+	}
+	var patchTypes []types.PatchType
+	var patches []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("expected no update of the statefulset, got %v", action)
+		}
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			patchTypes = append(patchTypes, patch.GetPatchType())
+			patches = append(patches, string(patch.GetPatch()))
+		}
+	}
+	if !reflect.DeepEqual(patchTypes, expectedTypes) {
+		t.Errorf("expected patch types %v, got %v", expectedTypes, patchTypes)
+	}
+	if !reflect.DeepEqual(patches, expected) {
+		t.Errorf("expected patches %v, got %v", expected, patches)
+	}
+
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"example.com/owner": "team-a"}; !reflect.DeepEqual(s.Annotations, expected) {
+		t.Errorf("expected the other annotations to be kept, got %v", s.Annotations)
+	}
+}
+
+func TestAnnotationStoreRecordPrecondition(t *testing.T) {
+	ctx := context.TODO()
+	cached := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       "n1",
+			ResourceVersion: "1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(cached)
+	store := newAnnotationStore(clientset)
+
+	// a status update of the controller doesn't fail the write of the cached record
+	live := cached.DeepCopy()
+	live.ResourceVersion = "2"
+	live.Status.ReadyReplicas = 1
+	if _, err := clientset.AppsV1().StatefulSets("n1").Update(ctx, live, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, cached, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}}}); err != nil {
+		t.Fatalf("expected the record to be written, got %v", err)
+	}
+
+	// the record written meanwhile is not lost
+	if err := store.Set(ctx, cached, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node3"}}}); !apierrors.IsConflict(err) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if err := store.Delete(ctx, cached); !apierrors.IsConflict(err) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1","web-1":"node2"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMultiStore(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}}
+	record := &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}
+
+	primary, secondary1, secondary2 := newMemoryStore(), newMemoryStore(), newMemoryStore()
+	store := newMultiStore(primary, secondary1, secondary2)
+	if err := store.Set(ctx, statefulset, record); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []*memoryStore{primary, secondary1, secondary2} {
+		got, err := s.Get(ctx, statefulset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(record, got) {
+			t.Errorf("store %d: expected %v, got %v", i, record, got)
+		}
+	}
+
+	// reads only come from the primary store
+	secondary1.records["n1/web"] = &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node2"}}}
+	got, err := store.Get(ctx, statefulset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record, got) {
+		t.Errorf("expected %v, got %v", record, got)
+	}
+
+	// a primary failure is not written to the secondary stores
+	primary.err = errors.New("unavailable")
+	if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node3"}}}); err == nil {
+		t.Error("expected an error when the primary store fails")
+	}
+	if got := secondary2.records["n1/web"].Records["web-0"].Node; got != "node1" {
+		t.Errorf("expected the secondary store to keep node1, got %v", got)
+	}
+}
+
+func TestSecondaryStores(t *testing.T) {
+	ctx := context.TODO()
+	statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", UID: "web-uid"}}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	args := defaultStableArgs()
+	args.StoreType = StoreTypeConfigMap
+	args.SecondaryStores = []string{StoreTypeAnnotation}
+	stableSchedule, err := NewWithOptions(
+		WithArgs(args),
+		WithClientSet(clientset),
+		WithStatefulSetLister(informers.Apps().V1().StatefulSets().Lister()),
+		WithConfigMapLister(informers.Core().V1().ConfigMaps().Lister()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, ok := stableSchedule.store.(*multiStore)
+	if !ok {
+		t.Fatalf("expected a multiStore, got %T", stableSchedule.store)
+	}
+	if _, ok := store.primary.(*configMapStore); !ok {
+		t.Errorf("expected the records to be read from the ConfigMap store, got %T", store.primary)
+	}
+
+	if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("n1").Get(ctx, "web-schedule-record", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":"node1"}}`
+	if got := configMap.Data["record"]; got != expected {
+		t.Errorf("expected the ConfigMap record %s, got %s", expected, got)
+	}
+	s, err := clientset.AppsV1().StatefulSets("n1").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected the record annotation %s, got %s", expected, got)
+	}
+}
+
+func TestCompareStores(t *testing.T) {
+	RegisterMetrics()
+	ctx := context.TODO()
+	statefulsets := []*appsv1.StatefulSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "n1"}},
+	}
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	for _, statefulset := range statefulsets {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	primary, secondary := newMemoryStore(), newMemoryStore()
+	store := newMultiStore(primary, secondary)
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		store:             store,
+	}
+	for _, statefulset := range statefulsets {
+		if err := store.Set(ctx, statefulset, &ScheduleRecord{Records: map[string]RecordEntry{statefulset.Name + "-0": {Node: "node1"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stableSchedule.compareStores(ctx, store)
+	if got, err := testutil.GetGaugeMetricValue(storeInconsistentStatefulSets); err != nil || got != 0 {
+		t.Errorf("expected 0 inconsistent statefulsets, got %v (%v)", got, err)
+	}
+
+	secondary.records["n1/db"] = &ScheduleRecord{Records: map[string]RecordEntry{"db-0": {Node: "node2"}}}
+	stableSchedule.compareStores(ctx, store)
+	if got, err := testutil.GetGaugeMetricValue(storeInconsistentStatefulSets); err != nil || got != 1 {
+		t.Errorf("expected 1 inconsistent statefulset, got %v (%v)", got, err)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// stuckPodsCheckInterval is the interval between two checks for stuck pods.
+const stuckPodsCheckInterval = 30 * time.Second
+
+// newEventRecorder returns a recorder for the events of the plugin. The scheduler framework
+// doesn't hand an event recorder to plugins yet.
+func newEventRecorder(clientset typedcorev1.EventsGetter) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: Name})
+}
+
+// checkStuckPods counts the pending pods pinned to a recorded node for longer than
+// StuckPendingSeconds. It only observes, the pods are scheduled as before. A warning is
+// logged and an event is emitted once for every pod that becomes stuck.
+func (st *Stable) checkStuckPods(ctx context.Context) {
+	// the pods opt in with a label or with the annotation of their statefulset, no selector finds both
+	pods, err := st.podLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list pods to check for stuck pods: %v", err)
+		return
+	}
+	threshold := time.Duration(st.args.StuckPendingSeconds) * time.Second
+	now := st.now()
+	stuck := sets.NewString()
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" || pod.DeletionTimestamp != nil || now.Sub(pod.CreationTimestamp.Time) < threshold {
+			continue
+		}
+		// the statefulset owner of the pod is looked up for isStableEnabled
+		if !st.eligible(pod) {
+			continue
+		}
+		s := st.computePreFilterState(ctx, pod)
+		if !s.enforce || s.advisory || !s.recorded(s.key) {
+			continue
+		}
+		stuck.Insert(string(pod.UID))
+		if st.stuckPodUIDs.Has(string(pod.UID)) {
+			continue
+		}
+		node := s.record.Records[s.key].Node
+		klog.Warningf("Pod %s/%s is pending for %v, pinned to node %s", pod.Namespace, pod.Name, now.Sub(pod.CreationTimestamp.Time), node)
+		if st.eventRecorder != nil {
+			st.eventRecorder.Eventf(pod, v1.EventTypeWarning, "StuckPending",
+				"Pod is pending for more than %v, pinned to node %s", threshold, node)
+		}
+	}
+	st.stuckPodUIDs = stuck
+	stuckPendingPods.Set(float64(stuck.Len()))
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestCheckStuckPods(t *testing.T) {
+	now := time.Now()
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1","web-1":"node1","web-3":"node2"}}`,
+			},
+		},
+	}
+	newPod := func(name string, age time.Duration, nodeName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "n1",
+				UID:               types.UID(name),
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+		}
+	}
+	pods := []*corev1.Pod{
+		// pending and pinned for too long
+		newPod("web-0", 20*time.Minute, ""),
+		// pending and pinned, but within the threshold
+		newPod("web-1", time.Minute, ""),
+		// pending for too long, but not pinned
+		newPod("web-2", 20*time.Minute, ""),
+		// pinned and bound
+		newPod("web-3", 20*time.Minute, "node2"),
+	}
+
+	// the pods of a statefulset opted in with its annotation have no label
+	db := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/enabled": "true",
+				"statefulset-stable.scheduling.sigs.k8s.io/record":  `{"Records":{"db-0":"node1"}}`,
+			},
+		},
+	}
+	dbPod := newPod("db-0", 20*time.Minute, "")
+	dbPod.Labels = nil
+	dbPod.OwnerReferences[0].Name = "db"
+	pods = append(pods, dbPod)
+
+	clientset := fake.NewSimpleClientset(statefulset, db)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	podInformer := informers.Core().V1().Pods()
+	recorder := record.NewFakeRecorder(10)
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		podLister:         podInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{StuckPendingSeconds: 600},
+		clock:             clock.NewFakeClock(now),
+		eventRecorder:     recorder,
+	}
+	for _, statefulset := range []*appsv1.StatefulSet{statefulset, db} {
+		if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pod := range pods {
+		if err := podInformer.Informer().GetIndexer().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	RegisterMetrics()
+	ctx := context.TODO()
+	stableSchedule.checkStuckPods(ctx)
+	if got, err := testutil.GetGaugeMetricValue(stuckPendingPods); err != nil || got != 2 {
+		t.Errorf("expected 2 stuck pods, got %v (%v)", got, err)
+	}
+	if len(recorder.Events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(recorder.Events))
+	}
+	<-recorder.Events
+	<-recorder.Events
+
+	// the pods are still stuck, but the events aren't repeated
+	stableSchedule.checkStuckPods(ctx)
+	if got, err := testutil.GetGaugeMetricValue(stuckPendingPods); err != nil || got != 2 {
+		t.Errorf("expected 2 stuck pods, got %v (%v)", got, err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no new event, got %d", len(recorder.Events))
+	}
+
+	// the pods are bound at last
+	boundDBPod := dbPod.DeepCopy()
+	boundDBPod.Spec.NodeName = "node1"
+	for _, pod := range []*corev1.Pod{newPod("web-0", 20*time.Minute, "node1"), boundDBPod} {
+		if err := podInformer.Informer().GetIndexer().Update(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stableSchedule.checkStuckPods(ctx)
+	if got, err := testutil.GetGaugeMetricValue(stuckPendingPods); err != nil || got != 0 {
+		t.Errorf("expected no stuck pod, got %v (%v)", got, err)
+	}
+}
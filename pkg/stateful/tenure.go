@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"time"
+
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// tenureEnabled checks whether the score of a recorded node is weighted by tenure.
+func (st *Stable) tenureEnabled() bool {
+	return st.args.TenureSaturationSeconds > 0
+}
+
+// floorRecordedNodeScore raises the score of the recorded node to the highest score of the
+// nodes, so the recorded node wins ties while soft records don't filter the other nodes. The
+// scores are left unchanged when the recorded node is not feasible.
+func (st *Stable) floorRecordedNodeScore(recorded string, scores framework.NodeScoreList) {
+	var highest int64
+	for _, score := range scores {
+		if score.Score > highest {
+			highest = score.Score
+		}
+	}
+	for i := range scores {
+		if st.matchesNode(recorded, scores[i].Name) && scores[i].Score < highest {
+			scores[i].Score = highest
+		}
+	}
+}
+
+// recordedNodeScore returns the score of the node recorded under the key. Without tenure
+// weighting it is MaxNodeScore. With tenure weighting, up to TenureMaxScore of it is earned by
+// the time the pod was recorded on the node, reaching the full score after
+// TenureSaturationSeconds. Entries recorded before tenure weighting have no tenure.
+func (st *Stable) recordedNodeScore(record *ScheduleRecord, key string) int64 {
+	if !st.tenureEnabled() {
+		return framework.MaxNodeScore
+	}
+	base := framework.MaxNodeScore - st.args.TenureMaxScore
+	since := record.Records[key].Since
+	if since.IsZero() {
+		return base
+	}
+	saturation := time.Duration(st.args.TenureSaturationSeconds) * time.Second
+	tenure := st.now().Sub(since.Time)
+	if tenure <= 0 {
+		return base
+	}
+	if tenure >= saturation {
+		return framework.MaxNodeScore
+	}
+	return base + int64(float64(st.args.TenureMaxScore)*float64(tenure)/float64(saturation))
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func TestRecordedNodeScore(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		saturation int64
+		since      map[string]metav1.Time
+		expected   int64
+	}{
+		{
+			name:     "without tenure weighting",
+			expected: framework.MaxNodeScore,
+		},
+		{
+			name:       "entry recorded before tenure weighting",
+			saturation: 3600,
+			expected:   70,
+		},
+		{
+			name:       "entry recorded just now",
+			saturation: 3600,
+			since:      map[string]metav1.Time{"web-0": metav1.NewTime(now)},
+			expected:   70,
+		},
+		{
+			name:       "entry recorded half the saturation ago",
+			saturation: 3600,
+			since:      map[string]metav1.Time{"web-0": metav1.NewTime(now.Add(-30 * time.Minute))},
+			expected:   85,
+		},
+		{
+			name:       "entry recorded longer than the saturation ago",
+			saturation: 3600,
+			since:      map[string]metav1.Time{"web-0": metav1.NewTime(now.Add(-48 * time.Hour))},
+			expected:   framework.MaxNodeScore,
+		},
+		{
+			name:       "entry recorded in the future",
+			saturation: 3600,
+			since:      map[string]metav1.Time{"web-0": metav1.NewTime(now.Add(time.Hour))},
+			expected:   70,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := StableArgs{TenureSaturationSeconds: tt.saturation}
+			if tt.saturation > 0 {
+				args.TenureMaxScore = 30
+			}
+			stableSchedule := &Stable{args: args, clock: clock.NewFakeClock(now)}
+			record := &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1", Since: tt.since["web-0"]}}}
+			if got := stableSchedule.recordedNodeScore(record, "web-0"); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPostBindRecordsTenure(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-1":{"Node":"node2","Since":"2020-05-01T00:00:00Z"}}}`,
+			},
+		},
+	}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+		}
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{TenureSaturationSeconds: 3600, TenureMaxScore: 30},
+		clock:             clock.NewFakeClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	stableSchedule.PostBind(ctx, nil, newPod("web-0"), "node1")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+		t.Fatal(err)
+	}
+	// binding to the recorded node again keeps the tenure
+	stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node2")
+
+	s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":{"Node":"node1","Since":"2020-06-01T00:00:00Z"},"web-1":{"Node":"node2","Since":"2020-05-01T00:00:00Z"}}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestNormalizeScoreRecordedNodeFloor(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		floor    bool
+		scores   []int64
+		expected []int64
+	}{
+		{
+			name:     "recorded node scored below a peer",
+			floor:    true,
+			scores:   []int64{70, framework.MaxNodeScore, 20},
+			expected: []int64{framework.MaxNodeScore, framework.MaxNodeScore, 20},
+		},
+		{
+			name:     "recorded node already scored highest",
+			floor:    true,
+			scores:   []int64{70, 50, 0},
+			expected: []int64{70, 50, 0},
+		},
+		{
+			name:     "scores are unchanged without the floor",
+			scores:   []int64{70, framework.MaxNodeScore, 20},
+			expected: []int64{70, framework.MaxNodeScore, 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RecordedNodeScoreFloor: tt.floor},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+
+			scores := newNodeScores("node1", "node2", "node3")
+			for i := range scores {
+				scores[i].Score = tt.scores[i]
+			}
+			if status := stableSchedule.ScoreExtensions().NormalizeScore(context.TODO(), nil, pod, scores); !status.IsSuccess() {
+				t.Fatal(status.Message())
+			}
+			for i, score := range scores {
+				if score.Score != tt.expected[i] {
+					t.Errorf("expected score %d for %s, got %d", tt.expected[i], score.Name, score.Score)
+				}
+			}
+			if tt.floor && scores[0].Score < scores[1].Score {
+				t.Errorf("expected the recorded node to never score below its peers, got %v", scores)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+// spreadViolations tracks the statefulsets whose records violate their topology spread
+// constraints for the aggregate gauge, it is only used by checkTopologySpread.
+type spreadViolations struct {
+	lock sync.Mutex
+	keys sets.String
+}
+
+// track records whether the statefulset with the key violates its constraints and updates the gauge.
+func (v *spreadViolations) track(key string, violated bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.keys == nil {
+		v.keys = sets.NewString()
+	}
+	if violated {
+		v.keys.Insert(key)
+	} else {
+		v.keys.Delete(key)
+	}
+	topologySpreadViolatedStatefulSets.Set(float64(v.keys.Len()))
+}
+
+// violatedSpreadConstraints returns the topology keys of the spread constraints of the statefulset
+// whose MaxSkew the recorded nodes exceed. The domains are the values of the topology key
+// among the given nodes, records on nodes without the key are ignored.
+func violatedSpreadConstraints(statefulset *appsv1.StatefulSet, record *ScheduleRecord, nodes []*v1.Node) []string {
+	constraints := statefulset.Spec.Template.Spec.TopologySpreadConstraints
+	if len(constraints) == 0 || record == nil || len(record.Records) == 0 {
+		return nil
+	}
+	nodeLabels := make(map[string]map[string]string, len(nodes))
+	for _, node := range nodes {
+		nodeLabels[node.Name] = node.Labels
+	}
+
+	var violated []string
+	for _, constraint := range constraints {
+		counts := make(map[string]int)
+		for _, node := range nodes {
+			if value, ok := node.Labels[constraint.TopologyKey]; ok {
+				counts[value] += 0
+			}
+		}
+		for _, nodeName := range record.nodes() {
+			if value, ok := nodeLabels[nodeName][constraint.TopologyKey]; ok {
+				counts[value]++
+			}
+		}
+		if len(counts) == 0 {
+			continue
+		}
+		min, max := -1, 0
+		for _, count := range counts {
+			if min < 0 || count < min {
+				min = count
+			}
+			if count > max {
+				max = count
+			}
+		}
+		if int32(max-min) > constraint.MaxSkew {
+			violated = append(violated, constraint.TopologyKey)
+		}
+	}
+	return violated
+}
+
+// checkTopologySpread reports whether the records of the statefulset violate its topology spread
+// constraints. It is diagnostic only, the records are enforced either way. The statefulset is
+// only reported by name when perStatefulSetMetrics allows.
+func (st *Stable) checkTopologySpread(statefulset *appsv1.StatefulSet, record *ScheduleRecord) {
+	key := statefulset.Namespace + "/" + statefulset.Name
+	series := map[string]string{"namespace": statefulset.Namespace, "statefulset": statefulset.Name}
+	if len(statefulset.Spec.Template.Spec.TopologySpreadConstraints) == 0 {
+		st.spreadViolated.track(key, false)
+		topologySpreadViolated.Delete(series)
+		return
+	}
+	nodes, err := st.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.V(3).Infof("Failed to list nodes to check the topology spread of statefulset %s/%s: %v",
+			statefulset.Namespace, statefulset.Name, err)
+		return
+	}
+	violated := violatedSpreadConstraints(statefulset, record, nodes)
+	if len(violated) == 0 {
+		st.spreadViolated.track(key, false)
+		topologySpreadViolated.Delete(series)
+		return
+	}
+	klog.Warningf("The records of statefulset %s/%s violate the max skew of its topology spread constraints on %v",
+		statefulset.Namespace, statefulset.Name, violated)
+	st.spreadViolated.track(key, true)
+	if !st.perStatefulSetMetrics() {
+		topologySpreadViolated.Delete(series)
+		return
+	}
+	topologySpreadViolated.WithLabelValues(statefulset.Namespace, statefulset.Name).Set(1)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestViolatedSpreadConstraints(t *testing.T) {
+	const zoneKey = "topology.kubernetes.io/zone"
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{zoneKey: "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{zoneKey: "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{zoneKey: "b"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node4"}},
+	}
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+						{MaxSkew: 1, TopologyKey: zoneKey, WhenUnsatisfiable: corev1.DoNotSchedule},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		records  map[string]RecordEntry
+		expected []string
+	}{
+		{
+			name:    "spread across zones",
+			records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node3"}, "web-2": {Node: "node2"}},
+		},
+		{
+			name:     "concentrated in one zone",
+			records:  map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}},
+			expected: []string{zoneKey},
+		},
+		{
+			name:    "records on nodes without the topology key are ignored",
+			records: map[string]RecordEntry{"web-0": {Node: "node4"}, "web-1": {Node: "node4"}, "web-2": {Node: "node1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violatedSpreadConstraints(statefulset, &ScheduleRecord{Records: tt.records}, nodes)
+			if !reflect.DeepEqual(tt.expected, got) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheckTopologySpreadMetricLabels(t *testing.T) {
+	RegisterMetrics()
+	const zoneKey = "topology.kubernetes.io/zone"
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{zoneKey: "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{zoneKey: "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{zoneKey: "b"}}},
+	}
+	tests := []struct {
+		name         string
+		metricLabels string
+		statefulsets int
+		expectSeries bool
+	}{
+		{
+			name:         "per statefulset",
+			metricLabels: MetricLabelsPerStatefulSet,
+			statefulsets: autoAggregateMetricsStatefulSets + 1,
+			expectSeries: true,
+		},
+		{
+			name:         "aggregate",
+			metricLabels: MetricLabelsAggregate,
+			statefulsets: 1,
+		},
+		{
+			name:         "auto in a small cluster",
+			metricLabels: MetricLabelsAuto,
+			statefulsets: 1,
+			expectSeries: true,
+		},
+		{
+			name:         "auto in a large cluster",
+			metricLabels: MetricLabelsAuto,
+			statefulsets: autoAggregateMetricsStatefulSets + 1,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			nodeInformer := informers.Core().V1().Nodes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				nodeLister:        nodeInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{MetricLabels: tt.metricLabels},
+			}
+			for _, node := range nodes {
+				if err := nodeInformer.Informer().GetIndexer().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for j := 0; j < tt.statefulsets; j++ {
+				other := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("other-%d", j), Namespace: "n1"}}
+				if err := statefulsetInformer.Informer().GetIndexer().Add(other); err != nil {
+					t.Fatal(err)
+				}
+			}
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("web-%d", i), Namespace: "n1"},
+				Spec: appsv1.StatefulSetSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+								{MaxSkew: 1, TopologyKey: zoneKey, WhenUnsatisfiable: corev1.DoNotSchedule},
+							},
+						},
+					},
+				},
+			}
+
+			stableSchedule.checkTopologySpread(statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node2"}}})
+			if got, err := testutil.GetGaugeMetricValue(topologySpreadViolatedStatefulSets); err != nil || got != 1 {
+				t.Errorf("expected 1 statefulset violating its constraints, got %v (%v)", got, err)
+			}
+			series := map[string]string{"namespace": "n1", "statefulset": statefulset.Name}
+			if deleted := topologySpreadViolated.Delete(series); deleted != tt.expectSeries {
+				t.Errorf("expected the statefulset series to be reported: %v, got %v", tt.expectSeries, deleted)
+			}
+
+			stableSchedule.checkTopologySpread(statefulset, &ScheduleRecord{Records: map[string]RecordEntry{"web-0": {Node: "node1"}, "web-1": {Node: "node3"}}})
+			if got, err := testutil.GetGaugeMetricValue(topologySpreadViolatedStatefulSets); err != nil || got != 0 {
+				t.Errorf("expected no statefulset violating its constraints, got %v (%v)", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Span attributes set by the plugin.
+const (
+	SpanAttributePod      = "pod"
+	SpanAttributeNode     = "node"
+	SpanAttributeRecorded = "recorded"
+	SpanAttributeDecision = "decision"
+)
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key, value string)
+	End()
+}
+
+// Tracer starts spans around the PreFilter, Filter and PostBind decisions of the plugin, e.g.
+// backed by OpenTelemetry to join the traces of the scheduler. StartSpan is called from the
+// scheduling goroutines and must not block.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// noopSpan drops all attributes, it is returned when no tracer is set.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+
+func (noopSpan) End() {}
+
+// startSpan starts a span for the pod with the tracer of the plugin, a no-op span when no
+// tracer is set.
+func (st *Stable) startSpan(ctx context.Context, name string, pod *v1.Pod) (context.Context, Span) {
+	if st.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := st.tracer.StartSpan(ctx, Name+"/"+name)
+	span.SetAttribute(SpanAttributePod, pod.Namespace+"/"+pod.Name)
+	return ctx, span
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// memorySpan is a span kept in memory by memoryTracer.
+type memorySpan struct {
+	name       string
+	attributes map[string]string
+	ended      bool
+}
+
+func (s *memorySpan) SetAttribute(key, value string) {
+	s.attributes[key] = value
+}
+
+func (s *memorySpan) End() {
+	s.ended = true
+}
+
+// memoryTracer keeps the started spans in memory.
+type memoryTracer struct {
+	lock  sync.Mutex
+	spans []*memorySpan
+}
+
+func (t *memoryTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	span := &memorySpan{name: name, attributes: make(map[string]string)}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTraceSpans(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	tracer := &memoryTracer{}
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		tracer:            tracer,
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "n1",
+			Labels: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io": "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "StatefulSet",
+					Name: "web",
+				},
+			},
+		},
+	}
+
+	ctx := context.TODO()
+	state := framework.NewCycleState()
+	stableSchedule.PreFilter(ctx, state, pod)
+	for _, nodeName := range []string{"node1", "node2"} {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+			t.Fatal(err)
+		}
+		stableSchedule.Filter(ctx, state, pod, nodeInfo)
+	}
+	stableSchedule.PostBind(ctx, state, pod, "node1")
+
+	expected := []memorySpan{
+		{
+			name:       "statefulset-stable/PreFilter",
+			attributes: map[string]string{"pod": "n1/web-0", "recorded": "true", "decision": "Success"},
+			ended:      true,
+		},
+		{
+			name:       "statefulset-stable/Filter",
+			attributes: map[string]string{"pod": "n1/web-0", "node": "node1", "recorded": "true", "decision": "Success"},
+			ended:      true,
+		},
+		{
+			name:       "statefulset-stable/Filter",
+			attributes: map[string]string{"pod": "n1/web-0", "node": "node2", "recorded": "true", "decision": "Unschedulable"},
+			ended:      true,
+		},
+		{
+			name:       "statefulset-stable/PostBind",
+			attributes: map[string]string{"pod": "n1/web-0", "node": "node1", "recorded": "true"},
+			ended:      true,
+		},
+	}
+	if len(tracer.spans) != len(expected) {
+		t.Fatalf("expected %d spans, got %d", len(expected), len(tracer.spans))
+	}
+	for i, span := range tracer.spans {
+		if !reflect.DeepEqual(*span, expected[i]) {
+			t.Errorf("expected span %+v, got %+v", expected[i], *span)
+		}
+	}
+}
+
+func TestTraceSpansSkipIneligiblePostBind(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	tracer := &memoryTracer{}
+	stableSchedule := &Stable{
+		statefulSetLister: informers.Apps().V1().StatefulSets().Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		tracer:            tracer,
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "n1"}}
+	stableSchedule.PostBind(context.TODO(), nil, pod, "node1")
+	if len(tracer.spans) != 0 {
+		t.Errorf("expected no span for a pod that is not stable scheduled, got %d", len(tracer.spans))
+	}
+}
+
+// fakeHandle is a framework handle serving the clientset and informers of the plugin, its other
+// methods are not used to create the plugin.
+type fakeHandle struct {
+	framework.FrameworkHandle
+	clientset       clientset.Interface
+	informerFactory informers.SharedInformerFactory
+}
+
+func (h *fakeHandle) ClientSet() clientset.Interface {
+	return h.clientset
+}
+
+func (h *fakeHandle) SharedInformerFactory() informers.SharedInformerFactory {
+	return h.informerFactory
+}
+
+func TestNewWithTracer(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	handle := &fakeHandle{clientset: clientset, informerFactory: informers.NewSharedInformerFactory(clientset, 0)}
+	tracer := &memoryTracer{}
+	plugin, err := NewWithTracer(tracer)(&runtime.Unknown{}, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plugin.(*Stable).tracer; got != tracer {
+		t.Errorf("expected the plugin to start its spans with the tracer, got %v", got)
+	}
+
+	plugin, err = New(&runtime.Unknown{}, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plugin.(*Stable).tracer; got != nil {
+		t.Errorf("expected no tracer by default, got %v", got)
+	}
+}
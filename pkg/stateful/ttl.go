@@ -0,0 +1,45 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/klog"
+)
+
+// StatefulsetStableTTL is the statefulset annotation overriding the record TTL of its entries,
+// a duration parsed by time.ParseDuration, e.g. "24h". "0s" disables the expiry. An invalid
+// value is ignored in favor of StableArgs.TTL.
+const StatefulsetStableTTL = "statefulset-stable.scheduling.sigs.k8s.io/ttl"
+
+// recordTTL returns the effective record TTL of the statefulset, 0 when its entries don't expire.
+func (st *Stable) recordTTL(statefulset *appsv1.StatefulSet) time.Duration {
+	global := st.args.TTL.Duration
+	value, ok := statefulset.GetAnnotations()[StatefulsetStableTTL]
+	if !ok {
+		return global
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		klog.Warningf("Ignoring annotation %s=%q of statefulset %s/%s, using the record TTL of %v: not a positive duration",
+			StatefulsetStableTTL, value, statefulset.Namespace, statefulset.Name, global)
+		return global
+	}
+	return ttl
+}
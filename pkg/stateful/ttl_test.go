@@ -0,0 +1,267 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestRecordTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        StableArgs
+		annotations map[string]string
+		expected    time.Duration
+	}{
+		{
+			name:     "global TTL",
+			args:     StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+			expected: time.Hour,
+		},
+		{
+			name:     "global TTL duration",
+			args:     StableArgs{TTL: metav1.Duration{Duration: 2 * time.Hour}},
+			expected: 2 * time.Hour,
+		},
+		{
+			name:        "longer TTL",
+			args:        StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+			annotations: map[string]string{StatefulsetStableTTL: "24h"},
+			expected:    24 * time.Hour,
+		},
+		{
+			name:        "shorter TTL",
+			args:        StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+			annotations: map[string]string{StatefulsetStableTTL: "90s"},
+			expected:    90 * time.Second,
+		},
+		{
+			name:        "expiry disabled",
+			args:        StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+			annotations: map[string]string{StatefulsetStableTTL: "0s"},
+		},
+		{
+			name:        "invalid duration",
+			args:        StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+			annotations: map[string]string{StatefulsetStableTTL: "one day"},
+			expected:    time.Hour,
+		},
+		{
+			name:        "negative duration",
+			args:        StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+			annotations: map[string]string{StatefulsetStableTTL: "-1h"},
+			expected:    time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stableSchedule := &Stable{args: tt.args}
+			statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "n1", Annotations: tt.annotations}}
+			if got := stableSchedule.recordTTL(statefulset); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExpiredRecord(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		ttl            time.Duration
+		record         string
+		expectedCode   framework.Code
+		expectedRecord string
+	}{
+		{
+			name:           "fresh entry",
+			ttl:            24 * time.Hour,
+			record:         `{"Records":{"web-0":"node1"},"RecordedAt":{"web-0":"2020-05-31T22:00:00Z"}}`,
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"},"RecordedAt":{"web-0":"2020-05-31T22:00:00Z"}}`,
+		},
+		{
+			name:           "expired entry",
+			ttl:            time.Hour,
+			record:         `{"Records":{"web-0":"node1"},"RecordedAt":{"web-0":"2020-05-31T22:00:00Z"}}`,
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node2","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+		},
+		{
+			name:           "expired entry next to an entry recorded before the TTL",
+			ttl:            time.Hour,
+			record:         `{"Records":{"web-0":"node1","web-1":"node1"},"RecordedAt":{"web-0":"2020-05-31T22:00:00Z"}}`,
+			expectedCode:   framework.Success,
+			expectedRecord: `{"Records":{"web-0":{"Node":"node2","RecordedAt":"2020-06-01T00:00:00Z"},"web-1":"node1"}}`,
+		},
+		{
+			name:           "entry without recorded time",
+			ttl:            time.Hour,
+			record:         `{"Records":{"web-0":"node1"}}`,
+			expectedCode:   framework.Unschedulable,
+			expectedRecord: `{"Records":{"web-0":"node1"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{TTL: metav1.Duration{Duration: tt.ttl}},
+				clock:             clock.NewFakeClock(now),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, nil, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+			if tt.expectedCode != framework.Success {
+				return
+			}
+			// the expired entry is overwritten by the next bind
+			stableSchedule.PostBind(ctx, nil, pod, "node2")
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != tt.expectedRecord {
+				t.Errorf("expected %v, got %v", tt.expectedRecord, got)
+			}
+		})
+	}
+}
+
+func TestPostBindRenewsRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   string
+		node     string
+		expected string
+	}{
+		{
+			name:     "fresh entry is renewed",
+			record:   `{"Records":{"web-0":"node1"},"RecordedAt":{"web-0":"2020-05-31T23:30:00Z"}}`,
+			node:     "node1",
+			expected: `{"Records":{"web-0":{"Node":"node1","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+		},
+		{
+			name:     "expired entry is overwritten",
+			record:   `{"Records":{"web-0":"node1"},"RecordedAt":{"web-0":"2020-05-31T22:00:00Z"}}`,
+			node:     "node2",
+			expected: `{"Records":{"web-0":{"Node":"node2","RecordedAt":"2020-06-01T00:00:00Z"}}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": test.record,
+					},
+				},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{TTL: metav1.Duration{Duration: time.Hour}},
+				clock:             clock.NewFakeClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			stableSchedule.PostBind(ctx, nil, pod, test.node)
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
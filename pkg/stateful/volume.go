@@ -0,0 +1,98 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// annSelectedNode is the annotation the volume binder sets on a WaitForFirstConsumer
+// claim with the node selected for provisioning its volume.
+const annSelectedNode = "volume.kubernetes.io/selected-node"
+
+// getVolumeNode returns the node selected for the WaitForFirstConsumer claims of the pod,
+// empty if none of its bound claims has a selected node. The volume binder binds the claims of
+// the pod before binding the pod, a claim that is not bound in the cache yet was bound for the
+// node the pod was just bound to, and its selected node may still be the one of an earlier
+// scheduling attempt.
+func (st *Stable) getVolumeNode(pod *v1.Pod) string {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim, err := st.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			klog.V(4).Infof("Failed to get claim %s/%s of pod %s: %v",
+				pod.Namespace, volume.PersistentVolumeClaim.ClaimName, pod.Name, err)
+			continue
+		}
+		if claim.Spec.VolumeName == "" {
+			klog.V(4).Infof("Claim %s/%s of pod %s is not bound yet, ignoring its selected node",
+				pod.Namespace, claim.Name, pod.Name)
+			continue
+		}
+		if node := claim.GetAnnotations()[annSelectedNode]; node != "" {
+			return node
+		}
+	}
+	return ""
+}
+
+// localVolumesBound checks whether every claim of the pod is bound to a node-local volume, with
+// a local or host path source or a required node affinity. Without a volume lister, the claims
+// only have to be bound. Pods without claims have no data to return to and count as bound, as
+// do all pods without a claim lister.
+func (st *Stable) localVolumesBound(pod *v1.Pod) bool {
+	if st.pvcLister == nil {
+		return true
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim, err := st.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			klog.V(4).Infof("Failed to get claim %s/%s of pod %s: %v",
+				pod.Namespace, volume.PersistentVolumeClaim.ClaimName, pod.Name, err)
+			return false
+		}
+		if claim.Status.Phase != v1.ClaimBound || claim.Spec.VolumeName == "" {
+			return false
+		}
+		if st.pvLister == nil {
+			continue
+		}
+		pv, err := st.pvLister.Get(claim.Spec.VolumeName)
+		if err != nil {
+			klog.V(4).Infof("Failed to get volume %s of claim %s/%s: %v", claim.Spec.VolumeName, pod.Namespace, claim.Name, err)
+			return false
+		}
+		if !isNodeLocalVolume(pv) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNodeLocalVolume checks whether the volume can only be used on some nodes.
+func isNodeLocalVolume(pv *v1.PersistentVolume) bool {
+	if pv.Spec.Local != nil || pv.Spec.HostPath != nil {
+		return true
+	}
+	return pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil
+}
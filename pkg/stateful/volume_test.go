@@ -0,0 +1,393 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+func TestPostBindPinToVolumeNode(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "n1",
+			Annotations: map[string]string{
+				"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-1":"node1"}}`,
+			},
+		},
+	}
+	claims := []*corev1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "data-web-0",
+				Namespace:   "n1",
+				Annotations: map[string]string{"volume.kubernetes.io/selected-node": "node2"},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pv-web-0"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "data-web-1",
+				Namespace:   "n1",
+				Annotations: map[string]string{"volume.kubernetes.io/selected-node": "node3"},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pv-web-1"},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(statefulset)
+	informers := informers.NewSharedInformerFactory(clientset, 0)
+	statefulsetInformer := informers.Apps().V1().StatefulSets()
+	pvcInformer := informers.Core().V1().PersistentVolumeClaims()
+	stableSchedule := &Stable{
+		statefulSetLister: statefulsetInformer.Lister(),
+		pvcLister:         pvcInformer.Lister(),
+		clientset:         clientset,
+		store:             newAnnotationStore(clientset),
+		args:              StableArgs{PinToVolumeNode: true},
+	}
+	if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+		t.Fatal(err)
+	}
+	for _, claim := range claims {
+		if err := pvcInformer.Informer().GetIndexer().Add(claim); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "n1",
+				Labels: map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io": "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind: "StatefulSet",
+						Name: "web",
+					},
+				},
+			},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-" + name},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	ctx := context.TODO()
+	// web-0 has no record yet, the node of its volume wins over the bound node
+	stableSchedule.PostBind(ctx, nil, newPod("web-0"), "node1")
+	s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Records":{"web-0":{"Node":"node2","Source":"override"},"web-1":"node1"}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// web-1 has a record that conflicts with its volume, the record follows the volume
+	if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+		t.Fatal(err)
+	}
+	stableSchedule.PostBind(ctx, nil, newPod("web-1"), "node1")
+	s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"Records":{"web-0":{"Node":"node2","Source":"override"},"web-1":{"Node":"node3","Source":"override"}}}`
+	if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestWaitForFirstConsumerPin(t *testing.T) {
+	tests := []struct {
+		name            string
+		pinToVolumeNode bool
+		// selectedNode is the node the claim was selected for by an earlier scheduling attempt,
+		// seen by PostBind before the cache caught up with the binding.
+		selectedNode string
+	}{
+		{
+			name: "first bind",
+		},
+		{
+			name:            "first bind pinned to the volume node",
+			pinToVolumeNode: true,
+		},
+		{
+			name:            "first bind with a stale selected node",
+			pinToVolumeNode: true,
+			selectedNode:    "node2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+				},
+			}
+			claim := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "data-web-0",
+					Namespace: "n1",
+				},
+			}
+			if tt.selectedNode != "" {
+				claim.Annotations = map[string]string{"volume.kubernetes.io/selected-node": tt.selectedNode}
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			pvcInformer := informers.Core().V1().PersistentVolumeClaims()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				pvcLister:         pvcInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{PinToVolumeNode: tt.pinToVolumeNode},
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			if err := pvcInformer.Informer().GetIndexer().Add(claim); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{
+						{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-web-0"},
+							},
+						},
+					},
+				},
+			}
+
+			// the volume binder selected node1 and bound the claim, PostBind records the node
+			ctx := context.TODO()
+			stableSchedule.PostBind(ctx, nil, pod, "node1")
+			s, err := clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := `{"Records":{"web-0":"node1"}}`
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+				t.Fatalf("expected %v, got %v", expected, got)
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Update(s); err != nil {
+				t.Fatal(err)
+			}
+			bound := claim.DeepCopy()
+			bound.Annotations = map[string]string{"volume.kubernetes.io/selected-node": "node1"}
+			bound.Spec.VolumeName = "pv-web-0"
+			if err := pvcInformer.Informer().GetIndexer().Update(bound); err != nil {
+				t.Fatal(err)
+			}
+
+			// the recreated pod is kept on the node of its bound volume
+			for nodeName, expectedCode := range map[string]framework.Code{"node1": framework.Success, "node2": framework.Unschedulable} {
+				nodeInfo := schedulernodeinfo.NewNodeInfo()
+				if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}); err != nil {
+					t.Fatal(err)
+				}
+				if code := stableSchedule.Filter(ctx, nil, pod, nodeInfo).Code(); code != expectedCode {
+					t.Errorf("expected %v on %s, got %v", expectedCode, nodeName, code)
+				}
+			}
+			stableSchedule.PostBind(ctx, nil, pod, "node1")
+			s, err = clientset.AppsV1().StatefulSets(statefulset.Namespace).Get(ctx, statefulset.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Annotations["statefulset-stable.scheduling.sigs.k8s.io/record"]; got != expected {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func TestEnforceAfterVolumeBound(t *testing.T) {
+	localVolume := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-local"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{Local: &corev1.LocalVolumeSource{Path: "/mnt/disks/ssd1"}},
+		},
+	}
+	networkVolume := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-network"}}
+	tests := []struct {
+		name         string
+		enforce      bool
+		phase        corev1.PersistentVolumeClaimPhase
+		volumeName   string
+		withPVLister bool
+		expectedCode framework.Code
+	}{
+		{
+			name:         "unbound claim schedules freely",
+			enforce:      true,
+			phase:        corev1.ClaimPending,
+			withPVLister: true,
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "claim bound to a node-local volume is enforced",
+			enforce:      true,
+			phase:        corev1.ClaimBound,
+			volumeName:   "pv-local",
+			withPVLister: true,
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "claim bound to a network volume schedules freely",
+			enforce:      true,
+			phase:        corev1.ClaimBound,
+			volumeName:   "pv-network",
+			withPVLister: true,
+			expectedCode: framework.Success,
+		},
+		{
+			name:         "bound claim is enforced without a volume lister",
+			enforce:      true,
+			phase:        corev1.ClaimBound,
+			volumeName:   "pv-network",
+			expectedCode: framework.Unschedulable,
+		},
+		{
+			name:         "unbound claim is enforced by default",
+			phase:        corev1.ClaimPending,
+			expectedCode: framework.Unschedulable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+					Annotations: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io/record": `{"Records":{"web-0":"node1"}}`,
+					},
+				},
+			}
+			claim := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "data-web-0", Namespace: "n1"},
+				Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: tt.volumeName},
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: tt.phase},
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			pvcInformer := informers.Core().V1().PersistentVolumeClaims()
+			pvInformer := informers.Core().V1().PersistentVolumes()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				pvcLister:         pvcInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{EnforceAfterVolumeBound: tt.enforce},
+			}
+			if tt.withPVLister {
+				stableSchedule.pvLister = pvInformer.Lister()
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			if err := pvcInformer.Informer().GetIndexer().Add(claim); err != nil {
+				t.Fatal(err)
+			}
+			for _, pv := range []*corev1.PersistentVolume{localVolume, networkVolume} {
+				if err := pvInformer.Informer().GetIndexer().Add(pv); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{
+						{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-web-0"},
+							},
+						},
+					},
+				},
+			}
+
+			ctx := context.TODO()
+			state := framework.NewCycleState()
+			stableSchedule.PreFilter(ctx, state, pod)
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			if err := nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if code := stableSchedule.Filter(ctx, state, pod, nodeInfo).Code(); code != tt.expectedCode {
+				t.Errorf("expected %v, got %v", tt.expectedCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	// placementWebhookTimeout bounds each request to the placement webhook.
+	placementWebhookTimeout = 5 * time.Second
+	// placementWebhookAttempts bounds the requests sent for a placement change.
+	placementWebhookAttempts = 3
+	// placementWebhookQueueSize bounds the placement changes waiting to be sent, further
+	// changes are dropped so a slow webhook never blocks a bind.
+	placementWebhookQueueSize = 1000
+)
+
+// PlacementChange is sent to the placement webhook when the recorded node of a pod changes.
+type PlacementChange struct {
+	Namespace   string `json:"namespace"`
+	StatefulSet string `json:"statefulset"`
+	Pod         string `json:"pod"`
+	// OldNode is the node recorded for the pod before, empty for its first record.
+	OldNode string    `json:"oldNode,omitempty"`
+	NewNode string    `json:"newNode"`
+	Time    time.Time `json:"time"`
+}
+
+// placementNotifier posts placement changes to the placement webhook from a queue, so a slow or
+// failing webhook never blocks or fails a bind.
+type placementNotifier struct {
+	url    string
+	client *http.Client
+	queue  chan PlacementChange
+	// retryInterval is the wait before the first retry of a change, doubled for each retry.
+	retryInterval time.Duration
+}
+
+func newPlacementNotifier(url string) *placementNotifier {
+	return &placementNotifier{
+		url:           url,
+		client:        &http.Client{Timeout: placementWebhookTimeout},
+		queue:         make(chan PlacementChange, placementWebhookQueueSize),
+		retryInterval: time.Second,
+	}
+}
+
+// notify queues the change without blocking, it is dropped when the queue is full.
+func (n *placementNotifier) notify(change PlacementChange) {
+	select {
+	case n.queue <- change:
+	default:
+		klog.Warningf("Dropping placement change of pod %s/%s to node %s, the placement webhook queue is full",
+			change.Namespace, change.Pod, change.NewNode)
+	}
+}
+
+// run sends the queued changes until the stop channel is closed.
+func (n *placementNotifier) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case change := <-n.queue:
+			n.send(change)
+		}
+	}
+}
+
+// send posts the change, retrying failed requests with a backoff up to placementWebhookAttempts.
+func (n *placementNotifier) send(change PlacementChange) {
+	body, err := json.Marshal(change)
+	if err != nil {
+		klog.Warningf("Failed to encode placement change of pod %s/%s: %v", change.Namespace, change.Pod, err)
+		return
+	}
+	backoff := wait.Backoff{Duration: n.retryInterval, Factor: 2, Steps: placementWebhookAttempts}
+	var lastErr error
+	err = wait.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = n.post(body)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		klog.Warningf("Failed to send placement change of pod %s/%s to node %s after %d attempts: %v",
+			change.Namespace, change.Pod, change.NewNode, placementWebhookAttempts, lastErr)
+	}
+}
+
+func (n *placementNotifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), placementWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("placement webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyPlacement queues the change of the recorded node of a pod for the placement webhook.
+func (st *Stable) notifyPlacement(change PlacementChange) {
+	if st.placementNotifier == nil {
+		return
+	}
+	change.Time = st.now()
+	st.placementNotifier.notify(change)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stateful
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNotifyPlacement(t *testing.T) {
+	now := time.Date(2020, 4, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		record   string
+		nodeName string
+		expected *PlacementChange
+	}{
+		{
+			name:     "first record",
+			nodeName: "node1",
+			expected: &PlacementChange{Namespace: "n1", StatefulSet: "web", Pod: "web-0", NewNode: "node1", Time: now},
+		},
+		{
+			name:     "pod moved to another node",
+			record:   `{"Records":{"web-0":"node1"}}`,
+			nodeName: "node2",
+			expected: &PlacementChange{Namespace: "n1", StatefulSet: "web", Pod: "web-0", OldNode: "node1", NewNode: "node2", Time: now},
+		},
+		{
+			name:     "pod bound to its recorded node",
+			record:   `{"Records":{"web-0":"node1"}}`,
+			nodeName: "node1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan PlacementChange, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var change PlacementChange
+				if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+					t.Errorf("failed to decode placement change: %v", err)
+				}
+				received <- change
+			}))
+			defer server.Close()
+
+			statefulset := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web",
+					Namespace: "n1",
+				},
+			}
+			if tt.record != "" {
+				statefulset.Annotations = map[string]string{
+					"statefulset-stable.scheduling.sigs.k8s.io/record": tt.record,
+				}
+			}
+			clientset := fake.NewSimpleClientset(statefulset)
+			informers := informers.NewSharedInformerFactory(clientset, 0)
+			statefulsetInformer := informers.Apps().V1().StatefulSets()
+			stableSchedule := &Stable{
+				statefulSetLister: statefulsetInformer.Lister(),
+				clientset:         clientset,
+				store:             newAnnotationStore(clientset),
+				args:              StableArgs{RecordUpdatePolicy: RecordUpdateMutable, PlacementWebhookURL: server.URL},
+				clock:             clock.NewFakeClock(now),
+				placementNotifier: newPlacementNotifier(server.URL),
+			}
+			if err := statefulsetInformer.Informer().GetIndexer().Add(statefulset); err != nil {
+				t.Fatal(err)
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			go stableSchedule.placementNotifier.run(stopCh)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "n1",
+					Labels: map[string]string{
+						"statefulset-stable.scheduling.sigs.k8s.io": "true",
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							Kind: "StatefulSet",
+							Name: "web",
+						},
+					},
+				},
+			}
+			stableSchedule.PostBind(context.TODO(), nil, pod, tt.nodeName)
+
+			if tt.expected == nil {
+				select {
+				case change := <-received:
+					t.Errorf("expected no placement change, got %+v", change)
+				case <-time.After(100 * time.Millisecond):
+				}
+				return
+			}
+			select {
+			case change := <-received:
+				if !change.Time.Equal(tt.expected.Time) {
+					t.Errorf("expected time %v, got %v", tt.expected.Time, change.Time)
+				}
+				change.Time = tt.expected.Time
+				if change != *tt.expected {
+					t.Errorf("expected %+v, got %+v", *tt.expected, change)
+				}
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatal("expected a placement change")
+			}
+		})
+	}
+}
+
+func TestPlacementWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int32
+		expectedRequests int32
+	}{
+		{
+			name:             "webhook succeeds",
+			expectedRequests: 1,
+		},
+		{
+			name:             "webhook succeeds after failures",
+			failures:         2,
+			expectedRequests: 3,
+		},
+		{
+			name:             "webhook keeps failing",
+			failures:         10,
+			expectedRequests: placementWebhookAttempts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer server.Close()
+
+			notifier := newPlacementNotifier(server.URL)
+			notifier.retryInterval = time.Millisecond
+			notifier.send(PlacementChange{Namespace: "n1", StatefulSet: "web", Pod: "web-0", NewNode: "node1"})
+			if got := atomic.LoadInt32(&requests); got != tt.expectedRequests {
+				t.Errorf("expected %d requests, got %d", tt.expectedRequests, got)
+			}
+		})
+	}
+}
+
+func TestPlacementNotifierQueueFull(t *testing.T) {
+	notifier := newPlacementNotifier("http://127.0.0.1:1")
+	done := make(chan struct{})
+	go func() {
+		// nothing consumes the queue, the changes beyond its size are dropped
+		for i := 0; i <= placementWebhookQueueSize; i++ {
+			notifier.notify(PlacementChange{Namespace: "n1", StatefulSet: "web", Pod: "web-0", NewNode: "node1"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("expected notify not to block on a full queue")
+	}
+	if got := len(notifier.queue); got != placementWebhookQueueSize {
+		t.Errorf("expected %d queued changes, got %d", placementWebhookQueueSize, got)
+	}
+}